@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestAttachRunnerCluster(t *testing.T) {
+	for _, tc := range []struct {
+		Name     string
+		Global   []string
+		Command  []string
+		Expected string
+		Err      bool
+	}{
+		{Name: "default", Expected: "default"},
+		{Name: "global cluster", Global: []string{"--cluster", "prod"}, Expected: "prod"},
+		{Name: "command cluster", Global: []string{"--cluster", "prod"}, Command: []string{"--cluster", "jobs"}, Expected: "jobs"},
+		{Name: "several clusters", Global: []string{"--cluster", "prod", "--cluster", "jobs"}, Err: true},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			global := flag.NewFlagSet("global", flag.ContinueOnError)
+			global.Var(&cli.StringSlice{}, "cluster", "")
+			if err := global.Parse(tc.Global); err != nil {
+				t.Fatal(err)
+			}
+			command := flag.NewFlagSet("attach", flag.ContinueOnError)
+			command.String("cluster", "", "")
+			if err := command.Parse(tc.Command); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := attachRunner(cli.NewContext(nil, command, cli.NewContext(nil, global, nil)))
+			if tc.Err {
+				if err == nil {
+					t.Fatalf("Expected an error, got cluster %q", r.Cluster)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Cluster != tc.Expected {
+				t.Errorf("Expected cluster %q, got %q", tc.Expected, r.Cluster)
+			}
+		})
+	}
+}
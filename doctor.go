@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/buildkite/ecs-run-task/runner"
+	"github.com/urfave/cli"
+)
+
+func doctorAction(ctx *cli.Context) error {
+	if !ctx.GlobalBool("debug") {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	clusters := ctx.GlobalStringSlice("cluster")
+	if len(clusters) == 0 {
+		clusters = []string{"default"}
+	}
+
+	r := runner.New()
+	r.LogGroupName = ctx.GlobalString("log-group")
+	r.LogRegion = ctx.GlobalString("log-region")
+	r.LogRoleARN = ctx.GlobalString("log-role")
+	r.NoLogs = ctx.GlobalBool("no-logs")
+	r.Subnets = ctx.GlobalStringSlice("subnet")
+	r.SecurityGroups = ctx.GlobalStringSlice("security-group")
+
+	runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var failed bool
+	for i, cluster := range clusters {
+		if len(clusters) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("Cluster %s:\n", cluster)
+		}
+		r.Cluster = cluster
+		if !printDoctorChecks(os.Stdout, r.Doctor(runCtx)) {
+			failed = true
+		}
+	}
+	if failed {
+		return cli.NewExitError("Some checks failed", 1)
+	}
+	return nil
+}
+
+// printDoctorChecks prints a checklist of the checks, returning whether they
+// all passed
+func printDoctorChecks(w io.Writer, checks []runner.DoctorCheck) bool {
+	passed := true
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, check := range checks {
+		result := "PASS"
+		switch {
+		case check.Skipped:
+			result = "SKIP"
+		case !check.Passed:
+			result = "FAIL"
+			passed = false
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", result, check.Name, check.Detail)
+	}
+	tw.Flush()
+	return passed
+}
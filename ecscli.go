@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/ghodss/yaml"
+)
+
+// ecsCLIDefaults are defaults read from the configuration files of ecs-cli
+type ecsCLIDefaults struct {
+	Cluster        string
+	Region         string
+	LaunchType     string
+	Subnets        []string
+	SecurityGroups []string
+}
+
+// ecsCLIConfig is the subset of ~/.ecs/config that we use
+type ecsCLIConfig struct {
+	Default  string `json:"default"`
+	Clusters map[string]struct {
+		Cluster           string `json:"cluster"`
+		Region            string `json:"region"`
+		DefaultLaunchType string `json:"default_launch_type"`
+	} `json:"clusters"`
+}
+
+// ecsParams is the subset of an ecs-params.yml that we use
+type ecsParams struct {
+	RunParams struct {
+		NetworkConfiguration struct {
+			AwsvpcConfiguration struct {
+				Subnets        []string `json:"subnets"`
+				SecurityGroups []string `json:"security_groups"`
+			} `json:"awsvpc_configuration"`
+		} `json:"network_configuration"`
+	} `json:"run_params"`
+}
+
+// loadECSCLIDefaults reads the default cluster configuration from
+// ~/.ecs/config and network configuration from an ecs-params.yml in the
+// current directory, if they exist
+func loadECSCLIDefaults() (*ecsCLIDefaults, error) {
+	defaults := &ecsCLIDefaults{}
+
+	home, err := os.UserHomeDir()
+	if err == nil {
+		var config ecsCLIConfig
+		found, err := readYAMLIfExists(filepath.Join(home, ".ecs", "config"), &config)
+		if err != nil {
+			return nil, err
+		}
+		if cluster, ok := config.Clusters[config.Default]; found && ok {
+			log.Printf("Using ecs-cli cluster configuration %q", config.Default)
+			defaults.Cluster = cluster.Cluster
+			defaults.Region = cluster.Region
+			defaults.LaunchType = cluster.DefaultLaunchType
+		}
+	}
+
+	var params ecsParams
+	found, err := readYAMLIfExists("ecs-params.yml", &params)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		log.Printf("Using network configuration from ecs-params.yml")
+		awsvpc := params.RunParams.NetworkConfiguration.AwsvpcConfiguration
+		defaults.Subnets = awsvpc.Subnets
+		defaults.SecurityGroups = awsvpc.SecurityGroups
+	}
+
+	return defaults, nil
+}
+
+func readYAMLIfExists(file string, v interface{}) (bool, error) {
+	body, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err := yaml.Unmarshal(body, v); err != nil {
+		return false, fmt.Errorf("Failed to parse %s: %v", file, err)
+	}
+	return true, nil
+}
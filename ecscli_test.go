@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadECSCLIDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ecscli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, ".ecs"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, ".ecs", "config"), []byte(`version: v1
+default: staging
+clusters:
+  staging:
+    cluster: staging-cluster
+    region: us-west-2
+    default_launch_type: FARGATE
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "ecs-params.yml"), []byte(`version: 1
+run_params:
+  network_configuration:
+    awsvpc_configuration:
+      subnets: [subnet-1, subnet-2]
+      security_groups: [sg-1]
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", dir)
+
+	defaults, err := loadECSCLIDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaults.Cluster != "staging-cluster" || defaults.Region != "us-west-2" || defaults.LaunchType != "FARGATE" {
+		t.Fatalf("Bad cluster defaults %+v", defaults)
+	}
+	if len(defaults.Subnets) != 2 || len(defaults.SecurityGroups) != 1 {
+		t.Fatalf("Bad network defaults %+v", defaults)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readEnvFile reads `KEY=value` lines from a dotenv style file. Blank lines
+// and lines starting with # are skipped, an `export ` prefix is allowed, and
+// values can be quoted. A line with just `KEY` passes the variable through
+// from the current environment, like --env.
+func readEnvFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("Invalid line %d in %s, expected KEY=value", n, file)
+		}
+		if len(parts) == 1 {
+			env = append(env, key)
+			continue
+		}
+
+		value, err := unquoteEnvValue(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value on line %d in %s: %v", n, file, err)
+		}
+		env = append(env, key+"="+value)
+	}
+	return env, scanner.Err()
+}
+
+// unquoteEnvValue removes the quotes around a value. Double quoted values
+// have Go escapes like \n interpreted, and single quoted values are literal.
+func unquoteEnvValue(value string) (string, error) {
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		return strconv.Unquote(value)
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1], nil
+	}
+	return value, nil
+}
+
+// envName returns the name of a `KEY=value` or `KEY` variable
+func envName(env string) string {
+	return strings.SplitN(env, "=", 2)[0]
+}
+
+// resolveEnvironment layers environment files from left to right, so that
+// later files win, and then variables from --env, returning the variables
+// along with where each came from
+func resolveEnvironment(files, flags []string) ([]string, map[string]string, error) {
+	var env []string
+	sources := map[string]string{}
+	for _, file := range files {
+		vars, err := readEnvFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		env = mergeEnvironment(env, vars)
+		for _, v := range vars {
+			sources[envName(v)] = file
+		}
+	}
+
+	for _, v := range flags {
+		sources[envName(v)] = "--env"
+	}
+	return mergeEnvironment(env, flags), sources, nil
+}
+
+// printResolvedEnv prints each variable that will be given to the task and
+// where it came from, with values that look like secrets redacted
+func printResolvedEnv(w io.Writer, env []string, sources map[string]string) {
+	for _, v := range env {
+		source := sources[envName(v)]
+		if !strings.Contains(v, "=") {
+			source += ", passed through from the current environment"
+		}
+		fmt.Fprintf(w, "%s  # %s\n", redactEnv(v), source)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveEnvironment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "base.env")
+	err = ioutil.WriteFile(base, []byte(`# shared settings
+LOG_LEVEL=info
+DB_HOST=db.staging.internal
+export GREETING="hello\nworld"
+QUOTED='$not_interpolated'
+BUILD_ID
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prod := filepath.Join(dir, "prod.env")
+	err = ioutil.WriteFile(prod, []byte("DB_HOST=db.prod.internal\nDB_PASSWORD=hunter2\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, sources, err := resolveEnvironment([]string{base, prod}, []string{"LOG_LEVEL=debug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"GREETING=hello\nworld",
+		"QUOTED=$not_interpolated",
+		"BUILD_ID",
+		"DB_HOST=db.prod.internal",
+		"DB_PASSWORD=hunter2",
+		"LOG_LEVEL=debug",
+	}
+	if strings.Join(env, "|") != strings.Join(expected, "|") {
+		t.Fatalf("Expected %q, got %q", expected, env)
+	}
+	if sources["DB_HOST"] != prod || sources["LOG_LEVEL"] != "--env" || sources["QUOTED"] != base {
+		t.Fatalf("Unexpected sources %v", sources)
+	}
+
+	var out bytes.Buffer
+	printResolvedEnv(&out, env, sources)
+	if !strings.Contains(out.String(), "DB_PASSWORD=[REDACTED]  # "+prod) {
+		t.Fatalf("Expected the password to be redacted, got %s", out.String())
+	}
+	if !strings.Contains(out.String(), "BUILD_ID  # "+base+", passed through") {
+		t.Fatalf("Expected a pass-through variable, got %s", out.String())
+	}
+}
+
+func TestReadEnvFileInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "bad.env")
+	if err := ioutil.WriteFile(file, []byte("OK=1\nNOT A VARIABLE\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readEnvFile(file); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("Expected an error on line 2, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// envVarPrefix is prepended to a flag's name to get the environment variable
+// that sets its default, such as ECS_RUN_TASK_CLUSTER for --cluster
+const envVarPrefix = "ECS_RUN_TASK_"
+
+// flagEnvVar returns the environment variable for a flag, named after its
+// first name
+func flagEnvVar(name string) string {
+	name = strings.TrimSpace(strings.Split(name, ",")[0])
+	return envVarPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// withEnvVars gives each flag an environment variable that's used when the
+// flag isn't passed, so options can be set once for a whole CI pipeline
+func withEnvVars(flags []cli.Flag) []cli.Flag {
+	for i, f := range flags {
+		switch f := f.(type) {
+		case cli.BoolFlag:
+			f.EnvVar = flagEnvVar(f.Name)
+			flags[i] = f
+		case cli.BoolTFlag:
+			f.EnvVar = flagEnvVar(f.Name)
+			flags[i] = f
+		case cli.StringFlag:
+			f.EnvVar = flagEnvVar(f.Name)
+			flags[i] = f
+		case cli.StringSliceFlag:
+			f.EnvVar = flagEnvVar(f.Name)
+			flags[i] = f
+		case cli.IntFlag:
+			f.EnvVar = flagEnvVar(f.Name)
+			flags[i] = f
+		case cli.Int64Flag:
+			f.EnvVar = flagEnvVar(f.Name)
+			flags[i] = f
+		case cli.DurationFlag:
+			f.EnvVar = flagEnvVar(f.Name)
+			flags[i] = f
+		}
+	}
+	return flags
+}
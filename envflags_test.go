@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestFlagEnvVar(t *testing.T) {
+	for name, expected := range map[string]string{
+		"cluster, c":              "ECS_RUN_TASK_CLUSTER",
+		"security-group":          "ECS_RUN_TASK_SECURITY_GROUP",
+		"wait-for-stable-service": "ECS_RUN_TASK_WAIT_FOR_STABLE_SERVICE",
+	} {
+		if actual := flagEnvVar(name); actual != expected {
+			t.Errorf("Expected %s for %q, got %s", expected, name, actual)
+		}
+	}
+}
+
+func TestWithEnvVars(t *testing.T) {
+	flags := withEnvVars([]cli.Flag{
+		cli.StringFlag{Name: "cluster, c"},
+		cli.StringSliceFlag{Name: "subnet"},
+		cli.BoolTFlag{Name: "wait"},
+	})
+
+	if f := flags[0].(cli.StringFlag); f.EnvVar != "ECS_RUN_TASK_CLUSTER" {
+		t.Errorf("Unexpected env var %q for --cluster", f.EnvVar)
+	}
+	if f := flags[1].(cli.StringSliceFlag); f.EnvVar != "ECS_RUN_TASK_SUBNET" {
+		t.Errorf("Unexpected env var %q for --subnet", f.EnvVar)
+	}
+	if f := flags[2].(cli.BoolTFlag); f.EnvVar != "ECS_RUN_TASK_WAIT" {
+		t.Errorf("Unexpected env var %q for --wait", f.EnvVar)
+	}
+}
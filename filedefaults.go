@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/buildkite/ecs-run-task/parser"
+	"github.com/buildkite/ecs-run-task/runner"
+	"github.com/urfave/cli"
+)
+
+// applyFileDefaults applies the run settings in the x-ecs-run-task block of a
+// task definition file. Flags and their environment variables take
+// precedence, and the file takes precedence over ecs-cli configuration. The
+// cluster is left to where targets are chosen.
+func applyFileDefaults(ctx *cli.Context, r *runner.Runner, ext *parser.Extensions) error {
+	if !ctx.IsSet("fargate") && !ctx.IsSet("container-instance") {
+		switch ext.LaunchType {
+		case "":
+		case ecs.LaunchTypeFargate:
+			r.Fargate = true
+		case ecs.LaunchTypeEc2:
+			r.Fargate = false
+		default:
+			return fmt.Errorf("%s.launchType must be FARGATE or EC2, not %q", parser.ExtensionsKey, ext.LaunchType)
+		}
+	}
+
+	if !ctx.IsSet("count") && ext.Count > 0 {
+		r.Count = ext.Count
+	}
+
+	if len(ext.Tags) > 0 {
+		r.Tags = ext.Tags
+	}
+
+	if o := ext.Overrides; o != nil {
+		if !ctx.IsSet("service") && o.Service != "" {
+			r.Service = o.Service
+		}
+		if len(ctx.Args()) == 0 && len(o.Command) > 0 {
+			log.Printf("Using command from %s", parser.ExtensionsKey)
+			r.Overrides = append(r.Overrides, runner.Override{
+				Service: r.Service,
+				Command: o.Command,
+			})
+		}
+		r.Environment = mergeEnvironment(o.Environment, r.Environment)
+	}
+
+	if nc := ext.NetworkConfiguration; nc != nil && !ctx.IsSet("network-from") {
+		switch nc.AssignPublicIp {
+		case "", ecs.AssignPublicIpEnabled, ecs.AssignPublicIpDisabled:
+		default:
+			return fmt.Errorf("%s.networkConfiguration.assignPublicIp must be ENABLED or DISABLED, not %q", parser.ExtensionsKey, nc.AssignPublicIp)
+		}
+		log.Printf("Using network configuration from %s", parser.ExtensionsKey)
+		if !ctx.IsSet("subnet") && len(nc.Subnets) > 0 {
+			r.Subnets = nc.Subnets
+		}
+		if !ctx.IsSet("security-group") && len(nc.SecurityGroups) > 0 {
+			r.SecurityGroups = nc.SecurityGroups
+		}
+		r.AssignPublicIP = nc.AssignPublicIp
+	}
+	return nil
+}
+
+// mergeEnvironment returns the `KEY=value` or `KEY` variables of defaults
+// that aren't in env, followed by env
+func mergeEnvironment(defaults, env []string) []string {
+	set := map[string]bool{}
+	for _, e := range env {
+		set[strings.SplitN(e, "=", 2)[0]] = true
+	}
+
+	var merged []string
+	for _, e := range defaults {
+		if !set[strings.SplitN(e, "=", 2)[0]] {
+			merged = append(merged, e)
+		}
+	}
+	return append(merged, env...)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/ecs-run-task/parser"
+	"github.com/buildkite/ecs-run-task/runner"
+	"github.com/urfave/cli"
+)
+
+func TestApplyFileDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filedefaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "taskdefinition.yml")
+	err = ioutil.WriteFile(file, []byte(`family: migrate
+containerDefinitions:
+  - name: app
+    image: app:latest
+x-ecs-run-task:
+  networkConfiguration:
+    subnets: [$SUBNET]
+    securityGroups: [sg-from-file]
+    assignPublicIp: DISABLED
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := []string{"SUBNET=subnet-1"}
+	input, err := parser.Parse(file, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(input.ContainerDefinitions) != 1 {
+		t.Fatalf("Unexpected task definition %v", input)
+	}
+	ext, err := parser.ParseExtensions(file, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.String("security-group", "", "")
+	if err := set.Parse([]string{"--security-group", "sg-from-flag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := runner.New()
+	r.SecurityGroups = []string{"sg-from-flag"}
+	if err := applyFileDefaults(cli.NewContext(nil, set, nil), r, ext); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Subnets) != 1 || r.Subnets[0] != "subnet-1" {
+		t.Errorf("Expected subnets from the file, got %v", r.Subnets)
+	}
+	if len(r.SecurityGroups) != 1 || r.SecurityGroups[0] != "sg-from-flag" {
+		t.Errorf("Expected the flag to take precedence, got %v", r.SecurityGroups)
+	}
+	if r.AssignPublicIP != "DISABLED" {
+		t.Errorf("Expected public IPs to be disabled, got %q", r.AssignPublicIP)
+	}
+}
+
+func TestApplyFileDefaultsInvalid(t *testing.T) {
+	ext := &parser.Extensions{NetworkConfiguration: &parser.NetworkConfiguration{AssignPublicIp: "yes"}}
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	if err := applyFileDefaults(cli.NewContext(nil, set, nil), runner.New(), ext); err == nil {
+		t.Fatal("Expected an invalid assignPublicIp to be an error")
+	}
+}
+
+func TestApplyFileDefaultsRunSettings(t *testing.T) {
+	ext := &parser.Extensions{
+		LaunchType: "FARGATE",
+		Count:      3,
+		Tags:       map[string]string{"team": "data"},
+		Overrides: &parser.Overrides{
+			Service:     "app",
+			Command:     []string{"./migrate.sh"},
+			Environment: []string{"RAILS_ENV=production", "DRY_RUN=1"},
+		},
+	}
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.Int64("count", 1, "")
+	if err := set.Parse([]string{"--count", "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := runner.New()
+	r.Count = 2
+	r.Environment = []string{"DRY_RUN=0"}
+	if err := applyFileDefaults(cli.NewContext(nil, set, nil), r, ext); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Fargate || r.Count != 2 || r.Tags["team"] != "data" || r.Service != "app" {
+		t.Errorf("Unexpected runner %+v", r)
+	}
+	if len(r.Overrides) != 1 || r.Overrides[0].Service != "app" || r.Overrides[0].Command[0] != "./migrate.sh" {
+		t.Errorf("Unexpected overrides %+v", r.Overrides)
+	}
+	if expected := "RAILS_ENV=production DRY_RUN=0"; strings.Join(r.Environment, " ") != expected {
+		t.Errorf("Expected environment %q, got %q", expected, r.Environment)
+	}
+}
@@ -3,10 +3,16 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
+	"github.com/buildkite/ecs-run-task/parser"
 	"github.com/buildkite/ecs-run-task/runner"
 	"github.com/urfave/cli"
 )
@@ -22,38 +28,166 @@ func main() {
 	app.UsageText = "ecs-run-task [options] [command override]"
 	app.Version = Version
 
-	app.Flags = []cli.Flag{
+	app.Flags = withEnvVars([]cli.Flag{
 		cli.BoolFlag{
 			Name:  "debug",
 			Usage: "Show debugging information",
 		},
+		cli.BoolFlag{
+			Name:  "explain-exit-codes",
+			Usage: "Print what each exit status means and exit",
+		},
 		cli.StringFlag{
 			Name:  "file, f",
 			Usage: "Task definition file in JSON or YAML",
 		},
 		cli.StringFlag{
-			Name:  "name, n",
-			Usage: "Task name",
+			Name:  "task-definition",
+			Usage: "Run an existing task definition as it's registered, without registering a new revision, given as `FAMILY[:REVISION]` or an ARN",
+		},
+		cli.StringFlag{
+			Name:  "from-family",
+			Usage: "Use the latest revision of an existing task definition family instead of a file",
+		},
+		cli.Int64Flag{
+			Name:  "revision",
+			Usage: "With --from-family, use revision `N` of the family rather than its latest, such as to roll back to a known-good revision",
+		},
+		cli.BoolFlag{
+			Name:  "allow-inactive",
+			Usage: "Allow --revision to run a revision that has been deregistered",
+		},
+		cli.BoolFlag{
+			Name:  "force-register",
+			Usage: "Register a new revision of the task definition even if the family's latest revision has the same content, rather than reusing it",
+		},
+		cli.IntFlag{
+			Name:  "revision-retention",
+			Usage: "Once a run passes, deregister revisions of the task definition's family beyond the newest `N`, keeping any that a service uses",
+		},
+		cli.StringFlag{
+			Name:  "from-service",
+			Usage: "Run a task like an existing service, using its task definition, network configuration and launch type, in the form `[CLUSTER/]SERVICE`",
+		},
+		cli.StringSliceFlag{
+			Name:  "image",
+			Usage: "Replace a container's image, in the form `[CONTAINER=]IMAGE`. Without a container name, replaces the image of containers using the same repository. Can be specified multiple times",
+		},
+		cli.StringSliceFlag{
+			Name:  "inference-accelerator",
+			Usage: "Add an Elastic Inference accelerator to the task definition, in the form `DEVICE=TYPE`. Can be specified multiple times",
+		},
+		cli.StringSliceFlag{
+			Name:  "firelens-option",
+			Usage: "Set an option of the FireLens log router, in the form `[CONTAINER:]KEY=VALUE`. Can be specified multiple times",
+		},
+		cli.BoolFlag{
+			Name:  "disable-proxy",
+			Usage: "Remove the task definition's proxy configuration and proxy container, such as App Mesh's Envoy, for tasks that don't need mesh routing",
+		},
+		cli.StringFlag{
+			Name:  "pid-mode",
+			Usage: "Set the task's PID namespace mode, either `host` or task",
+		},
+		cli.StringFlag{
+			Name:  "ipc-mode",
+			Usage: "Set the task's IPC namespace mode, either `host`, task or none",
+		},
+		cli.BoolFlag{
+			Name:  "init-process",
+			Usage: "Run an init process in each container that forwards signals and reaps processes",
+		},
+		cli.StringSliceFlag{
+			Name:  "shared-memory-size",
+			Usage: "Set the size of a container's /dev/shm, in the form `[CONTAINER=]MIB`. Can be specified multiple times",
+		},
+		cli.StringSliceFlag{
+			Name:  "tmpfs",
+			Usage: "Add a tmpfs mount to a container, in the form `[CONTAINER=]PATH:MIB[:OPTION,...]`. Can be specified multiple times",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-add",
+			Usage: "Add a Linux capability to a container, such as SYS_PTRACE for a profiler, in the form `[CONTAINER=]CAPABILITY`. Can be specified multiple times",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-drop",
+			Usage: "Drop a Linux capability from a container, in the form `[CONTAINER=]CAPABILITY`. Can be specified multiple times",
 		},
 		cli.StringFlag{
+			Name:  "name, n",
+			Usage: "Task name, used as the prefix of log streams. Can be a template using {{.Family}}, {{.Cluster}}, {{.Date}}, {{.GitSHA}}, {{.BuildID}}, {{.Attempt}} and {{.RunID}}",
+		},
+		cli.BoolFlag{
+			Name:  "log-stream-per-attempt",
+			Usage: "Add the attempt to log stream names, from CI's count of retries of the job, so that each retry's logs are in their own streams",
+		},
+		cli.StringSliceFlag{
 			Name:  "cluster, c",
-			Value: "default",
-			Usage: "ECS cluster name",
+			Usage: "ECS cluster name or ARN (default: \"default\"). Can be specified multiple times to run against several clusters in parallel",
+		},
+		cli.StringFlag{
+			Name:  "targets-file",
+			Usage: "YAML or JSON file listing clusters (and optionally regions) to run against in parallel",
 		},
 		cli.StringFlag{
 			Name:  "log-group, l",
 			Value: "ecs-task-runner",
 			Usage: "Cloudwatch Log Group Name to write logs to",
 		},
+		cli.StringFlag{
+			Name:  "log-region",
+			Usage: "Region of the log group, if it's different from the tasks' region",
+		},
+		cli.StringFlag{
+			Name:  "log-role",
+			Usage: "ARN of a role to assume for CloudWatch Logs, for a log group owned by another account such as a central logging account",
+		},
+		cli.StringSliceFlag{
+			Name:  "log-container",
+			Usage: "Only send logs from this container to the log group and stream them, leaving other containers' log configuration alone. Can be specified multiple times",
+		},
 		cli.StringFlag{
 			Name:  "service, s",
 			Value: "",
 			Usage: "service to replace cmd for",
 		},
+		cli.BoolFlag{
+			Name:  "no-ecs-cli-config",
+			Usage: "Don't default the cluster, region, launch type and network configuration from ~/.ecs/config and ecs-params.yml",
+		},
 		cli.BoolFlag{
 			Name:  "fargate",
 			Usage: "Specified if task is to be run under FARGATE as opposed to EC2",
 		},
+		cli.StringSliceFlag{
+			Name:  "container-instance",
+			Usage: "Start the task on a specific EC2 container instance ID or ARN with StartTask, rather than letting ECS place it. Can be specified multiple times to start a task on each",
+		},
+		cli.BoolFlag{
+			Name:  "skip-capacity-check",
+			Usage: "Don't check that a container instance in an EC2 cluster has the architecture, attributes, CPU and memory to run the task before running it",
+		},
+		cli.BoolFlag{
+			Name:  "skip-secrets-check",
+			Usage: "Don't check that the parameters and secrets that containers read with valueFrom exist before running",
+		},
+		cli.StringFlag{
+			Name:  "no-tasks",
+			Value: runner.NoTasksFail,
+			Usage: "What to do when there are no tasks to run, because --count is 0 or --targets-file has no targets. Either `fail` with exit status 73, or `succeed` for batch jobs where that's expected",
+		},
+		cli.DurationFlag{
+			Name:  "wait-for-capacity",
+			Usage: "When tasks can't be placed on an EC2 cluster, keep retrying for this long while a capacity provider with managed scaling adds instances",
+		},
+		cli.BoolFlag{
+			Name:  "use-cluster-default-strategy",
+			Usage: "Run tasks without a launch type or capacity provider strategy, even one from --from-service, so the cluster's default capacity provider strategy decides where they run",
+		},
+		cli.BoolFlag{
+			Name:  "scale-in-protection",
+			Usage: "Protect the EC2 instances that tasks run on from scale-in by their auto scaling group until the tasks stop, so a capacity provider can't reclaim them mid-run",
+		},
 		cli.StringSliceFlag{
 			Name:  "security-group",
 			Usage: "Security groups to launch task in (required for FARGATE). Can be specified multiple times",
@@ -62,10 +196,51 @@ func main() {
 			Name:  "subnet",
 			Usage: "Subnet to launch task in (required for FARGATE). Can be specified multiple times",
 		},
+		cli.StringFlag{
+			Name:  "network-from",
+			Usage: "Copy subnets, security groups and public IP assignment from a running `service:NAME` or `task:ARN`",
+		},
+		cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "Add environment variables from a `FILE` of KEY=value lines. Can be specified multiple times, with later files taking precedence over earlier ones, and --env over all of them",
+		},
+		cli.BoolFlag{
+			Name:  "print-resolved-env",
+			Usage: "Print the environment variables given to the task and where each came from before running it, with values that look like secrets redacted",
+		},
 		cli.StringSliceFlag{
 			Name:  "env, e",
 			Usage: "An environment variable to add in the form `KEY=value` or `KEY` (shorthand for `KEY=$KEY` to pass through an env var from the current host). Can be specified multiple times",
 		},
+		cli.StringSliceFlag{
+			Name:  "ssm-env",
+			Usage: "An SSM parameter to add as an environment variable, either `/PATH/NAME`, `KEY=/PATH/NAME` or `/PATH/*` for every parameter under a path. SecureString parameters are decrypted. Can be specified multiple times",
+		},
+		cli.StringSliceFlag{
+			Name:  "secret-env",
+			Usage: "A secret to add as environment variables, in the form `SCHEME:REF` where SCHEME is ssm, secretsmanager, vault or sops. Can be specified multiple times",
+		},
+		cli.StringSliceFlag{
+			Name:  "init-command",
+			Usage: "Run a `COMMAND` with sh -c in an init container with the main container's image before it starts, such as to download fixtures. The main container is the --service one, or the only one. Can be specified multiple times to run several in order, each only if the one before succeeded",
+		},
+		cli.StringFlag{
+			Name:  "artifacts",
+			Usage: "Upload the `PATH` directory of the main container to S3 once its command exits and download it, wrapping the command with sh, tar and curl from its image. The main container is the --service one, or the only one",
+		},
+		cli.StringFlag{
+			Name:  "artifacts-bucket",
+			Usage: "Upload --artifacts through `BUCKET[/PREFIX]`, which should be in the cluster's region",
+		},
+		cli.StringFlag{
+			Name:  "artifacts-dest",
+			Value: "artifacts",
+			Usage: "Extract --artifacts into `DIR`",
+		},
+		cli.BoolFlag{
+			Name:  "interpolate-command",
+			Usage: "Expand $VAR and ${VAR} in the command override from the task's environment and the calling shell's. Use $$ for a literal $",
+		},
 		cli.BoolFlag{
 			Name:  "inherit-env, E",
 			Usage: "Inherit all of the environment variables from the calling shell",
@@ -75,13 +250,363 @@ func main() {
 			Value: 1,
 			Usage: "Number of tasks to run",
 		},
+		cli.StringFlag{
+			Name:  "reference-id",
+			Usage: "Set the reference `ID` of the tasks, such as a build or deployment ID, so that other systems can find them",
+		},
+		cli.StringFlag{
+			Name:  "started-by",
+			Usage: "Set who or what started the tasks, which ECS lets tasks be listed by",
+		},
+		cli.StringFlag{
+			Name:  "client-token",
+			Usage: "Start tasks with a `TOKEN` that makes RunTask idempotent, so that retrying the same run with the same token doesn't start the tasks again",
+		},
+		cli.BoolFlag{
+			Name:  "ecs-managed-tags",
+			Usage: "Tag the tasks with their cluster and service with ECS managed tags",
+		},
+		cli.StringFlag{
+			Name:  "wait-for-stable-service",
+			Usage: "Wait until a service has no deployments in progress before running the task, in the form `[CLUSTER/]SERVICE`",
+		},
+		cli.DurationFlag{
+			Name:  "stable-service-timeout",
+			Value: 30 * time.Minute,
+			Usage: "How long to wait for --wait-for-stable-service before giving up",
+		},
+		cli.BoolTFlag{
+			Name:  "wait",
+			Usage: "Wait for tasks to stop and exit with their status. With --wait=false logs are followed until interrupted",
+		},
+		cli.BoolFlag{
+			Name:  "detach",
+			Usage: "Start the tasks, print their ARNs to stdout and exit, without following their logs or waiting for them",
+		},
+		cli.StringFlag{
+			Name:  "wait-for",
+			Value: runner.WaitForStopped,
+			Usage: "What to wait for once tasks start, either `stopped` to exit with their status, essential to exit once their essential containers have stopped without waiting for sidecars to drain, or running to exit once they're all running, leaving them running",
+		},
+		cli.StringFlag{
+			Name:  "health-check",
+			Usage: "With --wait-for running, wait until each task passes a health check on its IP address, in the form `tcp:PORT` or http:PORT[/PATH]",
+		},
+		cli.DurationFlag{
+			Name:  "health-check-timeout",
+			Value: 2 * time.Minute,
+			Usage: "How long to wait for each task to pass --health-check before giving up",
+		},
+		cli.BoolFlag{
+			Name:  "task-events",
+			Usage: "See task state changes as they happen through a temporary EventBridge rule and SQS queue, rather than by describing tasks every few seconds",
+		},
+		cli.BoolFlag{
+			Name:  "aggregate-logs",
+			Usage: "Print identical log lines from several containers once, annotated with how many times they were seen, such as [x3]",
+		},
+		cli.Int64Flag{
+			Name:  "max-log-lines",
+			Usage: "Once a container has logged more than this many lines, only print the first and last half of them. 0 is unlimited",
+		},
+		cli.BoolFlag{
+			Name:  "fail-fast",
+			Usage: "Stop all tasks as soon as an essential or log-streamed container exits with a non-zero status",
+		},
+		cli.BoolFlag{
+			Name:  "ephemeral-logs",
+			Usage: "Delete the log streams of containers once their logs have been printed in full, to keep log groups from growing",
+		},
+		cli.BoolFlag{
+			Name:  "no-logs",
+			Usage: "Don't stream logs from CloudWatch",
+		},
+		cli.StringFlag{
+			Name:  "log-fetch-strategy",
+			Value: runner.LogFetchFilter,
+			Usage: "How to fetch containers' logs, which have separate quotas. Either `filter` to poll FilterLogEvents, get to poll GetLogEvents for each container's stream, which has more generous quotas at scale, or live-tail to stream them from a Live Tail session",
+		},
+		cli.BoolFlag{
+			Name:  "no-wait-logs",
+			Usage: "Exit with the tasks' status as soon as they stop, rather than waiting until their logs have been printed in full",
+		},
+		cli.DurationFlag{
+			Name:  "stuck-logs-timeout",
+			Value: 5 * time.Minute,
+			Usage: "Once tasks stop, stop following the logs of a container that has had no new events for this long, rather than waiting for them forever. 0 waits forever",
+		},
+		cli.BoolFlag{
+			Name:  "no-finish-message",
+			Usage: "Don't write a message to each container's log stream once it exits to mark the end of its logs, which needs logs:PutLogEvents, and stop following its logs once it stops instead, which may miss its last lines",
+		},
+		cli.DurationFlag{
+			Name:  "describe-grace-period",
+			Value: 30 * time.Second,
+			Usage: "How long to keep describing tasks that ECS reports as missing right after they are started",
+		},
+		cli.StringFlag{
+			Name:  "missing-exit-code",
+			Value: runner.MissingExitCodeFail,
+			Usage: "What to do when a container stops without an exit code, such as when it never started. Either `fail` with exit status 255, or `ignore` and print why it stopped",
+		},
+		cli.DurationFlag{
+			Name:  "pull-warning-threshold",
+			Value: 2 * time.Minute,
+			Usage: "Warn when pulling a task's images takes longer than this",
+		},
+		cli.StringFlag{
+			Name:  "soci-check",
+			Usage: "Check ECR images for SOCI indexes before running, one of `report`, `warn` or `fail` for large images without one",
+		},
+		cli.Int64Flag{
+			Name:  "soci-min-image-size",
+			Value: 250,
+			Usage: "Size in MiB above which an image without a SOCI index is warned about or fails the SOCI check",
+		},
+		cli.StringFlag{
+			Name:  "dump-task-state",
+			Usage: "Save the state of tasks as JSON files in a `DIR` once they start, whenever a task or container's status changes and once they stop",
+		},
+		cli.BoolFlag{
+			Name:  "cache",
+			Usage: "Skip running and replay the output of an earlier successful run with the same task definition, cluster, command and environment",
+		},
+		cli.StringFlag{
+			Name:  "cache-dir",
+			Usage: "Directory to cache the output of successful runs in with --cache (default: ecs-run-task in the user's cache directory)",
+		},
+		cli.DurationFlag{
+			Name:  "cache-ttl",
+			Value: 24 * time.Hour,
+			Usage: "How long a cached run can be replayed for with --cache",
+		},
+		cli.StringFlag{
+			Name:  "insights-query",
+			Usage: "Once the tasks have stopped, run the CloudWatch Logs Insights query in `FILE` over their log streams and print the results",
+		},
+		cli.DurationFlag{
+			Name:  "cloudtrail-lookup",
+			Usage: "After the run, wait up to this long for CloudTrail to record the RegisterTaskDefinition and RunTask calls and print their event IDs",
+		},
+		cli.BoolFlag{
+			Name:  "console-links",
+			Usage: "Print links to each task and its containers' log streams in the AWS console once tasks have started",
+		},
+		cli.StringFlag{
+			Name:  "summary-file",
+			Usage: "Write the outcome of the run as JSON to `FILE` once it finishes, including the state of each task and container and where their logs are",
+		},
+		cli.StringFlag{
+			Name:  "support-bundle",
+			Usage: "Write a zip to `FILE` when the run fails with what's needed to debug it: the task definition, RunTask input, each change in the tasks' state, a timeline, debug messages and the last lines of each container's logs, with environment values redacted",
+		},
+		cli.StringFlag{
+			Name:  "support-bundle-when",
+			Value: runner.SupportBundleOnFailure,
+			Usage: "When to write --support-bundle, either `failure` or always",
+		},
+		cli.StringFlag{
+			Name:  "state-file",
+			Usage: "Keep the tasks and how far their logs have been printed in `FILE` while the run goes, so `ecs-run-task resume FILE` can pick it up if it's interrupted. It's removed once the run finishes",
+		},
+		cli.StringFlag{
+			Name:  "save-task-definition",
+			Usage: "Write the task definition that tasks are run with to `FILE` as JSON, as ECS describes it with its ARN and revision, so later steps can use the same revision",
+		},
+		cli.StringFlag{
+			Name:  "pre-hook",
+			Usage: "Run `COMMAND` with the shell before running any tasks, stopping the run if it fails",
+		},
+		cli.StringFlag{
+			Name:  "post-hook",
+			Usage: "Run `COMMAND` with the shell once the run finishes, with its summary as JSON on stdin and its exit code in $ECS_RUN_TASK_HOOK_EXIT_CODE",
+		},
+		cli.StringFlag{
+			Name:  "events-file",
+			Usage: "Write task and container status changes as they happen to `FILE` as newline-delimited JSON",
+		},
+		cli.BoolFlag{
+			Name:  "show-rerun",
+			Usage: "Print an equivalent command line once the run finishes, with options from the environment and ecs-cli configuration spelled out and --env values redacted, to reproduce the run elsewhere",
+		},
+		cli.StringFlag{
+			Name:   "simulate",
+			Usage:  "Run against a fake backend that fails with `MODE`, one of placement-failure, pull-error, oom or timeout, to test how failures are handled",
+			Hidden: true,
+		},
+		cli.StringFlag{
+			Name:  "timings-file",
+			Usage: "Write how long each container's task ran to `FILE`, keyed by task definition family and command",
+		},
+		cli.StringFlag{
+			Name:  "timings-format",
+			Value: runner.TimingsFormatBuildkite,
+			Usage: "Format of --timings-file, either `buildkite` for Buildkite Test Analytics JSON or `csv`",
+		},
+	})
+
+	app.Commands = []cli.Command{
+		{
+			Name:      "grep",
+			Usage:     "Search the CloudWatch Logs of a task's containers, while it's running or after it has stopped",
+			ArgsUsage: "TASK PATTERN",
+			Description: "Searches each container's log stream with a CloudWatch Logs filter pattern, such as '\"step 7\"'. " +
+				"TASK is a task ARN, or a task ID in the cluster given with --cluster. Exits with 1 if nothing matched.",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "context, C",
+					Usage: "Print `NUM` lines around each match",
+				},
+			},
+			Action: grepAction,
+		},
+		{
+			Name:      "resume",
+			Usage:     "Pick up a run from its --state-file, such as after ecs-run-task was killed, waiting for its tasks and printing their logs from where they were left",
+			ArgsUsage: "STATE_FILE",
+			Action:    resumeAction,
+		},
+		{
+			Name:  "attach",
+			Usage: "Follow tasks that were started some other way, such as by Step Functions, printing their logs and exiting with their status",
+			Description: "Skips registering a task definition and running tasks, and follows the tasks given with --task-arn as if " +
+				"ecs-run-task had started them, with the same exit codes. Logs are printed from the containers that use the awslogs driver.",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "task-arn",
+					Usage: "The `ARN` of a task to follow, or its ID in --cluster. Can be given more than once, for tasks in the same cluster",
+				},
+				cli.StringFlag{
+					Name:  "cluster, c",
+					Usage: "The cluster of tasks given by ID, rather than the global --cluster. Task ARNs say their cluster",
+				},
+			},
+			Action: attachAction,
+		},
+		{
+			Name:  "doctor",
+			Usage: "Check that ecs-run-task can run tasks with your credentials, region, cluster, log group, subnets and security groups, without running any",
+			Description: "Uses the same options and ECS_RUN_TASK_* variables as running a task, such as --cluster, --log-group, --subnet and " +
+				"--security-group, and prints whether each check passed. Exits with 1 if any failed.",
+			Action: doctorAction,
+		},
+		{
+			Name:      "pipeline",
+			Usage:     "Print the command that runs the same task as a step of a Buildkite pipeline that uses the ecs-run-task plugin, or run it with --run",
+			ArgsUsage: "PIPELINE_FILE",
+			Description: "Each of the plugin's options is passed as the ecs-run-task flag of the same name, and the step's command is " +
+				"split into words as the task's command. Environment variables are interpolated as when the pipeline is uploaded, " +
+				"and the step's env is set when it's run.",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "step",
+					Usage: "The `KEY` or label of the step to run, when more than one step uses the plugin",
+				},
+				cli.BoolFlag{
+					Name:  "run",
+					Usage: "Run the step's task rather than printing the command that runs it",
+				},
+			},
+			Action: pipelineAction,
+		},
+		{
+			Name:  "self-update",
+			Usage: "Replace this binary with the latest release from GitHub, after verifying its signature and checksum",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "Replace the binary even if it's already up to date or is a development build",
+				},
+			},
+			Action: selfUpdateAction,
+		},
 	}
 
+	app.Before = checkProjectConfig
+
 	app.Action = func(ctx *cli.Context) error {
-		requireFlagValue(ctx, "file")
+		if ctx.Bool("explain-exit-codes") {
+			explainExitCodes(os.Stdout)
+			return nil
+		}
 
-		if _, err := os.Stat(ctx.String("file")); err != nil {
-			return cli.NewExitError(err, 1)
+		var sources int
+		for _, name := range []string{"file", "task-definition", "from-family", "from-service"} {
+			if ctx.String(name) != "" {
+				sources++
+			}
+		}
+		if sources > 1 {
+			return cli.NewExitError("Only one of --file, --task-definition, --from-family or --from-service can be used", 1)
+		}
+
+		if sources == 0 {
+			requireFlagValue(ctx, "file")
+		}
+		if ctx.String("file") != "" {
+			if _, err := os.Stat(ctx.String("file")); err != nil {
+				return cli.NewExitError(err, 1)
+			}
+		}
+
+		switch ctx.String("missing-exit-code") {
+		case runner.MissingExitCodeFail, runner.MissingExitCodeIgnore:
+		default:
+			return cli.NewExitError(fmt.Sprintf("Invalid --missing-exit-code value %q", ctx.String("missing-exit-code")), 1)
+		}
+
+		switch ctx.String("log-fetch-strategy") {
+		case runner.LogFetchFilter, runner.LogFetchGet, runner.LogFetchLiveTail:
+		default:
+			return cli.NewExitError(fmt.Sprintf("Invalid --log-fetch-strategy value %q", ctx.String("log-fetch-strategy")), 1)
+		}
+
+		switch ctx.String("no-tasks") {
+		case runner.NoTasksFail, runner.NoTasksSucceed:
+		default:
+			return cli.NewExitError(fmt.Sprintf("Invalid --no-tasks value %q", ctx.String("no-tasks")), 1)
+		}
+
+		switch ctx.String("soci-check") {
+		case "", runner.SOCICheckReport, runner.SOCICheckWarn, runner.SOCICheckFail:
+		default:
+			return cli.NewExitError(fmt.Sprintf("Invalid --soci-check value %q", ctx.String("soci-check")), 1)
+		}
+
+		switch ctx.String("timings-format") {
+		case runner.TimingsFormatBuildkite, runner.TimingsFormatCSV:
+		default:
+			return cli.NewExitError(fmt.Sprintf("Invalid --timings-format value %q", ctx.String("timings-format")), 1)
+		}
+
+		switch ctx.String("pid-mode") {
+		case "", runner.NamespaceModeHost, runner.NamespaceModeTask:
+		default:
+			return cli.NewExitError(fmt.Sprintf("Invalid --pid-mode value %q", ctx.String("pid-mode")), 1)
+		}
+
+		switch ctx.String("ipc-mode") {
+		case "", runner.NamespaceModeHost, runner.NamespaceModeTask, runner.NamespaceModeNone:
+		default:
+			return cli.NewExitError(fmt.Sprintf("Invalid --ipc-mode value %q", ctx.String("ipc-mode")), 1)
+		}
+
+		switch ctx.String("support-bundle-when") {
+		case runner.SupportBundleOnFailure, runner.SupportBundleAlways:
+		default:
+			return cli.NewExitError(fmt.Sprintf("Invalid --support-bundle-when value %q", ctx.String("support-bundle-when")), 1)
+		}
+
+		switch ctx.String("wait-for") {
+		case runner.WaitForStopped, runner.WaitForEssential, runner.WaitForRunning:
+		default:
+			return cli.NewExitError(fmt.Sprintf("Invalid --wait-for value %q", ctx.String("wait-for")), 1)
+		}
+
+		switch ctx.String("simulate") {
+		case "", runner.SimulatePlacementFailure, runner.SimulatePullError, runner.SimulateOOM, runner.SimulateTimeout:
+		default:
+			return cli.NewExitError(fmt.Sprintf("Invalid --simulate value %q", ctx.String("simulate")), 1)
 		}
 
 		if !ctx.Bool("debug") {
@@ -90,34 +615,223 @@ func main() {
 
 		r := runner.New()
 		r.TaskDefinitionFile = ctx.String("file")
-		r.Cluster = ctx.String("cluster")
+		r.ExistingTaskDefinition = ctx.String("task-definition")
+		r.Family = ctx.String("from-family")
+		r.Revision = ctx.Int64("revision")
+		r.AllowInactive = ctx.Bool("allow-inactive")
+		r.ForceRegister = ctx.Bool("force-register")
+		r.RevisionRetention = ctx.Int("revision-retention")
+		r.Images = ctx.StringSlice("image")
+		r.InferenceAccelerators = ctx.StringSlice("inference-accelerator")
+		r.FirelensOptions = ctx.StringSlice("firelens-option")
+		r.DisableProxy = ctx.Bool("disable-proxy")
+		r.PidMode = ctx.String("pid-mode")
+		r.IpcMode = ctx.String("ipc-mode")
+		r.InitProcessEnabled = ctx.Bool("init-process")
+		r.SharedMemorySizes = ctx.StringSlice("shared-memory-size")
+		r.Tmpfs = ctx.StringSlice("tmpfs")
+		r.CapAdd = ctx.StringSlice("cap-add")
+		r.CapDrop = ctx.StringSlice("cap-drop")
+
+		serviceCluster, service := runner.ParseServiceRef(ctx.String("from-service"))
+		r.FromService = service
 		r.TaskName = ctx.String("name")
+		r.LogStreamPerAttempt = ctx.Bool("log-stream-per-attempt")
+		r.Service = ctx.String("service")
+		r.InitCommands = ctx.StringSlice("init-command")
+		r.ArtifactsPath = ctx.String("artifacts")
+		r.ArtifactsBucket = ctx.String("artifacts-bucket")
+		r.ArtifactsDest = ctx.String("artifacts-dest")
 		r.LogGroupName = ctx.String("log-group")
+		r.LogContainers = ctx.StringSlice("log-container")
+		r.LogRegion = ctx.String("log-region")
+		r.LogRoleARN = ctx.String("log-role")
 		r.Fargate = ctx.Bool("fargate")
+		r.ContainerInstances = ctx.StringSlice("container-instance")
+		r.SkipCapacityCheck = ctx.Bool("skip-capacity-check")
+		r.SkipSecretsCheck = ctx.Bool("skip-secrets-check")
+		r.WaitForCapacity = ctx.Duration("wait-for-capacity")
+		r.ScaleInProtection = ctx.Bool("scale-in-protection")
+		r.UseClusterDefaultStrategy = ctx.Bool("use-cluster-default-strategy")
 		r.SecurityGroups = ctx.StringSlice("security-group")
 		r.Subnets = ctx.StringSlice("subnet")
-		r.Environment = ctx.StringSlice("env")
+		r.NetworkFrom = ctx.String("network-from")
+		env, envSources, err := resolveEnvironment(ctx.StringSlice("env-file"), ctx.StringSlice("env"))
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		r.Environment = env
+		r.SSMEnvironment = ctx.StringSlice("ssm-env")
+		r.SecretEnvironment = ctx.StringSlice("secret-env")
 		r.Count = ctx.Int64("count")
+		r.ReferenceID = ctx.String("reference-id")
+		r.StartedBy = ctx.String("started-by")
+		r.ClientToken = ctx.String("client-token")
+		r.ECSManagedTags = ctx.Bool("ecs-managed-tags")
+		r.MissingExitCode = ctx.String("missing-exit-code")
+		r.NoTasks = ctx.String("no-tasks")
+		r.DescribeGracePeriod = ctx.Duration("describe-grace-period")
+		r.NoWait = !ctx.BoolT("wait")
+		r.Detach = ctx.Bool("detach")
+		r.WaitFor = ctx.String("wait-for")
+		r.HealthCheck = ctx.String("health-check")
+		r.HealthCheckTimeout = ctx.Duration("health-check-timeout")
+		r.TaskEvents = ctx.Bool("task-events")
+		r.WaitForStableService = ctx.String("wait-for-stable-service")
+		r.StableServiceTimeout = ctx.Duration("stable-service-timeout")
+		r.NoLogs = ctx.Bool("no-logs")
+		r.NoFinishMessage = ctx.Bool("no-finish-message")
+		r.NoWaitLogs = ctx.Bool("no-wait-logs")
+		r.LogFetchStrategy = ctx.String("log-fetch-strategy")
+		r.StuckLogsTimeout = ctx.Duration("stuck-logs-timeout")
+		r.EphemeralLogs = ctx.Bool("ephemeral-logs")
+		r.FailFast = ctx.Bool("fail-fast")
+		r.AggregateLogs = ctx.Bool("aggregate-logs")
+		r.MaxLogLines = ctx.Int64("max-log-lines")
+		r.PullWarningThreshold = ctx.Duration("pull-warning-threshold")
+		r.SOCICheck = ctx.String("soci-check")
+		r.SOCIMinImageSize = ctx.Int64("soci-min-image-size") * 1024 * 1024
+		r.DumpTaskState = ctx.String("dump-task-state")
+		r.CloudTrailLookup = ctx.Duration("cloudtrail-lookup")
+		r.SummaryFile = ctx.String("summary-file")
+		r.EventsFile = ctx.String("events-file")
+		r.StateFile = ctx.String("state-file")
+		r.SaveTaskDefinition = ctx.String("save-task-definition")
+		r.PreHook = ctx.String("pre-hook")
+		r.PostHook = ctx.String("post-hook")
+		r.SupportBundle = ctx.String("support-bundle")
+		r.SupportBundleWhen = ctx.String("support-bundle-when")
+		r.ConsoleLinks = ctx.Bool("console-links")
+		r.Simulate = ctx.String("simulate")
+
+		if ctx.Bool("cache") {
+			r.ResultCacheDir = ctx.String("cache-dir")
+			if r.ResultCacheDir == "" {
+				dir, err := os.UserCacheDir()
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				r.ResultCacheDir = filepath.Join(dir, "ecs-run-task")
+			}
+			r.ResultCacheTTL = ctx.Duration("cache-ttl")
+		}
+
+		if file := ctx.String("insights-query"); file != "" {
+			query, err := ioutil.ReadFile(file)
+			if err != nil {
+				return cli.NewExitError(err, 1)
+			}
+			r.InsightsQuery = string(query)
+		}
+		r.TimingsFile = ctx.String("timings-file")
+		r.TimingsFormat = ctx.String("timings-format")
+
+		ecsCLI := &ecsCLIDefaults{}
+		if !ctx.Bool("no-ecs-cli-config") {
+			var err error
+			if ecsCLI, err = loadECSCLIDefaults(); err != nil {
+				return cli.NewExitError(err, 1)
+			}
+		}
+		if !ctx.IsSet("fargate") && !ctx.IsSet("container-instance") && !ctx.IsSet("use-cluster-default-strategy") && ecsCLI.LaunchType == "FARGATE" {
+			r.Fargate = true
+		}
+		if !ctx.IsSet("subnet") && !ctx.IsSet("network-from") && len(ecsCLI.Subnets) > 0 {
+			r.Subnets = ecsCLI.Subnets
+		}
+		if !ctx.IsSet("security-group") && !ctx.IsSet("network-from") && len(ecsCLI.SecurityGroups) > 0 {
+			r.SecurityGroups = ecsCLI.SecurityGroups
+		}
+
+		ext := &parser.Extensions{}
+		if file := ctx.String("file"); file != "" {
+			var err error
+			if ext, err = parser.ParseExtensions(file, os.Environ()); err != nil {
+				return cli.NewExitError(err, 1)
+			}
+			if err := applyFileDefaults(ctx, r, ext); err != nil {
+				return cli.NewExitError(err, 1)
+			}
+			for _, v := range r.Environment {
+				if _, ok := envSources[envName(v)]; !ok {
+					envSources[envName(v)] = parser.ExtensionsKey
+				}
+			}
+		}
 
 		if ctx.Bool("inherit-env") {
 			for _, env := range os.Environ() {
 				r.Environment = append(r.Environment, env)
+				if _, ok := envSources[envName(env)]; !ok {
+					envSources[envName(env)] = "--inherit-env"
+				}
 			}
 		}
 
+		if ctx.Bool("print-resolved-env") {
+			printResolvedEnv(os.Stderr, r.Environment, envSources)
+		}
+
+		r.InterpolateCommand = ctx.Bool("interpolate-command")
+
 		if args := ctx.Args(); len(args) > 0 {
 			r.Overrides = append(r.Overrides, runner.Override{
-				Service: ctx.String("service"),
+				Service: r.Service,
 				Command: args,
 			})
 		}
 
-		if err := r.Run(context.Background()); err != nil {
+		if err := validateFlags(ctx, r); err != nil {
+			return err
+		}
+
+		var targets []runner.Target
+		if file := ctx.String("targets-file"); file != "" {
+			var err error
+			if targets, err = runner.LoadTargets(file); err != nil {
+				return cli.NewExitError(err, 1)
+			}
+		}
+		for _, cluster := range ctx.StringSlice("cluster") {
+			targets = append(targets, runner.TargetForCluster(cluster))
+		}
+
+		// an empty targets file means there's nothing to run, rather than
+		// running against a default cluster
+		if ctx.String("targets-file") == "" {
+			if len(targets) == 0 && serviceCluster != "" {
+				targets = append(targets, runner.TargetForCluster(serviceCluster))
+			}
+			if len(targets) == 0 && ext.Cluster != "" {
+				targets = append(targets, runner.TargetForCluster(ext.Cluster))
+			}
+			if len(targets) == 0 && ecsCLI.Cluster != "" {
+				target := runner.TargetForCluster(ecsCLI.Cluster)
+				if os.Getenv("AWS_REGION") == "" && target.Region == "" {
+					target.Region = ecsCLI.Region
+				}
+				targets = append(targets, target)
+			}
+			if len(targets) == 0 {
+				targets = append(targets, runner.TargetForCluster("default"))
+			}
+		}
+
+		r.RerunCommand = rerunCommand(ctx, r, targets)
+
+		runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		err = r.RunTargets(runCtx, targets)
+		if ctx.Bool("show-rerun") {
+			fmt.Fprintf(os.Stderr, "To run this again: %s\n", r.RerunCommand)
+		}
+		if err != nil {
 			if ec, ok := err.(cli.ExitCoder); ok {
 				return ec
 			}
 			fmt.Fprintln(os.Stderr, err.Error())
-			os.Exit(1)
+			os.Exit(runner.ExitCode(err))
 		}
 		return nil
 	}
@@ -129,6 +843,125 @@ func main() {
 	}
 }
 
+func grepAction(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		return cli.NewExitError("Usage: ecs-run-task grep TASK PATTERN", 1)
+	}
+	if !ctx.GlobalBool("debug") {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	r := runner.New()
+	r.Cluster = "default"
+	r.LogRegion = ctx.GlobalString("log-region")
+	r.LogRoleARN = ctx.GlobalString("log-role")
+	if clusters := ctx.GlobalStringSlice("cluster"); len(clusters) > 0 {
+		r.Cluster = clusters[0]
+	}
+
+	runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	matches, err := r.Grep(runCtx, ctx.Args().Get(0), ctx.Args().Get(1), ctx.Int("context"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(runner.ExitCode(err))
+	}
+	if matches == 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func resumeAction(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return cli.NewExitError("Usage: ecs-run-task resume STATE_FILE", 1)
+	}
+	if !ctx.GlobalBool("debug") {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	r := runner.New()
+	r.LogRegion = ctx.GlobalString("log-region")
+	r.LogRoleARN = ctx.GlobalString("log-role")
+	r.SummaryFile = ctx.GlobalString("summary-file")
+	r.EventsFile = ctx.GlobalString("events-file")
+	r.MissingExitCode = ctx.GlobalString("missing-exit-code")
+	r.StuckLogsTimeout = ctx.GlobalDuration("stuck-logs-timeout")
+
+	runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := r.Resume(runCtx, ctx.Args().Get(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(runner.ExitCode(err))
+	}
+	return nil
+}
+
+func attachAction(ctx *cli.Context) error {
+	tasks := ctx.StringSlice("task-arn")
+	if len(tasks) == 0 || len(ctx.Args()) > 0 {
+		return cli.NewExitError("Usage: ecs-run-task attach --task-arn ARN [--task-arn ARN...]", 1)
+	}
+	if !ctx.GlobalBool("debug") {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	r, err := attachRunner(ctx)
+	if err != nil {
+		return err
+	}
+
+	runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := r.Attach(runCtx, tasks); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(runner.ExitCode(err))
+	}
+	return nil
+}
+
+// attachRunner returns a runner for the attach command, in the cluster from
+// its --cluster, or the global --cluster, which can only be one cluster
+func attachRunner(ctx *cli.Context) (*runner.Runner, error) {
+	r := runner.New()
+	r.Cluster = ctx.String("cluster")
+	if r.Cluster == "" {
+		clusters := ctx.GlobalStringSlice("cluster")
+		if len(clusters) > 1 {
+			return nil, cli.NewExitError("Tasks can only be attached to in one --cluster at a time", 1)
+		}
+		if len(clusters) == 1 {
+			r.Cluster = clusters[0]
+		}
+	}
+	if r.Cluster == "" {
+		r.Cluster = "default"
+	}
+	r.LogRegion = ctx.GlobalString("log-region")
+	r.LogRoleARN = ctx.GlobalString("log-role")
+	r.LogFetchStrategy = ctx.GlobalString("log-fetch-strategy")
+	r.StateFile = ctx.GlobalString("state-file")
+	r.SummaryFile = ctx.GlobalString("summary-file")
+	r.EventsFile = ctx.GlobalString("events-file")
+	r.MissingExitCode = ctx.GlobalString("missing-exit-code")
+	r.StuckLogsTimeout = ctx.GlobalDuration("stuck-logs-timeout")
+	return r, nil
+}
+
+func explainExitCodes(w io.Writer) {
+	fmt.Fprintf(w, "%-7s %s\n", "0", "Every container exited with 0")
+	fmt.Fprintf(w, "%-7s %s\n", "1-255", "The exit code of the first container that exited non-zero")
+	fmt.Fprintf(w, "%-7s %s\n", fmt.Sprint(runner.ExitCodeError), "Invalid options, or an error that isn't covered below")
+	fmt.Fprintf(w, "%-7s %s\n", fmt.Sprint(runner.ExitCodeAPI), "An AWS API call failed")
+	fmt.Fprintf(w, "%-7s %s\n", fmt.Sprint(runner.ExitCodeTimeout), "Timed out, such as waiting for a service to be stable or a log stream")
+	fmt.Fprintf(w, "%-7s %s\n", fmt.Sprint(runner.ExitCodePlacement), "Some or all tasks failed to start, such as when the cluster has no capacity")
+	fmt.Fprintf(w, "%-7s %s\n", fmt.Sprint(runner.ExitCodeNoTasks), "There were no tasks to run, unless --no-tasks=succeed")
+	fmt.Fprintf(w, "%-7s %s\n", "255", "A container stopped without an exit code, unless --missing-exit-code=ignore")
+}
+
 func requireFlagValue(ctx *cli.Context, name string) {
 	if ctx.String(name) == "" {
 		fmt.Fprintf(os.Stderr, "ERROR: Required flag %q isn't set\n\n", name)
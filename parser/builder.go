@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Builder constructs a task definition from a JSON or YAML file, with changes
+// made on top of it, for programs that set up tasks without writing files.
+// Nothing is parsed until Build or Validate is called, and each call parses
+// it again, so a Builder can be built from more than once.
+type Builder struct {
+	body    []byte
+	err     error
+	env     []string
+	expand  bool
+	changes []func(*ecs.RegisterTaskDefinitionInput) error
+}
+
+// LoadFile returns a Builder for a task definition file. Any error reading
+// it is returned by Build.
+func LoadFile(file string) *Builder {
+	body, err := ioutil.ReadFile(file)
+	return &Builder{body: body, err: err}
+}
+
+// FromReader returns a Builder for a task definition read from r
+func FromReader(r io.Reader) *Builder {
+	body, err := ioutil.ReadAll(r)
+	return &Builder{body: body, err: err}
+}
+
+// WithEnvExpansion interpolates variables such as $IMAGE or ${TAG:-latest}
+// from env, as Parse does, which otherwise are left as they are
+func (b *Builder) WithEnvExpansion(env []string) *Builder {
+	b.env = env
+	b.expand = true
+	return b
+}
+
+// SetImage sets the image of the named container
+func (b *Builder) SetImage(container, image string) *Builder {
+	return b.change(container, func(def *ecs.ContainerDefinition) {
+		def.Image = aws.String(image)
+	})
+}
+
+// SetLogConfig sets the log configuration of the named container, such as to
+// send its logs to a different awslogs group
+func (b *Builder) SetLogConfig(container string, lc *ecs.LogConfiguration) *Builder {
+	return b.change(container, func(def *ecs.ContainerDefinition) {
+		def.LogConfiguration = lc
+	})
+}
+
+func (b *Builder) change(container string, fn func(def *ecs.ContainerDefinition)) *Builder {
+	b.changes = append(b.changes, func(input *ecs.RegisterTaskDefinitionInput) error {
+		for _, def := range input.ContainerDefinitions {
+			if aws.StringValue(def.Name) == container {
+				fn(def)
+				return nil
+			}
+		}
+		return fmt.Errorf("No container named %q in the task definition", container)
+	})
+	return b
+}
+
+// Build returns the task definition with the changes made to it, or the
+// first error reading, parsing or changing it
+func (b *Builder) Build() (*ecs.RegisterTaskDefinitionInput, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	jsonBytes, err := bodyToJSON(b.body, b.env, b.expand)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ecs.RegisterTaskDefinitionInput
+
+	// And then into the task definition 👌🏻 🤞🏻
+	if err := json.Unmarshal(jsonBytes, &result); err != nil {
+		return nil, err
+	}
+
+	for _, change := range b.changes {
+		if err := change(&result); err != nil {
+			return nil, err
+		}
+	}
+	return &result, nil
+}
+
+// Validate builds the task definition and checks it has what ECS needs to
+// register it, returning every problem at once
+func (b *Builder) Validate() error {
+	input, err := b.Build()
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	if aws.StringValue(input.Family) == "" {
+		problems = append(problems, "The task definition has no family")
+	}
+	if len(input.ContainerDefinitions) == 0 {
+		problems = append(problems, "The task definition has no container definitions")
+	}
+	names := map[string]bool{}
+	for i, def := range input.ContainerDefinitions {
+		name := aws.StringValue(def.Name)
+		if name == "" {
+			problems = append(problems, fmt.Sprintf("Container definition %d has no name", i+1))
+			name = fmt.Sprintf("%d", i+1)
+		} else if names[name] {
+			problems = append(problems, fmt.Sprintf("More than one container is named %s", name))
+		}
+		names[name] = true
+
+		if aws.StringValue(def.Image) == "" {
+			problems = append(problems, fmt.Sprintf("Container %s has no image", name))
+		}
+		if def.LogConfiguration != nil && aws.StringValue(def.LogConfiguration.LogDriver) == "" {
+			problems = append(problems, fmt.Sprintf("Container %s has a log configuration without a log driver", name))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
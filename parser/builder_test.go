@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const testTaskDefinition = `
+family: app
+containerDefinitions:
+  - name: app
+    image: my-org/app:${TAG}
+  - name: sidecar
+    image: my-org/sidecar
+`
+
+func TestBuilder(t *testing.T) {
+	input, err := FromReader(strings.NewReader(testTaskDefinition)).
+		WithEnvExpansion([]string{"TAG=v1"}).
+		SetImage("sidecar", "my-org/sidecar:v2").
+		SetLogConfig("app", &ecs.LogConfiguration{LogDriver: aws.String("awslogs")}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image := aws.StringValue(input.ContainerDefinitions[0].Image); image != "my-org/app:v1" {
+		t.Errorf("Expected the tag to be expanded, got %s", image)
+	}
+	if image := aws.StringValue(input.ContainerDefinitions[1].Image); image != "my-org/sidecar:v2" {
+		t.Errorf("Expected the sidecar's image to be set, got %s", image)
+	}
+	if lc := input.ContainerDefinitions[0].LogConfiguration; lc == nil || aws.StringValue(lc.LogDriver) != "awslogs" {
+		t.Errorf("Expected the app's log configuration to be set, got %v", lc)
+	}
+}
+
+func TestBuilderWithoutEnvExpansion(t *testing.T) {
+	input, err := FromReader(strings.NewReader(testTaskDefinition)).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image := aws.StringValue(input.ContainerDefinitions[0].Image); image != "my-org/app:${TAG}" {
+		t.Errorf("Expected the tag to be left as it is, got %s", image)
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing.yml")).Build(); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing file error, got %v", err)
+	}
+
+	_, err := FromReader(strings.NewReader(testTaskDefinition)).SetImage("db", "postgres").Build()
+	if err == nil || !strings.Contains(err.Error(), `"db"`) {
+		t.Errorf("Expected an error about the missing container, got %v", err)
+	}
+}
+
+func TestBuilderValidate(t *testing.T) {
+	if err := FromReader(strings.NewReader(testTaskDefinition)).Validate(); err != nil {
+		t.Errorf("Expected a valid task definition, got %v", err)
+	}
+
+	err := FromReader(strings.NewReader(`
+containerDefinitions:
+  - name: app
+  - name: app
+    image: my-org/app
+    logConfiguration: {}
+`)).Validate()
+	if err == nil {
+		t.Fatal("Expected the task definition to be invalid")
+	}
+	for _, problem := range []string{"no family", "More than one container is named app", "Container app has no image", "without a log driver"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected %q in %v", problem, err)
+		}
+	}
+}
@@ -10,39 +10,93 @@ import (
 	"github.com/ghodss/yaml"
 )
 
+// ExtensionsKey is the top-level key of a task definition file that holds
+// settings for running it, which aren't part of the task definition
+const ExtensionsKey = "x-ecs-run-task"
+
+// Extensions are the settings for running a task definition from its file
+type Extensions struct {
+	Cluster              string                `json:"cluster,omitempty"`
+	LaunchType           string                `json:"launchType,omitempty"`
+	Count                int64                 `json:"count,omitempty"`
+	Tags                 map[string]string     `json:"tags,omitempty"`
+	Overrides            *Overrides            `json:"overrides,omitempty"`
+	NetworkConfiguration *NetworkConfiguration `json:"networkConfiguration,omitempty"`
+}
+
+// Overrides are the defaults for overriding the main container
+type Overrides struct {
+	Service     string   `json:"service,omitempty"`
+	Command     []string `json:"command,omitempty"`
+	Environment []string `json:"environment,omitempty"`
+}
+
+// NetworkConfiguration is the awsvpc network configuration to run tasks with
+type NetworkConfiguration struct {
+	Subnets        []string `json:"subnets,omitempty"`
+	SecurityGroups []string `json:"securityGroups,omitempty"`
+	AssignPublicIp string   `json:"assignPublicIp,omitempty"`
+}
+
+// Parse returns the task definition in a JSON or YAML file, with variables
+// interpolated from env
 func Parse(file string, env []string) (*ecs.RegisterTaskDefinitionInput, error) {
-	body, err := ioutil.ReadFile(file)
+	return LoadFile(file).WithEnvExpansion(env).Build()
+}
+
+// ParseExtensions returns the x-ecs-run-task block of a task definition
+// file, which is empty if there isn't one
+func ParseExtensions(file string, env []string) (*Extensions, error) {
+	jsonBytes, err := parseToJSON(file, env)
 	if err != nil {
 		return nil, err
 	}
 
-	interpolated, err := interpolate.Interpolate(
-		interpolate.NewSliceEnv(env),
-		string(body),
-	)
-	if err != nil {
+	var wrapper map[string]json.RawMessage
+	if err := json.Unmarshal(jsonBytes, &wrapper); err != nil {
 		return nil, err
 	}
 
-	unmarshaled, err := unmarshal([]byte(interpolated))
-	if err != nil {
-		return nil, err
+	var result Extensions
+	if raw, ok := wrapper[ExtensionsKey]; ok {
+		if err := json.Unmarshal(raw, &result); err != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %v", ExtensionsKey, err)
+		}
 	}
 
-	// Return to json which aws will parse
-	jsonBytes, err := json.Marshal(unmarshaled)
+	return &result, nil
+}
+
+// parseToJSON reads a JSON or YAML file with variables interpolated from env
+func parseToJSON(file string, env []string) ([]byte, error) {
+	body, err := ioutil.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
+	return bodyToJSON(body, env, true)
+}
 
-	var result ecs.RegisterTaskDefinitionInput
+// bodyToJSON converts JSON or YAML to JSON, interpolating variables from env
+// if expand is set
+func bodyToJSON(body []byte, env []string, expand bool) ([]byte, error) {
+	if expand {
+		interpolated, err := interpolate.Interpolate(
+			interpolate.NewSliceEnv(env),
+			string(body),
+		)
+		if err != nil {
+			return nil, err
+		}
+		body = []byte(interpolated)
+	}
 
-	// And then into the task definition 👌🏻 🤞🏻
-	if err = json.Unmarshal(jsonBytes, &result); err != nil {
+	unmarshaled, err := unmarshal(body)
+	if err != nil {
 		return nil, err
 	}
 
-	return &result, nil
+	// Return to json which aws will parse
+	return json.Marshal(unmarshaled)
 }
 
 func unmarshal(body []byte) (interface{}, error) {
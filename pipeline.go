@@ -0,0 +1,384 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/buildkite/interpolate"
+	"github.com/ghodss/yaml"
+	"github.com/urfave/cli"
+)
+
+// pipelinePlugin is the name of the Buildkite plugin that runs ecs-run-task,
+// whose options are ecs-run-task's flags
+const pipelinePlugin = "ecs-run-task"
+
+// pipelineStep is a step of a Buildkite pipeline that uses the plugin
+type pipelineStep struct {
+	Label    string
+	Key      string
+	Env      map[string]string
+	Commands []string
+	Options  map[string]interface{}
+}
+
+// name returns how the step is referred to, by its key or label
+func (s *pipelineStep) name() string {
+	if s.Key != "" {
+		return s.Key
+	}
+	if s.Label != "" {
+		return s.Label
+	}
+	return "without a key or label"
+}
+
+// readPipelineSteps returns the steps of a Buildkite pipeline that use the
+// plugin, with environment variables interpolated the way the agent does
+// when the pipeline is uploaded
+func readPipelineSteps(file string, env []string) ([]*pipelineStep, error) {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	interpolated, err := interpolate.Interpolate(interpolate.NewSliceEnv(env), string(body))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to interpolate %s: %v", file, err)
+	}
+
+	var pipeline interface{}
+	if err := yaml.Unmarshal([]byte(interpolated), &pipeline); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", file, err)
+	}
+	// a pipeline is either a list of steps, or has them under steps
+	if m, ok := pipeline.(map[string]interface{}); ok {
+		pipeline = m["steps"]
+	}
+	steps, ok := pipeline.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s isn't a pipeline with steps", file)
+	}
+	return pipelineSteps(steps)
+}
+
+func pipelineSteps(steps []interface{}) ([]*pipelineStep, error) {
+	var found []*pipelineStep
+	for _, s := range steps {
+		// steps like wait are strings
+		m, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if group, ok := m["steps"].([]interface{}); ok {
+			inGroup, err := pipelineSteps(group)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, inGroup...)
+			continue
+		}
+
+		step := &pipelineStep{}
+		step.Label, _ = m["label"].(string)
+		step.Key, _ = m["key"].(string)
+		options, ok, err := pluginOptions(m["plugins"])
+		if err != nil {
+			return nil, fmt.Errorf("Step %s: %v", step.name(), err)
+		}
+		if !ok {
+			continue
+		}
+		step.Options = options
+
+		step.Env = map[string]string{}
+		if env, ok := m["env"].(map[string]interface{}); ok {
+			for k, v := range env {
+				step.Env[k] = fmt.Sprint(v)
+			}
+		}
+		for _, key := range []string{"command", "commands"} {
+			switch c := m[key].(type) {
+			case string:
+				step.Commands = append(step.Commands, c)
+			case []interface{}:
+				for _, v := range c {
+					step.Commands = append(step.Commands, fmt.Sprint(v))
+				}
+			}
+		}
+		found = append(found, step)
+	}
+	return found, nil
+}
+
+// pluginOptions returns the options of the plugin in a step's plugins, which
+// are either a list or a map of plugins to their options
+func pluginOptions(plugins interface{}) (map[string]interface{}, bool, error) {
+	var entries []interface{}
+	switch p := plugins.(type) {
+	case []interface{}:
+		entries = p
+	case map[string]interface{}:
+		entries = []interface{}{p}
+	}
+
+	for _, entry := range entries {
+		var named map[string]interface{}
+		switch e := entry.(type) {
+		case string:
+			named = map[string]interface{}{e: nil}
+		case map[string]interface{}:
+			named = e
+		}
+		for name, options := range named {
+			if !isPipelinePlugin(name) {
+				continue
+			}
+			if options == nil {
+				return map[string]interface{}{}, true, nil
+			}
+			m, ok := options.(map[string]interface{})
+			if !ok {
+				return nil, false, fmt.Errorf("The options of plugin %s aren't a map", name)
+			}
+			return m, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// isPipelinePlugin returns whether a plugin reference, such as
+// ecs-run-task#v1.2.3 or github.com/org/ecs-run-task-buildkite-plugin, is the
+// plugin
+func isPipelinePlugin(ref string) bool {
+	name := strings.SplitN(ref, "#", 2)[0]
+	name = strings.TrimSuffix(name, ".git")
+	name = name[strings.LastIndex(name, "/")+1:]
+	return strings.TrimSuffix(name, "-buildkite-plugin") == pipelinePlugin
+}
+
+// selectPipelineStep returns the step with a key or label, or the only step
+// if there's one
+func selectPipelineStep(steps []*pipelineStep, file, name string) (*pipelineStep, error) {
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("No steps in %s use the %s plugin", file, pipelinePlugin)
+	}
+	if name == "" {
+		if len(steps) > 1 {
+			var names []string
+			for _, s := range steps {
+				names = append(names, s.name())
+			}
+			return nil, fmt.Errorf("%d steps in %s use the %s plugin, choose one with --step: %s",
+				len(steps), file, pipelinePlugin, strings.Join(names, ", "))
+		}
+		return steps[0], nil
+	}
+	for _, s := range steps {
+		if s.Key == name || s.Label == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("No step with the key or label %q in %s uses the %s plugin", name, file, pipelinePlugin)
+}
+
+// pipelineStepArgs returns the arguments that run a step's task the same way
+// as the plugin does, with each option passed as the flag of the same name,
+// written with dashes or underscores, and the step's command split into
+// words, as the plugin passes it on. Options that aren't flags are errors, so
+// the two can't silently drift apart.
+func pipelineStepArgs(flags []cli.Flag, step *pipelineStep) ([]string, error) {
+	byName := map[string]cli.Flag{}
+	for _, f := range flags {
+		byName[strings.TrimSpace(strings.Split(f.GetName(), ",")[0])] = f
+	}
+
+	var names []string
+	for name := range step.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args []string
+	for _, option := range names {
+		name := strings.Replace(option, "_", "-", -1)
+		f, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("Step %s has the %s plugin option %q, which isn't an ecs-run-task flag", step.name(), pipelinePlugin, option)
+		}
+		value := step.Options[option]
+		switch f.(type) {
+		case cli.BoolFlag:
+			if b, ok := value.(bool); !ok {
+				return nil, fmt.Errorf("Step %s has the %s plugin option %q, which must be true or false", step.name(), pipelinePlugin, name)
+			} else if b {
+				args = append(args, "--"+name)
+			}
+		case cli.BoolTFlag:
+			if b, ok := value.(bool); !ok {
+				return nil, fmt.Errorf("Step %s has the %s plugin option %q, which must be true or false", step.name(), pipelinePlugin, name)
+			} else if !b {
+				args = append(args, "--"+name+"=false")
+			}
+		case cli.StringSliceFlag:
+			values, ok := value.([]interface{})
+			if !ok {
+				values = []interface{}{value}
+			}
+			for _, v := range values {
+				s, err := pipelineOptionValue(step, name, v)
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, "--"+name, s)
+			}
+		default:
+			s, err := pipelineOptionValue(step, name, value)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, "--"+name+"="+s)
+		}
+	}
+
+	if len(step.Commands) > 1 {
+		return nil, fmt.Errorf("Step %s has %d commands, but a task runs one, so join them into a single command run with sh -c", step.name(), len(step.Commands))
+	}
+	if len(step.Commands) == 1 {
+		words, err := splitCommand(strings.TrimSpace(step.Commands[0]))
+		if err != nil {
+			return nil, fmt.Errorf("Step %s has the command %q, which %v, but the task's command isn't run by a shell, so run it with sh -c", step.name(), step.Commands[0], err)
+		}
+		args = append(args, "--")
+		args = append(args, words...)
+	}
+	return args, nil
+}
+
+// splitCommand splits a step's command into words as a POSIX shell would,
+// with quotes and backslashes. A task's command isn't run by a shell, so
+// commands that need one, such as several commands or expansions, are errors.
+func splitCommand(command string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var inWord bool
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case c == '\\':
+			if i++; i == len(command) {
+				return nil, fmt.Errorf("ends with a backslash")
+			}
+			// a line continuation
+			if command[i] == '\n' {
+				continue
+			}
+			word.WriteByte(command[i])
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end == -1 {
+				return nil, fmt.Errorf("has an unterminated quote")
+			}
+			word.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			for i++; ; i++ {
+				if i == len(command) {
+					return nil, fmt.Errorf("has an unterminated quote")
+				}
+				if command[i] == '"' {
+					break
+				}
+				if command[i] == '$' || command[i] == '`' {
+					return nil, fmt.Errorf("has a shell expansion")
+				}
+				if command[i] == '\\' && i+1 < len(command) && strings.IndexByte("\"\\$`\n", command[i+1]) != -1 {
+					i++
+					if command[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(command[i])
+			}
+		case c == '\n' || c == ';' || c == '&' || c == '|':
+			return nil, fmt.Errorf("has more than one command")
+		case c == '$' || c == '`' || c == '<' || c == '>' || c == '(' || c == ')':
+			return nil, fmt.Errorf("has shell syntax")
+		default:
+			word.WriteByte(c)
+		}
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func pipelineOptionValue(step *pipelineStep, name string, v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("Step %s has the %s plugin option %q, which must be a string, number or list of them", step.name(), pipelinePlugin, name)
+}
+
+func pipelineAction(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return cli.NewExitError("Usage: ecs-run-task pipeline [--step KEY] [--run] PIPELINE_FILE", 1)
+	}
+	file := ctx.Args().First()
+
+	steps, err := readPipelineSteps(file, os.Environ())
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	step, err := selectPipelineStep(steps, file, ctx.String("step"))
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	args, err := pipelineStepArgs(ctx.App.Flags, step)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var envNames []string
+	for k := range step.Env {
+		envNames = append(envNames, k)
+	}
+	sort.Strings(envNames)
+
+	if !ctx.Bool("run") {
+		var words []string
+		for _, k := range envNames {
+			parts := strings.SplitN(redactEnv(k+"="+step.Env[k]), "=", 2)
+			words = append(words, parts[0]+"="+shellQuote(parts[1]))
+		}
+		words = append(words, ctx.App.Name)
+		for _, a := range args {
+			words = append(words, shellQuote(a))
+		}
+		fmt.Println(strings.Join(words, " "))
+		return nil
+	}
+
+	for _, k := range envNames {
+		os.Setenv(k, step.Env[k])
+	}
+	return ctx.App.Run(append([]string{ctx.App.Name}, args...))
+}
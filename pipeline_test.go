@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+const testPipeline = `
+steps:
+  - label: ":docker: Build"
+    command: make build
+  - wait
+  - group: Deploy
+    steps:
+      - label: Migrate
+        key: migrate
+        command: bin/migrate --env ${STAGE}
+        env:
+          RAILS_ENV: production
+        plugins:
+          - ecs-run-task#v1.2.0:
+              file: task.yml
+              cluster: [ci, ci-spare]
+              log_group: migrations
+              count: 2
+              wait: false
+              fargate: true
+  - label: Smoke test
+    key: smoke
+    command: bin/smoke
+    plugins:
+      github.com/example/ecs-run-task-buildkite-plugin.git#main:
+        file: smoke.yml
+`
+
+func writeTestPipeline(t *testing.T, body string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "pipeline.yml")
+	if err := ioutil.WriteFile(file, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func testPipelineFlags() []cli.Flag {
+	return withEnvVars([]cli.Flag{
+		cli.StringFlag{Name: "file, f"},
+		cli.StringFlag{Name: "log-group, l"},
+		cli.StringSliceFlag{Name: "cluster, c"},
+		cli.IntFlag{Name: "count, C", Value: 1},
+		cli.BoolTFlag{Name: "wait"},
+		cli.BoolFlag{Name: "fargate"},
+	})
+}
+
+func TestReadPipelineSteps(t *testing.T) {
+	file := writeTestPipeline(t, testPipeline)
+	steps, err := readPipelineSteps(file, []string{"STAGE=staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("Expected 2 steps, got %d", len(steps))
+	}
+
+	_, err = selectPipelineStep(steps, file, "")
+	if err == nil || !strings.Contains(err.Error(), "migrate, smoke") {
+		t.Errorf("Expected an error listing the steps, got %v", err)
+	}
+	if _, err := selectPipelineStep(steps, file, "deploy"); err == nil {
+		t.Error("Expected an error selecting a step that doesn't use the plugin")
+	}
+
+	step, err := selectPipelineStep(steps, file, "Migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(step.Env, map[string]string{"RAILS_ENV": "production"}) {
+		t.Errorf("Unexpected env %v", step.Env)
+	}
+
+	args, err := pipelineStepArgs(testPipelineFlags(), step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"--cluster", "ci", "--cluster", "ci-spare", "--count=2", "--fargate", "--file=task.yml",
+		"--log-group=migrations", "--wait=false", "--", "bin/migrate", "--env", "staging"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %q, got %q", expected, args)
+	}
+
+	step, err = selectPipelineStep(steps, file, "smoke")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, err = pipelineStepArgs(testPipelineFlags(), step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"--file=smoke.yml", "--", "bin/smoke"}; !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %q, got %q", expected, args)
+	}
+}
+
+func TestPipelineStepArgsRejectsUnknownOptions(t *testing.T) {
+	step := &pipelineStep{Key: "deploy", Options: map[string]interface{}{"file": "task.yml", "image": "alpine"}}
+	_, err := pipelineStepArgs(testPipelineFlags(), step)
+	if err == nil || !strings.Contains(err.Error(), `"image"`) {
+		t.Errorf("Expected an error about the image option, got %v", err)
+	}
+
+	step = &pipelineStep{Key: "deploy", Options: map[string]interface{}{"fargate": "yes"}}
+	if _, err := pipelineStepArgs(testPipelineFlags(), step); err == nil {
+		t.Error("Expected an error for a bool option that isn't true or false")
+	}
+
+	step = &pipelineStep{Key: "deploy", Commands: []string{"one", "two"}}
+	if _, err := pipelineStepArgs(testPipelineFlags(), step); err == nil {
+		t.Error("Expected an error for a step with more than one command")
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	for _, tc := range []struct {
+		Command string
+		Words   []string
+		Err     string
+	}{
+		{Command: "bin/migrate --env staging", Words: []string{"bin/migrate", "--env", "staging"}},
+		{Command: `rake "db:seed[users, posts]" 'it''s' a\ b`, Words: []string{"rake", "db:seed[users, posts]", "its", "a b"}},
+		{Command: `echo "say \"hi\"" ''`, Words: []string{"echo", `say "hi"`, ""}},
+		{Command: "bin/migrate \\\n  --env staging", Words: []string{"bin/migrate", "--env", "staging"}},
+		{Command: "bin/migrate && bin/seed", Err: "more than one command"},
+		{Command: "bin/migrate\nbin/seed", Err: "more than one command"},
+		{Command: "bin/migrate > out.log", Err: "shell syntax"},
+		{Command: "echo $HOME", Err: "shell syntax"},
+		{Command: `echo "$HOME"`, Err: "shell expansion"},
+		{Command: `echo "unterminated`, Err: "unterminated quote"},
+	} {
+		words, err := splitCommand(tc.Command)
+		if tc.Err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.Err) {
+				t.Errorf("Expected an error containing %q for %q, got %v", tc.Err, tc.Command, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.Command, err)
+		} else if !reflect.DeepEqual(words, tc.Words) {
+			t.Errorf("Expected %q for %q, got %q", tc.Words, tc.Command, words)
+		}
+	}
+
+	step := &pipelineStep{Key: "migrate", Commands: []string{"bin/migrate; bin/seed"}}
+	if _, err := pipelineStepArgs(testPipelineFlags(), step); err == nil || !strings.Contains(err.Error(), "sh -c") {
+		t.Errorf("Expected an error suggesting sh -c, got %v", err)
+	}
+}
+
+func TestReadPipelineStepsFromList(t *testing.T) {
+	file := writeTestPipeline(t, "- command: echo hi\n  plugins: [ecs-run-task]\n")
+	steps, err := readPipelineSteps(file, os.Environ())
+	if err != nil {
+		t.Fatal(err)
+	}
+	step, err := selectPipelineStep(steps, file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(step.Commands, []string{"echo hi"}) {
+		t.Errorf("Unexpected commands %q", step.Commands)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// projectConfigFile is read from the current directory, so that a project can
+// set requirements for every pipeline that runs tasks from it
+const projectConfigFile = ".ecs-run-task.yml"
+
+const releasesURL = "https://github.com/buildkite/ecs-run-task/releases"
+
+// projectConfig is the project configuration file
+type projectConfig struct {
+	MinVersion string `json:"min_version"`
+}
+
+// checkProjectConfig refuses to run if the project needs a newer version,
+// other than to update to one
+func checkProjectConfig(ctx *cli.Context) error {
+	if ctx.Args().First() == "self-update" {
+		return nil
+	}
+
+	var config projectConfig
+	found, err := readYAMLIfExists(projectConfigFile, &config)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	if !found || config.MinVersion == "" {
+		return nil
+	}
+	return checkMinVersion(Version, config.MinVersion)
+}
+
+// checkMinVersion returns an error if version is older than min. Development
+// builds don't have a version to compare, so they're always allowed.
+func checkMinVersion(version, min string) error {
+	want, ok := parseVersion(min)
+	if !ok {
+		return cli.NewExitError(fmt.Sprintf("Invalid min_version %q in %s, expected a version like 1.8.0", min, projectConfigFile), 1)
+	}
+	have, ok := parseVersion(version)
+	if !ok {
+		return nil
+	}
+	if compareVersions(have, want) < 0 {
+		return cli.NewExitError(fmt.Sprintf(
+			"This project needs ecs-run-task %s or later, as set by min_version in %s, but this is %s.\n"+
+				"Run ecs-run-task self-update, download the latest release from %s, or update the version installed on your agents.",
+			strings.TrimPrefix(min, "v"), projectConfigFile, strings.TrimPrefix(version, "v"), releasesURL), 1)
+	}
+	return nil
+}
+
+// parseVersion parses MAJOR[.MINOR[.PATCH]] with an optional v prefix,
+// ignoring any pre-release or build suffix such as from git describe
+func parseVersion(version string) ([3]int, bool) {
+	var parts [3]int
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "-+"); i != -1 {
+		version = version[:i]
+	}
+	fields := strings.Split(version, ".")
+	if version == "" || len(fields) > 3 {
+		return parts, false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckMinVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version, min string
+		fails        bool
+	}{
+		{"1.8.0", "1.8.0", false},
+		{"v1.9.2", "1.8.0", false},
+		{"1.10.0", "1.9", false},
+		{"v1.8.0-4-gabc1234-dirty", "1.8.0", false},
+		{"1.7.9", "1.8.0", true},
+		{"v1.7.2-3-gabc1234", "v1.8", true},
+		{"0.9.0", "1", true},
+		{"", "1.8.0", false},
+		{"dev", "1.8.0", false},
+	} {
+		err := checkMinVersion(tc.version, tc.min)
+		if (err != nil) != tc.fails {
+			t.Errorf("checkMinVersion(%q, %q) = %v, expected failure %v", tc.version, tc.min, err, tc.fails)
+		}
+		if err != nil && !strings.Contains(err.Error(), releasesURL) {
+			t.Errorf("Expected upgrade instructions, got %q", err)
+		}
+	}
+}
+
+func TestCheckMinVersionInvalid(t *testing.T) {
+	for _, min := range []string{"latest", "1.x", "1.2.3.4"} {
+		if err := checkMinVersion("1.8.0", min); err == nil || !strings.Contains(err.Error(), "Invalid min_version") {
+			t.Errorf("Expected min_version %q to be invalid, got %v", min, err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Package report defines the JSON written by ecs-run-task's --summary-file
+// and the NDJSON events written by --events-file, so that other tools can
+// read them.
+//
+// SchemaVersion is bumped when a field changes meaning or is removed. Fields
+// may be added without changing it, so readers should ignore fields they
+// don't know.
+package report
+
+import "time"
+
+// SchemaVersion is the version of the summary and events written
+const SchemaVersion = 1
+
+// Summary is the outcome of an invocation, written once every run has finished
+type Summary struct {
+	SchemaVersion int `json:"schema_version"`
+
+	// RunID identifies the invocation, and is shared by each of its runs,
+	// events and debug messages, and the tags of its tasks if they're tagged
+	RunID string `json:"run_id,omitempty"`
+
+	// ExitCode is the process's exit status, see --explain-exit-codes
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+
+	// Rerun is a command line that runs the same task again, with secret
+	// looking environment values redacted
+	Rerun string `json:"rerun,omitempty"`
+
+	// Runs has a run for each cluster the task was run against
+	Runs []Run `json:"runs"`
+}
+
+// Run is the outcome of running a task against a single cluster
+type Run struct {
+	RunID          string `json:"run_id,omitempty"`
+	Cluster        string `json:"cluster"`
+	Region         string `json:"region,omitempty"`
+	TaskDefinition string `json:"task_definition,omitempty"`
+
+	// Attempt is which attempt at the run this was, counting from 1, such as
+	// when a CI job is retried
+	Attempt int `json:"attempt,omitempty"`
+
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+
+	Tasks []Task `json:"tasks"`
+
+	// Failures are tasks that ECS couldn't place
+	Failures []Failure `json:"failures,omitempty"`
+
+	// CloudTrailEvents are the calls that registered the task definition
+	// and started the tasks, with --cloudtrail-lookup
+	CloudTrailEvents []CloudTrailEvent `json:"cloudtrail_events,omitempty"`
+}
+
+// Task is the last known state of a task that was started
+type Task struct {
+	ARN           string     `json:"arn"`
+	LastStatus    string     `json:"last_status,omitempty"`
+	StopCode      string     `json:"stop_code,omitempty"`
+	StoppedReason string     `json:"stopped_reason,omitempty"`
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	StoppedAt     *time.Time `json:"stopped_at,omitempty"`
+
+	// LaunchType and CapacityProvider are how the task was placed, which
+	// can be decided by the cluster's default capacity provider strategy
+	LaunchType       string `json:"launch_type,omitempty"`
+	CapacityProvider string `json:"capacity_provider,omitempty"`
+
+	// ConsoleURL is the task's detail page in the AWS console
+	ConsoleURL string `json:"console_url,omitempty"`
+
+	Containers []Container `json:"containers"`
+}
+
+// Container is the last known state of a container in a task
+type Container struct {
+	Name       string `json:"name"`
+	LastStatus string `json:"last_status,omitempty"`
+	ExitCode   *int   `json:"exit_code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+
+	// LogGroup and LogStream are where the container's logs were streamed
+	// from, if they were
+	LogGroup  string `json:"log_group,omitempty"`
+	LogStream string `json:"log_stream,omitempty"`
+
+	// LogConsoleURL is the log stream in the AWS console
+	LogConsoleURL string `json:"log_console_url,omitempty"`
+}
+
+// Failure is a task that ECS failed to place
+type Failure struct {
+	ARN    string `json:"arn,omitempty"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// CloudTrailEvent is a call made by a run, and its event in CloudTrail
+type CloudTrailEvent struct {
+	Operation string `json:"operation"`
+	RequestID string `json:"request_id"`
+
+	// EventID is empty if CloudTrail hadn't recorded the call in time
+	EventID string `json:"event_id,omitempty"`
+}
+
+// Types of events
+const (
+	// EventTaskStarted is a task that ECS has accepted
+	EventTaskStarted = "task_started"
+	// EventTaskFailedToStart is a task that ECS couldn't place
+	EventTaskFailedToStart = "task_failed_to_start"
+	// EventTaskStatus is a change in a task's last status
+	EventTaskStatus = "task_status"
+	// EventContainerStatus is a change in a container's last status
+	EventContainerStatus = "container_status"
+	// EventRunFinished is the end of a run against a cluster
+	EventRunFinished = "run_finished"
+	// EventCloudTrailEvent is the CloudTrail event of a call the run made,
+	// looked up after it finishes
+	EventCloudTrailEvent = "cloudtrail_event"
+)
+
+// Statuses of a container's image pull, reported in container_status events
+// before the container's own statuses. ECS only reports when a task's pulls
+// started and stopped, so every container of a task pulls at the same times.
+const (
+	ContainerStatusPulling = "PULLING"
+	ContainerStatusPulled  = "PULLED"
+)
+
+// Event is a line of NDJSON written as a run progresses
+type Event struct {
+	SchemaVersion int       `json:"schema_version"`
+	Time          time.Time `json:"time"`
+	Type          string    `json:"type"`
+	RunID         string    `json:"run_id,omitempty"`
+	Cluster       string    `json:"cluster"`
+
+	Task      string `json:"task,omitempty"`
+	Container string `json:"container,omitempty"`
+	Status    string `json:"status,omitempty"`
+	ExitCode  *int   `json:"exit_code,omitempty"`
+
+	// Reason is why a task stopped or failed to start, or a run failed
+	Reason string `json:"reason,omitempty"`
+
+	// CloudTrailEvent is set for cloudtrail_event events
+	CloudTrailEvent *CloudTrailEvent `json:"cloudtrail_event,omitempty"`
+}
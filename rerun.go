@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/buildkite/ecs-run-task/runner"
+	"github.com/urfave/cli"
+)
+
+// rerunSkipFlags aren't repeated in a re-run command, either because they
+// don't affect the run or because they're spelled out from the runner
+var rerunSkipFlags = map[string]bool{
+	"explain-exit-codes": true,
+	"show-rerun":         true,
+	"print-resolved-env": true,
+	"client-token":       true,
+	"no-ecs-cli-config":  true,
+	"cluster":            true,
+	"fargate":            true,
+	"subnet":             true,
+	"security-group":     true,
+}
+
+// secretEnvName matches environment variable names whose values are redacted
+var secretEnvName = regexp.MustCompile(`(?i)(SECRET|TOKEN|PASSWORD|PASSWD|CREDENTIAL|PRIVATE|API_?KEY|ACCESS_?KEY)`)
+
+// rerunCommand returns a command line that runs the same task again, with
+// options that came from the environment or ecs-cli configuration spelled
+// out so that it can be run from anywhere. Values of --env variables are all
+// redacted, as a name doesn't say whether its value is a secret, and the
+// command ends up in CI logs and summaries.
+func rerunCommand(ctx *cli.Context, r *runner.Runner, targets []runner.Target) string {
+	var args []string
+	if r.Region != "" {
+		args = append(args, "AWS_REGION="+shellQuote(r.Region))
+	}
+	args = append(args, ctx.App.Name)
+
+	for _, f := range ctx.App.Flags {
+		name := strings.TrimSpace(strings.Split(f.GetName(), ",")[0])
+		if rerunSkipFlags[name] {
+			continue
+		}
+
+		switch f.(type) {
+		case cli.BoolFlag:
+			if ctx.Bool(name) {
+				args = append(args, "--"+name)
+			}
+		case cli.BoolTFlag:
+			if !ctx.BoolT(name) {
+				args = append(args, "--"+name+"=false")
+			}
+		case cli.StringSliceFlag:
+			for _, v := range ctx.StringSlice(name) {
+				if name == "env" {
+					v = redactEnvValue(v)
+				}
+				args = append(args, "--"+name, shellQuote(v))
+			}
+		case cli.StringFlag:
+			if ctx.IsSet(name) && ctx.String(name) != "" {
+				args = append(args, "--"+name, shellQuote(ctx.String(name)))
+			}
+		default:
+			if ctx.IsSet(name) {
+				args = append(args, fmt.Sprintf("--%s=%v", name, ctx.Generic(name)))
+			}
+		}
+	}
+
+	// clusters from a targets file are left to the file
+	clusters := ctx.StringSlice("cluster")
+	if len(clusters) == 0 && ctx.String("targets-file") == "" {
+		for _, t := range targets {
+			clusters = append(clusters, t.Cluster)
+		}
+	}
+	for _, c := range clusters {
+		args = append(args, "--cluster", shellQuote(c))
+	}
+	if r.Fargate {
+		args = append(args, "--fargate")
+	}
+	for _, s := range r.Subnets {
+		args = append(args, "--subnet", shellQuote(s))
+	}
+	for _, sg := range r.SecurityGroups {
+		args = append(args, "--security-group", shellQuote(sg))
+	}
+	args = append(args, "--no-ecs-cli-config")
+
+	if len(ctx.Args()) > 0 {
+		args = append(args, "--")
+		for _, a := range ctx.Args() {
+			args = append(args, shellQuote(a))
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// redactEnv hides the value of a KEY=value environment variable if its name
+// looks like a secret
+func redactEnv(env string) string {
+	parts := strings.SplitN(env, "=", 2)
+	if len(parts) == 2 && secretEnvName.MatchString(parts[0]) {
+		return parts[0] + "=[REDACTED]"
+	}
+	return env
+}
+
+// redactEnvValue hides the value of a KEY=value environment variable, leaving
+// the name of one that's passed through from the environment
+func redactEnvValue(env string) string {
+	if i := strings.Index(env, "="); i >= 0 {
+		return env[:i] + "=[REDACTED]"
+	}
+	return env
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes a word for a POSIX shell, if it needs quoting
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
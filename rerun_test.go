@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/buildkite/ecs-run-task/runner"
+	"github.com/urfave/cli"
+)
+
+func TestRerunCommand(t *testing.T) {
+	os.Setenv("ECS_RUN_TASK_LOG_GROUP", "from-env")
+	defer os.Unsetenv("ECS_RUN_TASK_LOG_GROUP")
+
+	app := cli.NewApp()
+	app.Name = "ecs-run-task"
+	app.Flags = withEnvVars([]cli.Flag{
+		cli.StringFlag{Name: "file, f"},
+		cli.StringFlag{Name: "log-group, l", Value: "ecs-task-runner"},
+		cli.StringSliceFlag{Name: "cluster, c"},
+		cli.StringSliceFlag{Name: "env, e"},
+		cli.IntFlag{Name: "count, C", Value: 1},
+		cli.BoolTFlag{Name: "wait"},
+		cli.BoolFlag{Name: "show-rerun"},
+	})
+
+	var actual string
+	app.Action = func(ctx *cli.Context) error {
+		r := runner.New()
+		r.Region = "us-east-1"
+		r.Subnets = []string{"subnet-1"}
+		actual = rerunCommand(ctx, r, []runner.Target{runner.TargetForCluster("ci")})
+		return nil
+	}
+
+	err := app.Run([]string{"ecs-run-task", "-f", "task.yml", "-e", "DB_PASSWORD=hunter2", "-e", "GREETING=hello world",
+		"-e", "BUILDKITE_BUILD_ID", "--wait=false", "--show-rerun", "--", "echo", "it's done"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `AWS_REGION=us-east-1 ecs-run-task --file task.yml --log-group from-env --env 'DB_PASSWORD=[REDACTED]' ` +
+		`--env 'GREETING=[REDACTED]' --env BUILDKITE_BUILD_ID --wait=false --cluster ci --subnet subnet-1 --no-ecs-cli-config -- echo 'it'\''s done'`
+	if actual != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, actual)
+	}
+}
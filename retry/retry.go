@@ -0,0 +1,126 @@
+// Package retry is the backoff that ecs-run-task retries its own operations
+// with, such as writing to CloudWatch Logs while throttled, and how it decides
+// which errors are worth retrying. It's for programs that embed the runner,
+// and hooks written in Go, to retry their own AWS calls the same way.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Backoff is an exponential backoff, with jitter so that clients that failed
+// together don't all retry together
+type Backoff struct {
+	// Initial is the delay before the first retry
+	Initial time.Duration
+
+	// Max caps each delay, which isn't capped if it's 0
+	Max time.Duration
+
+	// Multiplier is how much each delay grows by, doubling if it's 0
+	Multiplier float64
+
+	// Jitter is the fraction of each delay, from 0 to 1, that's taken off at
+	// random
+	Jitter float64
+
+	// Attempts is the most times an operation is tried, including the first,
+	// with no limit if it's 0
+	Attempts int
+
+	// Retryable returns whether an error is worth retrying, which is
+	// IsRetryable if it's nil
+	Retryable func(err error) bool
+}
+
+// Default is the backoff that the runner retries with
+var Default = Backoff{
+	Initial:    time.Second,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+	Attempts:   5,
+}
+
+// Delay returns how long to wait before a retry, where the first retry is 1
+func (b Backoff) Delay(retry int) time.Duration {
+	if retry < 1 {
+		retry = 1
+	}
+	multiplier := b.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	delay := float64(b.Initial) * math.Pow(multiplier, float64(retry-1))
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		delay -= delay * math.Min(b.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// Do calls fn until it succeeds, returns an error that isn't retryable, or
+// has been tried Attempts times, waiting between each try. The last error is
+// returned, or the context's error if it's done while waiting.
+func (b Backoff) Do(ctx context.Context, fn func() error) error {
+	retryable := b.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !retryable(err) || (b.Attempts > 0 && attempt >= b.Attempts) {
+			return err
+		}
+
+		timer := time.NewTimer(b.Delay(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// IsThrottled returns whether an error, or an error it wraps, is an AWS API
+// call being throttled
+func IsThrottled(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	switch aerr.Code() {
+	case "Throttling", "ThrottlingException":
+		return true
+	}
+	return request.IsErrorThrottle(aerr)
+}
+
+// IsRetryable returns whether an error, or an error it wraps, is one that
+// the same call might not fail with again, such as throttling, a server error
+// or a network error. Errors that aren't from AWS aren't retryable.
+func IsRetryable(err error) bool {
+	if IsThrottled(err) {
+		return true
+	}
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	if rf, ok := aerr.(awserr.RequestFailure); ok && rf.StatusCode() >= 500 {
+		return true
+	}
+	return request.IsErrorRetryable(aerr)
+}
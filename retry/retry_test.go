@@ -0,0 +1,90 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestDelay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 5 * time.Second}
+	for retry, expected := range []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		if d := b.Delay(retry); d != expected {
+			t.Errorf("Expected retry %d to wait %v, got %v", retry, expected, d)
+		}
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := b.Delay(2); d < time.Second || d > 2*time.Second {
+			t.Fatalf("Expected a jittered delay between 1s and 2s, got %v", d)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	throttled := awserr.New("ThrottlingException", "Rate exceeded", nil)
+	b := Backoff{Initial: time.Millisecond, Attempts: 3}
+
+	var calls int
+	err := b.Do(context.Background(), func() error {
+		calls++
+		if calls < 2 {
+			return throttled
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("Expected a throttled call to be retried once, got %d calls and %v", calls, err)
+	}
+
+	calls = 0
+	err = b.Do(context.Background(), func() error {
+		calls++
+		return throttled
+	})
+	if err != throttled || calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d calls and %v", calls, err)
+	}
+
+	calls = 0
+	denied := awserr.New("AccessDeniedException", "Not allowed", nil)
+	if err := b.Do(context.Background(), func() error { calls++; return denied }); err != denied || calls != 1 {
+		t.Errorf("Expected an error that isn't retryable to be returned, got %d calls and %v", calls, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.Initial = time.Hour
+	if err := b.Do(ctx, func() error { return throttled }); err != context.Canceled {
+		t.Errorf("Expected the context's error, got %v", err)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		throttled bool
+		retryable bool
+	}{
+		{awserr.New("Throttling", "Rate exceeded", nil), true, true},
+		{fmt.Errorf("PutLogEvents failed: %w", awserr.New("ThrottlingException", "Rate exceeded", nil)), true, true},
+		{awserr.NewRequestFailure(awserr.New("TooManyRequestsException", "Slow down", nil), http.StatusTooManyRequests, "1"), true, true},
+		{awserr.NewRequestFailure(awserr.New("ServerException", "Oops", nil), http.StatusInternalServerError, "1"), false, true},
+		{awserr.New("ClientException", "Bad task definition", nil), false, false},
+		{errors.New("not from AWS"), false, false},
+		{nil, false, false},
+	} {
+		if IsThrottled(tc.err) != tc.throttled {
+			t.Errorf("Expected IsThrottled(%v) to be %v", tc.err, tc.throttled)
+		}
+		if IsRetryable(tc.err) != tc.retryable {
+			t.Errorf("Expected IsRetryable(%v) to be %v", tc.err, tc.retryable)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const defaultAggregateWindow = 5 * time.Second
+
+// logAggregator collapses identical lines printed within a window, such as
+// by several replicas of a task, into a single line annotated with how many
+// times it was seen
+type logAggregator struct {
+	w      io.Writer
+	mu     sync.Mutex
+	lines  []string
+	counts map[string]int
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newLogAggregator(w io.Writer, window time.Duration) *logAggregator {
+	a := &logAggregator{
+		w:      w,
+		counts: map[string]int{},
+		done:   make(chan struct{}),
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ticker := time.NewTicker(window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.flush()
+			case <-a.done:
+				a.flush()
+				return
+			}
+		}
+	}()
+
+	return a
+}
+
+// Println adds a line to be printed when the current window is flushed
+func (a *logAggregator) Println(line string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.counts[line] == 0 {
+		a.lines = append(a.lines, line)
+	}
+	a.counts[line]++
+}
+
+func (a *logAggregator) flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, line := range a.lines {
+		if n := a.counts[line]; n > 1 {
+			fmt.Fprintf(a.w, "%s [x%d]\n", line, n)
+		} else {
+			fmt.Fprintln(a.w, line)
+		}
+	}
+	a.lines = nil
+	a.counts = map[string]int{}
+}
+
+// Close prints any remaining lines and stops flushing
+func (a *logAggregator) Close() {
+	close(a.done)
+	a.wg.Wait()
+}
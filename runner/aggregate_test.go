@@ -0,0 +1,24 @@
+package runner
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestLogAggregatorCollapsesIdenticalLines(t *testing.T) {
+	var buf bytes.Buffer
+	a := newLogAggregator(&buf, time.Hour)
+
+	a.Println("Starting worker")
+	a.Println("Processing shard 1")
+	a.Println("Starting worker")
+	a.Println("Processing shard 2")
+	a.Println("Starting worker")
+	a.Close()
+
+	expected := "Starting worker [x3]\nProcessing shard 1\nProcessing shard 2\n"
+	if buf.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, buf.String())
+	}
+}
@@ -0,0 +1,227 @@
+package runner
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	artifactsURLEnv = "ECS_RUN_TASK_ARTIFACTS_URL"
+	artifactsDirEnv = "ECS_RUN_TASK_ARTIFACTS_DIR"
+
+	// artifactsURLExpiry is how long a task has to upload its artifacts
+	artifactsURLExpiry = 12 * time.Hour
+)
+
+// artifactsWrapper runs a container's command, forwarding signals to it, then
+// uploads the artifacts directory as a tarball to a presigned URL with curl
+// and prints a footer saying whether it did. It needs sh, tar and curl in the
+// image.
+const artifactsWrapper = `"$@" &
+child=$!
+trap 'kill -TERM "$child" 2>/dev/null' TERM INT
+wait "$child"
+status=$?
+if kill -0 "$child" 2>/dev/null; then
+  wait "$child"
+  status=$?
+fi
+uploaded=false
+archive=/tmp/ecs-run-task-artifacts.tar.gz
+if [ -d "$ECS_RUN_TASK_ARTIFACTS_DIR" ] &&
+  tar -czf "$archive" -C "$ECS_RUN_TASK_ARTIFACTS_DIR" . &&
+  curl -sSf -X PUT -T "$archive" "$ECS_RUN_TASK_ARTIFACTS_URL"; then
+  uploaded=true
+fi
+echo "ecs-run-task-artifacts: {\"exit_code\":$status,\"uploaded\":$uploaded}"
+exit $status`
+
+// applyArtifactsWrapper wraps the main container's entrypoint so that the
+// artifacts directory is uploaded once its command exits. The upload URL is
+// only given when the task is run, so the task definition can be reused.
+func (r *Runner) applyArtifactsWrapper(input *ecs.RegisterTaskDefinitionInput) error {
+	if r.ArtifactsPath == "" {
+		return nil
+	}
+
+	def, err := mainContainer(withoutInitContainers(input.ContainerDefinitions, len(r.InitCommands)), r.Service, "artifacts")
+	if err != nil {
+		return err
+	}
+	// the wrapper replaces the image's ENTRYPOINT, so it has to be in the
+	// task definition to still be run
+	if len(def.EntryPoint) == 0 {
+		return fmt.Errorf("Container %s has no entryPoint in its task definition, which --artifacts needs as the upload wrapper replaces the image's ENTRYPOINT. Set it to the image's ENTRYPOINT, or to [\"env\"] for an image without one", aws.StringValue(def.Name))
+	}
+
+	r.logf("Wrapping %s to upload artifacts from %s", aws.StringValue(def.Name), r.ArtifactsPath)
+	def.EntryPoint = append(aws.StringSlice([]string{"sh", "-c", artifactsWrapper, "ecs-run-task"}), def.EntryPoint...)
+	def.Environment = append(def.Environment, &ecs.KeyValuePair{
+		Name:  aws.String(artifactsDirEnv),
+		Value: aws.String(r.ArtifactsPath),
+	})
+	return nil
+}
+
+// overridesCommand returns whether a container's command is overridden
+func (r *Runner) overridesCommand(container string) bool {
+	for _, override := range r.Overrides {
+		if len(override.Command) > 0 && (override.Service == "" || override.Service == container) {
+			return true
+		}
+	}
+	return false
+}
+
+// artifactsUpload is where a run's artifacts are uploaded to
+type artifactsUpload struct {
+	Container string
+	Bucket    string
+	Key       string
+}
+
+// prepareArtifactsUpload presigns a URL for the wrapped container to upload
+// its artifacts to, passing it to the container in an environment variable.
+// The URL can only upload that one object, but anyone who can describe the
+// task can see it, so it's redacted from task dumps.
+func (r *Runner) prepareArtifactsUpload(sess *session.Session, td *preparedTaskDefinition, input *ecs.RunTaskInput) (*artifactsUpload, error) {
+	if r.ArtifactsPath == "" {
+		return nil, nil
+	}
+
+	def, err := mainContainer(withoutInitContainers(td.Containers, len(r.InitCommands)), r.Service, "artifacts")
+	if err != nil {
+		return nil, err
+	}
+
+	bucket, prefix := r.ArtifactsBucket, ""
+	if i := strings.Index(bucket, "/"); i != -1 {
+		bucket, prefix = bucket[:i], strings.Trim(bucket[i+1:], "/")+"/"
+	}
+	upload := &artifactsUpload{
+		Container: aws.StringValue(def.Name),
+		Bucket:    bucket,
+		Key:       fmt.Sprintf("%s%s/%d.tar.gz", prefix, r.Cluster, time.Now().UnixNano()),
+	}
+
+	req, _ := s3.New(sess).PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String(upload.Bucket),
+		Key:    aws.String(upload.Key),
+	})
+	url, err := req.Presign(artifactsURLExpiry)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to presign an upload to s3://%s/%s: %v", upload.Bucket, upload.Key, err)
+	}
+
+	env := &ecs.KeyValuePair{Name: aws.String(artifactsURLEnv), Value: aws.String(url)}
+	for _, override := range input.Overrides.ContainerOverrides {
+		if aws.StringValue(override.Name) == upload.Container {
+			override.Environment = append(override.Environment, env)
+			return upload, nil
+		}
+	}
+	input.Overrides.ContainerOverrides = append(input.Overrides.ContainerOverrides, &ecs.ContainerOverride{
+		Name:        aws.String(upload.Container),
+		Environment: []*ecs.KeyValuePair{env},
+	})
+	return upload, nil
+}
+
+// downloadArtifacts extracts what a task uploaded into the artifacts
+// destination. Artifacts are best effort, so failures are only warned about.
+func (r *Runner) downloadArtifacts(ctx context.Context, sess *session.Session, upload *artifactsUpload) {
+	if upload == nil {
+		return
+	}
+
+	logf(ctx, "Downloading artifacts from s3://%s/%s", upload.Bucket, upload.Key)
+	resp, err := s3.New(sess).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(upload.Bucket),
+		Key:    aws.String(upload.Key),
+	})
+	if err != nil {
+		err = wrapAPIError("GetObject", err)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Code() == s3.ErrCodeNoSuchKey {
+			fmt.Fprintf(r.Stderr, "No artifacts were uploaded by container %s, its output says why\n", upload.Container)
+			return
+		}
+		fmt.Fprintf(r.Stderr, "Failed to download artifacts: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	n, err := extractTarGz(resp.Body, r.ArtifactsDest)
+	if err != nil {
+		fmt.Fprintf(r.Stderr, "Failed to extract artifacts to %s: %v\n", r.ArtifactsDest, err)
+		return
+	}
+	fmt.Fprintf(r.Stderr, "Downloaded %d artifacts from container %s to %s\n", n, upload.Container, r.ArtifactsDest)
+}
+
+// extractTarGz extracts the regular files and directories of a gzipped
+// tarball into dir, refusing paths that would escape it, and returns how many
+// files were extracted
+func extractTarGz(r io.Reader, dir string) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+
+	var files int
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return files, err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return files, fmt.Errorf("Artifact %q is outside of the artifacts directory", hdr.Name)
+		}
+		target := filepath.Join(dir, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return files, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return files, err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm()|0600)
+			if err != nil {
+				return files, err
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return files, err
+			}
+			files++
+		default:
+			log.Printf("Skipping artifact %s, which isn't a regular file or directory", hdr.Name)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestApplyArtifactsWrapper(t *testing.T) {
+	r := &Runner{ArtifactsPath: "/app/out"}
+	input := &ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions: []*ecs.ContainerDefinition{{
+			Name:       aws.String("app"),
+			EntryPoint: aws.StringSlice([]string{"/docker-entrypoint.sh"}),
+			Command:    aws.StringSlice([]string{"make", "test"}),
+		}},
+	}
+	if err := r.applyArtifactsWrapper(input); err != nil {
+		t.Fatal(err)
+	}
+
+	def := input.ContainerDefinitions[0]
+	entrypoint := aws.StringValueSlice(def.EntryPoint)
+	if len(entrypoint) != 5 || entrypoint[0] != "sh" || entrypoint[2] != artifactsWrapper || entrypoint[4] != "/docker-entrypoint.sh" {
+		t.Fatalf("Unexpected entrypoint %q", entrypoint)
+	}
+	if len(def.Environment) != 1 || aws.StringValue(def.Environment[0].Value) != "/app/out" {
+		t.Fatalf("Unexpected environment %v", def.Environment)
+	}
+}
+
+func TestApplyArtifactsWrapperNeedsEntryPoint(t *testing.T) {
+	r := &Runner{ArtifactsPath: "/app/out", Overrides: []Override{{Command: []string{"make", "test"}}}}
+	input := &ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions: []*ecs.ContainerDefinition{{
+			Name:    aws.String("app"),
+			Command: aws.StringSlice([]string{"make", "test"}),
+		}},
+	}
+	if err := r.applyArtifactsWrapper(input); err == nil || !strings.Contains(err.Error(), "no entryPoint") {
+		t.Fatalf("Expected an error without an entrypoint, got %v", err)
+	}
+
+	input.ContainerDefinitions[0].EntryPoint = aws.StringSlice([]string{"env"})
+	if err := r.applyArtifactsWrapper(input); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrepareArtifactsUpload(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	}))
+	r := &Runner{Cluster: "ci", ArtifactsPath: "/app/out", ArtifactsBucket: "ci-artifacts/builds/"}
+	td := &preparedTaskDefinition{Containers: []*ecs.ContainerDefinition{{Name: aws.String("app")}}}
+	input := &ecs.RunTaskInput{Overrides: &ecs.TaskOverride{}}
+
+	upload, err := r.prepareArtifactsUpload(sess, td, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Bucket != "ci-artifacts" || !strings.HasPrefix(upload.Key, "builds/ci/") {
+		t.Fatalf("Unexpected upload %+v", upload)
+	}
+
+	overrides := input.Overrides.ContainerOverrides
+	if len(overrides) != 1 || aws.StringValue(overrides[0].Name) != "app" || len(overrides[0].Environment) != 1 {
+		t.Fatalf("Unexpected overrides %v", overrides)
+	}
+	url := aws.StringValue(overrides[0].Environment[0].Value)
+	if !strings.Contains(url, "ci-artifacts") || !strings.Contains(url, "X-Amz-Signature=") {
+		t.Fatalf("Expected a presigned URL, got %s", url)
+	}
+}
+
+func testTarGz(t *testing.T, files map[string]string) *bytes.Buffer {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, body := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(body))
+	}
+	tw.Close()
+	gz.Close()
+	return &buf
+}
+
+func TestExtractTarGz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n, err := extractTarGz(testTarGz(t, map[string]string{
+		"./junit.xml":        "<testsuites/>",
+		"./coverage/cov.out": "mode: set",
+	}), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("Expected 2 files, got %d", n)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "coverage", "cov.out")); string(b) != "mode: set" {
+		t.Fatalf("Unexpected contents %q", b)
+	}
+
+	if _, err := extractTarGz(testTarGz(t, map[string]string{"../escape": "x"}), dir); err == nil {
+		t.Fatal("Expected a path outside of the directory to be refused")
+	}
+}
@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Attach follows tasks that were started some other way, such as by Step
+// Functions or another pipeline, as if the runner had started them: their
+// logs are printed, and it waits for them to stop and returns their exit
+// status. Tasks are ARNs, which say their cluster and region, or IDs of tasks
+// in the runner's cluster.
+func (r *Runner) Attach(ctx context.Context, tasks []string) error {
+	if len(tasks) == 0 {
+		return r.noTasks("there are no tasks to attach to")
+	}
+	r = r.clone()
+
+	var cluster, region string
+	for _, task := range tasks {
+		a, err := arn.Parse(task)
+		if err != nil {
+			continue
+		}
+		// older task ARNs don't include the cluster
+		if parts := strings.Split(a.Resource, "/"); len(parts) == 3 {
+			if cluster != "" && parts[1] != cluster {
+				return fmt.Errorf("Tasks are in clusters %s and %s, attach to the tasks in each cluster separately", cluster, parts[1])
+			}
+			cluster = parts[1]
+		}
+		if region != "" && a.Region != region {
+			return fmt.Errorf("Tasks are in regions %s and %s, attach to the tasks in each region separately", region, a.Region)
+		}
+		region = a.Region
+	}
+	if cluster != "" {
+		r.Cluster = cluster
+	}
+	if region != "" {
+		r.Region = region
+		r.Config = r.Config.Copy().WithRegion(region)
+	}
+
+	r.resume = &runState{Cluster: r.Cluster, Tasks: tasks}
+	r.Count = int64(len(tasks))
+	fmt.Fprintf(r.Stderr, "Attaching to %d tasks in cluster %s\n", len(tasks), r.Cluster)
+	return r.Run(ctx)
+}
+
+// attachedTaskDefinition returns the task definition that attached tasks
+// were started from, streaming logs from the containers that use awslogs
+func (r *Runner) attachedTaskDefinition(ctx context.Context, sess *session.Session, svc *ecs.ECS) (*preparedTaskDefinition, error) {
+	resp, err := r.resumedTasks(ctx, svc)
+	if err != nil {
+		return nil, err
+	}
+
+	var taskDefinition string
+	for _, task := range resp.Tasks {
+		arn := aws.StringValue(task.TaskDefinitionArn)
+		if taskDefinition != "" && arn != taskDefinition {
+			return nil, fmt.Errorf("Tasks were started from task definitions %s and %s, attach to the tasks of each separately", taskDefinition, arn)
+		}
+		taskDefinition = arn
+	}
+	return r.existingTaskDefinition(sess, svc, taskDefinition)
+}
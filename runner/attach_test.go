@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestAttachedTaskDefinition(t *testing.T) {
+	sess := fakeSession(func(req *request.Request) {
+		switch out := req.Data.(type) {
+		case *ecs.DescribeTasksOutput:
+			out.Tasks = []*ecs.Task{{
+				TaskArn:           aws.String("arn:aws:ecs:us-east-1:123456789012:task/jobs/abc"),
+				TaskDefinitionArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/report:7"),
+			}}
+		case *ecs.DescribeTaskDefinitionOutput:
+			out.TaskDefinition = &ecs.TaskDefinition{
+				TaskDefinitionArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/report:7"),
+				// tasks that are already running are followed even so
+				Status: aws.String(ecs.TaskDefinitionStatusInactive),
+				ContainerDefinitions: []*ecs.ContainerDefinition{
+					{
+						Name: aws.String("report"),
+						LogConfiguration: &ecs.LogConfiguration{
+							LogDriver: aws.String("awslogs"),
+							Options: map[string]*string{
+								"awslogs-group":         aws.String("jobs"),
+								"awslogs-stream-prefix": aws.String("sfn"),
+							},
+						},
+					},
+					{Name: aws.String("sidecar")},
+				},
+			}
+		}
+	})
+
+	var stderr bytes.Buffer
+	r := &Runner{Cluster: "jobs", Stderr: &stderr}
+	r.resume = &runState{Cluster: "jobs", Tasks: []string{"arn:aws:ecs:us-east-1:123456789012:task/jobs/abc"}}
+	td, err := r.resumedTaskDefinition(context.Background(), sess, ecs.New(sess))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if td.Name != "arn:aws:ecs:us-east-1:123456789012:task-definition/report:7" {
+		t.Errorf("Expected the tasks' task definition, got %s", td.Name)
+	}
+	if lc, ok := td.Logs["report"]; !ok || lc.Group != "jobs" || lc.StreamPrefix != "sfn" {
+		t.Errorf("Expected logs from the report container's awslogs config, got %+v", td.Logs)
+	}
+	if _, ok := td.Logs["sidecar"]; ok {
+		t.Error("Expected no logs from a container without awslogs")
+	}
+}
+
+func TestAttachTasksInDifferentClusters(t *testing.T) {
+	r := New()
+	err := r.Attach(context.Background(), []string{
+		"arn:aws:ecs:us-east-1:123456789012:task/jobs/abc",
+		"arn:aws:ecs:us-east-1:123456789012:task/web/def",
+	})
+	if err == nil || !strings.Contains(err.Error(), "Tasks are in clusters jobs and web") {
+		t.Fatalf("Expected tasks in different clusters to be an error, got %v", err)
+	}
+}
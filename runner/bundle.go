@@ -0,0 +1,334 @@
+package runner
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// When to write a support bundle
+const (
+	SupportBundleOnFailure = "failure"
+	SupportBundleAlways    = "always"
+)
+
+const (
+	// supportBundleLogLines is how many of the last lines of each
+	// container's logs are included in a support bundle
+	supportBundleLogLines = 200
+
+	redactedValue = "[REDACTED]"
+)
+
+// supportBundle collects what's needed to debug a run after the fact, to be
+// written as a zip that can be attached to a ticket or support case
+type supportBundle struct {
+	mu        sync.Mutex
+	startedAt time.Time
+	td        *preparedTaskDefinition
+	runTask   *ecs.RunTaskInput
+	tasks     []*ecs.Task
+	describes []bundleFile
+	states    map[string]string
+	timeline  bytes.Buffer
+	toolLog   bytes.Buffer
+}
+
+type bundleFile struct {
+	Name string
+	Body []byte
+}
+
+func (r *Runner) newSupportBundle() *supportBundle {
+	if r.SupportBundle == "" {
+		return nil
+	}
+	return &supportBundle{startedAt: time.Now(), states: map[string]string{}}
+}
+
+// logger returns a logger that records debug messages in the bundle as well
+// as passing them to next, or the standard logger without one
+func (b *supportBundle) logger(next Logger) Logger {
+	return LoggerFunc(func(entry LogEntry) {
+		b.mu.Lock()
+		fmt.Fprintf(&b.toolLog, "%s", entry.Time.UTC().Format(time.RFC3339Nano))
+		if entry.TaskARN != "" {
+			fmt.Fprintf(&b.toolLog, " task=%s", path.Base(entry.TaskARN))
+		}
+		if entry.Container != "" {
+			fmt.Fprintf(&b.toolLog, " container=%s", entry.Container)
+		}
+		fmt.Fprintf(&b.toolLog, " %s\n", entry.Message)
+		b.mu.Unlock()
+
+		if next != nil {
+			next.Log(entry)
+		} else {
+			log.Print(entry.Message)
+		}
+	})
+}
+
+func (b *supportBundle) setTaskDefinition(td *preparedTaskDefinition) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.td = td
+}
+
+// setRunTaskInput records the input to RunTask, with environment values
+// redacted as they're often secrets
+func (b *supportBundle) setRunTaskInput(input *ecs.RunTaskInput) {
+	if b == nil {
+		return
+	}
+	var copied ecs.RunTaskInput
+	if body, err := json.Marshal(input); err == nil && json.Unmarshal(body, &copied) == nil {
+		if copied.Overrides != nil {
+			for _, override := range copied.Overrides.ContainerOverrides {
+				redactKeyValuePairs(override.Environment)
+			}
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.runTask = &copied
+}
+
+// started records the tasks that RunTask started and the ones it couldn't
+func (b *supportBundle) started(resp *ecs.RunTaskOutput) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	fmt.Fprintf(&b.timeline, "%s  started %d tasks\n", time.Now().UTC().Format(time.RFC3339), len(resp.Tasks))
+	for _, f := range resp.Failures {
+		fmt.Fprintf(&b.timeline, "%s  failed to start a task on %s: %s\n", time.Now().UTC().Format(time.RFC3339),
+			aws.StringValue(f.Arn), aws.StringValue(f.Reason))
+	}
+	b.mu.Unlock()
+	b.describe("started", resp.Tasks)
+}
+
+// describe records the state of tasks if any task or container's status has
+// changed since they were last recorded
+func (b *supportBundle) describe(event string, tasks []*ecs.Task) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tasks = tasks
+
+	var changed bool
+	for _, task := range tasks {
+		state := taskState(task)
+		if b.states[aws.StringValue(task.TaskArn)] == state {
+			continue
+		}
+		b.states[aws.StringValue(task.TaskArn)] = state
+		changed = true
+		fmt.Fprintf(&b.timeline, "%s  task %s %s\n", time.Now().UTC().Format(time.RFC3339), path.Base(aws.StringValue(task.TaskArn)), state)
+	}
+	if !changed && event != "final" {
+		return
+	}
+
+	body, err := json.MarshalIndent(&ecs.DescribeTasksOutput{Tasks: redactTaskOverrides(tasks)}, "", "  ")
+	if err != nil {
+		return
+	}
+	b.describes = append(b.describes, bundleFile{
+		Name: fmt.Sprintf("describe-tasks/%03d-%s.json", len(b.describes)+1, event),
+		Body: body,
+	})
+}
+
+// supportBundleRun is the outcome of a run in a support bundle
+type supportBundleRun struct {
+	RunID          string    `json:"run_id"`
+	Cluster        string    `json:"cluster"`
+	Region         string    `json:"region,omitempty"`
+	TaskDefinition string    `json:"task_definition,omitempty"`
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	ExitCode       int       `json:"exit_code"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// writeSupportBundle writes the bundle to the runner's SupportBundle file if
+// the run failed, or always if asked to. Bundles are best effort, so
+// failures are only warned about.
+func (r *Runner) writeSupportBundle(ctx context.Context, sess *session.Session, b *supportBundle, runErr error) {
+	if b == nil || (runErr == nil && r.SupportBundleWhen != SupportBundleAlways) {
+		return
+	}
+
+	// the run may have been cancelled, but the bundle should still be written
+	ctx = withLogScope(context.Background(), logScopeFrom(ctx))
+
+	b.mu.Lock()
+	files := []bundleFile{}
+	run := supportBundleRun{
+		RunID:      r.logScope.fields.RunID,
+		Cluster:    r.Cluster,
+		Region:     aws.StringValue(sess.Config.Region),
+		StartedAt:  b.startedAt,
+		FinishedAt: time.Now(),
+		ExitCode:   ExitCode(runErr),
+	}
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+	if b.td != nil {
+		run.TaskDefinition = b.td.Name
+	}
+	files = append(files, jsonBundleFile("run.json", run))
+	if b.runTask != nil {
+		files = append(files, jsonBundleFile("run-task-input.json", b.runTask))
+	}
+	files = append(files, b.describes...)
+	files = append(files,
+		bundleFile{Name: "timeline.txt", Body: append([]byte{}, b.timeline.Bytes()...)},
+		bundleFile{Name: "ecs-run-task.log", Body: append([]byte{}, b.toolLog.Bytes()...)},
+	)
+	td, tasks := b.td, b.tasks
+	b.mu.Unlock()
+
+	if td != nil {
+		files = append(files, describeTaskDefinitionFile(ecs.New(sess), td.Name))
+		if !r.NoLogs {
+			files = append(files, recentContainerLogs(ctx, &cloudWatchLogsClients{sess: r.logsSession(sess)}, td, tasks)...)
+		}
+	}
+
+	if err := writeZip(r.SupportBundle, files); err != nil {
+		fmt.Fprintf(r.Stderr, "Failed to write support bundle to %s: %v\n", r.SupportBundle, err)
+		return
+	}
+	fmt.Fprintf(r.Stderr, "Wrote support bundle to %s\n", r.SupportBundle)
+}
+
+func jsonBundleFile(name string, v interface{}) bundleFile {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		body = []byte(err.Error())
+	}
+	return bundleFile{Name: name, Body: body}
+}
+
+// describeTaskDefinitionFile returns the task definition that was run as it
+// was registered, with environment values redacted
+func describeTaskDefinitionFile(svc *ecs.ECS, name string) bundleFile {
+	resp, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: aws.String(name)})
+	if err != nil {
+		return bundleFile{Name: "task-definition.error.txt", Body: []byte(wrapAPIError("DescribeTaskDefinition", err).Error())}
+	}
+	for _, def := range resp.TaskDefinition.ContainerDefinitions {
+		redactKeyValuePairs(def.Environment)
+	}
+	return jsonBundleFile("task-definition.json", resp.TaskDefinition)
+}
+
+// recentContainerLogs returns the last lines of each container's logs
+func recentContainerLogs(ctx context.Context, cwl *cloudWatchLogsClients, td *preparedTaskDefinition, tasks []*ecs.Task) []bundleFile {
+	var files []bundleFile
+	for _, task := range tasks {
+		for _, container := range task.Containers {
+			lc, ok := td.Logs[aws.StringValue(container.Name)]
+			if !ok || container.ContainerArn == nil {
+				continue
+			}
+			name := fmt.Sprintf("logs/%s-%s.log", aws.StringValue(container.Name), path.Base(aws.StringValue(task.TaskArn)))
+			resp, err := cwl.forRegion(lc.Region).GetLogEventsWithContext(ctx, &cloudwatchlogs.GetLogEventsInput{
+				LogGroupName:  aws.String(lc.Group),
+				LogStreamName: aws.String(logStreamName(lc.StreamPrefix, container, task)),
+				Limit:         aws.Int64(supportBundleLogLines),
+				StartFromHead: aws.Bool(false),
+			})
+			if err != nil {
+				logf(ctx, "Failed to get recent logs of container %s: %v", aws.StringValue(container.Name), err)
+				files = append(files, bundleFile{Name: name, Body: []byte(wrapAPIError("GetLogEvents", err).Error() + "\n")})
+				continue
+			}
+			var buf bytes.Buffer
+			for _, ev := range resp.Events {
+				fmt.Fprintf(&buf, "%s %s\n", aws.MillisecondsTimeValue(ev.Timestamp).UTC().Format(time.RFC3339Nano), aws.StringValue(ev.Message))
+			}
+			files = append(files, bundleFile{Name: name, Body: buf.Bytes()})
+		}
+	}
+	return files
+}
+
+// redactTaskOverrides returns a copy of tasks with the values of their
+// environment overrides redacted, as they're what RunTask was called with
+func redactTaskOverrides(tasks []*ecs.Task) []*ecs.Task {
+	var copied []*ecs.Task
+	body, err := json.Marshal(tasks)
+	if err != nil || json.Unmarshal(body, &copied) != nil {
+		return nil
+	}
+	for _, task := range copied {
+		if task.Overrides == nil {
+			continue
+		}
+		for _, override := range task.Overrides.ContainerOverrides {
+			redactKeyValuePairs(override.Environment)
+		}
+	}
+	return copied
+}
+
+func redactKeyValuePairs(pairs []*ecs.KeyValuePair) {
+	for _, kv := range pairs {
+		if aws.StringValue(kv.Value) != "" {
+			kv.Value = aws.String(redactedValue)
+		}
+	}
+}
+
+// writeZip writes files to a zip, which is only readable by the current
+// user as it can include details of the account
+func writeZip(file string, files []bundleFile) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+	for _, bf := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     strings.TrimPrefix(bf.Name, "/"),
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := w.Write(bf.Body); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestSupportBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var stderr bytes.Buffer
+	r := &Runner{Cluster: "default", SupportBundle: filepath.Join(dir, "bundle.zip"), Stderr: &stderr}
+	b := r.newSupportBundle()
+
+	var forwarded []string
+	logger := b.logger(LoggerFunc(func(e LogEntry) { forwarded = append(forwarded, e.Message) }))
+	logger.Log(LogEntry{Time: time.Now(), TaskARN: "arn:aws:ecs:us-east-1:123456789012:task/default/abc", Message: "Waiting"})
+	if !reflect.DeepEqual(forwarded, []string{"Waiting"}) {
+		t.Fatalf("Expected debug messages to be passed on, got %v", forwarded)
+	}
+
+	input := &ecs.RunTaskInput{
+		TaskDefinition: aws.String("app:1"),
+		Overrides: &ecs.TaskOverride{ContainerOverrides: []*ecs.ContainerOverride{{
+			Name:        aws.String("app"),
+			Environment: []*ecs.KeyValuePair{{Name: aws.String("DB_PASSWORD"), Value: aws.String("hunter2")}},
+		}}},
+	}
+	b.setRunTaskInput(input)
+	if aws.StringValue(input.Overrides.ContainerOverrides[0].Environment[0].Value) != "hunter2" {
+		t.Fatal("Expected the input itself not to be redacted")
+	}
+
+	task := func(status string) *ecs.Task {
+		return &ecs.Task{
+			TaskArn:    aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/abc"),
+			LastStatus: aws.String(status),
+			Containers: []*ecs.Container{{Name: aws.String("app"), LastStatus: aws.String(status)}},
+			Overrides:  input.Overrides,
+		}
+	}
+	b.started(&ecs.RunTaskOutput{Tasks: []*ecs.Task{task("PENDING")}})
+	b.describe("changed", []*ecs.Task{task("PENDING")})
+	b.describe("changed", []*ecs.Task{task("RUNNING")})
+	b.describe("final", []*ecs.Task{task("STOPPED")})
+
+	sess := session.Must(session.NewSession(aws.NewConfig().WithRegion("us-east-1")))
+	r.writeSupportBundle(context.Background(), sess, b, nil)
+	if _, err := os.Stat(r.SupportBundle); !os.IsNotExist(err) {
+		t.Fatalf("Expected no bundle for a run that passed, got %v", err)
+	}
+
+	r.writeSupportBundle(context.Background(), sess, b, errors.New("container app exited with 1"))
+	zr, err := zip.OpenReader(r.SupportBundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	contents := map[string]string{}
+	var names []string
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		names = append(names, f.Name)
+		contents[f.Name] = string(body)
+	}
+
+	expected := []string{
+		"run.json",
+		"run-task-input.json",
+		"describe-tasks/001-started.json",
+		"describe-tasks/002-changed.json",
+		"describe-tasks/003-final.json",
+		"timeline.txt",
+		"ecs-run-task.log",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Expected files %v, got %v", expected, names)
+	}
+	if strings.Contains(contents["run-task-input.json"], "hunter2") || !strings.Contains(contents["run-task-input.json"], redactedValue) {
+		t.Errorf("Expected environment values to be redacted, got %s", contents["run-task-input.json"])
+	}
+	for _, name := range expected {
+		if strings.HasPrefix(name, "describe-tasks/") && (strings.Contains(contents[name], "hunter2") || !strings.Contains(contents[name], redactedValue)) {
+			t.Errorf("Expected the tasks' environment overrides to be redacted in %s, got %s", name, contents[name])
+		}
+	}
+	if aws.StringValue(input.Overrides.ContainerOverrides[0].Environment[0].Value) != "hunter2" {
+		t.Error("Expected the described tasks themselves not to be redacted")
+	}
+	if !strings.Contains(contents["run.json"], "container app exited with 1") {
+		t.Errorf("Expected the error in run.json, got %s", contents["run.json"])
+	}
+	if !strings.Contains(contents["ecs-run-task.log"], "task=abc Waiting") {
+		t.Errorf("Unexpected tool log %q", contents["ecs-run-task.log"])
+	}
+	if lines := strings.Count(contents["timeline.txt"], "\n"); lines != 4 {
+		t.Errorf("Expected 4 lines in the timeline, got %q", contents["timeline.txt"])
+	}
+}
+
+func TestTargetFileName(t *testing.T) {
+	for target, expected := range map[Target]string{
+		{Cluster: "default"}: "bundle-default.zip",
+		{Cluster: "jobs", Region: "eu-west-1", Account: "123456789012"}: "bundle-123456789012-eu-west-1-jobs.zip",
+	} {
+		if actual := targetFileName("bundle.zip", target); actual != expected {
+			t.Errorf("Expected %q, got %q", expected, actual)
+		}
+	}
+}
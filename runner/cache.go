@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/buildkite/ecs-run-task/parser"
+)
+
+// taskDefinitionCache shares parsed and registered task definitions between
+// runners, so running against several targets parses a file once and
+// registers a single revision per account and region
+type taskDefinitionCache struct {
+	mu            sync.Mutex
+	parsed        map[string]*cachedResult
+	registrations map[string]*cachedResult
+}
+
+// cachedResult is the result of an operation that other callers wait on
+// while it is in progress
+type cachedResult struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+func newTaskDefinitionCache() *taskDefinitionCache {
+	return &taskDefinitionCache{
+		parsed:        map[string]*cachedResult{},
+		registrations: map[string]*cachedResult{},
+	}
+}
+
+// once runs fn for a key the first time it is called, with later calls
+// waiting for and returning the same result
+func (c *taskDefinitionCache) once(results map[string]*cachedResult, key string, fn func() (string, error)) (string, error) {
+	c.mu.Lock()
+	result, ok := results[key]
+	if !ok {
+		result = &cachedResult{done: make(chan struct{})}
+		results[key] = result
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		result.value, result.err = fn()
+		close(result.done)
+	} else {
+		<-result.done
+	}
+
+	return result.value, result.err
+}
+
+// parse parses a task definition file once, returning a new copy each time
+func (c *taskDefinitionCache) parse(file string) (*ecs.RegisterTaskDefinitionInput, error) {
+	if c == nil {
+		return parser.Parse(file, os.Environ())
+	}
+
+	body, err := c.once(c.parsed, file, func() (string, error) {
+		input, err := parser.Parse(file, os.Environ())
+		if err != nil {
+			return "", err
+		}
+		body, err := json.Marshal(input)
+		return string(body), err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var input ecs.RegisterTaskDefinitionInput
+	if err := json.Unmarshal([]byte(body), &input); err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+// register registers a task definition once for each scope (such as an
+// account and region) and identical content, returning the family and revision
+func (c *taskDefinitionCache) register(scope string, input *ecs.RegisterTaskDefinitionInput, fn func() (string, error)) (string, error) {
+	if c == nil {
+		return fn()
+	}
+
+	body, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(body)
+	key := scope + "/" + hex.EncodeToString(hash[:])
+
+	return c.once(c.registrations, key, func() (string, error) {
+		log.Printf("Registering task definition with content hash %x", hash[:8])
+		return fn()
+	})
+}
@@ -0,0 +1,73 @@
+package runner
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestTaskDefinitionCacheRegistersOncePerScope(t *testing.T) {
+	c := newTaskDefinitionCache()
+
+	var mu sync.Mutex
+	calls := 0
+	register := func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return fmt.Sprintf("family:%d", calls), nil
+	}
+
+	input := &ecs.RegisterTaskDefinitionInput{Family: aws.String("family")}
+
+	var wg sync.WaitGroup
+	names := make([]string, 5)
+	for i := range names {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			names[i], _ = c.register("us-east-1/", input, register)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, name := range names {
+		if name != "family:1" {
+			t.Fatalf("Expected family:1, got %q", name)
+		}
+	}
+
+	if _, err := c.register("us-west-2/", input, register); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := &ecs.RegisterTaskDefinitionInput{Family: aws.String("family"), Cpu: aws.String("256")}
+	if _, err := c.register("us-east-1/", changed, register); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 3 {
+		t.Fatalf("Expected 3 registrations, got %d", calls)
+	}
+}
+
+func TestNilTaskDefinitionCacheRegistersEachTime(t *testing.T) {
+	var c *taskDefinitionCache
+
+	calls := 0
+	for i := 0; i < 2; i++ {
+		if _, err := c.register("", &ecs.RegisterTaskDefinitionInput{}, func() (string, error) {
+			calls++
+			return "family:1", nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if calls != 2 {
+		t.Fatalf("Expected 2 registrations, got %d", calls)
+	}
+}
@@ -0,0 +1,267 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// maxDescribeContainerInstances is the most container instances that a single
+// DescribeContainerInstances call accepts
+const maxDescribeContainerInstances = 100
+
+// checksCapacity returns whether tasks will be placed on the cluster's EC2
+// container instances, rather than Fargate or a capacity provider that can
+// scale out, such as one in the cluster's default strategy
+func (r *Runner) checksCapacity(input *ecs.RunTaskInput) bool {
+	return !r.SkipCapacityCheck &&
+		!r.Fargate &&
+		!r.UseClusterDefaultStrategy &&
+		len(r.ContainerInstances) == 0 &&
+		aws.StringValue(input.LaunchType) != ecs.LaunchTypeFargate &&
+		len(input.CapacityProviderStrategy) == 0
+}
+
+// checkCapacity checks that at least one container instance in the cluster
+// could run the task, so that a task that can never be placed is reported
+// with what it's missing rather than as a bare placement failure. Without an
+// explicit EC2 launch type, tasks are placed by the cluster's default capacity
+// provider strategy if it has one, which can use Fargate or scale out, so the
+// check is skipped. Problems are only warned about without an explicit EC2
+// launch type, as they are when waiting for capacity.
+func (r *Runner) checkCapacity(svc *ecs.ECS, input *ecs.RunTaskInput) error {
+	if aws.StringValue(input.LaunchType) == "" {
+		strategy, err := clusterDefaultStrategy(svc, r.Cluster)
+		if err != nil {
+			r.logf("Skipping capacity check: %v", err)
+			return nil
+		}
+		if len(strategy) > 0 {
+			r.logf("Skipping capacity check, as cluster %s's default capacity provider strategy places the tasks", r.Cluster)
+			return nil
+		}
+	}
+
+	err := r.checkInstancesCanRun(svc, *input.TaskDefinition)
+
+	var apiErr *APIError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &apiErr):
+		r.logf("Skipping capacity check: %v", err)
+		return nil
+	case aws.StringValue(input.LaunchType) != ecs.LaunchTypeEc2 || r.WaitForCapacity > 0:
+		fmt.Fprintf(r.Stderr, "WARNING: %v\n", err)
+		return nil
+	}
+	return err
+}
+
+func (r *Runner) checkInstancesCanRun(svc *ecs.ECS, taskDefinition string) error {
+	resp, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(taskDefinition),
+	})
+	if err != nil {
+		return wrapAPIError("DescribeTaskDefinition", err)
+	}
+
+	instances, err := activeContainerInstances(svc, r.Cluster)
+	if err != nil {
+		return err
+	}
+	r.logf("Checking %d container instances can run %s", len(instances), taskDefinition)
+
+	if problems := unsatisfiedRequirements(resp.TaskDefinition, instances); len(problems) > 0 {
+		return &PlacementError{Reason: fmt.Sprintf("No container instance in cluster %s can run %s: %s",
+			r.Cluster, taskDefinition, strings.Join(problems, "; "))}
+	}
+	return nil
+}
+
+func activeContainerInstances(svc *ecs.ECS, cluster string) ([]*ecs.ContainerInstance, error) {
+	var arns []*string
+	err := svc.ListContainerInstancesPages(&ecs.ListContainerInstancesInput{
+		Cluster: aws.String(cluster),
+		Status:  aws.String(ecs.ContainerInstanceStatusActive),
+	}, func(page *ecs.ListContainerInstancesOutput, lastPage bool) bool {
+		arns = append(arns, page.ContainerInstanceArns...)
+		return true
+	})
+	if err != nil {
+		return nil, wrapAPIError("ListContainerInstances", err)
+	}
+
+	var instances []*ecs.ContainerInstance
+	for _, chunk := range chunkStrings(arns, maxDescribeContainerInstances) {
+		resp, err := svc.DescribeContainerInstances(&ecs.DescribeContainerInstancesInput{
+			Cluster:            aws.String(cluster),
+			ContainerInstances: chunk,
+		})
+		if err != nil {
+			return nil, wrapAPIError("DescribeContainerInstances", err)
+		}
+		instances = append(instances, resp.ContainerInstances...)
+	}
+	return instances, nil
+}
+
+// requirement is something a container instance needs to run a task
+type requirement struct {
+	Description string
+	Satisfied   func(*ecs.ContainerInstance) bool
+}
+
+// taskRequirements returns what a container instance needs to run a task
+// definition: its architecture, required attributes, CPU and memory
+func taskRequirements(td *ecs.TaskDefinition) []requirement {
+	var reqs []requirement
+
+	if td.RuntimePlatform != nil && td.RuntimePlatform.CpuArchitecture != nil {
+		arch := strings.ToLower(*td.RuntimePlatform.CpuArchitecture)
+		reqs = append(reqs, requirement{
+			Description: "architecture " + arch,
+			Satisfied: func(ci *ecs.ContainerInstance) bool {
+				return instanceAttribute(ci, "ecs.cpu-architecture") == arch
+			},
+		})
+	}
+
+	for _, attr := range td.RequiresAttributes {
+		attr := attr
+		desc := "attribute " + *attr.Name
+		if attr.Value != nil {
+			desc += "=" + *attr.Value
+		}
+		reqs = append(reqs, requirement{
+			Description: desc,
+			Satisfied: func(ci *ecs.ContainerInstance) bool {
+				for _, a := range ci.Attributes {
+					if *a.Name == *attr.Name && (attr.Value == nil || aws.StringValue(a.Value) == *attr.Value) {
+						return true
+					}
+				}
+				return false
+			},
+		})
+	}
+
+	cpu, memory := taskResources(td)
+	if cpu > 0 {
+		reqs = append(reqs, requirement{
+			Description: fmt.Sprintf("%d CPU units", cpu),
+			Satisfied: func(ci *ecs.ContainerInstance) bool {
+				return remainingResource(ci, "CPU") >= cpu
+			},
+		})
+	}
+	if memory > 0 {
+		reqs = append(reqs, requirement{
+			Description: fmt.Sprintf("%d MiB of memory", memory),
+			Satisfied: func(ci *ecs.ContainerInstance) bool {
+				return remainingResource(ci, "MEMORY") >= memory
+			},
+		})
+	}
+
+	return reqs
+}
+
+// unsatisfiedRequirements returns why no instance could run a task, or nothing
+// if at least one could. Requirements that no instance meets are listed with
+// how many instances were checked.
+func unsatisfiedRequirements(td *ecs.TaskDefinition, instances []*ecs.ContainerInstance) []string {
+	var connected []*ecs.ContainerInstance
+	for _, ci := range instances {
+		if aws.BoolValue(ci.AgentConnected) {
+			connected = append(connected, ci)
+		}
+	}
+	if len(connected) == 0 {
+		return []string{fmt.Sprintf("no active container instances with a connected agent, out of %d", len(instances))}
+	}
+
+	reqs := taskRequirements(td)
+	for _, ci := range connected {
+		if satisfiesAll(ci, reqs) {
+			return nil
+		}
+	}
+
+	var problems []string
+	for _, req := range reqs {
+		var n int
+		for _, ci := range connected {
+			if req.Satisfied(ci) {
+				n++
+			}
+		}
+		if n == 0 {
+			problems = append(problems, fmt.Sprintf("none of %d instances has %s", len(connected), req.Description))
+		}
+	}
+	if len(problems) == 0 {
+		var descs []string
+		for _, req := range reqs {
+			descs = append(descs, req.Description)
+		}
+		problems = append(problems, fmt.Sprintf("no single instance has %s", strings.Join(descs, ", ")))
+	}
+	return problems
+}
+
+func satisfiesAll(ci *ecs.ContainerInstance, reqs []requirement) bool {
+	for _, req := range reqs {
+		if !req.Satisfied(ci) {
+			return false
+		}
+	}
+	return true
+}
+
+// taskResources returns the CPU units and MiB of memory a task reserves, either
+// at the task level or as the sum of its containers
+func taskResources(td *ecs.TaskDefinition) (int64, int64) {
+	cpu, _ := strconv.ParseInt(aws.StringValue(td.Cpu), 10, 64)
+	memory, _ := strconv.ParseInt(aws.StringValue(td.Memory), 10, 64)
+
+	var containerCPU, containerMemory int64
+	for _, def := range td.ContainerDefinitions {
+		containerCPU += aws.Int64Value(def.Cpu)
+		if def.MemoryReservation != nil {
+			containerMemory += *def.MemoryReservation
+		} else {
+			containerMemory += aws.Int64Value(def.Memory)
+		}
+	}
+
+	if cpu == 0 {
+		cpu = containerCPU
+	}
+	if memory == 0 {
+		memory = containerMemory
+	}
+	return cpu, memory
+}
+
+func instanceAttribute(ci *ecs.ContainerInstance, name string) string {
+	for _, a := range ci.Attributes {
+		if aws.StringValue(a.Name) == name {
+			return aws.StringValue(a.Value)
+		}
+	}
+	return ""
+}
+
+func remainingResource(ci *ecs.ContainerInstance, name string) int64 {
+	for _, res := range ci.RemainingResources {
+		if aws.StringValue(res.Name) == name {
+			return aws.Int64Value(res.IntegerValue)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,173 @@
+package runner
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func containerInstance(arch string, cpu, memory int64, attrs ...string) *ecs.ContainerInstance {
+	ci := &ecs.ContainerInstance{
+		AgentConnected: aws.Bool(true),
+		Attributes: []*ecs.Attribute{
+			{Name: aws.String("ecs.cpu-architecture"), Value: aws.String(arch)},
+		},
+		RemainingResources: []*ecs.Resource{
+			{Name: aws.String("CPU"), IntegerValue: aws.Int64(cpu)},
+			{Name: aws.String("MEMORY"), IntegerValue: aws.Int64(memory)},
+		},
+	}
+	for _, attr := range attrs {
+		ci.Attributes = append(ci.Attributes, &ecs.Attribute{Name: aws.String(attr)})
+	}
+	return ci
+}
+
+func armTaskDefinition() *ecs.TaskDefinition {
+	return &ecs.TaskDefinition{
+		RuntimePlatform: &ecs.RuntimePlatform{CpuArchitecture: aws.String("ARM64")},
+		RequiresAttributes: []*ecs.Attribute{
+			{Name: aws.String("com.amazonaws.ecs.capability.docker-remote-api.1.19")},
+		},
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{Cpu: aws.Int64(256), Memory: aws.Int64(1024)},
+			{Cpu: aws.Int64(256), MemoryReservation: aws.Int64(256), Memory: aws.Int64(2048)},
+		},
+	}
+}
+
+func TestTaskResources(t *testing.T) {
+	cpu, memory := taskResources(armTaskDefinition())
+	if cpu != 512 || memory != 1280 {
+		t.Fatalf("Expected 512 CPU and 1280 memory from containers, got %d and %d", cpu, memory)
+	}
+
+	td := armTaskDefinition()
+	td.Cpu = aws.String("1024")
+	td.Memory = aws.String("4096")
+	cpu, memory = taskResources(td)
+	if cpu != 1024 || memory != 4096 {
+		t.Fatalf("Expected 1024 CPU and 4096 memory from the task, got %d and %d", cpu, memory)
+	}
+}
+
+func TestUnsatisfiedRequirements(t *testing.T) {
+	const dockerAPI = "com.amazonaws.ecs.capability.docker-remote-api.1.19"
+
+	disconnected := containerInstance("arm64", 4096, 8192, dockerAPI)
+	disconnected.AgentConnected = aws.Bool(false)
+
+	for _, tc := range []struct {
+		Name      string
+		Instances []*ecs.ContainerInstance
+		Expected  []string
+	}{
+		{
+			Name: "satisfied",
+			Instances: []*ecs.ContainerInstance{
+				containerInstance("x86_64", 4096, 8192, dockerAPI),
+				containerInstance("arm64", 4096, 8192, dockerAPI),
+			},
+		},
+		{
+			Name: "architecture",
+			Instances: []*ecs.ContainerInstance{
+				containerInstance("x86_64", 4096, 8192, dockerAPI),
+				containerInstance("x86_64", 4096, 8192, dockerAPI),
+			},
+			Expected: []string{"none of 2 instances has architecture arm64"},
+		},
+		{
+			Name: "attribute and memory",
+			Instances: []*ecs.ContainerInstance{
+				containerInstance("arm64", 4096, 1024),
+			},
+			Expected: []string{
+				"none of 1 instances has attribute " + dockerAPI,
+				"none of 1 instances has 1280 MiB of memory",
+			},
+		},
+		{
+			Name: "no single instance",
+			Instances: []*ecs.ContainerInstance{
+				containerInstance("arm64", 256, 8192, dockerAPI),
+				containerInstance("x86_64", 4096, 8192, dockerAPI),
+			},
+			Expected: []string{"no single instance has architecture arm64, attribute " + dockerAPI + ", 512 CPU units, 1280 MiB of memory"},
+		},
+		{
+			Name:      "disconnected",
+			Instances: []*ecs.ContainerInstance{disconnected},
+			Expected:  []string{"no active container instances with a connected agent, out of 1"},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			problems := unsatisfiedRequirements(armTaskDefinition(), tc.Instances)
+			if !reflect.DeepEqual(problems, tc.Expected) {
+				t.Fatalf("Expected %q, got %q", tc.Expected, problems)
+			}
+		})
+	}
+}
+
+func TestChecksCapacity(t *testing.T) {
+	r := New()
+	if !r.checksCapacity(&ecs.RunTaskInput{}) {
+		t.Fatal("Expected capacity to be checked by default")
+	}
+	if r.checksCapacity(&ecs.RunTaskInput{LaunchType: aws.String("FARGATE")}) {
+		t.Fatal("Expected capacity not to be checked on Fargate")
+	}
+	if r.checksCapacity(&ecs.RunTaskInput{CapacityProviderStrategy: []*ecs.CapacityProviderStrategyItem{{}}}) {
+		t.Fatal("Expected capacity not to be checked with a capacity provider strategy")
+	}
+	r.UseClusterDefaultStrategy = true
+	if r.checksCapacity(&ecs.RunTaskInput{}) {
+		t.Fatal("Expected capacity not to be checked with the cluster's default strategy")
+	}
+	r.UseClusterDefaultStrategy = false
+	r.SkipCapacityCheck = true
+	if r.checksCapacity(&ecs.RunTaskInput{}) {
+		t.Fatal("Expected capacity not to be checked with SkipCapacityCheck")
+	}
+}
+
+func TestCheckCapacityWithDefaultStrategy(t *testing.T) {
+	var calls []string
+	var strategy []*ecs.CapacityProviderStrategyItem
+	sess := fakeSession(func(req *request.Request) {
+		calls = append(calls, req.Operation.Name)
+		switch out := req.Data.(type) {
+		case *ecs.DescribeClustersOutput:
+			out.Clusters = []*ecs.Cluster{{DefaultCapacityProviderStrategy: strategy}}
+		case *ecs.DescribeTaskDefinitionOutput:
+			out.TaskDefinition = armTaskDefinition()
+		}
+	})
+
+	var stderr bytes.Buffer
+	r := &Runner{Cluster: "default", Stderr: &stderr}
+	input := &ecs.RunTaskInput{TaskDefinition: aws.String("app:1")}
+
+	strategy = []*ecs.CapacityProviderStrategyItem{{CapacityProvider: aws.String("FARGATE_SPOT")}}
+	if err := r.checkCapacity(ecs.New(sess), input); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(calls, []string{"DescribeClusters"}) {
+		t.Fatalf("Expected the check to be skipped with a default strategy, got %v", calls)
+	}
+
+	// without one, tasks are placed on the cluster's instances, which it has none of
+	calls, strategy = nil, nil
+	if err := r.checkCapacity(ecs.New(sess), input); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), "No container instance in cluster default") {
+		t.Fatalf("Expected a warning that no instance can run the task, got %q", stderr.String())
+	}
+}
@@ -0,0 +1,175 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/buildkite/ecs-run-task/report"
+)
+
+// cloudTrailPollInterval is how often CloudTrail is searched for calls that
+// it hasn't recorded yet, which usually takes a few minutes
+const cloudTrailPollInterval = 30 * time.Second
+
+// auditedOperations are the calls that start tasks, whose CloudTrail events
+// are looked up after a run
+var auditedOperations = map[string]bool{
+	"RegisterTaskDefinition": true,
+	"RunTask":                true,
+	"StartTask":              true,
+}
+
+type cloudTrailInterface interface {
+	LookupEventsPagesWithContext(ctx aws.Context, input *cloudtrail.LookupEventsInput, fn func(*cloudtrail.LookupEventsOutput, bool) bool, opts ...request.Option) error
+}
+
+// auditedCall is a call made during a run, and its CloudTrail event once found
+type auditedCall struct {
+	Operation string
+	RequestID string
+	EventID   string
+}
+
+// auditedCalls records the request IDs of calls that start tasks, so their
+// CloudTrail events can be found after the run
+type auditedCalls struct {
+	mu      sync.Mutex
+	calls   []*auditedCall
+	started time.Time
+}
+
+// auditCalls records successful calls made with svc that start tasks
+func auditCalls(svc *ecs.ECS) *auditedCalls {
+	a := &auditedCalls{started: time.Now()}
+	svc.Handlers.Complete.PushBack(func(req *request.Request) {
+		if req.Error != nil || !auditedOperations[req.Operation.Name] {
+			return
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.calls = append(a.calls, &auditedCall{Operation: req.Operation.Name, RequestID: req.RequestID})
+	})
+	return a
+}
+
+// lookup waits up to timeout for CloudTrail to record the calls, and prints
+// each call's event ID and a link to it in the console
+func (a *auditedCalls) lookup(ctx context.Context, ct cloudTrailInterface, region string, timeout time.Duration, w io.Writer) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.calls) == 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		pending, err := a.findEvents(ctx, ct)
+		if err != nil {
+			return err
+		}
+		if pending == 0 || time.Now().Add(cloudTrailPollInterval).After(deadline) {
+			break
+		}
+
+		logf(ctx, "Waiting for CloudTrail to record %d calls", pending)
+		select {
+		case <-time.After(cloudTrailPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for _, call := range a.calls {
+		if call.EventID == "" {
+			fmt.Fprintf(w, "No CloudTrail event for %s request %s after %v\n", call.Operation, call.RequestID, timeout)
+			continue
+		}
+		fmt.Fprintf(w, "CloudTrail event for %s: %s https://%s.console.aws.amazon.com/cloudtrailv2/home?region=%s#/events/%s\n",
+			call.Operation, call.EventID, region, region, call.EventID)
+	}
+	return nil
+}
+
+// findEvents looks up events for calls without one yet, returning how many
+// are still missing
+func (a *auditedCalls) findEvents(ctx context.Context, ct cloudTrailInterface) (int, error) {
+	byRequestID := map[string]*auditedCall{}
+	operations := map[string]bool{}
+	for _, call := range a.calls {
+		if call.EventID == "" {
+			byRequestID[call.RequestID] = call
+			operations[call.Operation] = true
+		}
+	}
+
+	for operation := range operations {
+		err := ct.LookupEventsPagesWithContext(ctx, &cloudtrail.LookupEventsInput{
+			LookupAttributes: []*cloudtrail.LookupAttribute{{
+				AttributeKey:   aws.String(cloudtrail.LookupAttributeKeyEventName),
+				AttributeValue: aws.String(operation),
+			}},
+			StartTime: aws.Time(a.started.Add(-time.Minute)),
+		}, func(page *cloudtrail.LookupEventsOutput, lastPage bool) bool {
+			for _, event := range page.Events {
+				var detail struct {
+					RequestID string `json:"requestID"`
+				}
+				if err := json.Unmarshal([]byte(aws.StringValue(event.CloudTrailEvent)), &detail); err != nil {
+					continue
+				}
+				if call, ok := byRequestID[detail.RequestID]; ok {
+					call.EventID = aws.StringValue(event.EventId)
+					delete(byRequestID, detail.RequestID)
+				}
+			}
+			return len(byRequestID) > 0
+		})
+		if err != nil {
+			return 0, wrapAPIError("LookupEvents", err)
+		}
+	}
+
+	return len(byRequestID), nil
+}
+
+// printCloudTrailEvents prints the CloudTrail events of audited calls, unless
+// the run was interrupted, and records them in the summary and events
+func (r *Runner) printCloudTrailEvents(ctx context.Context, sess *session.Session, a *auditedCalls, rep *runReport, el *eventLog) {
+	if ctx.Err() != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.CloudTrailLookup+time.Minute)
+	defer cancel()
+	region := aws.StringValue(sess.Config.Region)
+	if err := a.lookup(ctx, cloudtrail.New(sess), region, r.CloudTrailLookup, r.Stderr); err != nil {
+		fmt.Fprintf(r.Stderr, "Failed to look up CloudTrail events: %v\n", err)
+	}
+
+	events := a.events()
+	rep.setCloudTrailEvents(events)
+	el.cloudTrailEvents(r.Cluster, events)
+}
+
+// events returns the calls and the CloudTrail events that were found for them
+func (a *auditedCalls) events() []report.CloudTrailEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var events []report.CloudTrailEvent
+	for _, call := range a.calls {
+		events = append(events, report.CloudTrailEvent{
+			Operation: call.Operation,
+			RequestID: call.RequestID,
+			EventID:   call.EventID,
+		})
+	}
+	return events
+}
@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/buildkite/ecs-run-task/report"
+)
+
+func TestAuditedCallsLookup(t *testing.T) {
+	ct := &mockCloudTrail{events: map[string][]*cloudtrail.Event{
+		"RunTask": {
+			{EventId: aws.String("other-event"), CloudTrailEvent: aws.String(`{"requestID":"other-request"}`)},
+			{EventId: aws.String("run-event"), CloudTrailEvent: aws.String(`{"requestID":"run-request"}`)},
+		},
+	}}
+
+	a := &auditedCalls{calls: []*auditedCall{
+		{Operation: "RegisterTaskDefinition", RequestID: "register-request"},
+		{Operation: "RunTask", RequestID: "run-request"},
+	}}
+
+	var out bytes.Buffer
+	if err := a.lookup(context.Background(), ct, "us-east-1", 0, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"No CloudTrail event for RegisterTaskDefinition request register-request after 0s",
+		"CloudTrail event for RunTask: run-event https://us-east-1.console.aws.amazon.com/cloudtrailv2/home?region=us-east-1#/events/run-event",
+	}
+	if actual := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected %q, got %q", expected, actual)
+	}
+	if ct.lookups["RunTask"] != 1 || ct.lookups["RegisterTaskDefinition"] != 1 {
+		t.Fatalf("Expected one lookup per operation, got %v", ct.lookups)
+	}
+
+	events := []report.CloudTrailEvent{
+		{Operation: "RegisterTaskDefinition", RequestID: "register-request"},
+		{Operation: "RunTask", RequestID: "run-request", EventID: "run-event"},
+	}
+	if actual := a.events(); !reflect.DeepEqual(actual, events) {
+		t.Fatalf("Expected events %+v for the summary, got %+v", events, actual)
+	}
+}
+
+func TestAuditedCallsLookupWithoutCalls(t *testing.T) {
+	ct := &mockCloudTrail{}
+
+	var out bytes.Buffer
+	if err := (&auditedCalls{}).lookup(context.Background(), ct, "us-east-1", 0, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() > 0 || len(ct.lookups) > 0 {
+		t.Fatalf("Expected no lookups or output, got %v and %q", ct.lookups, out.String())
+	}
+}
+
+type mockCloudTrail struct {
+	events  map[string][]*cloudtrail.Event
+	lookups map[string]int
+}
+
+func (m *mockCloudTrail) LookupEventsPagesWithContext(ctx aws.Context, input *cloudtrail.LookupEventsInput, fn func(*cloudtrail.LookupEventsOutput, bool) bool, opts ...request.Option) error {
+	name := *input.LookupAttributes[0].AttributeValue
+	if m.lookups == nil {
+		m.lookups = map[string]int{}
+	}
+	m.lookups[name]++
+	fn(&cloudtrail.LookupEventsOutput{Events: m.events[name]}, true)
+	return nil
+}
@@ -9,14 +9,20 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/buildkite/ecs-run-task/retry"
 )
 
 const (
 	defaultLogTimeout      = time.Minute * 60
 	defaultLogPollInterval = time.Second * 2
+
+	// finalLogFlushTimeout bounds the last fetch of events when a log
+	// watcher is cancelled
+	finalLogFlushTimeout = time.Second * 10
 )
 
 type cloudwatchLogsInterface interface {
@@ -24,8 +30,12 @@ type cloudwatchLogsInterface interface {
 		fn func(*cloudwatchlogs.DescribeLogStreamsOutput, bool) bool) error
 	DescribeLogStreams(input *cloudwatchlogs.DescribeLogStreamsInput) (*cloudwatchlogs.DescribeLogStreamsOutput, error)
 	PutLogEvents(input *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error)
-	FilterLogEventsPages(input *cloudwatchlogs.FilterLogEventsInput,
-		fn func(*cloudwatchlogs.FilterLogEventsOutput, bool) bool) error
+	DeleteLogStream(input *cloudwatchlogs.DeleteLogStreamInput) (*cloudwatchlogs.DeleteLogStreamOutput, error)
+	FilterLogEventsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.FilterLogEventsInput,
+		fn func(*cloudwatchlogs.FilterLogEventsOutput, bool) bool, opts ...request.Option) error
+	GetLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.GetLogEventsInput, opts ...request.Option) (*cloudwatchlogs.GetLogEventsOutput, error)
+	DescribeLogGroupsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogGroupsInput,
+		fn func(*cloudwatchlogs.DescribeLogGroupsOutput, bool) bool, opts ...request.Option) error
 }
 
 // logWaiter waits for a log stream to exist
@@ -65,7 +75,7 @@ func (lw *logWaiter) streamExists() (bool, error) {
 
 // Wait waits for a log stream to exist
 func (lw *logWaiter) Wait(ctx context.Context) error {
-	log.Printf("Waiting for log stream %s to exist...", lw.LogStreamName)
+	logf(ctx, "Waiting for log stream %s to exist...", lw.LogStreamName)
 	t := time.Now()
 
 	pollInterval := lw.Interval
@@ -80,46 +90,40 @@ func (lw *logWaiter) Wait(ctx context.Context) error {
 
 	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
-	done := make(chan bool)
-	go func() {
-		time.Sleep(timeout)
-		done <- true
-	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
 	for {
 		exists, err := lw.streamExists()
 
 		// handle rate-limiting errors which seem to occur during
 		// excessive polling operations
-		if isRateLimited(err) {
-			time.Sleep(5 * time.Second)
-			continue
+		if retry.IsThrottled(err) {
+			select {
+			case <-time.After(5 * time.Second):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		} else if err != nil {
-			return err
+			return wrapAPIError("DescribeLogStreams", err)
 		} else if exists {
-			log.Printf("Found stream %s after %v", lw.LogStreamName, time.Now().Sub(t))
+			logf(ctx, "Found stream %s after %v", lw.LogStreamName, time.Now().Sub(t))
 			return nil
 		}
 
 		select {
-		case <-done:
-			log.Printf("Timed out waiting for stream")
-			return fmt.Errorf("Timed out waiting for stream %s", lw.LogStreamName)
+		case <-timer.C:
+			logf(ctx, "Timed out waiting for stream")
+			return &TimeoutError{fmt.Errorf("Timed out waiting for stream %s", lw.LogStreamName)}
 		case <-ticker.C:
 			continue
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 }
 
-func isRateLimited(err error) bool {
-	if aerr, ok := err.(awserr.Error); ok {
-		if aerr.Code() == "Throttling" {
-			return true
-		}
-	}
-	return false
-}
-
 // logWatcher watches a given CloudWatch Logs stream and prints events as they appear
 type logWatcher struct {
 	CloudWatchLogs cloudwatchLogsInterface
@@ -128,11 +132,31 @@ type logWatcher struct {
 	LogStreamName string
 	Printer       func(event *cloudwatchlogs.FilteredLogEvent) bool
 
+	// StartAfter is the timestamp in milliseconds of the last event that was
+	// already printed, such as by a run that's being resumed
+	StartAfter int64
+
+	// Strategy is how events are fetched, with FilterLogEvents if it's empty.
+	// LiveTail starts the sessions that LogFetchLiveTail streams events from.
+	Strategy string
+	LiveTail func(ctx context.Context, input *cloudwatchlogs.StartLiveTailInput) (liveTailStream, error)
+
 	Interval time.Duration
 	Timeout  time.Duration
 
-	mu   sync.Mutex
-	stop chan struct{}
+	// nextToken is where the last GetLogEvents call left off
+	nextToken *string
+
+	// lastMessages counts the messages printed with the latest timestamp, so
+	// that a live tail can tell which of the events it pushes at the boundary
+	// with what was already printed are repeats
+	lastMessages map[string]int
+	lastTs       int64
+
+	mu           sync.Mutex
+	stop         chan struct{}
+	lastProgress time.Time
+	lastPrinted  int64
 }
 
 // Watch follows the log stream and prints events via a Printer
@@ -153,9 +177,20 @@ func (lw *logWatcher) Watch(ctx context.Context) error {
 		return err
 	}
 
-	var after int64
+	after := lw.StartAfter
 	var err error
 
+	if lw.Strategy == LogFetchLiveTail {
+		after, err = lw.tail(ctx, after)
+		if ctx.Err() != nil {
+			lw.flush(ctx, after)
+			return ctx.Err()
+		}
+		if err != errLiveTailUnavailable {
+			return err
+		}
+	}
+
 	pollInterval := lw.Interval
 	if pollInterval == time.Duration(0) {
 		pollInterval = time.Second * 2
@@ -172,17 +207,61 @@ func (lw *logWatcher) Watch(ctx context.Context) error {
 			return nil
 
 		case <-ctx.Done():
+			lw.flush(ctx, after)
 			return ctx.Err()
 		}
 	}
 }
 
+// flush does a final, bounded fetch of events when the watcher is cancelled,
+// so the last output of a task that timed out or was interrupted isn't lost
+func (lw *logWatcher) flush(ctx context.Context, after int64) {
+	ctx, cancel := context.WithTimeout(withLogScope(context.Background(), logScopeFrom(ctx)), finalLogFlushTimeout)
+	defer cancel()
+
+	logf(ctx, "Flushing events in stream %q", lw.LogStreamName)
+	if _, err := lw.printEventsAfter(ctx, after); err != nil {
+		logf(ctx, "Failed to flush events in stream %q: %v", lw.LogStreamName, err)
+	}
+}
+
+// progressed records that events up to ts were printed
+func (lw *logWatcher) progressed(ts int64) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.lastProgress = time.Now()
+	lw.lastPrinted = ts
+}
+
+// position returns the timestamp of the last event that was printed, or 0 if
+// none have been
+func (lw *logWatcher) position() int64 {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.lastPrinted
+}
+
+// idleSince returns how long it's been since events were last printed, or
+// since t if that was later
+func (lw *logWatcher) idleSince(t time.Time) time.Duration {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if lw.lastProgress.After(t) {
+		t = lw.lastProgress
+	}
+	return time.Since(t)
+}
+
 // Stop watching a log stream
 func (lw *logWatcher) Stop() error {
 	lw.mu.Lock()
 	defer lw.mu.Unlock()
 	if lw.stop != nil {
-		close(lw.stop)
+		select {
+		case <-lw.stop:
+		default:
+			close(lw.stop)
+		}
 		return nil
 	}
 	return errors.New("Log watcher not started")
@@ -190,7 +269,37 @@ func (lw *logWatcher) Stop() error {
 
 // printEventsAfter prints events from a given stream after a given timestamp
 func (lw *logWatcher) printEventsAfter(ctx context.Context, ts int64) (int64, error) {
-	log.Printf("Printing events in stream %q after %d", lw.LogStreamName, ts)
+	if lw.Strategy == LogFetchGet {
+		return lw.getEventsAfter(ctx, ts)
+	}
+	return lw.filterEventsAfter(ctx, ts)
+}
+
+// print prints an event, stopping the watcher if the printer says to, and
+// returns the later of its timestamp and ts
+func (lw *logWatcher) print(ctx context.Context, event *cloudwatchlogs.FilteredLogEvent, ts int64) int64 {
+	if !lw.Printer(event) {
+		logf(ctx, "Stopping log watcher via print function")
+		lw.Stop()
+	}
+	if lw.Strategy == LogFetchLiveTail {
+		switch {
+		case *event.Timestamp > lw.lastTs:
+			lw.lastTs = *event.Timestamp
+			lw.lastMessages = map[string]int{aws.StringValue(event.Message): 1}
+		case *event.Timestamp == lw.lastTs:
+			lw.lastMessages[aws.StringValue(event.Message)]++
+		}
+	}
+	if *event.Timestamp > ts {
+		ts = *event.Timestamp
+	}
+	return ts
+}
+
+// filterEventsAfter prints events from the stream with FilterLogEvents
+func (lw *logWatcher) filterEventsAfter(ctx context.Context, ts int64) (int64, error) {
+	logf(ctx, "Printing events in stream %q after %d", lw.LogStreamName, ts)
 	t := time.Now()
 	var count int64
 
@@ -200,25 +309,22 @@ func (lw *logWatcher) printEventsAfter(ctx context.Context, ts int64) (int64, er
 		StartTime:      aws.Int64(ts + 1),
 	}
 
-	err := lw.CloudWatchLogs.FilterLogEventsPages(filterInput,
+	err := lw.CloudWatchLogs.FilterLogEventsPagesWithContext(ctx, filterInput,
 		func(p *cloudwatchlogs.FilterLogEventsOutput, lastPage bool) (shouldContinue bool) {
 			for _, event := range p.Events {
 				count++
-				if !lw.Printer(event) {
-					log.Printf("Stopping log watcher via print function")
-					lw.Stop()
-				}
-				if *event.Timestamp > ts {
-					ts = *event.Timestamp
-				}
+				ts = lw.print(ctx, event, ts)
 			}
 			return lastPage
 		})
 	if err != nil {
-		log.Printf("Printed %d events in %v", count, time.Now().Sub(t))
+		logf(ctx, "Printed %d events in %v", count, time.Now().Sub(t))
+	}
+	if count > 0 {
+		lw.progressed(ts)
 	}
 
-	return ts, err
+	return ts, wrapAPIError("FilterLogEvents", err)
 }
 
 // logWriter appends a line to a finished log stream
@@ -232,8 +338,8 @@ type logWriter struct {
 	Timeout  time.Duration
 }
 
-func (lw *logWriter) nextSequenceToken() (*string, error) {
-	log.Printf("Finding next sequence token for stream %s", lw.LogStreamName)
+func (lw *logWriter) nextSequenceToken(ctx context.Context) (*string, error) {
+	logf(ctx, "Finding next sequence token for stream %s", lw.LogStreamName)
 
 	streams, err := lw.CloudWatchLogs.DescribeLogStreams(&cloudwatchlogs.DescribeLogStreamsInput{
 		LogGroupName:        aws.String(lw.LogGroupName),
@@ -242,7 +348,7 @@ func (lw *logWriter) nextSequenceToken() (*string, error) {
 		Limit:               aws.Int64(1),
 	})
 	if err != nil {
-		return nil, err
+		return nil, wrapAPIError("DescribeLogStreams", err)
 	} else if len(streams.LogStreams) == 0 {
 		return nil, fmt.Errorf("failed to find stream %s in group %s", lw.LogStreamName, lw.LogGroupName)
 	}
@@ -263,13 +369,12 @@ func (lw *logWriter) WriteString(ctx context.Context, msg string) error {
 		return err
 	}
 
-	sequence, err := lw.nextSequenceToken()
+	sequence, err := lw.nextSequenceToken(ctx)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Putting log message %q to %s", msg, lw.LogStreamName)
-	_, err = lw.CloudWatchLogs.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{
+	input := &cloudwatchlogs.PutLogEventsInput{
 		SequenceToken: sequence,
 		LogGroupName:  aws.String(lw.LogGroupName),
 		LogStreamName: aws.String(lw.LogStreamName),
@@ -279,29 +384,135 @@ func (lw *logWriter) WriteString(ctx context.Context, msg string) error {
 				Timestamp: aws.Int64(aws.TimeUnixMilli(time.Now())),
 			},
 		},
-	})
-	return err
+	}
+
+	backoff := lw.backoff()
+	for attempt := 1; ; attempt++ {
+		logf(ctx, "Putting log message %q to %s", msg, lw.LogStreamName)
+		_, err = lw.CloudWatchLogs.PutLogEvents(input)
+
+		var invalidToken *cloudwatchlogs.InvalidSequenceTokenException
+		var accepted *cloudwatchlogs.DataAlreadyAcceptedException
+		switch {
+		case err == nil:
+			return nil
+
+		// a retry of a put that succeeded, such as after a timeout
+		case errors.As(err, &accepted):
+			logf(ctx, "Log message was already accepted by %s", lw.LogStreamName)
+			return nil
+
+		// something else wrote to the stream since the token was found, which
+		// only matters to older endpoints as sequence tokens are now ignored
+		case errors.As(err, &invalidToken) && attempt < backoff.Attempts:
+			logf(ctx, "Sequence token for %s was out of date, retrying", lw.LogStreamName)
+			input.SequenceToken = invalidToken.ExpectedSequenceToken
+			continue
+
+		case retry.IsThrottled(err) && attempt < backoff.Attempts:
+			delay := backoff.Delay(attempt)
+			logf(ctx, "Throttled putting log message to %s, retrying in %v", lw.LogStreamName, delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+		return wrapAPIError("PutLogEvents", err)
+	}
 }
 
-func createLogGroup(sess *session.Session, logGroup string) error {
+// backoff is how putting a log message is retried, starting from Interval if
+// it's set
+func (lw *logWriter) backoff() retry.Backoff {
+	backoff := retry.Default
+	if lw.Interval > 0 {
+		backoff.Initial = lw.Interval
+	}
+	return backoff
+}
+
+// createLogGroup creates a log group if it doesn't exist, returning whether
+// it was created by this run
+func createLogGroup(sess *session.Session, logGroup string) (bool, error) {
 	cwl := cloudwatchlogs.New(sess)
 	groups, err := cwl.DescribeLogGroups(&cloudwatchlogs.DescribeLogGroupsInput{
 		Limit:              aws.Int64(1),
 		LogGroupNamePrefix: aws.String(logGroup),
 	})
 	if err != nil {
-		return err
+		return false, wrapAPIError("DescribeLogGroups", err)
 	}
-	if len(groups.LogGroups) == 0 {
-		log.Printf("Creating log group %s", logGroup)
-		_, err = cwl.CreateLogGroup(&cloudwatchlogs.CreateLogGroupInput{
-			LogGroupName: aws.String(logGroup),
-		})
-		if err != nil {
-			return err
-		}
-	} else {
+	if len(groups.LogGroups) > 0 {
 		log.Printf("Log group %s exists", logGroup)
+		return false, nil
+	}
+
+	log.Printf("Creating log group %s", logGroup)
+	_, err = cwl.CreateLogGroup(&cloudwatchlogs.CreateLogGroupInput{
+		LogGroupName: aws.String(logGroup),
+	})
+
+	// another run, such as against another target, may have created it
+	var exists *cloudwatchlogs.ResourceAlreadyExistsException
+	if errors.As(err, &exists) {
+		log.Printf("Log group %s was created by another run", logGroup)
+		return false, nil
+	}
+	if err != nil {
+		return false, wrapAPIError("CreateLogGroup", err)
+	}
+	return true, nil
+}
+
+// logConfig is where a container sends its logs with the awslogs driver
+type logConfig struct {
+	Group        string `json:"group"`
+	Region       string `json:"region,omitempty"`
+	StreamPrefix string `json:"stream_prefix"`
+}
+
+// awslogsConfig returns where a log configuration sends logs, or false if it
+// doesn't use awslogs with a stream prefix, which is needed to know the name
+// of the stream
+func awslogsConfig(lc *ecs.LogConfiguration, defaultRegion string) (logConfig, bool) {
+	if lc == nil || aws.StringValue(lc.LogDriver) != "awslogs" {
+		return logConfig{}, false
+	}
+
+	config := logConfig{
+		Group:        aws.StringValue(lc.Options["awslogs-group"]),
+		Region:       aws.StringValue(lc.Options["awslogs-region"]),
+		StreamPrefix: aws.StringValue(lc.Options["awslogs-stream-prefix"]),
+	}
+	if config.Region == "" {
+		config.Region = defaultRegion
+	}
+	if config.Group == "" || config.StreamPrefix == "" {
+		return logConfig{}, false
+	}
+
+	return config, true
+}
+
+// cloudWatchLogsClients creates a CloudWatch Logs client for each region that
+// logs are sent to
+type cloudWatchLogsClients struct {
+	sess    *session.Session
+	clients map[string]*cloudwatchlogs.CloudWatchLogs
+}
+
+func (c *cloudWatchLogsClients) forRegion(region string) *cloudwatchlogs.CloudWatchLogs {
+	if c.clients == nil {
+		c.clients = map[string]*cloudwatchlogs.CloudWatchLogs{}
+	}
+	if _, ok := c.clients[region]; !ok {
+		config := aws.NewConfig()
+		if region != "" {
+			config.WithRegion(region)
+		}
+		c.clients[region] = cloudwatchlogs.New(c.sess, config)
 	}
-	return nil
+	return c.clients[region]
 }
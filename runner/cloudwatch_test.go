@@ -2,13 +2,17 @@ package runner
 
 import (
 	"context"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
 )
 
 func TestLogsWatcherTimesOutWhenNoStreamIsFound(t *testing.T) {
@@ -108,6 +112,47 @@ func TestLogsWatcherRespectsContext(t *testing.T) {
 	}
 }
 
+func TestLogsWatcherFlushesWhenCancelled(t *testing.T) {
+	var events []*cloudwatchlogs.FilteredLogEvent
+	ts := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	cwlc := &mockCloudWatchLogs{
+		logStreams: []*cloudwatchlogs.LogStream{{
+			Arn:           aws.String("my-stream-arn"),
+			LogStreamName: aws.String("my-stream"),
+		}},
+		filterLogEvents: []*cloudwatchlogs.FilteredLogEvent{{
+			EventId:   aws.String("my-event"),
+			Message:   aws.String("last words"),
+			Timestamp: aws.Int64(ts.UnixNano() / int64(time.Millisecond)),
+		}},
+	}
+
+	w := logWatcher{
+		LogGroupName:   "my-group",
+		LogStreamName:  "my-stream",
+		CloudWatchLogs: cwlc,
+		Printer: func(ev *cloudwatchlogs.FilteredLogEvent) bool {
+			events = append(events, ev)
+			return true
+		},
+
+		// cancelled long before the first poll
+		Interval: time.Hour,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	if err := w.Watch(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("bad error %v", err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("Expected the last event to be flushed, got %d events", len(events))
+	}
+}
+
 func TestLogsWriterAppendsMessage(t *testing.T) {
 	cwlc := &mockCloudWatchLogs{
 		logStreams: []*cloudwatchlogs.LogStream{{
@@ -135,12 +180,75 @@ func TestLogsWriterAppendsMessage(t *testing.T) {
 	}
 }
 
+func TestLogsWriterRetries(t *testing.T) {
+	cwlc := &mockCloudWatchLogs{
+		logStreams: []*cloudwatchlogs.LogStream{{
+			Arn:                 aws.String("my-stream-arn"),
+			LogStreamName:       aws.String("my-stream"),
+			UploadSequenceToken: aws.String("stale-token"),
+		}},
+		putLogEventsErrors: []error{
+			&cloudwatchlogs.InvalidSequenceTokenException{ExpectedSequenceToken: aws.String("next-token")},
+			awserr.New("ThrottlingException", "Rate exceeded", nil),
+		},
+	}
+
+	w := logWriter{
+		LogGroupName:   "my-group",
+		LogStreamName:  "my-stream",
+		Timeout:        time.Millisecond * 50,
+		Interval:       time.Millisecond * 5,
+		CloudWatchLogs: cwlc,
+	}
+
+	if err := w.WriteString(context.Background(), "llamas rock"); err != nil {
+		t.Fatal(err)
+	}
+	if l := len(cwlc.inputLogEvents); l != 1 {
+		t.Fatal("bad number of input log events", l)
+	}
+	if expected := []string{"stale-token", "next-token", "next-token"}; !reflect.DeepEqual(cwlc.sequenceTokens, expected) {
+		t.Fatalf("Expected sequence tokens %v, got %v", expected, cwlc.sequenceTokens)
+	}
+
+	// a put that was already accepted doesn't need retrying
+	cwlc.putLogEventsErrors = []error{&cloudwatchlogs.DataAlreadyAcceptedException{}}
+	if err := w.WriteString(context.Background(), "llamas rock"); err != nil {
+		t.Fatal(err)
+	}
+
+	cwlc.putLogEventsErrors = []error{awserr.New("AccessDeniedException", "not authorized", nil)}
+	if err := w.WriteString(context.Background(), "llamas rock"); err == nil {
+		t.Fatal("Expected other errors to fail")
+	}
+}
+
 type mockCloudWatchLogs struct {
 	sync.Mutex
 
 	logStreams      []*cloudwatchlogs.LogStream
 	filterLogEvents []*cloudwatchlogs.FilteredLogEvent
 	inputLogEvents  []*cloudwatchlogs.InputLogEvent
+	logEvents       []*cloudwatchlogs.OutputLogEvent
+
+	// putLogEventsErrors are returned by the next calls to PutLogEvents
+	putLogEventsErrors []error
+	sequenceTokens     []string
+}
+
+func (cw *mockCloudWatchLogs) DeleteLogStream(input *cloudwatchlogs.DeleteLogStreamInput) (*cloudwatchlogs.DeleteLogStreamOutput, error) {
+	cw.Lock()
+	defer cw.Unlock()
+
+	var streams []*cloudwatchlogs.LogStream
+	for _, stream := range cw.logStreams {
+		if *stream.LogStreamName != *input.LogStreamName {
+			streams = append(streams, stream)
+		}
+	}
+	cw.logStreams = streams
+
+	return &cloudwatchlogs.DeleteLogStreamOutput{}, nil
 }
 
 func (cw *mockCloudWatchLogs) DescribeLogStreams(input *cloudwatchlogs.DescribeLogStreamsInput) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
@@ -172,8 +280,8 @@ func (cw *mockCloudWatchLogs) DescribeLogStreamsPages(input *cloudwatchlogs.Desc
 	return nil
 }
 
-func (cw *mockCloudWatchLogs) FilterLogEventsPages(input *cloudwatchlogs.FilterLogEventsInput,
-	fn func(*cloudwatchlogs.FilterLogEventsOutput, bool) bool) error {
+func (cw *mockCloudWatchLogs) FilterLogEventsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.FilterLogEventsInput,
+	fn func(*cloudwatchlogs.FilterLogEventsOutput, bool) bool, opts ...request.Option) error {
 
 	for {
 		output := &cloudwatchlogs.FilterLogEventsOutput{
@@ -188,7 +296,8 @@ func (cw *mockCloudWatchLogs) FilterLogEventsPages(input *cloudwatchlogs.FilterL
 			output.Events = append(output.Events, e)
 		}
 
-		if fn(output, len(cw.filterLogEvents) == 0) {
+		lastPage := len(cw.filterLogEvents) == 0
+		if !fn(output, lastPage) || lastPage {
 			cw.Unlock()
 			return nil
 		}
@@ -197,9 +306,115 @@ func (cw *mockCloudWatchLogs) FilterLogEventsPages(input *cloudwatchlogs.FilterL
 	}
 }
 
+func (cw *mockCloudWatchLogs) GetLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.GetLogEventsInput, opts ...request.Option) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	cw.Lock()
+	defer cw.Unlock()
+
+	var events []*cloudwatchlogs.OutputLogEvent
+	for _, e := range cw.logEvents {
+		if input.StartTime != nil && *e.Timestamp < *input.StartTime {
+			continue
+		}
+		if input.EndTime != nil && *e.Timestamp >= *input.EndTime {
+			continue
+		}
+		events = append(events, e)
+	}
+
+	if limit := int(aws.Int64Value(input.Limit)); limit > 0 && len(events) > limit {
+		if aws.BoolValue(input.StartFromHead) {
+			events = events[:limit]
+		} else {
+			events = events[len(events)-limit:]
+		}
+	}
+
+	return &cloudwatchlogs.GetLogEventsOutput{Events: events}, nil
+}
+
+func (cw *mockCloudWatchLogs) DescribeLogGroupsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogGroupsInput,
+	fn func(*cloudwatchlogs.DescribeLogGroupsOutput, bool) bool, opts ...request.Option) error {
+	fn(&cloudwatchlogs.DescribeLogGroupsOutput{
+		LogGroups: []*cloudwatchlogs.LogGroup{{
+			LogGroupName: input.LogGroupNamePrefix,
+			Arn:          aws.String("arn:aws:logs:us-east-1:123456789012:log-group:" + aws.StringValue(input.LogGroupNamePrefix) + ":*"),
+		}},
+	}, true)
+	return nil
+}
+
 func (cw *mockCloudWatchLogs) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
 	cw.Lock()
 	defer cw.Unlock()
+	cw.sequenceTokens = append(cw.sequenceTokens, aws.StringValue(input.SequenceToken))
+	if len(cw.putLogEventsErrors) > 0 {
+		err := cw.putLogEventsErrors[0]
+		cw.putLogEventsErrors = cw.putLogEventsErrors[1:]
+		return nil, err
+	}
 	cw.inputLogEvents = append(cw.inputLogEvents, input.LogEvents...)
 	return &cloudwatchlogs.PutLogEventsOutput{}, nil
 }
+
+func TestLogsWatcherRespectsContextWhileWaitingForStream(t *testing.T) {
+	w := logWatcher{
+		LogGroupName:  "my-group",
+		LogStreamName: "my-stream",
+		Interval:      time.Millisecond * 5,
+		CloudWatchLogs: &mockCloudWatchLogs{
+			logStreams: []*cloudwatchlogs.LogStream{},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	err := w.Watch(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("bad error %v", err)
+	}
+}
+
+func TestAWSLogsConfig(t *testing.T) {
+	lc, ok := awslogsConfig(&ecs.LogConfiguration{
+		LogDriver: aws.String("awslogs"),
+		Options: map[string]*string{
+			"awslogs-group":         aws.String("web"),
+			"awslogs-stream-prefix": aws.String("ecs"),
+		},
+	}, "us-east-1")
+	if !ok {
+		t.Fatal("Expected an awslogs config")
+	}
+	if lc != (logConfig{Group: "web", Region: "us-east-1", StreamPrefix: "ecs"}) {
+		t.Fatalf("Bad log config %+v", lc)
+	}
+
+	if _, ok := awslogsConfig(&ecs.LogConfiguration{
+		LogDriver: aws.String("awslogs"),
+		Options:   map[string]*string{"awslogs-group": aws.String("web")},
+	}, "us-east-1"); ok {
+		t.Fatal("Expected no config without a stream prefix")
+	}
+
+	if _, ok := awslogsConfig(&ecs.LogConfiguration{LogDriver: aws.String("fluentd")}, ""); ok {
+		t.Fatal("Expected no config for another log driver")
+	}
+}
+
+func TestDeleteLogStream(t *testing.T) {
+	cwlc := &mockCloudWatchLogs{
+		logStreams: []*cloudwatchlogs.LogStream{
+			{LogStreamName: aws.String("my-stream")},
+			{LogStreamName: aws.String("other-stream")},
+		},
+	}
+
+	if err := deleteLogStream(cwlc, "my-group", "my-stream"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cwlc.logStreams) != 1 || *cwlc.logStreams[0].LogStreamName != "other-stream" {
+		t.Fatalf("Expected only my-stream to be deleted, got %v", cwlc.logStreams)
+	}
+}
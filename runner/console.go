@@ -0,0 +1,76 @@
+package runner
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// consoleURL returns the AWS console URL of a path in a region, on the
+// console of the region's partition
+func consoleURL(region, p string) string {
+	var host string
+	switch partitionForRegion(region) {
+	case "aws-cn":
+		host = region + ".console.amazonaws.cn"
+	case "aws-us-gov":
+		host = "console.amazonaws-us-gov.com"
+	default:
+		host = region + ".console.aws.amazon.com"
+	}
+	return fmt.Sprintf("https://%s%s?region=%s", host, p, url.QueryEscape(region))
+}
+
+// taskConsoleURL returns the console URL of a task's detail page, or nothing
+// if the task's ARN can't be parsed
+func taskConsoleURL(task *ecs.Task) string {
+	a, err := arn.Parse(aws.StringValue(task.TaskArn))
+	if err != nil {
+		return ""
+	}
+
+	// older task ARNs don't include the cluster
+	parts := strings.Split(a.Resource, "/")
+	cluster := path.Base(aws.StringValue(task.ClusterArn))
+	if len(parts) == 3 {
+		cluster = parts[1]
+	}
+	if cluster == "" || cluster == "." {
+		return ""
+	}
+	return consoleURL(a.Region, fmt.Sprintf("/ecs/v2/clusters/%s/tasks/%s/configuration",
+		url.PathEscape(cluster), url.PathEscape(parts[len(parts)-1])))
+}
+
+// logStreamConsoleURL returns the console URL of a log stream's events
+func logStreamConsoleURL(region, group, stream string) string {
+	return consoleURL(region, "/cloudwatch/home") + fmt.Sprintf("#logsV2:log-groups/log-group/%s/log-events/%s",
+		consoleEscape(group), consoleEscape(stream))
+}
+
+// consoleEscape escapes a name in a CloudWatch console fragment, which is
+// query escaped with its percent signs escaped again as $25
+func consoleEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "%", "$25", -1)
+}
+
+// printConsoleLinks prints where to find each task and the logs of its
+// containers in the AWS console
+func (r *Runner) printConsoleLinks(td *preparedTaskDefinition, tasks []*ecs.Task) {
+	for _, task := range tasks {
+		if u := taskConsoleURL(task); u != "" {
+			fmt.Fprintf(r.Stderr, "Task %s: %s\n", path.Base(aws.StringValue(task.TaskArn)), u)
+		}
+		for _, container := range task.Containers {
+			if lc, ok := td.streamedLogs(aws.StringValue(container.Name)); ok && !r.NoLogs {
+				fmt.Fprintf(r.Stderr, "Logs of %s: %s\n", aws.StringValue(container.Name),
+					logStreamConsoleURL(lc.Region, lc.Group, logStreamName(lc.StreamPrefix, container, task)))
+			}
+		}
+	}
+}
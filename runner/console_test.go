@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestTaskConsoleURL(t *testing.T) {
+	for _, tc := range []struct {
+		task     *ecs.Task
+		expected string
+	}{
+		{
+			&ecs.Task{TaskArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/abc")},
+			"https://us-east-1.console.aws.amazon.com/ecs/v2/clusters/default/tasks/abc/configuration?region=us-east-1",
+		},
+		{
+			&ecs.Task{
+				TaskArn:    aws.String("arn:aws-cn:ecs:cn-north-1:123456789012:task/abc"),
+				ClusterArn: aws.String("arn:aws-cn:ecs:cn-north-1:123456789012:cluster/jobs"),
+			},
+			"https://cn-north-1.console.amazonaws.cn/ecs/v2/clusters/jobs/tasks/abc/configuration?region=cn-north-1",
+		},
+		{
+			&ecs.Task{TaskArn: aws.String("arn:aws-us-gov:ecs:us-gov-west-1:123456789012:task/default/abc")},
+			"https://console.amazonaws-us-gov.com/ecs/v2/clusters/default/tasks/abc/configuration?region=us-gov-west-1",
+		},
+		{&ecs.Task{TaskArn: aws.String("abc")}, ""},
+	} {
+		if u := taskConsoleURL(tc.task); u != tc.expected {
+			t.Errorf("Expected %q, got %q", tc.expected, u)
+		}
+	}
+}
+
+func TestLogStreamConsoleURL(t *testing.T) {
+	u := logStreamConsoleURL("eu-west-1", "/ecs/jobs", "run_1/app/abc")
+	expected := "https://eu-west-1.console.aws.amazon.com/cloudwatch/home?region=eu-west-1" +
+		"#logsV2:log-groups/log-group/$252Fecs$252Fjobs/log-events/run_1$252Fapp$252Fabc"
+	if u != expected {
+		t.Errorf("Expected %q, got %q", expected, u)
+	}
+}
@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"fmt"
+	"path"
+	"sync"
+)
+
+// ContainerError is an error that stopped a container's logs from being
+// followed, sent to Runner.ContainerErrors
+type ContainerError struct {
+	Task      string
+	Container string
+	Err       error
+}
+
+func (e *ContainerError) Error() string {
+	return fmt.Sprintf("Failed to stream logs of container %s in task %s: %v", e.Container, path.Base(e.Task), e.Err)
+}
+
+func (e *ContainerError) Unwrap() error {
+	return e.Err
+}
+
+// containerPrinters returns a func that picks where a container's log lines
+// are printed, which is its writer in ContainerOutput or else printLine.
+// Several tasks' containers can share a writer, so lines are written to each
+// one at a time. Lines are still captured for the result cache.
+func (r *Runner) containerPrinters(printLine func(string), captured *capturedOutput) func(container string) func(string) {
+	printers := map[string]func(string){}
+	for name, w := range r.ContainerOutput {
+		w := w
+		var mu sync.Mutex
+		printers[name] = captured.wrap(func(line string) {
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintln(w, line)
+		})
+	}
+	return func(container string) func(string) {
+		if printer, ok := printers[container]; ok {
+			return printer
+		}
+		return printLine
+	}
+}
+
+// sendContainerError sends an error following a container's logs to
+// ContainerErrors without blocking, so a full channel can't hold up the run
+func (r *Runner) sendContainerError(task, container string, err error) {
+	if r.ContainerErrors == nil {
+		return
+	}
+	select {
+	case r.ContainerErrors <- &ContainerError{Task: task, Container: container, Err: err}:
+	default:
+		r.logf("Dropped error for container %s, ContainerErrors is full: %v", container, err)
+	}
+}
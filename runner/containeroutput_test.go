@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestSimulatedContainerOutput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("simulated runs wait for logs and services")
+	}
+
+	var stdout, stderr, container bytes.Buffer
+	r := New()
+	r.Config = aws.NewConfig().WithRegion("us-east-1")
+	r.TaskDefinitionFile = "../examples/helloworld/taskdefinition.json"
+	r.Cluster = "default"
+	r.LogGroupName = "ecs-task-runner"
+	r.Count = 2
+	r.Simulate = SimulateOOM
+	r.ContainerOutput = map[string]io.Writer{"hello-world": &container}
+	r.Stdout, r.Stderr = &stdout, &stderr
+
+	if err := r.Run(context.Background()); ExitCode(err) != 137 {
+		t.Fatalf("Expected the simulated OOM exit code, got %v\n%s", err, stderr.String())
+	}
+	if !strings.Contains(container.String(), "Simulated output") {
+		t.Errorf("Expected the container's logs in its writer, got %q", container.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("Expected nothing on stdout, got %q", stdout.String())
+	}
+}
+
+func TestContainerPrinters(t *testing.T) {
+	var shared, app bytes.Buffer
+	r := &Runner{ContainerOutput: map[string]io.Writer{"app": &app}}
+	captured := &capturedOutput{}
+	printerFor := r.containerPrinters(func(line string) {
+		shared.WriteString(line + "\n")
+	}, captured)
+
+	printerFor("app")("from app")
+	printerFor("sidecar")("from sidecar")
+
+	if got := app.String(); got != "from app\n" {
+		t.Errorf("Expected app's line in its writer, got %q", got)
+	}
+	if got := shared.String(); got != "from sidecar\n" {
+		t.Errorf("Expected other containers' lines to be shared, got %q", got)
+	}
+	if got := captured.output(); len(got) != 1 || got[0] != "from app" {
+		t.Errorf("Expected app's line to be captured, got %q", got)
+	}
+}
+
+func TestSendContainerError(t *testing.T) {
+	errs := make(chan *ContainerError, 1)
+	r := &Runner{ContainerErrors: errs}
+	cause := errors.New("AccessDeniedException")
+
+	r.sendContainerError("arn:aws:ecs:us-east-1:000000000000:task/default/abc", "app", cause)
+	// the channel is full, so this is dropped rather than blocking
+	r.sendContainerError("arn:aws:ecs:us-east-1:000000000000:task/default/abc", "app", cause)
+
+	err := <-errs
+	if !errors.Is(err, cause) {
+		t.Errorf("Expected the error to wrap its cause, got %v", err)
+	}
+	if want := "Failed to stream logs of container app in task abc: AccessDeniedException"; err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+	if len(errs) != 0 {
+		t.Errorf("Expected the second error to be dropped")
+	}
+
+	// without a channel, errors are only warned about
+	(&Runner{}).sendContainerError("task", "app", cause)
+}
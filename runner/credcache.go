@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// credentialsCache shares credentials that are fetched with an API call, such
+// as by assuming a role, between the runs in a process. Running against many
+// targets, or many runs from a pool, would otherwise assume the same role for
+// each run and get throttled by STS. Shared credentials are refreshed once
+// they expire, for every run that uses them.
+type credentialsCache struct {
+	mu    sync.Mutex
+	creds map[string]cachedCredentials
+}
+
+// cachedCredentials are credentials along with the source credentials they
+// were fetched with, if any
+type cachedCredentials struct {
+	source string
+	creds  *credentials.Credentials
+}
+
+var sharedCredentials = &credentialsCache{creds: map[string]cachedCredentials{}}
+
+// get returns the credentials cached for a key, caching those returned by fn
+// if there aren't any, or if they were fetched with other source credentials.
+// Replacing rather than adding to them keeps the cache from growing as source
+// credentials are rotated.
+func (c *credentialsCache) get(key, source string, fn func() *credentials.Credentials) *credentials.Credentials {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.creds[key]; ok && cached.source == source {
+		return cached.creds
+	}
+	creds := fn()
+	c.creds[key] = cachedCredentials{source: source, creds: creds}
+	return creds
+}
+
+// assumeRoleCredentials returns credentials for a role assumed with a
+// session's credentials, shared with other runs that assume the same role
+// with the same credentials
+func assumeRoleCredentials(sess *session.Session, roleARN, externalID string) *credentials.Credentials {
+	assume := func() *credentials.Credentials {
+		return stscreds.NewCredentials(sess, roleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = "ecs-run-task"
+			if externalID != "" {
+				p.ExternalID = aws.String(externalID)
+			}
+		})
+	}
+
+	// the role is assumed again for different source credentials, which
+	// don't need a call to get unless they're expired
+	source, err := sess.Config.Credentials.Get()
+	if err != nil {
+		return assume()
+	}
+	key := strings.Join([]string{"role", roleARN, externalID}, "\x00")
+	return sharedCredentials.get(key, source.AccessKeyID, func() *credentials.Credentials {
+		log.Printf("Caching credentials for role %s", roleARN)
+		return assume()
+	})
+}
+
+// credentialsEnv are the environment variables that choose the credentials
+// the SDK finds when a config has none
+var credentialsEnv = []string{
+	"AWS_PROFILE",
+	"AWS_DEFAULT_PROFILE",
+	"AWS_SDK_LOAD_CONFIG",
+	"AWS_CONFIG_FILE",
+	"AWS_SHARED_CREDENTIALS_FILE",
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SESSION_TOKEN",
+	"AWS_ROLE_ARN",
+	"AWS_WEB_IDENTITY_TOKEN_FILE",
+	"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+	"AWS_CONTAINER_CREDENTIALS_FULL_URI",
+}
+
+// profileCredentialsKey returns the cache key for the credentials the SDK
+// finds from the environment, hashed as it includes secrets
+func profileCredentialsKey() string {
+	h := sha256.New()
+	for _, name := range credentialsEnv {
+		h.Write([]byte(name + "=" + os.Getenv(name) + "\x00"))
+	}
+	return "profile\x00" + hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedProfileCredentials returns the credentials that another session found
+// from the same environment, if they expire, such as from a profile that
+// assumes a role or SSO. Credentials that don't expire are cheap to find, so
+// each session finds them again, picking up changes to them. So are instance
+// and task roles, which the SDK finds through a chain of providers that
+// doesn't say when they expire, and fetches from a local endpoint.
+func cachedProfileCredentials() *credentials.Credentials {
+	sharedCredentials.mu.Lock()
+	defer sharedCredentials.mu.Unlock()
+	return sharedCredentials.creds[profileCredentialsKey()].creds
+}
+
+// cacheProfileCredentials shares the credentials a session found from the
+// environment with later sessions, if they expire
+func cacheProfileCredentials(sess *session.Session) {
+	if _, err := sess.Config.Credentials.ExpiresAt(); err != nil {
+		return
+	}
+	sharedCredentials.get(profileCredentialsKey(), "", func() *credentials.Credentials {
+		return sess.Config.Credentials
+	})
+}
@@ -0,0 +1,102 @@
+package runner
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func resetSharedCredentials(t *testing.T) {
+	sharedCredentials = &credentialsCache{creds: map[string]cachedCredentials{}}
+	t.Cleanup(func() {
+		sharedCredentials = &credentialsCache{creds: map[string]cachedCredentials{}}
+	})
+}
+
+func TestAssumeRoleCredentialsAreShared(t *testing.T) {
+	resetSharedCredentials(t)
+
+	var mu sync.Mutex
+	assumed := map[string]int{}
+	handle := func(req *request.Request) {
+		in := req.Params.(*sts.AssumeRoleInput)
+		mu.Lock()
+		assumed[aws.StringValue(in.RoleArn)+"/"+aws.StringValue(in.ExternalId)]++
+		mu.Unlock()
+		req.Data.(*sts.AssumeRoleOutput).Credentials = &sts.Credentials{
+			AccessKeyId:     aws.String("ASSUMED"),
+			SecretAccessKey: aws.String("SECRET"),
+			SessionToken:    aws.String("TOKEN"),
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		}
+	}
+	sess := fakeSession(handle)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := assumeRoleCredentials(sess, "arn:aws:iam::123456789012:role/deploy", "").Get(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := assumeRoleCredentials(sess, "arn:aws:iam::123456789012:role/deploy", "other").Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	if assumed["arn:aws:iam::123456789012:role/deploy/"] != 1 || assumed["arn:aws:iam::123456789012:role/deploy/other"] != 1 {
+		t.Errorf("Expected each role and external id to be assumed once, got %v", assumed)
+	}
+
+	// rotated source credentials replace the role's credentials rather than
+	// being cached alongside them
+	rotated := fakeSession(handle)
+	rotated.Config.Credentials = credentials.NewStaticCredentials("ROTATED", "SECRET", "")
+	if _, err := assumeRoleCredentials(rotated, "arn:aws:iam::123456789012:role/deploy", "").Get(); err != nil {
+		t.Fatal(err)
+	}
+	if assumed["arn:aws:iam::123456789012:role/deploy/"] != 2 || len(sharedCredentials.creds) != 2 {
+		t.Errorf("Expected rotated credentials to replace the cached ones, got %v and %d cached", assumed, len(sharedCredentials.creds))
+	}
+}
+
+type expiringProvider struct {
+	credentials.Expiry
+}
+
+func (p *expiringProvider) Retrieve() (credentials.Value, error) {
+	p.SetExpiration(time.Now().Add(time.Hour), 0)
+	return credentials.Value{AccessKeyID: "SSO", SecretAccessKey: "SECRET"}, nil
+}
+
+func TestProfileCredentialsAreSharedIfTheyExpire(t *testing.T) {
+	resetSharedCredentials(t)
+	t.Setenv("AWS_PROFILE", "credcache-test")
+
+	static := session.Must(session.NewSession(aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("AKID", "SECRET", ""))))
+	cacheProfileCredentials(static)
+	if creds := cachedProfileCredentials(); creds != nil {
+		t.Fatal("Expected credentials that don't expire not to be shared")
+	}
+
+	expiring := credentials.NewCredentials(&expiringProvider{})
+	cacheProfileCredentials(session.Must(session.NewSession(aws.NewConfig().WithCredentials(expiring))))
+	if creds := cachedProfileCredentials(); creds != expiring {
+		t.Fatal("Expected credentials that expire to be shared")
+	}
+
+	t.Setenv("AWS_PROFILE", "another-profile")
+	if creds := cachedProfileCredentials(); creds != nil {
+		t.Fatal("Expected another profile's credentials not to be shared")
+	}
+}
@@ -0,0 +1,61 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// refreshExpiredCredentials retries a call that failed because the session's
+// credentials expired, once, after the SDK expires them locally so that they're
+// fetched again. That refreshes credentials from an assumed role, SSO or an
+// instance or task role, which can be rejected as expired before the SDK
+// thinks they are. Static credentials come back the same, so the retry fails.
+func refreshExpiredCredentials(sess *session.Session) {
+	sess.Handlers.Retry.PushBack(func(req *request.Request) {
+		if !req.IsErrorExpired() {
+			return
+		}
+		// the retry state is kept between attempts, so it's set either way
+		req.Retryable = aws.Bool(req.RetryCount == 0)
+		if req.RetryCount == 0 {
+			logf(req.Context(), "Credentials expired during %s, refreshing them", req.Operation.Name)
+		}
+	})
+}
+
+// isExpiredCredentials returns whether a call failed because the credentials
+// expired and couldn't be refreshed
+func isExpiredCredentials(err error) bool {
+	var ae awserr.Error
+	if !errors.As(err, &ae) {
+		return false
+	}
+	return request.IsErrorExpiredCreds(ae) || ae.Code() == ssocreds.ErrCodeSSOProviderInvalidToken
+}
+
+// printCredentialsExpired explains that tasks are still running after the
+// credentials used to follow them expired, and how to keep following them
+func printCredentialsExpired(w io.Writer, cluster string, tasks []*ecs.Task, stateFile string) {
+	var arns []string
+	for _, task := range tasks {
+		arns = append(arns, aws.StringValue(task.TaskArn))
+	}
+	fmt.Fprintln(w, "AWS credentials expired during the run and couldn't be refreshed. "+
+		"This only stopped ecs-run-task from following the tasks, which keep running in ECS.")
+	if stateFile != "" {
+		fmt.Fprintf(w, "Once you have fresh credentials, pick up where it left off with:\n  ecs-run-task resume %s\n", stateFile)
+		return
+	}
+	fmt.Fprintln(w, "Once you have fresh credentials, wait for them to stop with:")
+	fmt.Fprintf(w, "  aws ecs wait tasks-stopped --cluster %s --tasks %s\n", cluster, strings.Join(arns, " "))
+	fmt.Fprintln(w, "and search their logs with `ecs-run-task grep TASK PATTERN`, with one of the task ARNs.")
+}
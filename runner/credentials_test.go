@@ -0,0 +1,92 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// countingProvider returns credentials that count how often they're fetched
+type countingProvider struct {
+	retrieved int
+}
+
+func (p *countingProvider) Retrieve() (credentials.Value, error) {
+	p.retrieved++
+	return credentials.Value{AccessKeyID: "AKID", SecretAccessKey: "SECRET", SessionToken: "TOKEN"}, nil
+}
+
+func (p *countingProvider) IsExpired() bool {
+	return false
+}
+
+func TestRefreshExpiredCredentials(t *testing.T) {
+	provider := &countingProvider{}
+	sess := session.Must(session.NewSession(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewCredentials(provider))))
+
+	var calls int
+	expiredFor := 1
+	sess.Handlers.Send.Clear()
+	sess.Handlers.Send.PushBack(func(req *request.Request) {
+		calls++
+		req.HTTPResponse = &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(&bytes.Buffer{})}
+		if calls <= expiredFor {
+			req.HTTPResponse.StatusCode = http.StatusBadRequest
+			req.Error = awserr.NewRequestFailure(awserr.New("ExpiredTokenException", "The security token included in the request is expired", nil), http.StatusBadRequest, "1")
+		}
+	})
+	refreshExpiredCredentials(sess)
+	svc := ecs.New(sess)
+
+	if _, err := svc.DescribeTasks(&ecs.DescribeTasksInput{Tasks: aws.StringSlice([]string{"arn:1"})}); err != nil {
+		t.Fatalf("Expected the call to succeed once credentials were refreshed, got %v", err)
+	}
+	if calls != 2 || provider.retrieved != 2 {
+		t.Fatalf("Expected credentials to be refreshed and the call retried once, got %d calls and %d retrievals", calls, provider.retrieved)
+	}
+
+	// credentials that are still expired once refreshed fail the call
+	calls, expiredFor = 0, 10
+	_, err := svc.DescribeTasks(&ecs.DescribeTasksInput{Tasks: aws.StringSlice([]string{"arn:1"})})
+	if calls != 2 || !isExpiredCredentials(wrapAPIError("DescribeTasks", err)) {
+		t.Fatalf("Expected an expired credentials error after one retry, got %v after %d calls", err, calls)
+	}
+}
+
+func TestIsExpiredCredentials(t *testing.T) {
+	if isExpiredCredentials(errors.New("ExpiredToken")) {
+		t.Fatal("Expected only AWS errors to be expired credentials")
+	}
+	if isExpiredCredentials(wrapAPIError("DescribeTasks", awserr.New("AccessDeniedException", "", nil))) {
+		t.Fatal("Expected access denied not to be expired credentials")
+	}
+	if !isExpiredCredentials(wrapAPIError("GetLogEvents", awserr.New("SSOProviderInvalidToken", "the SSO session has expired", nil))) {
+		t.Fatal("Expected an expired SSO session to be expired credentials")
+	}
+}
+
+func TestPrintCredentialsExpired(t *testing.T) {
+	var buf bytes.Buffer
+	printCredentialsExpired(&buf, "default", []*ecs.Task{{TaskArn: aws.String("arn:1")}, {TaskArn: aws.String("arn:2")}}, "")
+	if !strings.Contains(buf.String(), "aws ecs wait tasks-stopped --cluster default --tasks arn:1 arn:2\n") {
+		t.Fatalf("Expected a command to wait for the tasks, got %q", buf.String())
+	}
+
+	buf.Reset()
+	printCredentialsExpired(&buf, "default", []*ecs.Task{{TaskArn: aws.String("arn:1")}}, "run.json")
+	if !strings.Contains(buf.String(), "ecs-run-task resume run.json\n") {
+		t.Fatalf("Expected a command to resume the run, got %q", buf.String())
+	}
+}
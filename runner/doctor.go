@@ -0,0 +1,182 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// DoctorCheck is the result of one of the checks made by Doctor
+type DoctorCheck struct {
+	Name string
+
+	// Passed is set if the check passed, and Skipped if it couldn't be made
+	// because a check it depends on failed
+	Passed  bool
+	Skipped bool
+
+	// Detail is what was found, or what's wrong and how to fix it
+	Detail string
+}
+
+// Doctor checks that the runner is set up to run tasks, without running any:
+// that it has a region and working credentials, and that its cluster, log
+// group, subnets and security groups exist. Checks that need AWS are skipped
+// once there's no region or the credentials don't work.
+func (r *Runner) Doctor(ctx context.Context) []DoctorCheck {
+	sess, err := newSession(r.Config)
+	return r.doctor(ctx, sess, err)
+}
+
+func (r *Runner) doctor(ctx context.Context, sess *session.Session, err error) []DoctorCheck {
+	var checks []DoctorCheck
+	add := func(name string, err error, detail string) bool {
+		check := DoctorCheck{Name: name, Passed: err == nil, Detail: detail}
+		if err != nil {
+			check.Detail = err.Error()
+		}
+		checks = append(checks, check)
+		return err == nil
+	}
+
+	var region string
+	if err == nil {
+		if region = aws.StringValue(sess.Config.Region); region == "" {
+			err = fmt.Errorf("No region is set, set AWS_REGION")
+		}
+	}
+	ok := add("Region", err, region)
+	if ok {
+		var identity string
+		identity, err = doctorCredentials(ctx, sess)
+		ok = add("Credentials", err, identity)
+	} else {
+		checks = append(checks, DoctorCheck{Name: "Credentials", Skipped: true, Detail: "Needs a region"})
+	}
+
+	// security groups have to be in the subnets' VPC
+	var vpc string
+	for _, check := range []struct {
+		name string
+		skip bool
+		fn   func() (string, error)
+	}{
+		{"Cluster", false, func() (string, error) { return r.doctorCluster(ctx, sess) }},
+		{"Log group", r.NoLogs || r.LogGroupName == "", func() (string, error) { return r.doctorLogGroup(ctx, sess) }},
+		{"Subnets", len(r.Subnets) == 0, func() (detail string, err error) {
+			vpc, detail, err = r.doctorSubnets(ctx, sess)
+			return detail, err
+		}},
+		{"Security groups", len(r.SecurityGroups) == 0, func() (string, error) { return r.doctorSecurityGroups(ctx, sess, vpc) }},
+	} {
+		if check.skip {
+			continue
+		}
+		if !ok {
+			checks = append(checks, DoctorCheck{Name: check.name, Skipped: true, Detail: "Needs a region and working credentials"})
+			continue
+		}
+		detail, err := check.fn()
+		add(check.name, err, detail)
+	}
+	return checks
+}
+
+func doctorCredentials(ctx context.Context, sess *session.Session) (string, error) {
+	resp, err := sts.New(sess).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		if isExpiredCredentials(err) {
+			return "", fmt.Errorf("Credentials have expired, log in again: %v", wrapAPIError("GetCallerIdentity", err))
+		}
+		return "", wrapAPIError("GetCallerIdentity", err)
+	}
+	return aws.StringValue(resp.Arn), nil
+}
+
+func (r *Runner) doctorCluster(ctx context.Context, sess *session.Session) (string, error) {
+	resp, err := ecs.New(sess).DescribeClustersWithContext(ctx, &ecs.DescribeClustersInput{
+		Clusters: aws.StringSlice([]string{r.Cluster}),
+	})
+	if err != nil {
+		return "", wrapAPIError("DescribeClusters", err)
+	}
+	if len(resp.Clusters) == 0 {
+		return "", fmt.Errorf("No cluster named %s in %s, check --cluster and the region", r.Cluster, aws.StringValue(sess.Config.Region))
+	}
+	c := resp.Clusters[0]
+	if status := aws.StringValue(c.Status); status != "ACTIVE" {
+		return "", fmt.Errorf("Cluster %s is %s, so tasks can't be run in it", r.Cluster, status)
+	}
+	return fmt.Sprintf("%s is active, with %d running tasks and %d container instances",
+		aws.StringValue(c.ClusterName), aws.Int64Value(c.RunningTasksCount), aws.Int64Value(c.RegisteredContainerInstancesCount)), nil
+}
+
+func (r *Runner) doctorLogGroup(ctx context.Context, sess *session.Session) (string, error) {
+	var found bool
+	err := cloudwatchlogs.New(r.logsSession(sess)).DescribeLogGroupsPagesWithContext(ctx, &cloudwatchlogs.DescribeLogGroupsInput{
+		LogGroupNamePrefix: aws.String(r.LogGroupName),
+	}, func(page *cloudwatchlogs.DescribeLogGroupsOutput, lastPage bool) bool {
+		for _, group := range page.LogGroups {
+			if aws.StringValue(group.LogGroupName) == r.LogGroupName {
+				found = true
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", logAccessError(r.LogGroupName, r.logRegion(), wrapAPIError("DescribeLogGroups", err))
+	}
+	if !found {
+		return fmt.Sprintf("%s doesn't exist yet, and is created by the first run, which needs logs:CreateLogGroup", r.LogGroupName), nil
+	}
+	return fmt.Sprintf("%s exists", r.LogGroupName), nil
+}
+
+// doctorSubnets checks the subnets exist in one VPC, which it returns
+func (r *Runner) doctorSubnets(ctx context.Context, sess *session.Session) (string, string, error) {
+	resp, err := ec2.New(sess).DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{
+		SubnetIds: aws.StringSlice(r.Subnets),
+	})
+	if err != nil {
+		return "", "", wrapAPIError("DescribeSubnets", err)
+	}
+	if len(resp.Subnets) == 0 {
+		return "", "", fmt.Errorf("No subnets named %s", strings.Join(r.Subnets, ", "))
+	}
+	vpcs := map[string]bool{}
+	for _, subnet := range resp.Subnets {
+		vpcs[aws.StringValue(subnet.VpcId)] = true
+	}
+	if len(vpcs) > 1 {
+		return "", "", fmt.Errorf("Subnets %s are in %d different VPCs, and a task's subnets must all be in one", strings.Join(r.Subnets, ", "), len(vpcs))
+	}
+	vpc := aws.StringValue(resp.Subnets[0].VpcId)
+	return vpc, fmt.Sprintf("%s in %s", strings.Join(r.Subnets, ", "), vpc), nil
+}
+
+// doctorSecurityGroups checks the security groups exist, in the subnets' VPC
+// if it's known
+func (r *Runner) doctorSecurityGroups(ctx context.Context, sess *session.Session, vpc string) (string, error) {
+	resp, err := ec2.New(sess).DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{
+		GroupIds: aws.StringSlice(r.SecurityGroups),
+	})
+	if err != nil {
+		return "", wrapAPIError("DescribeSecurityGroups", err)
+	}
+	var names []string
+	for _, group := range resp.SecurityGroups {
+		if vpc != "" && aws.StringValue(group.VpcId) != vpc {
+			return "", fmt.Errorf("Security group %s is in %s, but the subnets are in %s", aws.StringValue(group.GroupId), aws.StringValue(group.VpcId), vpc)
+		}
+		names = append(names, fmt.Sprintf("%s (%s) in %s", aws.StringValue(group.GroupId), aws.StringValue(group.GroupName), aws.StringValue(group.VpcId)))
+	}
+	return strings.Join(names, ", "), nil
+}
@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func TestDoctor(t *testing.T) {
+	sess := fakeSession(func(req *request.Request) {
+		switch req.Params.(type) {
+		case *sts.GetCallerIdentityInput:
+			req.Data.(*sts.GetCallerIdentityOutput).Arn = aws.String("arn:aws:iam::123456789012:user/jane")
+		case *ecs.DescribeClustersInput:
+			req.Data.(*ecs.DescribeClustersOutput).Clusters = []*ecs.Cluster{{
+				ClusterName:                       aws.String("ci"),
+				Status:                            aws.String("ACTIVE"),
+				RunningTasksCount:                 aws.Int64(2),
+				RegisteredContainerInstancesCount: aws.Int64(3),
+			}}
+		case *cloudwatchlogs.DescribeLogGroupsInput:
+			req.Data.(*cloudwatchlogs.DescribeLogGroupsOutput).LogGroups = []*cloudwatchlogs.LogGroup{
+				{LogGroupName: aws.String("ecs-task-runner-old")},
+			}
+		case *ec2.DescribeSubnetsInput:
+			req.Data.(*ec2.DescribeSubnetsOutput).Subnets = []*ec2.Subnet{
+				{SubnetId: aws.String("subnet-1"), VpcId: aws.String("vpc-1")},
+			}
+		case *ec2.DescribeSecurityGroupsInput:
+			req.Data.(*ec2.DescribeSecurityGroupsOutput).SecurityGroups = []*ec2.SecurityGroup{
+				{GroupId: aws.String("sg-1"), GroupName: aws.String("tasks"), VpcId: aws.String("vpc-2")},
+			}
+		}
+	})
+
+	r := &Runner{
+		Cluster:        "ci",
+		LogGroupName:   "ecs-task-runner",
+		Subnets:        []string{"subnet-1"},
+		SecurityGroups: []string{"sg-1"},
+	}
+	expected := []DoctorCheck{
+		{Name: "Region", Passed: true, Detail: "us-east-1"},
+		{Name: "Credentials", Passed: true, Detail: "arn:aws:iam::123456789012:user/jane"},
+		{Name: "Cluster", Passed: true, Detail: "ci is active, with 2 running tasks and 3 container instances"},
+		{Name: "Log group", Passed: true, Detail: "ecs-task-runner doesn't exist yet, and is created by the first run, which needs logs:CreateLogGroup"},
+		{Name: "Subnets", Passed: true, Detail: "subnet-1 in vpc-1"},
+		{Name: "Security groups", Detail: "Security group sg-1 is in vpc-2, but the subnets are in vpc-1"},
+	}
+	if checks := r.doctor(context.Background(), sess, nil); !reflect.DeepEqual(checks, expected) {
+		t.Errorf("Expected:\n%+v\nGot:\n%+v", expected, checks)
+	}
+}
+
+func TestDoctorWithoutRegion(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	sess := session.Must(session.NewSession())
+	r := &Runner{Cluster: "ci", NoLogs: true}
+	expected := []DoctorCheck{
+		{Name: "Region", Detail: "No region is set, set AWS_REGION"},
+		{Name: "Credentials", Skipped: true, Detail: "Needs a region"},
+		{Name: "Cluster", Skipped: true, Detail: "Needs a region and working credentials"},
+	}
+	if checks := r.doctor(context.Background(), sess, nil); !reflect.DeepEqual(checks, expected) {
+		t.Errorf("Expected:\n%+v\nGot:\n%+v", expected, checks)
+	}
+}
@@ -0,0 +1,82 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// taskStateDumper saves the state of tasks as JSON files at points in their
+// lifecycle, for debugging how a task behaved after the fact
+type taskStateDumper struct {
+	dir    string
+	stderr io.Writer
+	n      int
+	states map[string]string
+}
+
+func newTaskStateDumper(dir string, stderr io.Writer) (*taskStateDumper, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &taskStateDumper{dir: dir, stderr: stderr, states: map[string]string{}}, nil
+}
+
+// dump writes tasks to a numbered file, so files sort in the order they
+// happened. Environment overrides are redacted, as they can have secrets or
+// the artifacts upload URL.
+func (d *taskStateDumper) dump(event string, tasks []*ecs.Task) {
+	if d == nil {
+		return
+	}
+	d.n++
+
+	file := filepath.Join(d.dir, fmt.Sprintf("%03d-%s.json", d.n, event))
+	body, err := json.MarshalIndent(&ecs.DescribeTasksOutput{Tasks: redactTaskOverrides(tasks)}, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(file, body, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(d.stderr, "Failed to dump task state to %s: %v\n", file, err)
+		return
+	}
+	log.Printf("Dumped task state to %s", file)
+
+	for _, task := range tasks {
+		d.states[*task.TaskArn] = taskState(task)
+	}
+}
+
+// dumpIfChanged dumps tasks if any task or container status has changed
+// since they were last dumped
+func (d *taskStateDumper) dumpIfChanged(tasks []*ecs.Task) {
+	if d == nil {
+		return
+	}
+	for _, task := range tasks {
+		if d.states[*task.TaskArn] != taskState(task) {
+			d.dump("changed", tasks)
+			return
+		}
+	}
+}
+
+// taskState summarizes the status of a task and its containers
+func taskState(task *ecs.Task) string {
+	states := []string{aws.StringValue(task.LastStatus)}
+	for _, container := range task.Containers {
+		states = append(states, aws.StringValue(container.Name)+"="+aws.StringValue(container.LastStatus))
+	}
+	return strings.Join(states, ",")
+}
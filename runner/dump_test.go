@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestTaskStateDumper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := newTaskStateDumper(filepath.Join(dir, "state"), ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := func(status string) []*ecs.Task {
+		return []*ecs.Task{{
+			TaskArn:    aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/abc"),
+			LastStatus: aws.String(status),
+			Containers: []*ecs.Container{{Name: aws.String("app"), LastStatus: aws.String(status)}},
+			Overrides: &ecs.TaskOverride{ContainerOverrides: []*ecs.ContainerOverride{{
+				Name:        aws.String("app"),
+				Environment: []*ecs.KeyValuePair{{Name: aws.String(artifactsURLEnv), Value: aws.String("https://bucket.s3.amazonaws.com/key?X-Amz-Signature=abc")}},
+			}}},
+		}}
+	}
+
+	d.dump("started", task("PENDING"))
+	d.dumpIfChanged(task("PENDING"))
+	d.dumpIfChanged(task("RUNNING"))
+	d.dumpIfChanged(task("RUNNING"))
+	d.dump("final", task("STOPPED"))
+
+	files, err := filepath.Glob(filepath.Join(dir, "state", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+
+	expected := []string{"001-started.json", "002-changed.json", "003-final.json"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("Expected %v, got %v", expected, files)
+	}
+
+	body, err := ioutil.ReadFile(filepath.Join(dir, "state", "003-final.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), "X-Amz-Signature") || !strings.Contains(string(body), redactedValue) {
+		t.Errorf("Expected environment overrides to be redacted, got %s", body)
+	}
+}
+
+func TestNilTaskStateDumper(t *testing.T) {
+	d, err := newTaskStateDumper("", ioutil.Discard)
+	if err != nil || d != nil {
+		t.Fatalf("Expected no dumper without a directory, got %v, %v", d, err)
+	}
+	d.dump("started", nil)
+	d.dumpIfChanged(nil)
+}
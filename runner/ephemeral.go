@@ -0,0 +1,61 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// streamedLog is a log stream whose output has been printed in full
+type streamedLog struct {
+	Config logConfig
+	Stream string
+}
+
+// streamedLogs records the log streams of a run that have been printed up to
+// their container's finished message, and so are safe to delete
+type streamedLogs struct {
+	mu      sync.Mutex
+	streams []streamedLog
+}
+
+func (sl *streamedLogs) add(lc logConfig, stream string) {
+	if sl == nil {
+		return
+	}
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.streams = append(sl.streams, streamedLog{Config: lc, Stream: stream})
+}
+
+// deleteStreamedLogs deletes log streams that were printed in full, leaving any
+// that weren't so their output isn't lost
+func (r *Runner) deleteStreamedLogs(cwl *cloudWatchLogsClients, sl *streamedLogs) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	var deleted int
+	for _, s := range sl.streams {
+		if err := deleteLogStream(cwl.forRegion(s.Config.Region), s.Config.Group, s.Stream); err != nil {
+			fmt.Fprintf(r.Stderr, "Failed to delete log stream %s: %v\n", s.Stream, err)
+			continue
+		}
+		deleted++
+	}
+
+	if deleted > 0 {
+		fmt.Fprintf(r.Stderr, "Deleted %d log streams\n", deleted)
+	}
+}
+
+func deleteLogStream(c cloudwatchLogsInterface, group, stream string) error {
+	log.Printf("Deleting log stream %s in %s", stream, group)
+	_, err := c.DeleteLogStream(&cloudwatchlogs.DeleteLogStreamInput{
+		LogGroupName:  aws.String(group),
+		LogStreamName: aws.String(stream),
+	})
+	return wrapAPIError("DeleteLogStream", err)
+}
@@ -0,0 +1,147 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Exit codes for failures other than a container exiting non-zero, whose exit
+// code is passed through. These are distinct from common container exit codes
+// so that CI can tell infrastructure problems from application ones.
+const (
+	ExitCodeError     = 1
+	ExitCodeAPI       = 70
+	ExitCodeTimeout   = 71
+	ExitCodePlacement = 72
+	ExitCodeNoTasks   = 73
+)
+
+// ExitCode returns the process exit code for an error returned by the runner
+func ExitCode(err error) int {
+	var (
+		ee           *exitError
+		placementErr *PlacementError
+		noTasksErr   *NoTasksError
+		timeoutErr   *TimeoutError
+		apiErr       *APIError
+	)
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &ee):
+		return ee.exitCode
+	case errors.As(err, &placementErr):
+		return ExitCodePlacement
+	case errors.As(err, &noTasksErr):
+		return ExitCodeNoTasks
+	case errors.As(err, &timeoutErr), errors.Is(err, context.DeadlineExceeded):
+		return ExitCodeTimeout
+	case errors.As(err, &apiErr):
+		return ExitCodeAPI
+	}
+	return ExitCodeError
+}
+
+// PlacementError is returned when ECS fails to start some or all of the tasks,
+// such as when there isn't enough capacity in the cluster
+type PlacementError struct {
+	Failures []*ecs.Failure
+	Started  int
+	Count    int64
+
+	// Reason is why tasks couldn't be placed, if it's known before starting them
+	Reason string
+}
+
+func (e *PlacementError) Error() string {
+	if e.Reason != "" {
+		return e.Reason
+	}
+	if e.Started == 0 {
+		return fmt.Sprintf("No tasks were started, %d failures", len(e.Failures))
+	}
+	return fmt.Sprintf("%d of %d tasks failed to start", len(e.Failures), e.Count)
+}
+
+// NoTasksError is returned when there are no tasks to run, such as when the
+// count is 0, unless NoTasks is NoTasksSucceed
+type NoTasksError struct {
+	Reason string
+}
+
+func (e *NoTasksError) Error() string {
+	return fmt.Sprintf("No tasks to run: %s", e.Reason)
+}
+
+// TimeoutError is returned when waiting for something took too long
+type TimeoutError struct {
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// APIError is an error returned by an AWS API call, along with the operation
+// that was being called so the request can be traced
+type APIError struct {
+	Operation string
+	Err       error
+}
+
+func (e *APIError) Error() string {
+	if rf, ok := e.Err.(awserr.RequestFailure); ok {
+		return fmt.Sprintf("%s failed: %s: %s (status %d, request id %s)",
+			e.Operation, rf.Code(), rf.Message(), rf.StatusCode(), rf.RequestID())
+	}
+	if ae, ok := e.Err.(awserr.Error); ok {
+		return fmt.Sprintf("%s failed: %s: %s", e.Operation, ae.Code(), ae.Message())
+	}
+	return fmt.Sprintf("%s failed: %v", e.Operation, e.Err)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Code returns the AWS error code, if there is one
+func (e *APIError) Code() string {
+	if ae, ok := e.Err.(awserr.Error); ok {
+		return ae.Code()
+	}
+	return ""
+}
+
+// RequestID returns the AWS request id, if the request reached AWS
+func (e *APIError) RequestID() string {
+	if rf, ok := e.Err.(awserr.RequestFailure); ok {
+		return rf.RequestID()
+	}
+	return ""
+}
+
+// wrapAPIError wraps a non-nil error from an AWS API call in an APIError
+func wrapAPIError(operation string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &APIError{Operation: operation, Err: err}
+}
+
+// noTasks returns the outcome of a run with nothing to start, which fails
+// unless NoTasks is NoTasksSucceed
+func (r *Runner) noTasks(reason string) error {
+	if r.NoTasks == NoTasksSucceed {
+		fmt.Fprintf(r.Stderr, "No tasks to run, %s\n", reason)
+		return nil
+	}
+	return &NoTasksError{Reason: reason}
+}
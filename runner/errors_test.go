@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestAPIErrorIncludesRequestID(t *testing.T) {
+	err := wrapAPIError("RunTask", awserr.NewRequestFailure(
+		awserr.New("ClientException", "No Container Instances were found in your cluster.", nil),
+		400, "0d7a8f24-example",
+	))
+
+	expected := "RunTask failed: ClientException: No Container Instances were found in your cluster. (status 400, request id 0d7a8f24-example)"
+	if err.Error() != expected {
+		t.Fatalf("Expected %q, got %q", expected, err.Error())
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatal("Expected an APIError")
+	}
+	if apiErr.RequestID() != "0d7a8f24-example" || apiErr.Code() != "ClientException" {
+		t.Fatalf("Bad request id %q or code %q", apiErr.RequestID(), apiErr.Code())
+	}
+}
+
+func TestWrapAPIErrorNil(t *testing.T) {
+	if err := wrapAPIError("RunTask", nil); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		expected int
+	}{
+		{nil, 0},
+		{&exitError{errors.New("container app exited with 3"), 3}, 3},
+		{errors.New("Failed to parse taskdefinition.json"), ExitCodeError},
+		{wrapAPIError("RunTask", errors.New("connection reset")), ExitCodeAPI},
+		{&TimeoutError{errors.New("Timed out waiting for stream")}, ExitCodeTimeout},
+		{fmt.Errorf("waiting: %w", context.DeadlineExceeded), ExitCodeTimeout},
+		{&PlacementError{Count: 1}, ExitCodePlacement},
+		{&NoTasksError{Reason: "the count is 0"}, ExitCodeNoTasks},
+	} {
+		if actual := ExitCode(tc.err); actual != tc.expected {
+			t.Errorf("Expected %d for %v, got %d", tc.expected, tc.err, actual)
+		}
+	}
+}
+
+func TestPlacementError(t *testing.T) {
+	failures := []*ecs.Failure{{Reason: aws.String("RESOURCE:MEMORY")}}
+
+	if err := (&PlacementError{Failures: failures, Count: 1}); err.Error() != "No tasks were started, 1 failures" {
+		t.Fatalf("Unexpected error %q", err.Error())
+	}
+	if err := (&PlacementError{Failures: failures, Started: 2, Count: 3}); err.Error() != "1 of 3 tasks failed to start" {
+		t.Fatalf("Unexpected error %q", err.Error())
+	}
+}
+
+func TestNoTasks(t *testing.T) {
+	var stderr bytes.Buffer
+	r := &Runner{NoTasks: NoTasksFail, Stderr: &stderr}
+	if err := r.RunTargets(context.Background(), nil); ExitCode(err) != ExitCodeNoTasks {
+		t.Fatalf("Expected no targets to fail with %d, got %v", ExitCodeNoTasks, err)
+	}
+
+	r.NoTasks = NoTasksSucceed
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatalf("Expected a count of 0 to succeed, got %v", err)
+	}
+	if stderr.String() != "No tasks to run, the count is 0\n" {
+		t.Fatalf("Unexpected output %q", stderr.String())
+	}
+}
@@ -0,0 +1,282 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	// taskEventFallbackInterval is how often tasks are still described when
+	// waiting on task events, in case an event is missed
+	taskEventFallbackInterval = time.Minute
+
+	// taskEventReceiveWait is how long each receive long polls for, which
+	// SQS limits to 20 seconds
+	taskEventReceiveWait = 20
+)
+
+type eventBridgeInterface interface {
+	PutRule(input *eventbridge.PutRuleInput) (*eventbridge.PutRuleOutput, error)
+	PutTargets(input *eventbridge.PutTargetsInput) (*eventbridge.PutTargetsOutput, error)
+	RemoveTargets(input *eventbridge.RemoveTargetsInput) (*eventbridge.RemoveTargetsOutput, error)
+	DeleteRule(input *eventbridge.DeleteRuleInput) (*eventbridge.DeleteRuleOutput, error)
+}
+
+type sqsInterface interface {
+	CreateQueue(input *sqs.CreateQueueInput) (*sqs.CreateQueueOutput, error)
+	GetQueueAttributes(input *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error)
+	SetQueueAttributes(input *sqs.SetQueueAttributesInput) (*sqs.SetQueueAttributesOutput, error)
+	ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
+	DeleteQueue(input *sqs.DeleteQueueInput) (*sqs.DeleteQueueOutput, error)
+}
+
+// taskEventSubscription receives ECS task state change events for a cluster
+// through a short-lived EventBridge rule and SQS queue, so that changes to
+// tasks are seen without constantly describing them
+type taskEventSubscription struct {
+	events eventBridgeInterface
+	sqs    sqsInterface
+	stderr io.Writer
+
+	name     string
+	queueURL string
+	ruleARN  string
+	targeted bool
+
+	// polling is set once events can't be received, after which tasks are
+	// described every interval instead
+	polling bool
+}
+
+// subscribeTaskEvents creates a queue and a rule that sends task state change
+// events for a cluster to it. The subscription must be closed to remove them.
+func subscribeTaskEvents(sess *session.Session, cluster string, stderr io.Writer) (*taskEventSubscription, error) {
+	s := &taskEventSubscription{
+		events: eventbridge.New(sess),
+		sqs:    sqs.New(sess),
+		stderr: stderr,
+		name:   "ecs-run-task-" + randomHex(8),
+	}
+	if err := s.create(cluster); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *taskEventSubscription) create(cluster string) error {
+	log.Printf("Creating queue %s for task events", s.name)
+	queue, err := s.sqs.CreateQueue(&sqs.CreateQueueInput{
+		QueueName: aws.String(s.name),
+		Attributes: map[string]*string{
+			sqs.QueueAttributeNameMessageRetentionPeriod: aws.String("3600"),
+		},
+	})
+	if err != nil {
+		return wrapAPIError("CreateQueue", err)
+	}
+	s.queueURL = *queue.QueueUrl
+
+	attrs, err := s.sqs.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       queue.QueueUrl,
+		AttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameQueueArn}),
+	})
+	if err != nil {
+		return wrapAPIError("GetQueueAttributes", err)
+	}
+	queueARN := aws.StringValue(attrs.Attributes[sqs.QueueAttributeNameQueueArn])
+
+	pattern, err := taskEventPattern(cluster)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Creating rule %s for task events", s.name)
+	rule, err := s.events.PutRule(&eventbridge.PutRuleInput{
+		Name:         aws.String(s.name),
+		Description:  aws.String("Task state changes for a running ecs-run-task"),
+		EventPattern: aws.String(pattern),
+	})
+	if err != nil {
+		return wrapAPIError("PutRule", err)
+	}
+	s.ruleARN = *rule.RuleArn
+
+	policy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []interface{}{map[string]interface{}{
+			"Effect":    "Allow",
+			"Principal": map[string]string{"Service": "events.amazonaws.com"},
+			"Action":    "sqs:SendMessage",
+			"Resource":  queueARN,
+			"Condition": map[string]interface{}{
+				"ArnEquals": map[string]string{"aws:SourceArn": s.ruleARN},
+			},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.sqs.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		QueueUrl: queue.QueueUrl,
+		Attributes: map[string]*string{
+			sqs.QueueAttributeNamePolicy: aws.String(string(policy)),
+		},
+	})
+	if err != nil {
+		return wrapAPIError("SetQueueAttributes", err)
+	}
+
+	resp, err := s.events.PutTargets(&eventbridge.PutTargetsInput{
+		Rule:    aws.String(s.name),
+		Targets: []*eventbridge.Target{{Id: aws.String("queue"), Arn: aws.String(queueARN)}},
+	})
+	if err != nil {
+		return wrapAPIError("PutTargets", err)
+	}
+	s.targeted = true
+	if aws.Int64Value(resp.FailedEntryCount) > 0 {
+		return fmt.Errorf("Failed to add queue %s as a target of rule %s: %s",
+			s.name, s.name, aws.StringValue(resp.FailedEntries[0].ErrorMessage))
+	}
+
+	return nil
+}
+
+// taskEventPattern matches task state change events for a cluster name or ARN
+func taskEventPattern(cluster string) (string, error) {
+	clusterMatch := []interface{}{cluster}
+	if _, err := arn.Parse(cluster); err != nil {
+		clusterMatch = []interface{}{map[string]string{"suffix": ":cluster/" + cluster}}
+	}
+
+	pattern, err := json.Marshal(map[string]interface{}{
+		"source":      []string{"aws.ecs"},
+		"detail-type": []string{"ECS Task State Change"},
+		"detail": map[string]interface{}{
+			"clusterArn": clusterMatch,
+		},
+	})
+	return string(pattern), err
+}
+
+// wait blocks until there's an event for one of the tasks, or until the
+// fallback interval has passed. Without a subscription, or once events can't
+// be received, it waits for interval.
+func (s *taskEventSubscription) wait(ctx context.Context, taskARNs []*string, interval time.Duration) error {
+	if s == nil || s.polling {
+		select {
+		case <-time.After(interval):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	tasks := map[string]bool{}
+	for _, t := range taskARNs {
+		tasks[*t] = true
+	}
+
+	deadline := time.Now().Add(taskEventFallbackInterval)
+	for time.Now().Before(deadline) {
+		resp, err := s.sqs.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(s.queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(taskEventReceiveWait),
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
+			fmt.Fprintf(s.stderr, "Failed to receive task events, describing tasks every %v instead: %v\n",
+				interval, wrapAPIError("ReceiveMessage", err))
+			s.polling = true
+			return s.wait(ctx, taskARNs, interval)
+		}
+
+		var matched bool
+		for _, msg := range resp.Messages {
+			if taskARN := taskEventARN(aws.StringValue(msg.Body)); tasks[taskARN] {
+				logf(ctx, "Received task state change event for %s", taskARN)
+				matched = true
+			}
+			_, err := s.sqs.DeleteMessage(&sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(s.queueURL),
+				ReceiptHandle: msg.ReceiptHandle,
+			})
+			if err != nil {
+				// the event is received again once it's visible, which only
+				// describes the tasks again
+				logf(ctx, "Failed to delete task event: %v", wrapAPIError("DeleteMessage", err))
+			}
+		}
+		if matched {
+			return nil
+		}
+	}
+
+	logf(ctx, "No task events after %v, describing tasks", taskEventFallbackInterval)
+	return nil
+}
+
+// taskEventARN returns the task ARN from a task state change event
+func taskEventARN(body string) string {
+	var event struct {
+		Detail struct {
+			TaskARN string `json:"taskArn"`
+		} `json:"detail"`
+	}
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		log.Printf("Ignoring event that isn't JSON: %v", err)
+		return ""
+	}
+	return event.Detail.TaskARN
+}
+
+// Close removes the rule and queue, warning rather than failing on errors as
+// the task has already run
+func (s *taskEventSubscription) Close() {
+	if s == nil {
+		return
+	}
+	if s.targeted {
+		if _, err := s.events.RemoveTargets(&eventbridge.RemoveTargetsInput{
+			Rule: aws.String(s.name),
+			Ids:  aws.StringSlice([]string{"queue"}),
+		}); err != nil {
+			fmt.Fprintf(s.stderr, "Failed to remove targets of rule %s: %v\n", s.name, err)
+		}
+	}
+	if s.ruleARN != "" {
+		log.Printf("Deleting rule %s", s.name)
+		if _, err := s.events.DeleteRule(&eventbridge.DeleteRuleInput{Name: aws.String(s.name)}); err != nil {
+			fmt.Fprintf(s.stderr, "Failed to delete rule %s: %v\n", s.name, err)
+		}
+	}
+	if s.queueURL != "" {
+		log.Printf("Deleting queue %s", s.name)
+		if _, err := s.sqs.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: aws.String(s.queueURL)}); err != nil {
+			fmt.Fprintf(s.stderr, "Failed to delete queue %s: %v\n", s.name, err)
+		}
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,127 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestTaskEventPattern(t *testing.T) {
+	for cluster, expected := range map[string]interface{}{
+		"default": []interface{}{map[string]interface{}{"suffix": ":cluster/default"}},
+		"arn:aws:ecs:us-east-1:123456789012:cluster/default": []interface{}{"arn:aws:ecs:us-east-1:123456789012:cluster/default"},
+	} {
+		pattern, err := taskEventPattern(cluster)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var parsed struct {
+			Detail struct {
+				ClusterArn interface{} `json:"clusterArn"`
+			} `json:"detail"`
+		}
+		if err := json.Unmarshal([]byte(pattern), &parsed); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(parsed.Detail.ClusterArn, expected) {
+			t.Errorf("Expected %v for %s, got %v", expected, cluster, parsed.Detail.ClusterArn)
+		}
+	}
+}
+
+type mockSQS struct {
+	sqsInterface
+	messages   []*sqs.Message
+	deleted    int
+	receives   int
+	receiveErr error
+	deleteErr  error
+}
+
+func (m *mockSQS) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	m.receives++
+	if m.receiveErr != nil {
+		return nil, m.receiveErr
+	}
+	if len(m.messages) == 0 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	msg := m.messages[0]
+	m.messages = m.messages[1:]
+	return &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{msg}}, nil
+}
+
+func (m *mockSQS) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	m.deleted++
+	return &sqs.DeleteMessageOutput{}, m.deleteErr
+}
+
+func TestTaskEventSubscriptionWaitsForMatchingTask(t *testing.T) {
+	event := func(taskARN string) *sqs.Message {
+		return &sqs.Message{
+			Body:          aws.String(`{"detail-type":"ECS Task State Change","detail":{"taskArn":"` + taskARN + `","lastStatus":"STOPPED"}}`),
+			ReceiptHandle: aws.String("handle"),
+		}
+	}
+
+	m := &mockSQS{messages: []*sqs.Message{
+		event("arn:aws:ecs:us-east-1:123456789012:task/default/other"),
+		event("arn:aws:ecs:us-east-1:123456789012:task/default/abc"),
+	}}
+	s := &taskEventSubscription{sqs: m, queueURL: "https://sqs.example/queue"}
+
+	err := s.wait(context.Background(), aws.StringSlice([]string{"arn:aws:ecs:us-east-1:123456789012:task/default/abc"}), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.deleted != 2 {
+		t.Fatalf("Expected both messages to be deleted, got %d", m.deleted)
+	}
+
+	// failing to delete an event still wakes the wait
+	m = &mockSQS{messages: []*sqs.Message{event("arn:aws:ecs:us-east-1:123456789012:task/default/abc")}, deleteErr: errors.New("AccessDenied")}
+	s = &taskEventSubscription{sqs: m, queueURL: "https://sqs.example/queue"}
+	if err := s.wait(context.Background(), aws.StringSlice([]string{"arn:aws:ecs:us-east-1:123456789012:task/default/abc"}), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTaskEventSubscriptionFallsBackToPolling(t *testing.T) {
+	var stderr bytes.Buffer
+	m := &mockSQS{receiveErr: errors.New("AccessDenied")}
+	s := &taskEventSubscription{sqs: m, queueURL: "https://sqs.example/queue", stderr: &stderr}
+
+	for i := 0; i < 2; i++ {
+		if err := s.wait(context.Background(), nil, time.Millisecond); err != nil {
+			t.Fatalf("Expected the wait to fall back to the interval, got %v", err)
+		}
+	}
+	if m.receives != 1 {
+		t.Errorf("Expected events to stop being received after the error, got %d receives", m.receives)
+	}
+	if !strings.Contains(stderr.String(), "describing tasks every 1ms instead") {
+		t.Errorf("Expected a warning about the fallback, got %q", stderr.String())
+	}
+}
+
+func TestTaskEventSubscriptionRespectsContext(t *testing.T) {
+	s := &taskEventSubscription{sqs: &mockSQS{}, queueURL: "https://sqs.example/queue"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := s.wait(ctx, nil, time.Hour); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the context's error, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// printExitCodes prints a table of how each container of stopped tasks
+// exited, so that when several fail it's clear which and why. Containers
+// don't report their own times, so the duration is the task's.
+func (r *Runner) printExitCodes(w io.Writer, td *preparedTaskDefinition, tasks []*ecs.Task) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTAINER\tTASK\tEXIT\tDURATION\tREASON\tLOG STREAM")
+	for _, task := range tasks {
+		duration := "-"
+		if task.StartedAt != nil && task.StoppedAt != nil {
+			duration = task.StoppedAt.Sub(*task.StartedAt).Round(time.Second).String()
+		}
+		for _, container := range task.Containers {
+			exit, reason := "-", containerStopReason(task, container)
+			if container.ExitCode != nil {
+				exit = strconv.FormatInt(*container.ExitCode, 10)
+				reason = aws.StringValue(container.Reason)
+			} else if r.lingeringContainer(td, container) {
+				reason = "Non-essential, still " + strings.ToLower(aws.StringValue(container.LastStatus)) + " when the essential containers stopped"
+			}
+			stream := "-"
+			if lc, ok := td.streamedLogs(aws.StringValue(container.Name)); ok && !r.NoLogs {
+				stream = logStreamName(lc.StreamPrefix, container, task)
+			}
+			fmt.Fprintln(tw, strings.Join([]string{
+				aws.StringValue(container.Name),
+				path.Base(aws.StringValue(task.TaskArn)),
+				exit,
+				duration,
+				tableCell(reason),
+				stream,
+			}, "\t"))
+		}
+	}
+	tw.Flush()
+}
+
+// tableCell keeps a value on one line of a table, with a dash if it's empty
+func tableCell(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if s == "" {
+		return "-"
+	}
+	return s
+}
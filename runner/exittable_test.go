@@ -0,0 +1,47 @@
+package runner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestPrintExitCodes(t *testing.T) {
+	started := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	stopped := started.Add(95 * time.Second)
+	td := &preparedTaskDefinition{Logs: map[string]logConfig{"app": {Group: "ecs-task-runner", StreamPrefix: "run_1"}}}
+	tasks := []*ecs.Task{{
+		TaskArn:       aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/abc"),
+		StartedAt:     &started,
+		StoppedAt:     &stopped,
+		StoppedReason: aws.String("Essential container in task exited"),
+		Containers: []*ecs.Container{
+			{Name: aws.String("app"), ContainerArn: aws.String("arn:aws:ecs:us-east-1:123456789012:container/abc/app"), ExitCode: aws.Int64(137), Reason: aws.String("OutOfMemoryError: Container killed\ndue to memory usage")},
+			{Name: aws.String("sidecar"), ExitCode: aws.Int64(0)},
+			{Name: aws.String("init")},
+		},
+	}}
+
+	var buf bytes.Buffer
+	(&Runner{}).printExitCodes(&buf, td, tasks)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected a header and 3 rows, got %q", buf.String())
+	}
+	for i, expected := range [][]string{
+		{"CONTAINER", "TASK", "EXIT", "DURATION", "REASON", "LOG STREAM"},
+		{"app", "abc", "137", "1m35s", "OutOfMemoryError: Container killed due to memory usage", "run_1/app/abc"},
+		{"sidecar", "abc", "0", "1m35s", "-", "-"},
+		{"init", "abc", "-", "1m35s", "Essential container in task exited", "-"},
+	} {
+		for _, cell := range expected {
+			if !strings.Contains(lines[i], cell) {
+				t.Errorf("Expected %q in line %d, got %q", cell, i, lines[i])
+			}
+		}
+	}
+}
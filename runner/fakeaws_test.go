@@ -0,0 +1,30 @@
+package runner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// fakeSession returns a session whose requests are answered by handle rather
+// than sent to AWS. Requests succeed with an empty response unless handle sets
+// req.Error, which fails them with a 400.
+func fakeSession(handle func(req *request.Request)) *session.Session {
+	sess := session.Must(session.NewSession(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("AKID", "SECRET", ""))))
+	sess.Handlers.Send.Clear()
+	sess.Handlers.Send.PushBack(func(req *request.Request) {
+		req.HTTPResponse = &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(&bytes.Buffer{})}
+		handle(req)
+		if req.Error != nil && req.HTTPResponse.StatusCode == http.StatusOK {
+			req.HTTPResponse.StatusCode = http.StatusBadRequest
+		}
+	})
+	return sess
+}
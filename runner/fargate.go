@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// stripFargateUnsupported removes what Fargate rejects from a task definition
+// that's been written for EC2, saying what was removed, rather than failing
+// with the ClientException that RegisterTaskDefinition or RunTask returns.
+// Fargate tasks always use the awsvpc network mode, which rules out links,
+// hostnames and extra hosts, and run without access to the host, which rules
+// out privileged containers, devices and host volumes.
+func (r *Runner) stripFargateUnsupported(input *ecs.RegisterTaskDefinitionInput) {
+	if !r.Fargate {
+		return
+	}
+	removed := func(what string) {
+		fmt.Fprintf(r.Stderr, "Removed %s from the task definition, as Fargate doesn't support it\n", what)
+	}
+
+	if mode := aws.StringValue(input.NetworkMode); mode != "" && mode != ecs.NetworkModeAwsvpc {
+		fmt.Fprintf(r.Stderr, "Using the awsvpc network mode instead of %s, as it's the only one Fargate supports\n", mode)
+		input.NetworkMode = aws.String(ecs.NetworkModeAwsvpc)
+	}
+	if aws.StringValue(input.PidMode) == ecs.PidModeHost {
+		removed("the host pid mode")
+		input.PidMode = nil
+	}
+	if input.IpcMode != nil {
+		removed("the ipc mode")
+		input.IpcMode = nil
+	}
+	if len(input.PlacementConstraints) > 0 {
+		removed("placement constraints")
+		input.PlacementConstraints = nil
+	}
+
+	// volumes on the host, or from a Docker volume driver, can't be mounted
+	hostVolumes := map[string]bool{}
+	var volumes []*ecs.Volume
+	for _, v := range input.Volumes {
+		if (v.Host != nil && v.Host.SourcePath != nil) || v.DockerVolumeConfiguration != nil {
+			removed("host volume " + aws.StringValue(v.Name))
+			hostVolumes[aws.StringValue(v.Name)] = true
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	input.Volumes = volumes
+
+	for _, def := range input.ContainerDefinitions {
+		var fields []string
+		if len(def.Links) > 0 {
+			fields = append(fields, "links")
+			def.Links = nil
+		}
+		if def.Hostname != nil {
+			fields = append(fields, "hostname")
+			def.Hostname = nil
+		}
+		if len(def.ExtraHosts) > 0 {
+			fields = append(fields, "extraHosts")
+			def.ExtraHosts = nil
+		}
+		if aws.BoolValue(def.Privileged) {
+			fields = append(fields, "privileged")
+			def.Privileged = nil
+		}
+		if len(def.DockerSecurityOptions) > 0 {
+			fields = append(fields, "dockerSecurityOptions")
+			def.DockerSecurityOptions = nil
+		}
+		if lp := def.LinuxParameters; lp != nil {
+			if len(lp.Devices) > 0 {
+				fields = append(fields, "devices")
+				lp.Devices = nil
+			}
+			if lp.SharedMemorySize != nil {
+				fields = append(fields, "sharedMemorySize")
+				lp.SharedMemorySize = nil
+			}
+			if len(lp.Tmpfs) > 0 {
+				fields = append(fields, "tmpfs")
+				lp.Tmpfs = nil
+			}
+			if lp.MaxSwap != nil || lp.Swappiness != nil {
+				fields = append(fields, "swap")
+				lp.MaxSwap, lp.Swappiness = nil, nil
+			}
+		}
+
+		var mountPoints []*ecs.MountPoint
+		for _, mp := range def.MountPoints {
+			if hostVolumes[aws.StringValue(mp.SourceVolume)] {
+				fields = append(fields, "the mount of host volume "+aws.StringValue(mp.SourceVolume))
+				continue
+			}
+			mountPoints = append(mountPoints, mp)
+		}
+		def.MountPoints = mountPoints
+
+		if len(fields) > 0 {
+			fmt.Fprintf(r.Stderr, "Removed %s from container %s, as Fargate doesn't support them\n", strings.Join(fields, ", "), aws.StringValue(def.Name))
+		}
+	}
+}
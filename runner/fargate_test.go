@@ -0,0 +1,65 @@
+package runner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestStripFargateUnsupported(t *testing.T) {
+	input := &ecs.RegisterTaskDefinitionInput{
+		NetworkMode: aws.String(ecs.NetworkModeBridge),
+		Volumes: []*ecs.Volume{
+			{Name: aws.String("docker"), Host: &ecs.HostVolumeProperties{SourcePath: aws.String("/var/run/docker.sock")}},
+			{Name: aws.String("scratch")},
+		},
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{
+				Name:       aws.String("app"),
+				Links:      aws.StringSlice([]string{"db"}),
+				Privileged: aws.Bool(true),
+				MountPoints: []*ecs.MountPoint{
+					{SourceVolume: aws.String("docker"), ContainerPath: aws.String("/var/run/docker.sock")},
+					{SourceVolume: aws.String("scratch"), ContainerPath: aws.String("/scratch")},
+				},
+			},
+			{Name: aws.String("db"), Image: aws.String("postgres")},
+		},
+	}
+
+	var stderr bytes.Buffer
+	r := &Runner{Fargate: true, Stderr: &stderr}
+	r.stripFargateUnsupported(input)
+
+	if aws.StringValue(input.NetworkMode) != ecs.NetworkModeAwsvpc {
+		t.Errorf("Expected the awsvpc network mode, got %s", aws.StringValue(input.NetworkMode))
+	}
+	if len(input.Volumes) != 1 || aws.StringValue(input.Volumes[0].Name) != "scratch" {
+		t.Errorf("Expected only the host volume to be removed, got %v", input.Volumes)
+	}
+	app := input.ContainerDefinitions[0]
+	if app.Links != nil || app.Privileged != nil {
+		t.Errorf("Expected links and privileged to be removed, got %v", app)
+	}
+	if len(app.MountPoints) != 1 || aws.StringValue(app.MountPoints[0].SourceVolume) != "scratch" {
+		t.Errorf("Expected the mount of the host volume to be removed, got %v", app.MountPoints)
+	}
+
+	expected := "Using the awsvpc network mode instead of bridge, as it's the only one Fargate supports\n" +
+		"Removed host volume docker from the task definition, as Fargate doesn't support it\n" +
+		"Removed links, privileged, the mount of host volume docker from container app, as Fargate doesn't support them\n"
+	if stderr.String() != expected {
+		t.Errorf("Unexpected output %q", stderr.String())
+	}
+
+	// nothing is removed without Fargate
+	stderr.Reset()
+	input.ContainerDefinitions[1].Links = aws.StringSlice([]string{"app"})
+	(&Runner{Stderr: &stderr}).stripFargateUnsupported(input)
+	if len(input.ContainerDefinitions[1].Links) != 1 || strings.Contains(stderr.String(), "Removed") {
+		t.Errorf("Expected nothing to be removed for EC2, got %q", stderr.String())
+	}
+}
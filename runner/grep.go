@@ -0,0 +1,178 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Grep searches the CloudWatch Logs of each container in a task for a filter
+// pattern, printing matches prefixed with the container's name and its
+// surrounding lines like grep's --context. The task can be an ARN, or an ID
+// in the runner's cluster. It returns how many lines matched.
+func (r *Runner) Grep(ctx context.Context, task, pattern string, contextLines int) (int, error) {
+	cluster, config := r.Cluster, r.Config.Copy()
+	if a, err := arn.Parse(task); err == nil {
+		config.WithRegion(a.Region)
+		if parts := strings.Split(a.Resource, "/"); len(parts) == 3 {
+			cluster = parts[1]
+		}
+	}
+	sess := session.Must(newSession(config))
+	svc := ecs.New(sess)
+
+	log.Printf("Describing task %s in %s", task, cluster)
+	resp, err := svc.DescribeTasksWithContext(ctx, &ecs.DescribeTasksInput{
+		Cluster: aws.String(cluster),
+		Tasks:   aws.StringSlice([]string{task}),
+	})
+	if err != nil {
+		return 0, wrapAPIError("DescribeTasks", err)
+	}
+	if len(resp.Tasks) == 0 {
+		return 0, fmt.Errorf("No task %s in cluster %s", task, cluster)
+	}
+	t := resp.Tasks[0]
+
+	tdResp, err := svc.DescribeTaskDefinitionWithContext(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: t.TaskDefinitionArn,
+	})
+	if err != nil {
+		return 0, wrapAPIError("DescribeTaskDefinition", err)
+	}
+
+	cwl := &cloudWatchLogsClients{sess: r.logsSession(sess)}
+	var matches, searched int
+	for _, container := range t.Containers {
+		def := findContainerDefinition(tdResp.TaskDefinition.ContainerDefinitions, *container.Name)
+		if def == nil {
+			continue
+		}
+		lc, ok := awslogsConfig(def.LogConfiguration, aws.StringValue(sess.Config.Region))
+		if !ok {
+			log.Printf("Not searching container %s, which doesn't log to CloudWatch Logs", *container.Name)
+			continue
+		}
+
+		g := &logGrep{
+			CloudWatchLogs: cwl.forRegion(lc.Region),
+			LogGroupName:   lc.Group,
+			LogStreamName:  logStreamName(lc.StreamPrefix, container, t),
+			Label:          *container.Name,
+			Context:        contextLines,
+		}
+		n, err := g.Search(ctx, pattern, r.Stdout)
+		if err != nil {
+			return matches, err
+		}
+		matches += n
+		searched++
+	}
+
+	if searched == 0 {
+		return 0, fmt.Errorf("No containers in task %s log to CloudWatch Logs", task)
+	}
+	return matches, nil
+}
+
+// logGrep searches a log stream with a CloudWatch Logs filter pattern
+type logGrep struct {
+	CloudWatchLogs cloudwatchLogsInterface
+
+	LogGroupName  string
+	LogStreamName string
+
+	// Label prefixes each line, followed by `:` for matches and `-` for
+	// context lines
+	Label   string
+	Context int
+}
+
+// Search prints each event in the stream that matches pattern, along with
+// the events around it. Groups of lines are separated by `--`.
+func (g *logGrep) Search(ctx context.Context, pattern string, w io.Writer) (int, error) {
+	log.Printf("Searching stream %s for %q", g.LogStreamName, pattern)
+
+	var events []*cloudwatchlogs.FilteredLogEvent
+	err := g.CloudWatchLogs.FilterLogEventsPagesWithContext(ctx, &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName:   aws.String(g.LogGroupName),
+		LogStreamNames: aws.StringSlice([]string{g.LogStreamName}),
+		FilterPattern:  aws.String(pattern),
+	}, func(page *cloudwatchlogs.FilterLogEventsOutput, lastPage bool) bool {
+		events = append(events, page.Events...)
+		return true
+	})
+	if err != nil {
+		return 0, wrapAPIError("FilterLogEvents", err)
+	}
+
+	for i, event := range events {
+		if g.Context > 0 && i > 0 {
+			fmt.Fprintln(w, "--")
+		}
+		before, after, err := g.around(ctx, event)
+		if err != nil {
+			return i, err
+		}
+		for _, e := range before {
+			fmt.Fprintf(w, "%s-%s\n", g.Label, aws.StringValue(e.Message))
+		}
+		fmt.Fprintf(w, "%s:%s\n", g.Label, aws.StringValue(event.Message))
+		for _, e := range after {
+			fmt.Fprintf(w, "%s-%s\n", g.Label, aws.StringValue(e.Message))
+		}
+	}
+
+	return len(events), nil
+}
+
+// around returns up to Context events either side of a matching event
+func (g *logGrep) around(ctx context.Context, event *cloudwatchlogs.FilteredLogEvent) (before, after []*cloudwatchlogs.OutputLogEvent, err error) {
+	if g.Context == 0 {
+		return nil, nil, nil
+	}
+
+	// the last events before the match's timestamp
+	resp, err := g.CloudWatchLogs.GetLogEventsWithContext(ctx, &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(g.LogGroupName),
+		LogStreamName: aws.String(g.LogStreamName),
+		EndTime:       event.Timestamp,
+		Limit:         aws.Int64(int64(g.Context)),
+		StartFromHead: aws.Bool(false),
+	})
+	if err != nil {
+		return nil, nil, wrapAPIError("GetLogEvents", err)
+	}
+	before = resp.Events
+
+	// events from the match's timestamp, skipping up to and including the match
+	resp, err = g.CloudWatchLogs.GetLogEventsWithContext(ctx, &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(g.LogGroupName),
+		LogStreamName: aws.String(g.LogStreamName),
+		StartTime:     event.Timestamp,
+		Limit:         aws.Int64(int64(g.Context) + 10),
+		StartFromHead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, nil, wrapAPIError("GetLogEvents", err)
+	}
+	var found bool
+	for _, e := range resp.Events {
+		if found && len(after) < g.Context {
+			after = append(after, e)
+		} else if aws.Int64Value(e.Timestamp) == aws.Int64Value(event.Timestamp) &&
+			aws.StringValue(e.Message) == aws.StringValue(event.Message) {
+			found = true
+		}
+	}
+
+	return before, after, nil
+}
@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+func TestLogGrepSearch(t *testing.T) {
+	var events []*cloudwatchlogs.OutputLogEvent
+	for i, msg := range []string{"starting", "step 6", "step 7", "step 8", "step 9", "step 7 again", "done"} {
+		events = append(events, &cloudwatchlogs.OutputLogEvent{
+			Timestamp: aws.Int64(int64(1000 + i)),
+			Message:   aws.String(msg),
+		})
+	}
+
+	cwl := &mockCloudWatchLogs{
+		logEvents: events,
+		filterLogEvents: []*cloudwatchlogs.FilteredLogEvent{
+			{Timestamp: events[2].Timestamp, Message: events[2].Message},
+			{Timestamp: events[5].Timestamp, Message: events[5].Message},
+		},
+	}
+
+	g := &logGrep{
+		CloudWatchLogs: cwl,
+		LogGroupName:   "my-group",
+		LogStreamName:  "my-stream",
+		Label:          "app",
+		Context:        1,
+	}
+
+	var out bytes.Buffer
+	n, err := g.Search(context.Background(), `"step 7"`, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("Expected 2 matches, got %d", n)
+	}
+
+	expected := "app-step 6\napp:step 7\napp-step 8\n--\napp-step 9\napp:step 7 again\napp-done\n"
+	if out.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestLogGrepSearchWithoutContext(t *testing.T) {
+	cwl := &mockCloudWatchLogs{
+		filterLogEvents: []*cloudwatchlogs.FilteredLogEvent{
+			{Timestamp: aws.Int64(1), Message: aws.String("step 7")},
+			{Timestamp: aws.Int64(2), Message: aws.String("step 7 again")},
+		},
+	}
+
+	var out bytes.Buffer
+	g := &logGrep{CloudWatchLogs: cwl, LogGroupName: "my-group", LogStreamName: "my-stream", Label: "app"}
+	if _, err := g.Search(context.Background(), "step", &out); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "app:step 7\napp:step 7 again\n"; out.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, out.String())
+	}
+}
@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// stopCancelledTimeout bounds stopping tasks once a run has been cancelled,
+// as the run's own context is already done
+const stopCancelledTimeout = 30 * time.Second
+
+// CancelledError is returned when a run is cancelled through its handle,
+// rather than failing
+type CancelledError struct {
+	Reason string
+}
+
+func (e *CancelledError) Error() string {
+	return fmt.Sprintf("Cancelled: %s", e.Reason)
+}
+
+// Handle is a run started in the background, which can be waited on or
+// cancelled, such as from an embedding application's UI
+type Handle struct {
+	done   chan struct{}
+	err    error
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	reason *string
+}
+
+// Start runs the task against a target in the background
+func (r *Runner) Start(ctx context.Context, target Target) *Handle {
+	ctx, cancel := context.WithCancel(ctx)
+	h := &Handle{done: make(chan struct{}), cancel: cancel}
+
+	go func() {
+		defer close(h.done)
+		defer cancel()
+
+		if err := r.runPreHook(ctx, []Target{target}); err != nil {
+			h.err = err
+			return
+		}
+		tr, err := r.forTarget(target)
+		if err != nil {
+			h.err = err
+			return
+		}
+		tr.handle = h
+		h.err = tr.Run(ctx)
+	}()
+
+	return h
+}
+
+// Wait blocks until the run has finished, returning a *CancelledError if it
+// was cancelled
+func (h *Handle) Wait() error {
+	<-h.done
+	return h.err
+}
+
+// Cancel stops any tasks that have been started with the given reason and
+// unblocks Wait. It does nothing once the run has finished.
+func (h *Handle) Cancel(reason string) {
+	select {
+	case <-h.done:
+		return
+	default:
+	}
+
+	h.mu.Lock()
+	if h.reason == nil {
+		h.reason = &reason
+	}
+	h.mu.Unlock()
+	h.cancel()
+}
+
+// Cancelled returns whether the run was cancelled, and why
+func (h *Handle) Cancelled() (string, bool) {
+	if h == nil {
+		return "", false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.reason == nil {
+		return "", false
+	}
+	return *h.reason, true
+}
+
+// cancelled turns an error from a run that was cancelled through its handle
+// into a CancelledError, stopping tasks that were started
+func (r *Runner) cancelled(svc *ecs.ECS, tasks []*ecs.Task, err error) error {
+	reason, ok := r.handle.Cancelled()
+	if !ok {
+		return err
+	}
+
+	if len(tasks) > 0 {
+		fmt.Fprintf(r.Stderr, "Cancelled, stopping %d tasks: %s\n", len(tasks), reason)
+		ctx, cancel := context.WithTimeout(context.Background(), stopCancelledTimeout)
+		defer cancel()
+		if err := r.stopTasks(ctx, svc, tasks, "cancelled, "+reason); err != nil {
+			return err
+		}
+	}
+
+	return &CancelledError{Reason: reason}
+}
@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestHandleCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &Handle{done: make(chan struct{}), cancel: cancel}
+
+	if _, ok := h.Cancelled(); ok {
+		t.Fatal("Expected the handle not to be cancelled yet")
+	}
+
+	h.Cancel("user clicked cancel")
+	h.Cancel("second reason")
+
+	if ctx.Err() == nil {
+		t.Fatal("Expected the run's context to be cancelled")
+	}
+	if reason, ok := h.Cancelled(); !ok || reason != "user clicked cancel" {
+		t.Fatalf("Expected the first reason, got %q", reason)
+	}
+
+	r := &Runner{Stderr: ioutil.Discard, handle: h}
+	err := r.cancelled(nil, nil, context.Canceled)
+
+	var ce *CancelledError
+	if !errors.As(err, &ce) || ce.Reason != "user clicked cancel" {
+		t.Fatalf("Expected a CancelledError, got %v", err)
+	}
+}
+
+func TestHandleCancelAfterFinishing(t *testing.T) {
+	h := &Handle{done: make(chan struct{}), cancel: func() {}}
+	close(h.done)
+
+	h.Cancel("too late")
+	if _, ok := h.Cancelled(); ok {
+		t.Fatal("Expected a finished run not to be cancelled")
+	}
+}
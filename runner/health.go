@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// What to wait for once tasks have started
+const (
+	WaitForStopped = "stopped"
+	WaitForRunning = "running"
+
+	// WaitForEssential finishes once each task's essential containers have
+	// stopped, which is when ECS stops the task, without waiting for
+	// non-essential containers such as sidecars that are slow to drain
+	WaitForEssential = "essential"
+)
+
+const (
+	healthCheckInterval       = 2 * time.Second
+	healthCheckAttemptTimeout = 2 * time.Second
+)
+
+// healthCheck probes a port on a task's IP address, either by connecting
+// to it or with an HTTP request
+type healthCheck struct {
+	HTTP bool
+	Port int
+	Path string
+}
+
+// parseHealthCheck parses a `tcp:PORT` or `http:PORT[/PATH]` health check
+func parseHealthCheck(check string) (*healthCheck, error) {
+	parts := strings.SplitN(check, ":", 2)
+	if len(parts) != 2 || (parts[0] != "tcp" && parts[0] != "http") {
+		return nil, fmt.Errorf("Health check %q should be in the form tcp:PORT or http:PORT[/PATH]", check)
+	}
+
+	hc := &healthCheck{HTTP: parts[0] == "http"}
+	port := parts[1]
+	if i := strings.Index(port, "/"); i != -1 {
+		if !hc.HTTP {
+			return nil, fmt.Errorf("Health check %q can only have a path with http", check)
+		}
+		port, hc.Path = port[:i], port[i:]
+	}
+
+	var err error
+	if hc.Port, err = strconv.Atoi(port); err != nil || hc.Port < 1 || hc.Port > 65535 {
+		return nil, fmt.Errorf("Health check %q has an invalid port %q", check, port)
+	}
+	if hc.HTTP && hc.Path == "" {
+		hc.Path = "/"
+	}
+	return hc, nil
+}
+
+func (hc *healthCheck) String() string {
+	if hc.HTTP {
+		return fmt.Sprintf("http:%d%s", hc.Port, hc.Path)
+	}
+	return fmt.Sprintf("tcp:%d", hc.Port)
+}
+
+// probe checks whether ip is healthy once
+func (hc *healthCheck) probe(ctx context.Context, ip string) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckAttemptTimeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(ip, strconv.Itoa(hc.Port))
+	if !hc.HTTP {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+hc.Path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s returned %s", hc.Path, resp.Status)
+	}
+	return nil
+}
+
+// waitUntilHealthy probes ip until it's healthy or the timeout passes
+func (hc *healthCheck) waitUntilHealthy(ctx context.Context, ip string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := hc.probe(ctx, ip)
+		if err == nil {
+			return nil
+		}
+		logf(ctx, "Health check %s on %s failed: %v", hc, ip, err)
+
+		if time.Now().After(deadline) {
+			return &TimeoutError{fmt.Errorf("Health check %s on %s failed after %v: %v", hc, ip, timeout, err)}
+		}
+		select {
+		case <-time.After(healthCheckInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// allTasksRunning returns whether every task has reached RUNNING
+func allTasksRunning(tasks []*ecs.Task) bool {
+	for _, task := range tasks {
+		if aws.StringValue(task.LastStatus) != ecs.DesiredStatusRunning {
+			return false
+		}
+	}
+	return len(tasks) > 0
+}
+
+// tasksRunning reports tasks that have reached RUNNING, once each passes the
+// health check if there is one. The tasks are left running.
+func (r *Runner) tasksRunning(ctx context.Context, tasks []*ecs.Task) error {
+	var hc *healthCheck
+	if r.HealthCheck != "" {
+		var err error
+		if hc, err = parseHealthCheck(r.HealthCheck); err != nil {
+			return err
+		}
+	}
+
+	for _, task := range tasks {
+		id := path.Base(*task.TaskArn)
+		ip := taskPrivateIP(task)
+		if hc == nil {
+			fmt.Fprintf(r.Stderr, "Task %s is running\n", id)
+			continue
+		}
+		if ip == "" {
+			return fmt.Errorf("Task %s has no IP address to health check, which needs the awsvpc network mode", id)
+		}
+
+		fmt.Fprintf(r.Stderr, "Task %s is running, waiting for %s on %s to be healthy\n", id, hc, ip)
+		if err := hc.waitUntilHealthy(ctx, ip, r.HealthCheckTimeout); err != nil {
+			return err
+		}
+		fmt.Fprintf(r.Stderr, "Task %s is healthy at %s\n", id, ip)
+	}
+	return nil
+}
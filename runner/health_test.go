@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestParseHealthCheck(t *testing.T) {
+	for check, expected := range map[string]string{
+		"tcp:5432":         "tcp:5432",
+		"http:8080":        "http:8080/",
+		"http:8080/health": "http:8080/health",
+	} {
+		hc, err := parseHealthCheck(check)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", check, err)
+			continue
+		}
+		if hc.String() != expected {
+			t.Errorf("Expected %s for %q, got %s", expected, check, hc)
+		}
+	}
+
+	for _, check := range []string{"8080", "udp:53", "tcp:8080/health", "http:", "http:99999"} {
+		if _, err := parseHealthCheck(check); err == nil {
+			t.Errorf("Expected an error for %q", check)
+		}
+	}
+}
+
+func TestHealthCheckProbe(t *testing.T) {
+	healthy := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !healthy || req.URL.Path != "/health" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	ctx := context.Background()
+
+	hc := &healthCheck{HTTP: true, Port: p, Path: "/health"}
+	if err := hc.probe(ctx, "127.0.0.1"); err == nil {
+		t.Fatal("Expected an unhealthy server to fail")
+	}
+	healthy = true
+	if err := hc.probe(ctx, "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tcp := &healthCheck{Port: p}
+	if err := tcp.probe(ctx, "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHealthCheckTimesOut(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	hc := &healthCheck{HTTP: true, Port: srv.Listener.Addr().(*net.TCPAddr).Port, Path: "/"}
+	err := hc.waitUntilHealthy(context.Background(), "127.0.0.1", 0)
+	if ExitCode(err) != ExitCodeTimeout {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+}
+
+func TestAllTasksRunning(t *testing.T) {
+	task := func(status string) *ecs.Task { return &ecs.Task{LastStatus: aws.String(status)} }
+
+	if allTasksRunning(nil) {
+		t.Error("Expected no tasks not to be running")
+	}
+	if allTasksRunning([]*ecs.Task{task("RUNNING"), task("PENDING")}) {
+		t.Error("Expected a pending task not to be running")
+	}
+	if !allTasksRunning([]*ecs.Task{task("RUNNING"), task("RUNNING")}) {
+		t.Error("Expected tasks to be running")
+	}
+}
@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// failureHint explains a common way for containers to fail, recognised from
+// their stopped reason, and how to fix it
+type failureHint struct {
+	Pattern *regexp.Regexp
+	Why     string
+	Fix     string
+
+	// Secrets is set on the hint that printSecretFailures explains in more
+	// detail, by naming the secret
+	Secrets bool
+}
+
+// failureHints are checked in order, so more specific patterns come first
+var failureHints = []failureHint{
+	{
+		Pattern: regexp.MustCompile(`CannotPullContainerError.*(not found|manifest unknown|does not exist)`),
+		Why:     "the image, or its tag, doesn't exist in the registry",
+		Fix:     "check the image name and that the tag has been pushed, or override it with --image",
+	},
+	{
+		Pattern: regexp.MustCompile(`CannotPullContainerError.*(denied|unauthorized|no basic auth credentials|AccessDenied)`),
+		Why:     "the registry refused to let the image be pulled",
+		Fix:     "give the task's execution role ecr:GetAuthorizationToken, ecr:BatchGetImage and ecr:GetDownloadUrlForLayer, or set repositoryCredentials for a private registry",
+	},
+	{
+		Pattern: regexp.MustCompile(`(CannotPullContainerError|ResourceInitializationError).*(i/o timeout|dial tcp|context deadline exceeded|Client\.Timeout)`),
+		Why:     "the task couldn't reach the registry or AWS APIs over the network",
+		Fix:     "run it in subnets with a NAT gateway or VPC endpoints, or in a public subnet with assignPublicIp ENABLED",
+	},
+	{
+		Pattern: regexp.MustCompile(`ResourceInitializationError.*ecr registry auth.*(AccessDenied|not authorized)`),
+		Why:     "the execution role isn't allowed to log in to ECR to pull the image",
+		Fix:     "give the task's execution role ecr:GetAuthorizationToken, ecr:BatchGetImage and ecr:GetDownloadUrlForLayer, such as with the AmazonECSTaskExecutionRolePolicy managed policy",
+	},
+	{
+		Pattern: regexp.MustCompile(`ResourceInitializationError.*(secret|ssm|kms|AccessDenied)`),
+		Why:     "the task's secrets couldn't be fetched before its containers started",
+		Fix:     "check the valueFrom ARNs of the secrets exist, and that the execution role can read them and decrypt them with their KMS key",
+		Secrets: true,
+	},
+	{
+		Pattern: regexp.MustCompile(`exec format error`),
+		Why:     "the image was built for a different CPU architecture than the one it ran on",
+		Fix:     "build it for linux/amd64, or a multi-architecture image, or set the task definition's runtimePlatform to ARM64 for an ARM image",
+	},
+	{
+		Pattern: regexp.MustCompile(`(executable file not found|no such file or directory).*(\$PATH|exec)|CannotStartContainerError.*(executable file not found|no such file or directory)`),
+		Why:     "the container's command or entrypoint isn't in the image",
+		Fix:     "check the command is installed in the image and on its PATH, and that scripts have a shebang for an interpreter that's installed",
+	},
+	{
+		Pattern: regexp.MustCompile(`no space left on device`),
+		Why:     "the container ran out of disk space",
+		Fix:     "raise the task definition's ephemeralStorage on Fargate, or use instances with bigger volumes and prune old images on EC2",
+	},
+	{
+		Pattern: regexp.MustCompile(`OutOfMemoryError`),
+		Why:     "the container used more memory than its limit and was killed",
+		Fix:     "raise the container's memory, or the task's on Fargate, or find what's using it",
+	},
+	{
+		Pattern: regexp.MustCompile(`Task failed (ELB|container) health checks`),
+		Why:     "the task failed its health checks and was stopped for being unhealthy",
+		Fix:     "check the health check's port and path answer from inside the container, and give it a longer startPeriod if it's slow to start",
+	},
+}
+
+// printFailureHints explains the failures of containers that stopped for a
+// reason that's commonly misunderstood, with how to fix it. Each hint is only
+// printed once, listing the containers that it applies to. Containers whose
+// secrets were already named as the reason aren't given the secrets hint.
+func (r *Runner) printFailureHints(w io.Writer, td *preparedTaskDefinition, tasks []*ecs.Task, secretsExplained map[string]bool) {
+	containers := make([][]string, len(failureHints))
+	seen := map[string]bool{}
+	for _, task := range tasks {
+		for _, container := range task.Containers {
+			// containers that exited cleanly didn't fail, whatever the task's reason
+			if (container.ExitCode != nil && *container.ExitCode == 0) || r.lingeringContainer(td, container) {
+				continue
+			}
+			i := matchFailureHint(aws.StringValue(container.Reason) + "\n" + aws.StringValue(task.StoppedReason))
+			name := aws.StringValue(container.Name)
+			if i < 0 || seen[fmt.Sprintf("%d/%s", i, name)] || (failureHints[i].Secrets && secretsExplained[name]) {
+				continue
+			}
+			seen[fmt.Sprintf("%d/%s", i, name)] = true
+			containers[i] = append(containers[i], name)
+		}
+	}
+
+	for i, names := range containers {
+		if len(names) == 0 {
+			continue
+		}
+		label := "Container"
+		if len(names) > 1 {
+			label = "Containers"
+		}
+		hint := failureHints[i]
+		fmt.Fprintf(w, "%s %s failed because %s. To fix it, %s.\n", label, strings.Join(names, ", "), hint.Why, hint.Fix)
+	}
+}
+
+// matchFailureHint returns the index of the first hint that matches a
+// stopped reason, or -1 if none do
+func matchFailureHint(reason string) int {
+	for i, hint := range failureHints {
+		if hint.Pattern.MatchString(reason) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestMatchFailureHint(t *testing.T) {
+	for reason, expected := range map[string]string{
+		"CannotPullContainerError: failed to resolve ref docker.io/my-org/app:v2: not found":                                                "doesn't exist in the registry",
+		"CannotPullContainerError: pull access denied for my-org/app, repository does not exist or may require 'docker login'":              "doesn't exist in the registry",
+		"CannotPullContainerError: Error response from daemon: Head https://123.dkr.ecr.us-east-1.amazonaws.com: no basic auth credentials": "refused",
+		"CannotPullContainerError: dial tcp 52.1.2.3:443: i/o timeout":                                                                      "over the network",
+		"ResourceInitializationError: unable to pull secrets or registry auth: execution resource retrieval failed: AccessDeniedException":  "secrets",
+		"CannotStartContainerError: exec /app/run: exec format error":                                                                       "CPU architecture",
+		`CannotStartContainerError: exec: "rake": executable file not found in $PATH`:                                                       "isn't in the image",
+		"CannotCreateContainerError: write /var/lib/docker/tmp: no space left on device":                                                    "disk space",
+		"OutOfMemoryError: Container killed due to memory usage":                                                                            "memory",
+		"Task failed ELB health checks in (target-group arn:aws:elasticloadbalancing:us-east-1:123:targetgroup/app/abc)":                    "health checks",
+		"ResourceInitializationError: unable to pull secrets or registry auth: execution resource retrieval failed: unable to retrieve ecr registry auth: " +
+			"service call has been retried 3 time(s): AccessDeniedException: User: arn:aws:sts::123456789012:assumed-role/ecsTaskExecutionRole/abc " +
+			"is not authorized to perform: ecr:GetAuthorizationToken": "log in to ECR",
+		"ResourceInitializationError: unable to pull secrets or registry auth: execution resource retrieval failed: unable to retrieve ecr registry auth: " +
+			"service call has been retried 3 time(s): RequestError: send request failed caused by: Post https://api.ecr.us-east-1.amazonaws.com/: " +
+			"dial tcp 10.0.0.1:443: i/o timeout": "over the network",
+	} {
+		i := matchFailureHint(reason)
+		if i < 0 {
+			t.Errorf("Expected a hint for %q", reason)
+		} else if !strings.Contains(failureHints[i].Why, expected) {
+			t.Errorf("Expected the hint for %q to be about %q, got %q", reason, expected, failureHints[i].Why)
+		}
+	}
+
+	if i := matchFailureHint("Essential container in task exited"); i >= 0 {
+		t.Errorf("Expected no hint for a container exiting, got %q", failureHints[i].Why)
+	}
+}
+
+func TestPrintFailureHints(t *testing.T) {
+	td := &preparedTaskDefinition{}
+	oom := func(task string) *ecs.Task {
+		return &ecs.Task{
+			TaskArn:       aws.String(task),
+			StoppedReason: aws.String("Essential container in task exited"),
+			Containers: []*ecs.Container{
+				{Name: aws.String("app"), ExitCode: aws.Int64(137), Reason: aws.String("OutOfMemoryError: Container killed due to memory usage")},
+				{Name: aws.String("sidecar"), ExitCode: aws.Int64(0), Reason: aws.String("OutOfMemoryError: Container killed due to memory usage")},
+			},
+		}
+	}
+
+	var buf bytes.Buffer
+	r := &Runner{}
+	r.printFailureHints(&buf, td, []*ecs.Task{oom("task/1"), oom("task/2")}, nil)
+	expected := "Container app failed because the container used more memory than its limit and was killed. " +
+		"To fix it, raise the container's memory, or the task's on Fargate, or find what's using it.\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
+
+func TestPrintFailureHintsSkipsNamedSecrets(t *testing.T) {
+	td := &preparedTaskDefinition{}
+	tasks := []*ecs.Task{{
+		StoppedReason: aws.String("ResourceInitializationError: unable to pull secrets or registry auth: execution resource retrieval failed: AccessDeniedException"),
+		Containers:    []*ecs.Container{{Name: aws.String("app")}, {Name: aws.String("worker")}},
+	}}
+
+	var buf bytes.Buffer
+	(&Runner{}).printFailureHints(&buf, td, tasks, map[string]bool{"app": true})
+	if !strings.HasPrefix(buf.String(), "Container worker failed because the task's secrets couldn't be fetched") {
+		t.Errorf("Expected the secrets hint for worker only, got %q", buf.String())
+	}
+}
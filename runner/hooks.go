@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/buildkite/ecs-run-task/report"
+)
+
+// Events that hooks are run for, given to them in ECS_RUN_TASK_HOOK_EVENT
+const (
+	hookEventPre  = "pre"
+	hookEventPost = "post"
+)
+
+// hookCommand returns a command that runs a hook with the shell
+func hookCommand(ctx context.Context, hook string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", hook)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", hook)
+}
+
+// runPreHook runs the pre-hook before any tasks are run against targets.
+// A pre-hook that fails stops the run, such as to check that it's allowed.
+func (r *Runner) runPreHook(ctx context.Context, targets []Target) error {
+	if r.PreHook == "" {
+		return nil
+	}
+
+	var clusters []string
+	for _, t := range targets {
+		clusters = append(clusters, t.Cluster)
+	}
+
+	logf(ctx, "Running pre-hook %q", r.PreHook)
+	cmd := hookCommand(ctx, r.PreHook)
+	cmd.Env = append(os.Environ(),
+		"ECS_RUN_TASK_HOOK_EVENT="+hookEventPre,
+		"ECS_RUN_TASK_HOOK_CLUSTERS="+strings.Join(clusters, ","),
+	)
+	cmd.Stdout = r.Stdout
+	cmd.Stderr = r.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Pre-hook %q failed: %v", r.PreHook, err)
+	}
+	return nil
+}
+
+// runPostHook runs the post-hook once every run has finished, with the
+// summary as JSON on its stdin. Its failure doesn't change the run's
+// outcome, so it's only warned about.
+func (r *Runner) runPostHook(summary report.Summary) {
+	if r.PostHook == "" {
+		return
+	}
+
+	body, err := json.Marshal(summary)
+	if err != nil {
+		fmt.Fprintf(r.Stderr, "Failed to run post-hook: %v\n", err)
+		return
+	}
+
+	var clusters []string
+	for _, run := range summary.Runs {
+		clusters = append(clusters, run.Cluster)
+	}
+
+	r.logf("Running post-hook %q", r.PostHook)
+	cmd := hookCommand(context.Background(), r.PostHook)
+	cmd.Env = append(os.Environ(),
+		"ECS_RUN_TASK_HOOK_EVENT="+hookEventPost,
+		"ECS_RUN_TASK_HOOK_CLUSTERS="+strings.Join(clusters, ","),
+		"ECS_RUN_TASK_HOOK_EXIT_CODE="+strconv.Itoa(summary.ExitCode),
+		"ECS_RUN_TASK_HOOK_ERROR="+summary.Error,
+		"ECS_RUN_TASK_HOOK_SUMMARY_FILE="+r.SummaryFile,
+	)
+	cmd.Stdin = bytes.NewReader(append(body, '\n'))
+	cmd.Stdout = r.Stdout
+	cmd.Stderr = r.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(r.Stderr, "Post-hook %q failed: %v\n", r.PostHook, err)
+	}
+}
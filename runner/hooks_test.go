@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/ecs-run-task/report"
+)
+
+func TestPreHook(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	r := &Runner{PreHook: `echo "$ECS_RUN_TASK_HOOK_EVENT $ECS_RUN_TASK_HOOK_CLUSTERS"; exit 3`, Stdout: &stdout, Stderr: &stderr}
+
+	err := r.RunTargets(context.Background(), []Target{{Cluster: "a"}, {Cluster: "b"}})
+	if err == nil || !strings.Contains(err.Error(), "Pre-hook") {
+		t.Fatalf("Expected the pre-hook to fail the run, got %v", err)
+	}
+	if stdout.String() != "pre a,b\n" {
+		t.Fatalf("Unexpected pre-hook output %q", stdout.String())
+	}
+}
+
+func TestPostHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "summary.json")
+	var stderr bytes.Buffer
+	r := &Runner{
+		PostHook: `cat > ` + out + `; echo "$ECS_RUN_TASK_HOOK_EVENT $ECS_RUN_TASK_HOOK_EXIT_CODE $ECS_RUN_TASK_HOOK_CLUSTERS" >&2`,
+		Stdout:   ioutil.Discard,
+		Stderr:   &stderr,
+	}
+	if !r.wantsSummary() {
+		t.Fatal("Expected a post-hook to need a summary")
+	}
+
+	r.saveSummary([]report.Run{{Cluster: "default"}}, errors.New("Task failed"))
+
+	if stderr.String() != "post 1 default\n" {
+		t.Fatalf("Unexpected post-hook output %q", stderr.String())
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary report.Summary
+	if err := json.Unmarshal(b, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Error != "Task failed" || len(summary.Runs) != 1 || summary.Runs[0].Cluster != "default" {
+		t.Fatalf("Unexpected summary on stdin %+v", summary)
+	}
+}
+
+func TestPostHookFailureIsOnlyWarned(t *testing.T) {
+	var stderr bytes.Buffer
+	r := &Runner{PostHook: "exit 2", Stdout: ioutil.Discard, Stderr: &stderr}
+	r.saveSummary(nil, nil)
+
+	if !strings.HasPrefix(stderr.String(), `Post-hook "exit 2" failed`) {
+		t.Fatalf("Expected a warning, got %q", stderr.String())
+	}
+}
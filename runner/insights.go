@@ -0,0 +1,160 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const (
+	insightsPollInterval = time.Second
+	insightsQueryTimeout = 5 * time.Minute
+)
+
+type insightsInterface interface {
+	StartQueryWithContext(ctx aws.Context, input *cloudwatchlogs.StartQueryInput, opts ...request.Option) (*cloudwatchlogs.StartQueryOutput, error)
+	GetQueryResultsWithContext(ctx aws.Context, input *cloudwatchlogs.GetQueryResultsInput, opts ...request.Option) (*cloudwatchlogs.GetQueryResultsOutput, error)
+}
+
+// insightsStreams returns the log streams of the tasks' containers by the
+// region and log group they're in
+func insightsStreams(td *preparedTaskDefinition, tasks []*ecs.Task) map[logConfig][]string {
+	streams := map[logConfig][]string{}
+	for _, task := range tasks {
+		for _, container := range task.Containers {
+			lc, ok := td.Logs[*container.Name]
+			if !ok || container.ExitCode == nil {
+				continue
+			}
+			key := logConfig{Group: lc.Group, Region: lc.Region}
+			streams[key] = append(streams[key], logStreamName(lc.StreamPrefix, container, task))
+		}
+	}
+	return streams
+}
+
+// scopeInsightsQuery limits a Logs Insights query to the given log streams,
+// dropping comment lines so that the query can follow the filter
+func scopeInsightsQuery(query string, streams []string) string {
+	quoted := make([]string, len(streams))
+	for i, s := range streams {
+		quoted[i] = fmt.Sprintf("%q", s)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(query), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			lines = append(lines, line)
+		}
+	}
+	return fmt.Sprintf("filter @logStream in [%s]\n| %s", strings.Join(quoted, ", "), strings.Join(lines, "\n"))
+}
+
+// runInsightsQuery runs the Logs Insights query over the run's log streams
+// once its tasks have stopped, printing the results of each log group. A
+// failed query is only warned about, as the tasks have already run.
+func (r *Runner) runInsightsQuery(ctx context.Context, cwl *cloudWatchLogsClients, td *preparedTaskDefinition, tasks []*ecs.Task) {
+	start := time.Now()
+	for _, task := range tasks {
+		if task.CreatedAt != nil && task.CreatedAt.Before(start) {
+			start = *task.CreatedAt
+		}
+	}
+
+	streams := insightsStreams(td, tasks)
+	var keys []logConfig
+	for lc := range streams {
+		keys = append(keys, lc)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Region+keys[i].Group < keys[j].Region+keys[j].Group })
+
+	for _, lc := range keys {
+		results, err := queryInsights(ctx, cwl.forRegion(lc.Region), lc.Group,
+			scopeInsightsQuery(r.InsightsQuery, streams[lc]), start.Add(-time.Minute), time.Now())
+		if err != nil {
+			fmt.Fprintf(r.Stderr, "Failed to run Logs Insights query on %s: %v\n", lc.Group, err)
+			continue
+		}
+		fmt.Fprintf(r.Stderr, "Logs Insights results for %s:\n", lc.Group)
+		printInsightsResults(r.Stdout, results)
+	}
+}
+
+// queryInsights starts a Logs Insights query and waits for its results
+func queryInsights(ctx context.Context, c insightsInterface, group, query string, start, end time.Time) ([][]*cloudwatchlogs.ResultField, error) {
+	ctx, cancel := context.WithTimeout(ctx, insightsQueryTimeout)
+	defer cancel()
+
+	logf(ctx, "Starting Logs Insights query on %s: %s", group, query)
+	resp, err := c.StartQueryWithContext(ctx, &cloudwatchlogs.StartQueryInput{
+		LogGroupName: aws.String(group),
+		QueryString:  aws.String(query),
+		StartTime:    aws.Int64(start.Unix()),
+		EndTime:      aws.Int64(end.Unix()),
+	})
+	if err != nil {
+		return nil, wrapAPIError("StartQuery", err)
+	}
+
+	for {
+		results, err := c.GetQueryResultsWithContext(ctx, &cloudwatchlogs.GetQueryResultsInput{
+			QueryId: resp.QueryId,
+		})
+		if err != nil {
+			return nil, wrapAPIError("GetQueryResults", err)
+		}
+
+		switch status := aws.StringValue(results.Status); status {
+		case cloudwatchlogs.QueryStatusComplete:
+			return results.Results, nil
+		case cloudwatchlogs.QueryStatusFailed, cloudwatchlogs.QueryStatusCancelled, cloudwatchlogs.QueryStatusTimeout:
+			return nil, fmt.Errorf("Query %s", strings.ToLower(status))
+		}
+
+		select {
+		case <-time.After(insightsPollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// printInsightsResults prints query results as a table, with a column for
+// each field of the first row other than @ptr
+func printInsightsResults(w io.Writer, results [][]*cloudwatchlogs.ResultField) {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "No results")
+		return
+	}
+
+	var columns []string
+	for _, f := range results[0] {
+		if name := aws.StringValue(f.Field); name != "@ptr" {
+			columns = append(columns, name)
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	for _, row := range results {
+		values := map[string]string{}
+		for _, f := range row {
+			values[aws.StringValue(f.Field)] = aws.StringValue(f.Value)
+		}
+		cells := make([]string, len(columns))
+		for i, c := range columns {
+			cells[i] = values[c]
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestScopeInsightsQuery(t *testing.T) {
+	query := "# errors by message\nfilter @message like /ERROR/\n| stats count(*) by @message\n"
+	expected := `filter @logStream in ["run/app/abc", "run/app/def"]` + "\n" +
+		"| filter @message like /ERROR/\n| stats count(*) by @message"
+
+	if actual := scopeInsightsQuery(query, []string{"run/app/abc", "run/app/def"}); actual != expected {
+		t.Fatalf("Expected %q, got %q", expected, actual)
+	}
+}
+
+func TestInsightsStreams(t *testing.T) {
+	td := &preparedTaskDefinition{Logs: map[string]logConfig{
+		"app": {Group: "ecs-run-task", Region: "us-east-1", StreamPrefix: "run"},
+	}}
+	tasks := []*ecs.Task{{
+		TaskArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/abc"),
+		Containers: []*ecs.Container{
+			{Name: aws.String("app"), ExitCode: aws.Int64(0)},
+			{Name: aws.String("sidecar"), ExitCode: aws.Int64(0)},
+		},
+	}, {
+		TaskArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/def"),
+		Containers: []*ecs.Container{
+			{Name: aws.String("app")},
+		},
+	}}
+
+	expected := map[logConfig][]string{
+		{Group: "ecs-run-task", Region: "us-east-1"}: {"run/app/abc"},
+	}
+	if actual := insightsStreams(td, tasks); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestQueryInsights(t *testing.T) {
+	c := &mockInsights{results: [][]*cloudwatchlogs.ResultField{{
+		{Field: aws.String("@message"), Value: aws.String("ERROR: timeout")},
+		{Field: aws.String("count(*)"), Value: aws.String("3")},
+		{Field: aws.String("@ptr"), Value: aws.String("abc")},
+	}, {
+		{Field: aws.String("@message"), Value: aws.String("ERROR: deadlock detected")},
+		{Field: aws.String("count(*)"), Value: aws.String("1")},
+	}}}
+
+	results, err := queryInsights(context.Background(), c, "ecs-run-task", "stats count(*) by @message", time.Now(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(c.started.LogGroupName) != "ecs-run-task" {
+		t.Fatalf("Unexpected log group %q", aws.StringValue(c.started.LogGroupName))
+	}
+
+	var out bytes.Buffer
+	printInsightsResults(&out, results)
+
+	expected := "@message                  count(*)\n" +
+		"ERROR: timeout            3\n" +
+		"ERROR: deadlock detected  1\n"
+	if out.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestQueryInsightsFailed(t *testing.T) {
+	c := &mockInsights{status: cloudwatchlogs.QueryStatusFailed}
+	if _, err := queryInsights(context.Background(), c, "ecs-run-task", "fields @message", time.Now(), time.Now()); err == nil || err.Error() != "Query failed" {
+		t.Fatalf("Bad error %v", err)
+	}
+}
+
+type mockInsights struct {
+	started *cloudwatchlogs.StartQueryInput
+	status  string
+	results [][]*cloudwatchlogs.ResultField
+}
+
+func (m *mockInsights) StartQueryWithContext(ctx aws.Context, input *cloudwatchlogs.StartQueryInput, opts ...request.Option) (*cloudwatchlogs.StartQueryOutput, error) {
+	m.started = input
+	return &cloudwatchlogs.StartQueryOutput{QueryId: aws.String("query")}, nil
+}
+
+func (m *mockInsights) GetQueryResultsWithContext(ctx aws.Context, input *cloudwatchlogs.GetQueryResultsInput, opts ...request.Option) (*cloudwatchlogs.GetQueryResultsOutput, error) {
+	status := m.status
+	if status == "" {
+		status = cloudwatchlogs.QueryStatusComplete
+	}
+	return &cloudwatchlogs.GetQueryResultsOutput{Status: aws.String(status), Results: m.results}, nil
+}
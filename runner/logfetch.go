@@ -0,0 +1,225 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// Strategies for fetching containers' log events, which have separate quotas
+const (
+	// LogFetchFilter polls FilterLogEvents, whose quota is shared by every
+	// call in the account and region
+	LogFetchFilter = "filter"
+
+	// LogFetchGet polls GetLogEvents, whose quota is more generous, following
+	// each stream from where the last call left off
+	LogFetchGet = "get"
+
+	// LogFetchLiveTail streams events from a CloudWatch Logs Live Tail
+	// session as they're ingested, rather than polling for them
+	LogFetchLiveTail = "live-tail"
+)
+
+// errLiveTailUnavailable is returned when a Live Tail session can't be
+// started, such as without logs:StartLiveTail, so events are polled for instead
+var errLiveTailUnavailable = errors.New("Live tail unavailable")
+
+// liveTailStream is the events pushed by a Live Tail session
+type liveTailStream interface {
+	Events() <-chan cloudwatchlogs.StartLiveTailResponseStreamEvent
+	Close() error
+	Err() error
+}
+
+// startLiveTail returns a func that starts Live Tail sessions with a client
+func startLiveTail(cwl *cloudwatchlogs.CloudWatchLogs) func(context.Context, *cloudwatchlogs.StartLiveTailInput) (liveTailStream, error) {
+	return func(ctx context.Context, input *cloudwatchlogs.StartLiveTailInput) (liveTailStream, error) {
+		resp, err := cwl.StartLiveTailWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		return resp.GetStream(), nil
+	}
+}
+
+// getEventsAfter prints events from the stream with GetLogEvents, starting
+// after a given timestamp and then following the stream's forward token, so
+// that no events are fetched twice
+func (lw *logWatcher) getEventsAfter(ctx context.Context, ts int64) (int64, error) {
+	logf(ctx, "Getting events in stream %q after %d", lw.LogStreamName, ts)
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(lw.LogGroupName),
+		LogStreamName: aws.String(lw.LogStreamName),
+		StartFromHead: aws.Bool(true),
+		NextToken:     lw.nextToken,
+	}
+	if input.NextToken == nil {
+		input.StartTime = aws.Int64(ts + 1)
+	}
+
+	var count int64
+	for {
+		resp, err := lw.CloudWatchLogs.GetLogEventsWithContext(ctx, input)
+		if err != nil {
+			return ts, wrapAPIError("GetLogEvents", err)
+		}
+		for _, event := range resp.Events {
+			count++
+			ts = lw.print(ctx, &cloudwatchlogs.FilteredLogEvent{
+				IngestionTime: event.IngestionTime,
+				LogStreamName: aws.String(lw.LogStreamName),
+				Message:       event.Message,
+				Timestamp:     event.Timestamp,
+			}, ts)
+		}
+
+		// the token that was passed is returned at the end of the stream
+		done := resp.NextForwardToken == nil || aws.StringValue(resp.NextForwardToken) == aws.StringValue(input.NextToken)
+		lw.nextToken = resp.NextForwardToken
+		if done {
+			break
+		}
+		input.NextToken = resp.NextForwardToken
+		input.StartTime = nil
+	}
+
+	if count > 0 {
+		lw.progressed(ts)
+	}
+	return ts, nil
+}
+
+// tail follows the stream with Live Tail sessions, printing events after a
+// given timestamp until the watcher is stopped. Events from before each
+// session started are fetched with FilterLogEvents first, as sessions only
+// push new events, and end after at most 3 hours.
+func (lw *logWatcher) tail(ctx context.Context, after int64) (int64, error) {
+	groupARN, err := lw.logGroupARN(ctx)
+	if err != nil {
+		logf(ctx, "Failed to find the log group to live tail, polling for events instead: %v", err)
+		return after, errLiveTailUnavailable
+	}
+
+	pollInterval := lw.Interval
+	if pollInterval == time.Duration(0) {
+		pollInterval = defaultLogPollInterval
+	}
+
+	for {
+		if after, err = lw.filterEventsAfter(ctx, after); err != nil {
+			return after, err
+		}
+
+		logf(ctx, "Starting a live tail of stream %q", lw.LogStreamName)
+		stream, err := lw.LiveTail(ctx, &cloudwatchlogs.StartLiveTailInput{
+			LogGroupIdentifiers: aws.StringSlice([]string{groupARN}),
+			LogStreamNames:      aws.StringSlice([]string{lw.LogStreamName}),
+		})
+		if err != nil {
+			logf(ctx, "Failed to start a live tail of stream %q, polling for events instead: %v",
+				lw.LogStreamName, wrapAPIError("StartLiveTail", err))
+			return after, errLiveTailUnavailable
+		}
+
+		var stopped bool
+		after, stopped = lw.printLiveTail(ctx, stream, after)
+		stream.Close()
+		if stopped {
+			return after, ctx.Err()
+		}
+		if err := stream.Err(); err != nil {
+			logf(ctx, "Live tail of stream %q ended: %v", lw.LogStreamName, err)
+		}
+
+		select {
+		case <-time.After(pollInterval):
+		case <-lw.stop:
+			return after, nil
+		case <-ctx.Done():
+			return after, ctx.Err()
+		}
+	}
+}
+
+// printLiveTail prints the events a Live Tail session pushes, returning
+// whether the watcher was stopped or cancelled rather than the session ending.
+// Events up to where the catch up left off can be pushed again, which are
+// skipped, including the ones it printed with its last timestamp, but not
+// others with the same timestamp.
+func (lw *logWatcher) printLiveTail(ctx context.Context, stream liveTailStream, after int64) (int64, bool) {
+	boundary := after
+	repeats := map[string]int{}
+	if lw.lastTs == boundary {
+		for message, n := range lw.lastMessages {
+			repeats[message] = n
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-stream.Events():
+			if !ok {
+				return after, false
+			}
+			update, ok := event.(*cloudwatchlogs.LiveTailSessionUpdate)
+			if !ok {
+				continue
+			}
+			var count int64
+			for _, ev := range update.SessionResults {
+				if ts := aws.Int64Value(ev.Timestamp); ts < boundary {
+					continue
+				} else if ts == boundary && repeats[aws.StringValue(ev.Message)] > 0 {
+					repeats[aws.StringValue(ev.Message)]--
+					continue
+				}
+				count++
+				after = lw.print(ctx, &cloudwatchlogs.FilteredLogEvent{
+					IngestionTime: ev.IngestionTime,
+					LogStreamName: ev.LogStreamName,
+					Message:       ev.Message,
+					Timestamp:     ev.Timestamp,
+				}, after)
+			}
+			if count > 0 {
+				lw.progressed(after)
+			}
+
+		case <-lw.stop:
+			return after, true
+
+		case <-ctx.Done():
+			return after, true
+		}
+	}
+}
+
+// logGroupARN looks up the ARN of the watcher's log group, which Live Tail
+// sessions need rather than its name
+func (lw *logWatcher) logGroupARN(ctx context.Context) (string, error) {
+	var groupARN string
+	err := lw.CloudWatchLogs.DescribeLogGroupsPagesWithContext(ctx, &cloudwatchlogs.DescribeLogGroupsInput{
+		LogGroupNamePrefix: aws.String(lw.LogGroupName),
+	}, func(page *cloudwatchlogs.DescribeLogGroupsOutput, lastPage bool) bool {
+		for _, group := range page.LogGroups {
+			if aws.StringValue(group.LogGroupName) == lw.LogGroupName {
+				groupARN = strings.TrimSuffix(aws.StringValue(group.Arn), ":*")
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", wrapAPIError("DescribeLogGroups", err)
+	}
+	if groupARN == "" {
+		return "", fmt.Errorf("No log group named %s to live tail", lw.LogGroupName)
+	}
+	return groupARN, nil
+}
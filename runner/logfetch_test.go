@@ -0,0 +1,271 @@
+package runner
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// pagedCloudWatchLogs returns GetLogEvents pages in order, each with the
+// token that's expected to fetch it
+type pagedCloudWatchLogs struct {
+	mockCloudWatchLogs
+	pages  []*cloudwatchlogs.GetLogEventsOutput
+	inputs []cloudwatchlogs.GetLogEventsInput
+}
+
+func (cw *pagedCloudWatchLogs) GetLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.GetLogEventsInput, opts ...request.Option) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	cw.inputs = append(cw.inputs, *input)
+	page := cw.pages[0]
+	if len(cw.pages) > 1 {
+		cw.pages = cw.pages[1:]
+	}
+	return page, nil
+}
+
+func outputEvents(messages ...string) []*cloudwatchlogs.OutputLogEvent {
+	var events []*cloudwatchlogs.OutputLogEvent
+	for i, message := range messages {
+		events = append(events, &cloudwatchlogs.OutputLogEvent{Message: aws.String(message), Timestamp: aws.Int64(int64(i + 1))})
+	}
+	return events
+}
+
+func TestLogsWatcherGetStrategy(t *testing.T) {
+	cwlc := &pagedCloudWatchLogs{pages: []*cloudwatchlogs.GetLogEventsOutput{
+		{Events: outputEvents("one", "two"), NextForwardToken: aws.String("f/1")},
+		{Events: outputEvents("three"), NextForwardToken: aws.String("f/2")},
+		{NextForwardToken: aws.String("f/2")},
+		{Events: outputEvents("four"), NextForwardToken: aws.String("f/3")},
+		{NextForwardToken: aws.String("f/3")},
+	}}
+
+	var printed []string
+	w := logWatcher{
+		LogGroupName:   "my-group",
+		LogStreamName:  "my-stream",
+		CloudWatchLogs: cwlc,
+		Strategy:       LogFetchGet,
+		Printer: func(ev *cloudwatchlogs.FilteredLogEvent) bool {
+			printed = append(printed, *ev.Message)
+			return true
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := w.printEventsAfter(context.Background(), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if expected := []string{"one", "two", "three", "four"}; !reflect.DeepEqual(printed, expected) {
+		t.Errorf("Expected %v, got %v", expected, printed)
+	}
+	var tokens []string
+	for _, input := range cwlc.inputs {
+		tokens = append(tokens, aws.StringValue(input.NextToken))
+	}
+	if expected := []string{"", "f/1", "f/2", "f/2", "f/3"}; !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Expected the stream to be followed with tokens %q, got %q", expected, tokens)
+	}
+	if cwlc.inputs[0].StartTime == nil || cwlc.inputs[1].StartTime != nil {
+		t.Error("Expected only the first call to start from a timestamp")
+	}
+}
+
+type fakeLiveTailStream struct {
+	events chan cloudwatchlogs.StartLiveTailResponseStreamEvent
+}
+
+func (s *fakeLiveTailStream) Events() <-chan cloudwatchlogs.StartLiveTailResponseStreamEvent {
+	return s.events
+}
+
+func (s *fakeLiveTailStream) Close() error { return nil }
+func (s *fakeLiveTailStream) Err() error   { return nil }
+
+func TestLogsWatcherLiveTail(t *testing.T) {
+	cwlc := &mockCloudWatchLogs{
+		logStreams: []*cloudwatchlogs.LogStream{{LogStreamName: aws.String("my-stream")}},
+		filterLogEvents: []*cloudwatchlogs.FilteredLogEvent{
+			{Message: aws.String("before the session"), Timestamp: aws.Int64(1)},
+		},
+	}
+
+	stream := &fakeLiveTailStream{events: make(chan cloudwatchlogs.StartLiveTailResponseStreamEvent, 2)}
+	stream.events <- &cloudwatchlogs.LiveTailSessionStart{}
+	stream.events <- &cloudwatchlogs.LiveTailSessionUpdate{SessionResults: []*cloudwatchlogs.LiveTailSessionLogEvent{
+		{Message: aws.String("before the session"), Timestamp: aws.Int64(1)},
+		{Message: aws.String("during the session"), Timestamp: aws.Int64(2)},
+		{Message: aws.String("finished"), Timestamp: aws.Int64(3)},
+	}}
+
+	var groups []string
+	var printed []string
+	w := logWatcher{
+		LogGroupName:   "my-group",
+		LogStreamName:  "my-stream",
+		CloudWatchLogs: cwlc,
+		Strategy:       LogFetchLiveTail,
+		Interval:       time.Millisecond,
+		LiveTail: func(ctx context.Context, input *cloudwatchlogs.StartLiveTailInput) (liveTailStream, error) {
+			groups = append(groups, aws.StringValueSlice(input.LogGroupIdentifiers)...)
+			return stream, nil
+		},
+		Printer: func(ev *cloudwatchlogs.FilteredLogEvent) bool {
+			printed = append(printed, *ev.Message)
+			return *ev.Message != "finished"
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Watch(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"before the session", "during the session", "finished"}; !reflect.DeepEqual(printed, expected) {
+		t.Errorf("Expected %v, got %v", expected, printed)
+	}
+	if expected := []string{"arn:aws:logs:us-east-1:123456789012:log-group:my-group"}; !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Expected a live tail of %v, got %v", expected, groups)
+	}
+}
+
+func TestLogsWatcherLiveTailFallsBackToPolling(t *testing.T) {
+	cwlc := &mockCloudWatchLogs{
+		logStreams: []*cloudwatchlogs.LogStream{{LogStreamName: aws.String("my-stream")}},
+		filterLogEvents: []*cloudwatchlogs.FilteredLogEvent{
+			{Message: aws.String("hello"), Timestamp: aws.Int64(1)},
+		},
+	}
+
+	var printed []string
+	w := logWatcher{
+		LogGroupName:   "my-group",
+		LogStreamName:  "my-stream",
+		CloudWatchLogs: cwlc,
+		Strategy:       LogFetchLiveTail,
+		Interval:       time.Millisecond,
+		LiveTail: func(ctx context.Context, input *cloudwatchlogs.StartLiveTailInput) (liveTailStream, error) {
+			return nil, awserr.New("AccessDeniedException", "not authorized to perform logs:StartLiveTail", nil)
+		},
+		Printer: func(ev *cloudwatchlogs.FilteredLogEvent) bool {
+			printed = append(printed, *ev.Message)
+			return *ev.Message != "finished"
+		},
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cwlc.Lock()
+		defer cwlc.Unlock()
+		cwlc.filterLogEvents = append(cwlc.filterLogEvents, &cloudwatchlogs.FilteredLogEvent{Message: aws.String("finished"), Timestamp: aws.Int64(2)})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Watch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"hello", "finished"}; !reflect.DeepEqual(printed, expected) {
+		t.Errorf("Expected events to be polled for, got %v", printed)
+	}
+}
+
+func TestLogsWatcherLiveTailEqualTimestamps(t *testing.T) {
+	cwlc := &mockCloudWatchLogs{
+		logStreams: []*cloudwatchlogs.LogStream{{LogStreamName: aws.String("my-stream")}},
+		filterLogEvents: []*cloudwatchlogs.FilteredLogEvent{
+			{Message: aws.String("first"), Timestamp: aws.Int64(5)},
+		},
+	}
+
+	stream := &fakeLiveTailStream{events: make(chan cloudwatchlogs.StartLiveTailResponseStreamEvent, 2)}
+	stream.events <- &cloudwatchlogs.LiveTailSessionUpdate{SessionResults: []*cloudwatchlogs.LiveTailSessionLogEvent{
+		{Message: aws.String("first"), Timestamp: aws.Int64(5)},
+		{Message: aws.String("second"), Timestamp: aws.Int64(5)},
+		{Message: aws.String("third"), Timestamp: aws.Int64(6)},
+		{Message: aws.String("fourth"), Timestamp: aws.Int64(6)},
+	}}
+	stream.events <- &cloudwatchlogs.LiveTailSessionUpdate{SessionResults: []*cloudwatchlogs.LiveTailSessionLogEvent{
+		{Message: aws.String("finished"), Timestamp: aws.Int64(6)},
+	}}
+
+	var printed []string
+	w := logWatcher{
+		LogGroupName:   "my-group",
+		LogStreamName:  "my-stream",
+		CloudWatchLogs: cwlc,
+		Strategy:       LogFetchLiveTail,
+		Interval:       time.Millisecond,
+		LiveTail: func(ctx context.Context, input *cloudwatchlogs.StartLiveTailInput) (liveTailStream, error) {
+			return stream, nil
+		},
+		Printer: func(ev *cloudwatchlogs.FilteredLogEvent) bool {
+			printed = append(printed, *ev.Message)
+			return *ev.Message != "finished"
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Watch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"first", "second", "third", "fourth", "finished"}; !reflect.DeepEqual(printed, expected) {
+		t.Errorf("Expected events with the same timestamp to be printed once each, got %v", printed)
+	}
+}
+
+// noLogGroupsCloudWatchLogs can't describe log groups
+type noLogGroupsCloudWatchLogs struct {
+	*mockCloudWatchLogs
+}
+
+func (cw noLogGroupsCloudWatchLogs) DescribeLogGroupsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogGroupsInput,
+	fn func(*cloudwatchlogs.DescribeLogGroupsOutput, bool) bool, opts ...request.Option) error {
+	return awserr.New("AccessDeniedException", "not authorized to perform logs:DescribeLogGroups", nil)
+}
+
+func TestLogsWatcherLiveTailWithoutLogGroupFallsBackToPolling(t *testing.T) {
+	cwlc := &mockCloudWatchLogs{
+		logStreams: []*cloudwatchlogs.LogStream{{LogStreamName: aws.String("my-stream")}},
+		filterLogEvents: []*cloudwatchlogs.FilteredLogEvent{
+			{Message: aws.String("hello"), Timestamp: aws.Int64(1)},
+			{Message: aws.String("finished"), Timestamp: aws.Int64(2)},
+		},
+	}
+
+	var printed []string
+	w := logWatcher{
+		LogGroupName:   "my-group",
+		LogStreamName:  "my-stream",
+		CloudWatchLogs: noLogGroupsCloudWatchLogs{cwlc},
+		Strategy:       LogFetchLiveTail,
+		Interval:       time.Millisecond,
+		LiveTail: func(ctx context.Context, input *cloudwatchlogs.StartLiveTailInput) (liveTailStream, error) {
+			t.Fatal("Expected no live tail without the log group's ARN")
+			return nil, nil
+		},
+		Printer: func(ev *cloudwatchlogs.FilteredLogEvent) bool {
+			printed = append(printed, *ev.Message)
+			return *ev.Message != "finished"
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Watch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"hello", "finished"}; !reflect.DeepEqual(printed, expected) {
+		t.Errorf("Expected events to be polled for, got %v", printed)
+	}
+}
@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"time"
+)
+
+// LogEntry is a debug message from a run, along with the run, task and
+// container it's about
+type LogEntry struct {
+	Time      time.Time `json:"time"`
+	RunID     string    `json:"run_id,omitempty"`
+	Cluster   string    `json:"cluster,omitempty"`
+	TaskARN   string    `json:"task_arn,omitempty"`
+	Container string    `json:"container,omitempty"`
+	Message   string    `json:"message"`
+}
+
+// Logger receives a runner's debug messages, such as to separate the output
+// of many runners in the same process. Without one, messages are written to
+// the standard logger.
+type Logger interface {
+	Log(entry LogEntry)
+}
+
+// LoggerFunc adapts a function to a Logger
+type LoggerFunc func(entry LogEntry)
+
+func (f LoggerFunc) Log(entry LogEntry) {
+	f(entry)
+}
+
+// logScope is where debug messages from part of a run go, and the fields
+// they're logged with
+type logScope struct {
+	logger Logger
+	fields LogEntry
+}
+
+func (s logScope) printf(format string, v ...interface{}) {
+	if s.logger == nil {
+		log.Printf(format, v...)
+		return
+	}
+	entry := s.fields
+	entry.Time = time.Now()
+	entry.Message = fmt.Sprintf(format, v...)
+	s.logger.Log(entry)
+}
+
+type logScopeKey struct{}
+
+func withLogScope(ctx context.Context, s logScope) context.Context {
+	return context.WithValue(ctx, logScopeKey{}, s)
+}
+
+func logScopeFrom(ctx context.Context) logScope {
+	s, _ := ctx.Value(logScopeKey{}).(logScope)
+	return s
+}
+
+// withTaskLog scopes debug messages to a task
+func withTaskLog(ctx context.Context, taskARN string) context.Context {
+	s := logScopeFrom(ctx)
+	s.fields.TaskARN = taskARN
+	return withLogScope(ctx, s)
+}
+
+// withContainerLog scopes debug messages to a container of a task
+func withContainerLog(ctx context.Context, taskARN, container string) context.Context {
+	s := logScopeFrom(ctx)
+	s.fields.TaskARN = taskARN
+	s.fields.Container = container
+	return withLogScope(ctx, s)
+}
+
+// logf writes a debug message with the fields of the context's scope
+func logf(ctx context.Context, format string, v ...interface{}) {
+	logScopeFrom(ctx).printf(format, v...)
+}
+
+// logf writes a debug message about the run, for where there's no context
+func (r *Runner) logf(format string, v ...interface{}) {
+	r.logScope.printf(format, v...)
+}
+
+// crockfordBase32 is the alphabet of ULIDs, which leaves out I, L, O and U
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newRunID returns a ULID to identify a run by, which is a 48 bit timestamp
+// in milliseconds followed by 80 random bits, so that IDs sort by when runs
+// started
+func newRunID() string {
+	var b [16]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> uint(40-8*i))
+	}
+	if _, err := rand.Read(b[6:]); err != nil {
+		binary.BigEndian.PutUint64(b[8:], uint64(time.Now().UnixNano()))
+	}
+
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	var id [26]byte
+	for i := len(id) - 1; i >= 0; i-- {
+		id[i] = crockfordBase32[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(id[:])
+}
+
+// runID returns the ID of the run, which is shared by every target of an
+// invocation
+func (r *Runner) runID() string {
+	if id := r.logScope.fields.RunID; id != "" {
+		return id
+	}
+	return r.RunID
+}
@@ -0,0 +1,97 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestLoggerScopes(t *testing.T) {
+	var mu sync.Mutex
+	var entries []LogEntry
+	logger := LoggerFunc(func(e LogEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		entries = append(entries, e)
+	})
+
+	ctx := withLogScope(context.Background(), logScope{logger: logger, fields: LogEntry{RunID: "run-1", Cluster: "ci"}})
+	logf(ctx, "Running task %s", "app:1")
+	logf(withTaskLog(ctx, "task-1"), "Task %s has stopped", "task-1")
+	logf(withContainerLog(ctx, "task-1", "app"), "Watching logs")
+
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if e := entries[0]; e.RunID != "run-1" || e.Cluster != "ci" || e.TaskARN != "" || e.Message != "Running task app:1" {
+		t.Errorf("Unexpected run entry %+v", e)
+	}
+	if e := entries[1]; e.TaskARN != "task-1" || e.Container != "" {
+		t.Errorf("Unexpected task entry %+v", e)
+	}
+	if e := entries[2]; e.RunID != "run-1" || e.TaskARN != "task-1" || e.Container != "app" || e.Time.IsZero() {
+		t.Errorf("Unexpected container entry %+v", e)
+	}
+}
+
+func TestRunLogsToLogger(t *testing.T) {
+	if testing.Short() {
+		t.Skip("simulated runs wait for logs")
+	}
+
+	var mu sync.Mutex
+	var entries []LogEntry
+	var stdout, stderr bytes.Buffer
+	r := New()
+	r.Config = aws.NewConfig().WithRegion("us-east-1")
+	r.TaskDefinitionFile = "../examples/helloworld/taskdefinition.json"
+	r.Cluster = "default"
+	r.LogGroupName = "ecs-task-runner"
+	r.Count = 1
+	r.Simulate = SimulateOOM
+	r.RunID = "run-1"
+	r.Logger = LoggerFunc(func(e LogEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		entries = append(entries, e)
+	})
+	r.Stdout, r.Stderr = &stdout, &stderr
+
+	r.Run(context.Background())
+
+	var tasks, containers int
+	for _, e := range entries {
+		if e.RunID != "run-1" || e.Cluster != "default" {
+			t.Fatalf("Expected every entry to be scoped to the run, got %+v", e)
+		}
+		if e.TaskARN != "" {
+			tasks++
+		}
+		if e.Container != "" {
+			containers++
+		}
+	}
+	if tasks == 0 || containers == 0 {
+		t.Fatalf("Expected entries about tasks and containers, got %+v", entries)
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	first := newRunID()
+	time.Sleep(2 * time.Millisecond)
+	second := newRunID()
+
+	for _, id := range []string{first, second} {
+		if len(id) != 26 || strings.Trim(id, crockfordBase32) != "" {
+			t.Fatalf("Expected a ULID, got %q", id)
+		}
+	}
+	if first >= second {
+		t.Errorf("Expected %s to sort before %s", first, second)
+	}
+}
@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// logStreamProbeTimeout is how long a stopped container's log stream is
+// looked for, allowing for CloudWatch Logs to catch up, before it's treated
+// as never having been created
+const logStreamProbeTimeout = 10 * time.Second
+
+// quickExitThreshold is how soon after its task started a container has to
+// exit for it to have possibly stopped before its log driver sent anything
+const quickExitThreshold = 5 * time.Second
+
+// probeLogStreams probes the log streams of the stopped containers whose logs
+// are followed all at once, so that each one without a stream doesn't add
+// logStreamProbeTimeout to the exit. It returns whether each container's
+// stream exists by container ARN, printing what was found in the order of the
+// containers.
+func (r *Runner) probeLogStreams(ctx context.Context, cwl *cloudWatchLogsClients, td *preparedTaskDefinition, tasks []*ecs.Task) map[string]bool {
+	type probe struct {
+		container string
+		out       bytes.Buffer
+		exists    bool
+	}
+
+	var probes []*probe
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		for _, container := range task.Containers {
+			lc, ok := td.Logs[aws.StringValue(container.Name)]
+			if r.NoLogs || !ok || container.ExitCode == nil {
+				continue
+			}
+			p := &probe{container: aws.StringValue(container.ContainerArn)}
+			probes = append(probes, p)
+			wg.Add(1)
+			go func(task *ecs.Task, container *ecs.Container, lc logConfig) {
+				defer wg.Done()
+				p.exists = r.probeLogStream(ctx, &p.out, cwl, lc, task, container)
+			}(task, container, lc)
+		}
+	}
+	wg.Wait()
+
+	exists := map[string]bool{}
+	for _, p := range probes {
+		fmt.Fprint(r.Stderr, p.out.String())
+		exists[p.container] = p.exists
+	}
+	return exists
+}
+
+// probeLogStream checks that a stopped container's log stream exists, as the
+// awslogs driver creates it when the container starts. If it was never
+// created, the likely reasons are printed to w rather than nothing for the
+// container, and false is returned as there's nothing to wait for or write to.
+func (r *Runner) probeLogStream(ctx context.Context, w io.Writer, cwl *cloudWatchLogsClients, lc logConfig, task *ecs.Task, container *ecs.Container) bool {
+	waiter := &logWaiter{
+		CloudWatchLogs: cwl.forRegion(lc.Region),
+		LogGroupName:   lc.Group,
+		LogStreamName:  logStreamName(lc.StreamPrefix, container, task),
+		Timeout:        logStreamProbeTimeout,
+	}
+	err := waiter.Wait(ctx)
+
+	var timeout *TimeoutError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &timeout):
+		fmt.Fprint(w, logStreamDiagnosis(lc, task, container, waiter.LogStreamName))
+		return false
+	case logAccessError(lc.Group, lc.Region, err) != err:
+		// the log watcher has already said it can't access the log group
+		logf(ctx, "Can't find log stream %s: %v", waiter.LogStreamName, err)
+		return false
+	}
+	logf(ctx, "Failed to check log stream %s exists: %v", waiter.LogStreamName, err)
+	return true
+}
+
+// logStreamDiagnosis explains why a container's log stream may never have
+// been created
+func logStreamDiagnosis(lc logConfig, task *ecs.Task, container *ecs.Container, stream string) string {
+	region := lc.Region
+	if region == "" {
+		region = "the default region"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "No logs were found for container %s in task %s, as its log stream %s was never created in log group %s in %s. Possible reasons are:\n",
+		aws.StringValue(container.Name), path.Base(aws.StringValue(task.TaskArn)), stream, lc.Group, region)
+
+	if task.StartedAt == nil {
+		fmt.Fprintln(&b, "  - the container exited before its log driver started, as the task never started running")
+	} else if task.StoppedAt != nil && task.StoppedAt.Sub(*task.StartedAt) < quickExitThreshold {
+		fmt.Fprintf(&b, "  - the container exited before its log driver sent anything, as the task stopped %v after it started\n",
+			task.StoppedAt.Sub(*task.StartedAt).Round(time.Millisecond))
+	}
+	fmt.Fprintf(&b, "  - the task's execution role, or the instance role on EC2, is missing logs:CreateLogStream and logs:PutLogEvents on %s\n", lc.Group)
+	fmt.Fprintf(&b, "  - awslogs-group or awslogs-region in the task definition send logs somewhere other than %s in %s\n", lc.Group, region)
+	return b.String()
+}
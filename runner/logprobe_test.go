@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestProbeLogStream(t *testing.T) {
+	sess := fakeSession(func(req *request.Request) {
+		in := req.Params.(*cloudwatchlogs.DescribeLogStreamsInput)
+		if aws.StringValue(in.LogGroupName) == "missing" {
+			req.Error = awserr.NewRequestFailure(awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException,
+				"The specified log group does not exist.", nil), http.StatusBadRequest, "1")
+			return
+		}
+		out := req.Data.(*cloudwatchlogs.DescribeLogStreamsOutput)
+		out.LogStreams = []*cloudwatchlogs.LogStream{{LogStreamName: in.LogStreamNamePrefix}}
+	})
+
+	var stderr bytes.Buffer
+	r := &Runner{Stderr: &stderr}
+	cwl := &cloudWatchLogsClients{sess: sess}
+	task := &ecs.Task{TaskArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/abc")}
+	container := &ecs.Container{Name: aws.String("app")}
+
+	if !r.probeLogStream(context.Background(), &stderr, cwl, logConfig{Group: "logs", StreamPrefix: "run"}, task, container) {
+		t.Error("Expected the log stream to exist")
+	}
+	if r.probeLogStream(context.Background(), &stderr, cwl, logConfig{Group: "missing", StreamPrefix: "run"}, task, container) {
+		t.Error("Expected the log stream not to exist in a missing log group")
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("Expected the log watcher to report a missing log group, got %q", stderr.String())
+	}
+}
+
+func TestProbeLogStreams(t *testing.T) {
+	sess := fakeSession(func(req *request.Request) {
+		in := req.Params.(*cloudwatchlogs.DescribeLogStreamsInput)
+		out := req.Data.(*cloudwatchlogs.DescribeLogStreamsOutput)
+		out.LogStreams = []*cloudwatchlogs.LogStream{{LogStreamName: in.LogStreamNamePrefix}}
+	})
+
+	r := &Runner{Stderr: &bytes.Buffer{}}
+	td := &preparedTaskDefinition{Logs: map[string]logConfig{"app": {Group: "logs", StreamPrefix: "run"}}}
+	task := &ecs.Task{
+		TaskArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/abc"),
+		Containers: []*ecs.Container{
+			{Name: aws.String("app"), ContainerArn: aws.String("app"), ExitCode: aws.Int64(0)},
+			{Name: aws.String("sidecar"), ContainerArn: aws.String("sidecar"), ExitCode: aws.Int64(0)},
+			{Name: aws.String("app"), ContainerArn: aws.String("never-ran")},
+		},
+	}
+
+	streams := r.probeLogStreams(context.Background(), &cloudWatchLogsClients{sess: sess}, td, []*ecs.Task{task})
+	if len(streams) != 1 || !streams["app"] {
+		t.Errorf("Expected only the stopped container with logs to be probed, got %v", streams)
+	}
+}
+
+func TestLogStreamDiagnosis(t *testing.T) {
+	started := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	task := &ecs.Task{
+		TaskArn:   aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/abc"),
+		StartedAt: aws.Time(started),
+		StoppedAt: aws.Time(started.Add(800 * time.Millisecond)),
+	}
+	container := &ecs.Container{Name: aws.String("app")}
+
+	diagnosis := logStreamDiagnosis(logConfig{Group: "logs", Region: "eu-west-1"}, task, container, "run/app/abc")
+	for _, expected := range []string{
+		"container app in task abc, as its log stream run/app/abc was never created in log group logs in eu-west-1",
+		"as the task stopped 800ms after it started",
+		"missing logs:CreateLogStream and logs:PutLogEvents on logs",
+		"somewhere other than logs in eu-west-1",
+	} {
+		if !strings.Contains(diagnosis, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, diagnosis)
+		}
+	}
+
+	task.StoppedAt = aws.Time(started.Add(time.Minute))
+	if diagnosis := logStreamDiagnosis(logConfig{Group: "logs"}, task, container, "run/app/abc"); strings.Contains(diagnosis, "exited before") {
+		t.Errorf("Expected a container that ran for a minute not to have exited before logging:\n%s", diagnosis)
+	}
+}
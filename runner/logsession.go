@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// logsSession returns the session for CloudWatch Logs calls, which can be in
+// a different region than the tasks, or in another account by assuming a role
+func (r *Runner) logsSession(sess *session.Session) *session.Session {
+	if r.LogRegion == "" && r.LogRoleARN == "" {
+		return sess
+	}
+
+	config := aws.NewConfig()
+	if r.LogRegion != "" {
+		config.WithRegion(r.LogRegion)
+	}
+	if r.LogRoleARN != "" {
+		config.WithCredentials(assumeRoleCredentials(sess, r.LogRoleARN, ""))
+	}
+	return sess.Copy(config)
+}
+
+// logRegion returns the region of the log group that tasks send logs to
+func (r *Runner) logRegion() string {
+	if r.LogRegion != "" {
+		return r.LogRegion
+	}
+	return r.Region
+}
+
+// logAccessError explains an error accessing a log group that's likely to be
+// in another region or account, or returns the error as-is
+func logAccessError(group, region string, err error) error {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch apiErr.Code() {
+	case "AccessDeniedException", cloudwatchlogs.ErrCodeResourceNotFoundException:
+	default:
+		return err
+	}
+
+	if region == "" {
+		region = "the default region"
+	}
+	return fmt.Errorf("Can't access log group %s in %s, check whether it's in another region or account that needs a log region or role: %v",
+		group, region, err)
+}
@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestLogsSession(t *testing.T) {
+	sess := session.Must(session.NewSession(aws.NewConfig().WithRegion("us-east-1")))
+
+	r := New()
+	if r.logsSession(sess) != sess {
+		t.Fatal("Expected the same session without a log region or role")
+	}
+
+	r.LogRegion = "eu-west-1"
+	if region := aws.StringValue(r.logsSession(sess).Config.Region); region != "eu-west-1" {
+		t.Fatalf("Expected the log region, got %q", region)
+	}
+	if region := aws.StringValue(sess.Config.Region); region != "us-east-1" {
+		t.Fatalf("Expected the original session to be unchanged, got %q", region)
+	}
+}
+
+func TestLogRegion(t *testing.T) {
+	r := New()
+	r.Region = "us-east-1"
+	if region := r.logRegion(); region != "us-east-1" {
+		t.Fatalf("Expected the tasks' region, got %q", region)
+	}
+	r.LogRegion = "eu-west-1"
+	if region := r.logRegion(); region != "eu-west-1" {
+		t.Fatalf("Expected the log region, got %q", region)
+	}
+}
+
+func TestLogAccessError(t *testing.T) {
+	denied := wrapAPIError("DescribeLogStreams", awserr.New("AccessDeniedException", "not authorized", nil))
+	err := logAccessError("central-logs", "eu-west-1", denied)
+	if !strings.HasPrefix(err.Error(), "Can't access log group central-logs in eu-west-1") {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	throttled := wrapAPIError("DescribeLogStreams", awserr.New("ThrottlingException", "slow down", nil))
+	if err := logAccessError("central-logs", "eu-west-1", throttled); err != throttled {
+		t.Fatalf("Expected other API errors as-is, got %v", err)
+	}
+
+	other := errors.New("Timed out")
+	if err := logAccessError("central-logs", "eu-west-1", other); err != other {
+		t.Fatalf("Expected other errors as-is, got %v", err)
+	}
+}
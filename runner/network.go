@@ -0,0 +1,137 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// parseNetworkFrom splits a `service:NAME` or `task:ARN` reference to copy
+// network configuration from into its kind and name
+func parseNetworkFrom(ref string) (kind, name string, err error) {
+	parts := strings.SplitN(ref, ":", 2)
+	if len(parts) != 2 || parts[1] == "" || (parts[0] != "service" && parts[0] != "task") {
+		return "", "", fmt.Errorf("Expected network to be copied from service:NAME or task:ARN, got %q", ref)
+	}
+	return parts[0], parts[1], nil
+}
+
+// networkConfigurationFrom copies the awsvpc network configuration of an
+// existing service or task
+func networkConfigurationFrom(sess *session.Session, svc *ecs.ECS, cluster, ref string) (*ecs.NetworkConfiguration, error) {
+	kind, name, err := parseNetworkFrom(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if kind == "service" {
+		serviceCluster, service := ParseServiceRef(name)
+		if serviceCluster != "" {
+			cluster = serviceCluster
+		}
+		return serviceNetworkConfiguration(svc, cluster, service)
+	}
+
+	log.Printf("Describing task %s to copy its network configuration", name)
+	resp, err := svc.DescribeTasks(&ecs.DescribeTasksInput{
+		Cluster: aws.String(cluster),
+		Tasks:   aws.StringSlice([]string{name}),
+	})
+	if err != nil {
+		return nil, wrapAPIError("DescribeTasks", err)
+	}
+	if len(resp.Tasks) == 0 {
+		return nil, fmt.Errorf("No task %s in cluster %s", name, cluster)
+	}
+	task := resp.Tasks[0]
+
+	// tasks started by a service have the same network configuration as it
+	if group := aws.StringValue(task.Group); strings.HasPrefix(group, "service:") {
+		return serviceNetworkConfiguration(svc, cluster, strings.TrimPrefix(group, "service:"))
+	}
+
+	eni := taskNetworkInterfaceID(task)
+	if eni == "" {
+		return nil, fmt.Errorf("Task %s doesn't use awsvpc networking", name)
+	}
+
+	log.Printf("Describing network interface %s of task %s", eni, name)
+	interfaces, err := ec2.New(sess).DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{
+		NetworkInterfaceIds: aws.StringSlice([]string{eni}),
+	})
+	if err != nil {
+		return nil, wrapAPIError("DescribeNetworkInterfaces", err)
+	}
+	if len(interfaces.NetworkInterfaces) == 0 {
+		return nil, fmt.Errorf("No network interface %s for task %s", eni, name)
+	}
+
+	return networkConfigurationFromInterface(interfaces.NetworkInterfaces[0]), nil
+}
+
+func serviceNetworkConfiguration(svc *ecs.ECS, cluster, service string) (*ecs.NetworkConfiguration, error) {
+	s, err := describeService(svc, cluster, service)
+	if err != nil {
+		return nil, err
+	}
+	if s.NetworkConfiguration == nil {
+		return nil, fmt.Errorf("Service %s doesn't use awsvpc networking", service)
+	}
+	return s.NetworkConfiguration, nil
+}
+
+// taskNetworkInterfaceID finds the elastic network interface attached to a task
+func taskNetworkInterfaceID(task *ecs.Task) string {
+	for _, attachment := range task.Attachments {
+		if aws.StringValue(attachment.Type) != "ElasticNetworkInterface" {
+			continue
+		}
+		for _, detail := range attachment.Details {
+			if aws.StringValue(detail.Name) == "networkInterfaceId" {
+				return aws.StringValue(detail.Value)
+			}
+		}
+	}
+	return ""
+}
+
+func networkConfigurationFromInterface(eni *ec2.NetworkInterface) *ecs.NetworkConfiguration {
+	var securityGroups []*string
+	for _, group := range eni.Groups {
+		securityGroups = append(securityGroups, group.GroupId)
+	}
+
+	assignPublicIP := "DISABLED"
+	if eni.Association != nil && eni.Association.PublicIp != nil {
+		assignPublicIP = "ENABLED"
+	}
+
+	return &ecs.NetworkConfiguration{
+		AwsvpcConfiguration: &ecs.AwsVpcConfiguration{
+			Subnets:        []*string{eni.SubnetId},
+			SecurityGroups: securityGroups,
+			AssignPublicIp: aws.String(assignPublicIP),
+		},
+	}
+}
+
+// taskPrivateIP finds the private IP address of a task's elastic network
+// interface, which only tasks using awsvpc networking have
+func taskPrivateIP(task *ecs.Task) string {
+	for _, attachment := range task.Attachments {
+		if aws.StringValue(attachment.Type) != "ElasticNetworkInterface" {
+			continue
+		}
+		for _, detail := range attachment.Details {
+			if aws.StringValue(detail.Name) == "privateIPv4Address" {
+				return aws.StringValue(detail.Value)
+			}
+		}
+	}
+	return ""
+}
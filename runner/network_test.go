@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestParseNetworkFrom(t *testing.T) {
+	kind, name, err := parseNetworkFrom("service:production/web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != "service" || name != "production/web" {
+		t.Fatalf("Bad kind %q or name %q", kind, name)
+	}
+
+	kind, name, err = parseNetworkFrom("task:arn:aws:ecs:us-east-1:123456789012:task/production/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != "task" || name != "arn:aws:ecs:us-east-1:123456789012:task/production/abc" {
+		t.Fatalf("Bad kind %q or name %q", kind, name)
+	}
+
+	for _, ref := range []string{"web", "service:", "instance:i-123"} {
+		if _, _, err := parseNetworkFrom(ref); err == nil {
+			t.Fatalf("Expected an error for %q", ref)
+		}
+	}
+}
+
+func TestNetworkConfigurationFromInterface(t *testing.T) {
+	task := &ecs.Task{
+		Attachments: []*ecs.Attachment{{
+			Type: aws.String("ElasticNetworkInterface"),
+			Details: []*ecs.KeyValuePair{
+				{Name: aws.String("subnetId"), Value: aws.String("subnet-1")},
+				{Name: aws.String("networkInterfaceId"), Value: aws.String("eni-1")},
+			},
+		}},
+	}
+	if eni := taskNetworkInterfaceID(task); eni != "eni-1" {
+		t.Fatalf("Expected eni-1, got %q", eni)
+	}
+
+	nc := networkConfigurationFromInterface(&ec2.NetworkInterface{
+		SubnetId:    aws.String("subnet-1"),
+		Groups:      []*ec2.GroupIdentifier{{GroupId: aws.String("sg-1")}, {GroupId: aws.String("sg-2")}},
+		Association: &ec2.NetworkInterfaceAssociation{PublicIp: aws.String("203.0.113.1")},
+	}).AwsvpcConfiguration
+
+	if len(nc.Subnets) != 1 || *nc.Subnets[0] != "subnet-1" {
+		t.Fatalf("Bad subnets %v", aws.StringValueSlice(nc.Subnets))
+	}
+	if len(nc.SecurityGroups) != 2 {
+		t.Fatalf("Bad security groups %v", aws.StringValueSlice(nc.SecurityGroups))
+	}
+	if *nc.AssignPublicIp != "ENABLED" {
+		t.Fatalf("Expected a public IP to be assigned, got %s", *nc.AssignPublicIp)
+	}
+}
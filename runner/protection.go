@@ -0,0 +1,218 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// maxAutoScalingInstances is the most instances that a single
+// DescribeAutoScalingInstances or SetInstanceProtection call accepts
+const maxAutoScalingInstances = 50
+
+// scaleInProtection is the container instances that a run protected from
+// scale-in, to be released once its tasks have stopped
+type scaleInProtection struct {
+	svc     *autoscaling.AutoScaling
+	ecs     *ecs.ECS
+	cluster string
+
+	// instances are the EC2 instance IDs protected in each auto scaling group
+	instances map[string][]string
+
+	// containerInstances are the container instance ARNs of the EC2 instances
+	containerInstances map[string]string
+
+	// tasksLeftRunning is set when the run finishes with its tasks still
+	// running, so the protection is kept
+	tasksLeftRunning bool
+}
+
+// protectFromScaleIn protects the container instances that tasks were placed
+// on from being scaled in by their auto scaling group, such as by a capacity
+// provider, while the run waits for them. ECS only supports task protection
+// for tasks in a service, so it's the instances that are protected. Instances
+// that were already protected are left as they are, and protection is best
+// effort, so failures are only warned about.
+func (r *Runner) protectFromScaleIn(ctx context.Context, sess *session.Session, svc *ecs.ECS, tasks []*ecs.Task) *scaleInProtection {
+	if !r.ScaleInProtection {
+		return nil
+	}
+
+	var arns []string
+	seen := map[string]bool{}
+	for _, task := range tasks {
+		arn := aws.StringValue(task.ContainerInstanceArn)
+		if arn == "" {
+			logf(withTaskLog(ctx, aws.StringValue(task.TaskArn)), "Task %s has no container instance to protect from scale-in", path.Base(aws.StringValue(task.TaskArn)))
+			continue
+		}
+		if !seen[arn] {
+			seen[arn] = true
+			arns = append(arns, arn)
+		}
+	}
+	if len(arns) == 0 {
+		return nil
+	}
+
+	var ec2IDs []string
+	containerInstances := map[string]string{}
+	for _, chunk := range chunkStrings(aws.StringSlice(arns), maxDescribeContainerInstances) {
+		resp, err := svc.DescribeContainerInstances(&ecs.DescribeContainerInstancesInput{
+			Cluster:            aws.String(r.Cluster),
+			ContainerInstances: chunk,
+		})
+		if err != nil {
+			fmt.Fprintf(r.Stderr, "Failed to protect container instances from scale-in: %v\n", wrapAPIError("DescribeContainerInstances", err))
+			return nil
+		}
+		for _, ci := range resp.ContainerInstances {
+			ec2IDs = append(ec2IDs, aws.StringValue(ci.Ec2InstanceId))
+			containerInstances[aws.StringValue(ci.Ec2InstanceId)] = aws.StringValue(ci.ContainerInstanceArn)
+		}
+	}
+
+	p := &scaleInProtection{
+		svc:                autoscaling.New(sess),
+		ecs:                svc,
+		cluster:            r.Cluster,
+		instances:          map[string][]string{},
+		containerInstances: containerInstances,
+	}
+	for _, chunk := range chunkStrings(aws.StringSlice(ec2IDs), maxAutoScalingInstances) {
+		resp, err := p.svc.DescribeAutoScalingInstancesWithContext(ctx, &autoscaling.DescribeAutoScalingInstancesInput{
+			InstanceIds: chunk,
+		})
+		if err != nil {
+			fmt.Fprintf(r.Stderr, "Failed to protect container instances from scale-in: %v\n", wrapAPIError("DescribeAutoScalingInstances", err))
+			return nil
+		}
+		for _, instance := range resp.AutoScalingInstances {
+			if aws.BoolValue(instance.ProtectedFromScaleIn) {
+				logf(ctx, "Instance %s is already protected from scale-in", aws.StringValue(instance.InstanceId))
+				continue
+			}
+			group := aws.StringValue(instance.AutoScalingGroupName)
+			p.instances[group] = append(p.instances[group], aws.StringValue(instance.InstanceId))
+		}
+	}
+
+	for _, group := range p.groups() {
+		if err := p.set(ctx, group, true); err != nil {
+			fmt.Fprintf(r.Stderr, "Failed to protect instances in %s from scale-in: %v\n", group, err)
+			delete(p.instances, group)
+			continue
+		}
+		fmt.Fprintf(r.Stderr, "Protected %d instances in %s from scale-in until the tasks stop\n", len(p.instances[group]), group)
+	}
+	return p
+}
+
+// leaveTasksRunning keeps the protection when the run finishes, as its tasks
+// are left running
+func (p *scaleInProtection) leaveTasksRunning() {
+	if p != nil {
+		p.tasksLeftRunning = true
+	}
+}
+
+// releaseScaleInProtection removes the protection that was added for the
+// run. It's done even if the run was cancelled, so that instances aren't left
+// protected forever. Instances that still have tasks on them, such as from
+// another run or this one's tasks that were left running, keep their
+// protection, as it may be that run's or ECS managed termination protection's.
+func (r *Runner) releaseScaleInProtection(ctx context.Context, p *scaleInProtection) {
+	if p == nil {
+		return
+	}
+	ctx = withLogScope(context.Background(), logScopeFrom(ctx))
+	if p.tasksLeftRunning {
+		for _, group := range p.groups() {
+			fmt.Fprintf(r.Stderr, "Leaving %d instances in %s protected from scale-in while the tasks run, remove it once they stop\n", len(p.instances[group]), group)
+		}
+		return
+	}
+
+	busy, err := p.busyInstances(ctx)
+	if err != nil {
+		fmt.Fprintf(r.Stderr, "Failed to check for other tasks on instances protected from scale-in, remove it by hand: %v\n", err)
+		return
+	}
+	for _, group := range p.groups() {
+		var idle []string
+		for _, id := range p.instances[group] {
+			if busy[id] {
+				logf(ctx, "Keeping scale-in protection of instance %s, which has other tasks", id)
+				continue
+			}
+			idle = append(idle, id)
+		}
+		p.instances[group] = idle
+		if len(idle) == 0 {
+			continue
+		}
+		if err := p.set(ctx, group, false); err != nil {
+			fmt.Fprintf(r.Stderr, "Failed to remove scale-in protection from instances in %s, remove it by hand: %v\n", group, err)
+			continue
+		}
+		logf(ctx, "Removed scale-in protection from %d instances in %s", len(idle), group)
+	}
+}
+
+// busyInstances returns the protected EC2 instances that still have tasks
+// running or pending on them
+func (p *scaleInProtection) busyInstances(ctx context.Context) (map[string]bool, error) {
+	var arns []string
+	for _, group := range p.groups() {
+		for _, id := range p.instances[group] {
+			arns = append(arns, p.containerInstances[id])
+		}
+	}
+
+	busy := map[string]bool{}
+	for _, chunk := range chunkStrings(aws.StringSlice(arns), maxDescribeContainerInstances) {
+		resp, err := p.ecs.DescribeContainerInstancesWithContext(ctx, &ecs.DescribeContainerInstancesInput{
+			Cluster:            aws.String(p.cluster),
+			ContainerInstances: chunk,
+		})
+		if err != nil {
+			return nil, wrapAPIError("DescribeContainerInstances", err)
+		}
+		for _, ci := range resp.ContainerInstances {
+			if aws.Int64Value(ci.RunningTasksCount)+aws.Int64Value(ci.PendingTasksCount) > 0 {
+				busy[aws.StringValue(ci.Ec2InstanceId)] = true
+			}
+		}
+	}
+	return busy, nil
+}
+
+func (p *scaleInProtection) groups() []string {
+	var groups []string
+	for group := range p.instances {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+func (p *scaleInProtection) set(ctx context.Context, group string, protected bool) error {
+	for _, chunk := range chunkStrings(aws.StringSlice(p.instances[group]), maxAutoScalingInstances) {
+		_, err := p.svc.SetInstanceProtectionWithContext(ctx, &autoscaling.SetInstanceProtectionInput{
+			AutoScalingGroupName: aws.String(group),
+			InstanceIds:          chunk,
+			ProtectedFromScaleIn: aws.Bool(protected),
+		})
+		if err != nil {
+			return wrapAPIError("SetInstanceProtection", err)
+		}
+	}
+	return nil
+}
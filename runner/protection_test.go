@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestScaleInProtection(t *testing.T) {
+	var calls []string
+	busy := map[string]bool{}
+	sess := fakeSession(func(req *request.Request) {
+		switch in := req.Params.(type) {
+		case *ecs.DescribeContainerInstancesInput:
+			out := req.Data.(*ecs.DescribeContainerInstancesOutput)
+			for _, arn := range in.ContainerInstances {
+				out.ContainerInstances = append(out.ContainerInstances, &ecs.ContainerInstance{
+					ContainerInstanceArn: arn,
+					Ec2InstanceId:        aws.String("i-" + aws.StringValue(arn)),
+					RunningTasksCount:    aws.Int64(map[bool]int64{true: 1}[busy[aws.StringValue(arn)]]),
+				})
+			}
+		case *autoscaling.DescribeAutoScalingInstancesInput:
+			out := req.Data.(*autoscaling.DescribeAutoScalingInstancesOutput)
+			for _, id := range in.InstanceIds {
+				out.AutoScalingInstances = append(out.AutoScalingInstances, &autoscaling.InstanceDetails{
+					InstanceId:           id,
+					AutoScalingGroupName: aws.String("workers"),
+					ProtectedFromScaleIn: aws.Bool(aws.StringValue(id) == "i-protected"),
+				})
+			}
+		case *autoscaling.SetInstanceProtectionInput:
+			calls = append(calls, aws.StringValue(in.AutoScalingGroupName)+" "+
+				aws.StringValue(in.InstanceIds[0])+" "+map[bool]string{true: "on", false: "off"}[aws.BoolValue(in.ProtectedFromScaleIn)])
+		}
+	})
+
+	var stderr bytes.Buffer
+	r := &Runner{Cluster: "default", ScaleInProtection: true, Stderr: &stderr}
+	tasks := []*ecs.Task{
+		{TaskArn: aws.String("task/1"), ContainerInstanceArn: aws.String("a")},
+		{TaskArn: aws.String("task/2"), ContainerInstanceArn: aws.String("a")},
+		{TaskArn: aws.String("task/3"), ContainerInstanceArn: aws.String("protected")},
+		{TaskArn: aws.String("task/4")},
+		{TaskArn: aws.String("task/5"), ContainerInstanceArn: aws.String("b")},
+	}
+	p := r.protectFromScaleIn(context.Background(), sess, ecs.New(sess), tasks)
+	if !reflect.DeepEqual(p.instances, map[string][]string{"workers": {"i-a", "i-b"}}) {
+		t.Fatalf("Expected only the unprotected instances to be protected, got %v", p.instances)
+	}
+
+	// another run's task is still on b, so it keeps its protection
+	busy["b"] = true
+	r.releaseScaleInProtection(context.Background(), p)
+
+	if expected := []string{"workers i-a on", "workers i-a off"}; !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, calls)
+	}
+	if stderr.String() != "Protected 2 instances in workers from scale-in until the tasks stop\n" {
+		t.Fatalf("Unexpected output %q", stderr.String())
+	}
+
+	// tasks that are left running keep their instances protected
+	calls = nil
+	stderr.Reset()
+	p = r.protectFromScaleIn(context.Background(), sess, ecs.New(sess), tasks[:1])
+	p.leaveTasksRunning()
+	r.releaseScaleInProtection(context.Background(), p)
+	if expected := []string{"workers i-a on"}; !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, calls)
+	}
+	if !strings.Contains(stderr.String(), "Leaving 1 instances in workers protected from scale-in") {
+		t.Fatalf("Expected the protection to be left, got %q", stderr.String())
+	}
+
+	r.ScaleInProtection = false
+	if p := r.protectFromScaleIn(context.Background(), sess, ecs.New(sess), tasks); p != nil {
+		t.Fatal("Expected no protection unless asked for")
+	}
+}
@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// proxyContainerName returns the name of the proxy container, such as an App
+// Mesh Envoy sidecar, if the task definition has a proxy configuration
+func proxyContainerName(input *ecs.RegisterTaskDefinitionInput) string {
+	if input.ProxyConfiguration == nil {
+		return ""
+	}
+	return aws.StringValue(input.ProxyConfiguration.ContainerName)
+}
+
+// validateProxyConfiguration checks a proxy configuration before registering,
+// as ECS otherwise only fails once the task is started
+func validateProxyConfiguration(input *ecs.RegisterTaskDefinitionInput) error {
+	if input.ProxyConfiguration == nil {
+		return nil
+	}
+
+	name := proxyContainerName(input)
+	if name == "" {
+		return fmt.Errorf("Proxy configuration has no container name")
+	}
+	if findContainerDefinition(input.ContainerDefinitions, name) == nil {
+		return fmt.Errorf("Proxy configuration uses container %q, which isn't in the task definition", name)
+	}
+	if mode := aws.StringValue(input.NetworkMode); mode != ecs.NetworkModeAwsvpc {
+		return fmt.Errorf("Proxy configuration needs the awsvpc network mode, not %q", mode)
+	}
+	return nil
+}
+
+// disableProxy removes the proxy configuration and its container, along with
+// any dependencies on it, for tasks that don't need mesh routing
+func disableProxy(input *ecs.RegisterTaskDefinitionInput) {
+	name := proxyContainerName(input)
+	if name == "" {
+		return
+	}
+	log.Printf("Removing proxy configuration and container %s", name)
+	input.ProxyConfiguration = nil
+
+	var defs []*ecs.ContainerDefinition
+	for _, def := range input.ContainerDefinitions {
+		if aws.StringValue(def.Name) == name {
+			continue
+		}
+
+		var dependsOn []*ecs.ContainerDependency
+		for _, dep := range def.DependsOn {
+			if aws.StringValue(dep.ContainerName) != name {
+				dependsOn = append(dependsOn, dep)
+			}
+		}
+		def.DependsOn = dependsOn
+		defs = append(defs, def)
+	}
+	input.ContainerDefinitions = defs
+}
@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func appMeshTaskDefinition() *ecs.RegisterTaskDefinitionInput {
+	return &ecs.RegisterTaskDefinitionInput{
+		NetworkMode: aws.String("awsvpc"),
+		ProxyConfiguration: &ecs.ProxyConfiguration{
+			Type:          aws.String("APPMESH"),
+			ContainerName: aws.String("envoy"),
+		},
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{
+				Name: aws.String("app"),
+				DependsOn: []*ecs.ContainerDependency{
+					{ContainerName: aws.String("envoy"), Condition: aws.String("HEALTHY")},
+					{ContainerName: aws.String("migrate"), Condition: aws.String("SUCCESS")},
+				},
+			},
+			{Name: aws.String("migrate")},
+			{Name: aws.String("envoy")},
+		},
+	}
+}
+
+func TestValidateProxyConfiguration(t *testing.T) {
+	input := appMeshTaskDefinition()
+	if err := validateProxyConfiguration(input); err != nil {
+		t.Fatal(err)
+	}
+
+	input.NetworkMode = aws.String("bridge")
+	if err := validateProxyConfiguration(input); err == nil {
+		t.Fatal("Expected an error without awsvpc")
+	}
+
+	input = appMeshTaskDefinition()
+	input.ProxyConfiguration.ContainerName = aws.String("sidecar")
+	if err := validateProxyConfiguration(input); err == nil {
+		t.Fatal("Expected an error for a missing proxy container")
+	}
+}
+
+func TestDisableProxy(t *testing.T) {
+	input := appMeshTaskDefinition()
+	disableProxy(input)
+
+	if input.ProxyConfiguration != nil {
+		t.Fatal("Expected the proxy configuration to be removed")
+	}
+	if len(input.ContainerDefinitions) != 2 || findContainerDefinition(input.ContainerDefinitions, "envoy") != nil {
+		t.Fatalf("Expected the envoy container to be removed, got %v", input.ContainerDefinitions)
+	}
+
+	deps := input.ContainerDefinitions[0].DependsOn
+	if len(deps) != 1 || *deps[0].ContainerName != "migrate" {
+		t.Fatalf("Expected only the dependency on migrate to remain, got %v", deps)
+	}
+}
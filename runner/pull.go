@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// imagePullDuration returns how long a task spent pulling its container images,
+// or false if ECS didn't report when the pull started and stopped
+func imagePullDuration(task *ecs.Task) (time.Duration, bool) {
+	if task.PullStartedAt == nil || task.PullStoppedAt == nil {
+		return 0, false
+	}
+	return task.PullStoppedAt.Sub(*task.PullStartedAt), true
+}
+
+// reportImagePull prints how long a task took to pull images and warns if it
+// took longer than the configured threshold
+func (r *Runner) reportImagePull(task *ecs.Task) {
+	taskID := path.Base(*task.TaskArn)
+
+	d, ok := imagePullDuration(task)
+	if !ok {
+		r.logf("No image pull timing reported for task %s", taskID)
+		return
+	}
+
+	fmt.Fprintf(r.Stderr, "Task %s pulled images in %v (%s to %s)\n",
+		taskID,
+		d.Round(time.Millisecond),
+		task.PullStartedAt.Format(time.RFC3339),
+		task.PullStoppedAt.Format(time.RFC3339),
+	)
+
+	if r.PullWarningThreshold > 0 && d > r.PullWarningThreshold {
+		fmt.Fprintf(r.Stderr, "WARNING: Image pull for task %s took longer than %v\n",
+			taskID, r.PullWarningThreshold)
+		if r.Fargate {
+			fmt.Fprintln(r.Stderr, "Large images start faster on Fargate when they have a SOCI (seekable OCI) index, which lets the image be lazily loaded")
+		}
+	}
+}
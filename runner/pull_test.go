@@ -0,0 +1,30 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestImagePullDuration(t *testing.T) {
+	started := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	d, ok := imagePullDuration(&ecs.Task{
+		PullStartedAt: aws.Time(started),
+		PullStoppedAt: aws.Time(started.Add(90 * time.Second)),
+	})
+	if !ok {
+		t.Fatal("Expected a pull duration")
+	}
+	if d != 90*time.Second {
+		t.Fatalf("Expected 1m30s, got %v", d)
+	}
+}
+
+func TestImagePullDurationMissingTimestamps(t *testing.T) {
+	if _, ok := imagePullDuration(&ecs.Task{PullStartedAt: aws.Time(time.Now())}); ok {
+		t.Fatal("Expected no pull duration without a stop time")
+	}
+}
@@ -0,0 +1,250 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// maxTaskDefinitionRevisions is how many revisions ECS allows in a family.
+// Revisions are numbered in order and never reused, so deregistering or
+// deleting old ones doesn't make room for more.
+const maxTaskDefinitionRevisions = 1000000
+
+// revisionLimitMargin is how close to the limit a family's revisions have to
+// get before each registration warns about it
+const revisionLimitMargin = 10000
+
+// contentHashTag is a tag of the hash of what a revision was registered with,
+// so that a run that would register the same content can reuse it instead
+const contentHashTag = runTagPrefix + "content-hash"
+
+var revisionLimitPattern = regexp.MustCompile(`(?i)too many revisions|revision.*limit|limit.*revision`)
+
+// printRegistrationWarnings surfaces what ECS said about a task definition
+// that was just registered beyond its name: that it isn't active, that it
+// dropped parameters it no longer supports, or that its family is running out
+// of revisions
+func printRegistrationWarnings(w io.Writer, input *ecs.RegisterTaskDefinitionInput, registered *ecs.TaskDefinition) {
+	name := fmt.Sprintf("%s:%d", aws.StringValue(registered.Family), aws.Int64Value(registered.Revision))
+
+	if status := aws.StringValue(registered.Status); status != "" && status != ecs.TaskDefinitionStatusActive {
+		fmt.Fprintf(w, "Task definition %s was registered as %s rather than ACTIVE, so running it may fail\n", name, status)
+	}
+
+	for _, param := range ignoredParameters(input, registered) {
+		fmt.Fprintf(w, "Task definition %s was registered without %s, which ECS ignored and may no longer support\n", name, param)
+	}
+
+	if left := maxTaskDefinitionRevisions - aws.Int64Value(registered.Revision); left < revisionLimitMargin {
+		fmt.Fprintf(w, "Task definition family %s has %d revisions left of ECS's limit of %d, after which registering fails. Use a new family before then.\n",
+			aws.StringValue(registered.Family), left, maxTaskDefinitionRevisions)
+	}
+}
+
+// ignoredParameters returns the parameters that were set when registering a
+// task definition but are missing from what ECS registered, such as
+// "cpu" or "containerDefinitions[app].links"
+func ignoredParameters(input *ecs.RegisterTaskDefinitionInput, registered *ecs.TaskDefinition) []string {
+	sent, err := parameterMap(input)
+	if err != nil {
+		return nil
+	}
+	got, err := parameterMap(registered)
+	if err != nil {
+		return nil
+	}
+	// tags are returned alongside the task definition rather than in it
+	delete(sent, "Tags")
+
+	ignored := missingParameters(sent, got, "")
+
+	registeredContainers := map[string]*ecs.ContainerDefinition{}
+	for _, def := range registered.ContainerDefinitions {
+		registeredContainers[aws.StringValue(def.Name)] = def
+	}
+	for _, def := range input.ContainerDefinitions {
+		got, ok := registeredContainers[aws.StringValue(def.Name)]
+		if !ok {
+			continue
+		}
+		sentParams, err := parameterMap(def)
+		if err != nil {
+			continue
+		}
+		gotParams, err := parameterMap(got)
+		if err != nil {
+			continue
+		}
+		prefix := fmt.Sprintf("containerDefinitions[%s].", aws.StringValue(def.Name))
+		ignored = append(ignored, missingParameters(sentParams, gotParams, prefix)...)
+	}
+	return ignored
+}
+
+// missingParameters returns the keys of sent with values that aren't empty,
+// which aren't in got, named as they are in task definition files
+func missingParameters(sent, got map[string]interface{}, prefix string) []string {
+	var missing []string
+	for key, value := range sent {
+		if key == "ContainerDefinitions" || isEmptyParameter(value) {
+			continue
+		}
+		if _, ok := got[key]; !ok {
+			missing = append(missing, prefix+strings.ToLower(key[:1])+key[1:])
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func parameterMap(v interface{}) (map[string]interface{}, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	for key, value := range m {
+		if value == nil {
+			delete(m, key)
+		}
+	}
+	return m, nil
+}
+
+// isEmptyParameter returns whether a parameter's value is one that ECS leaves
+// out of the task definitions it returns, such as false or an empty list
+func isEmptyParameter(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// revisionLimitError explains a failure to register a task definition because
+// its family has run out of revisions, returning other errors as they are
+func revisionLimitError(family string, err error) error {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || !revisionLimitPattern.MatchString(aerr.Message()) {
+		return err
+	}
+	return fmt.Errorf("Task definition family %s has reached ECS's limit of %d revisions, and revision numbers aren't reused, so register it under a new family: %w",
+		family, maxTaskDefinitionRevisions, err)
+}
+
+// checkTaskDefinitionStatus returns an error for a task definition that ECS
+// won't start new tasks from, as it's been deregistered
+func checkTaskDefinitionStatus(td *ecs.TaskDefinition) error {
+	switch status := aws.StringValue(td.Status); status {
+	case ecs.TaskDefinitionStatusInactive, ecs.TaskDefinitionStatusDeleteInProgress:
+		return fmt.Errorf("Task definition %s is %s, as it's been deregistered, so ECS won't start new tasks from it, but --from-family with --revision and --allow-inactive can run a copy of it",
+			aws.StringValue(td.TaskDefinitionArn), status)
+	}
+	return nil
+}
+
+// unchangedRevision returns the latest active revision of a family, if it was
+// registered with the same content hash. Revisions that weren't registered by
+// ecs-run-task have no hash, so aren't reused.
+func (r *Runner) unchangedRevision(svc *ecs.ECS, family, hash string) (*ecs.TaskDefinition, bool) {
+	resp, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(family),
+		Include:        aws.StringSlice([]string{ecs.TaskDefinitionFieldTags}),
+	})
+	if err != nil {
+		r.logf("Not reusing a revision of %s, as its latest couldn't be described: %v", family, wrapAPIError("DescribeTaskDefinition", err))
+		return nil, false
+	}
+	if aws.StringValue(resp.TaskDefinition.Status) != ecs.TaskDefinitionStatusActive {
+		return nil, false
+	}
+	for _, tag := range resp.Tags {
+		if aws.StringValue(tag.Key) == contentHashTag && aws.StringValue(tag.Value) == hash {
+			return resp.TaskDefinition, true
+		}
+	}
+	return nil, false
+}
+
+// contentHash hashes a task definition to tell whether it's unchanged from a
+// revision that's already registered. The default stream prefix has the run's
+// ID in it, so it's left out, and a reused revision keeps the prefix of the
+// run that registered it.
+func (r *Runner) contentHash(input *ecs.RegisterTaskDefinitionInput, logs map[string]logConfig) (string, error) {
+	if r.TaskName != "" {
+		return definitionDigest(input)
+	}
+	normalised := *input
+	normalised.ContainerDefinitions = nil
+	for _, def := range input.ContainerDefinitions {
+		if _, ok := logs[aws.StringValue(def.Name)]; ok {
+			copied := *def
+			lc := *def.LogConfiguration
+			lc.Options = map[string]*string{}
+			for k, v := range def.LogConfiguration.Options {
+				lc.Options[k] = v
+			}
+			lc.Options["awslogs-stream-prefix"] = aws.String(defaultStreamPrefix(""))
+			copied.LogConfiguration = &lc
+			def = &copied
+		}
+		normalised.ContainerDefinitions = append(normalised.ContainerDefinitions, def)
+	}
+	return definitionDigest(&normalised)
+}
+
+// useStreamPrefixes sets the stream prefixes that logs are followed under to
+// those of a registered revision, such as one that's reused
+func (td *preparedTaskDefinition) useStreamPrefixes(registered *ecs.TaskDefinition) {
+	for _, def := range registered.ContainerDefinitions {
+		lc, ok := td.Logs[aws.StringValue(def.Name)]
+		if !ok || def.LogConfiguration == nil {
+			continue
+		}
+		if prefix := aws.StringValue(def.LogConfiguration.Options["awslogs-stream-prefix"]); prefix != "" {
+			lc.StreamPrefix = prefix
+			td.Logs[aws.StringValue(def.Name)] = lc
+		}
+	}
+}
+
+// registerWithContentHash registers a task definition tagged with its content
+// hash. Tagging needs ecs:TagResource, so without it the task definition is
+// registered untagged, and won't be reused.
+func (r *Runner) registerWithContentHash(svc *ecs.ECS, input *ecs.RegisterTaskDefinitionInput, hash string) (*ecs.RegisterTaskDefinitionOutput, error) {
+	tagged := *input
+	tagged.Tags = append(append([]*ecs.Tag{}, input.Tags...), &ecs.Tag{
+		Key:   aws.String(contentHashTag),
+		Value: aws.String(hash),
+	})
+	resp, err := svc.RegisterTaskDefinition(&tagged)
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == "AccessDeniedException" {
+		r.logf("Not allowed to tag task definition %s with its content hash, registering it untagged: %v", aws.StringValue(input.Family), err)
+		resp, err = svc.RegisterTaskDefinition(input)
+	}
+	return resp, err
+}
@@ -0,0 +1,226 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestIgnoredParameters(t *testing.T) {
+	input := &ecs.RegisterTaskDefinitionInput{
+		Family:                aws.String("app"),
+		Cpu:                   aws.String("256"),
+		InferenceAccelerators: []*ecs.InferenceAccelerator{{DeviceName: aws.String("device_1"), DeviceType: aws.String("eia2.medium")}},
+		Tags:                  []*ecs.Tag{{Key: aws.String("team"), Value: aws.String("platform")}},
+		ContainerDefinitions: []*ecs.ContainerDefinition{{
+			Name:        aws.String("app"),
+			Image:       aws.String("app:latest"),
+			Links:       aws.StringSlice([]string{"db"}),
+			Environment: []*ecs.KeyValuePair{},
+			Privileged:  aws.Bool(false),
+		}},
+	}
+	registered := &ecs.TaskDefinition{
+		Family:               aws.String("app"),
+		Revision:             aws.Int64(3),
+		Status:               aws.String(ecs.TaskDefinitionStatusActive),
+		Cpu:                  aws.String("256"),
+		ContainerDefinitions: []*ecs.ContainerDefinition{{Name: aws.String("app"), Image: aws.String("app:latest")}},
+	}
+
+	expected := []string{"inferenceAccelerators", "containerDefinitions[app].links"}
+	if got := ignoredParameters(input, registered); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v to be ignored, got %v", expected, got)
+	}
+
+	var w bytes.Buffer
+	printRegistrationWarnings(&w, input, registered)
+	if !strings.Contains(w.String(), "app:3 was registered without containerDefinitions[app].links") {
+		t.Errorf("Expected a warning about the ignored links, got %q", w.String())
+	}
+}
+
+func TestRegistrationWarningsRevisionLimit(t *testing.T) {
+	input := &ecs.RegisterTaskDefinitionInput{Family: aws.String("app")}
+	for _, tc := range []struct {
+		Revision int64
+		Expected string
+	}{
+		{Revision: 42},
+		{Revision: 995000, Expected: "app has 5000 revisions left"},
+	} {
+		var w bytes.Buffer
+		printRegistrationWarnings(&w, input, &ecs.TaskDefinition{
+			Family:   aws.String("app"),
+			Revision: aws.Int64(tc.Revision),
+			Status:   aws.String(ecs.TaskDefinitionStatusActive),
+		})
+		if tc.Expected == "" && w.Len() > 0 {
+			t.Errorf("Expected no warnings for revision %d, got %q", tc.Revision, w.String())
+		} else if !strings.Contains(w.String(), tc.Expected) {
+			t.Errorf("Expected %q for revision %d, got %q", tc.Expected, tc.Revision, w.String())
+		}
+	}
+}
+
+func TestRevisionLimitError(t *testing.T) {
+	limit := wrapAPIError("RegisterTaskDefinition", awserr.New(ecs.ErrCodeClientException, "Too many revisions for family app", nil))
+	err := revisionLimitError("app", limit)
+	if !strings.Contains(err.Error(), "register it under a new family") || !errors.Is(err, limit) {
+		t.Errorf("Expected the revision limit to be explained, got %v", err)
+	}
+
+	other := wrapAPIError("RegisterTaskDefinition", awserr.New(ecs.ErrCodeClientException, "Invalid container name", nil))
+	if err := revisionLimitError("app", other); err != other {
+		t.Errorf("Expected other errors to be returned as they are, got %v", err)
+	}
+}
+
+func TestCheckTaskDefinitionStatus(t *testing.T) {
+	td := &ecs.TaskDefinition{
+		TaskDefinitionArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/app:3"),
+		Status:            aws.String(ecs.TaskDefinitionStatusActive),
+	}
+	if err := checkTaskDefinitionStatus(td); err != nil {
+		t.Errorf("Expected an active task definition to be run, got %v", err)
+	}
+	td.Status = aws.String(ecs.TaskDefinitionStatusInactive)
+	if err := checkTaskDefinitionStatus(td); err == nil || !strings.Contains(err.Error(), "deregistered") {
+		t.Errorf("Expected an inactive task definition to be an error, got %v", err)
+	}
+}
+
+func TestUnchangedRevision(t *testing.T) {
+	var described *ecs.DescribeTaskDefinitionInput
+	sess := fakeSession(func(req *request.Request) {
+		described = req.Params.(*ecs.DescribeTaskDefinitionInput)
+		out := req.Data.(*ecs.DescribeTaskDefinitionOutput)
+		out.TaskDefinition = &ecs.TaskDefinition{
+			Family:   aws.String("app"),
+			Revision: aws.Int64(7),
+			Status:   aws.String(ecs.TaskDefinitionStatusActive),
+		}
+		out.Tags = []*ecs.Tag{
+			{Key: aws.String("team"), Value: aws.String("platform")},
+			{Key: aws.String(contentHashTag), Value: aws.String("sha256:abc")},
+		}
+	})
+
+	r := &Runner{}
+	def, ok := r.unchangedRevision(ecs.New(sess), "app", "sha256:abc")
+	if !ok || aws.Int64Value(def.Revision) != 7 {
+		t.Errorf("Expected app:7 to be reused, got %v", def)
+	}
+	if aws.StringValue(described.TaskDefinition) != "app" || len(described.Include) != 1 {
+		t.Errorf("Expected the family's latest revision to be described with its tags, got %v", described)
+	}
+
+	if def, ok := r.unchangedRevision(ecs.New(sess), "app", "sha256:def"); ok {
+		t.Errorf("Expected a changed task definition not to reuse a revision, got %v", def)
+	}
+}
+
+func TestUnchangedRevisionNewFamily(t *testing.T) {
+	sess := fakeSession(func(req *request.Request) {
+		req.Error = awserr.New(ecs.ErrCodeClientException, "Unable to describe task definition.", nil)
+	})
+
+	if def, ok := (&Runner{}).unchangedRevision(ecs.New(sess), "new", "sha256:abc"); ok {
+		t.Errorf("Expected a family without revisions to register one, got %v", def)
+	}
+}
+
+func TestContentHashDefaultStreamPrefix(t *testing.T) {
+	input := func(prefix string) *ecs.RegisterTaskDefinitionInput {
+		return &ecs.RegisterTaskDefinitionInput{
+			Family: aws.String("app"),
+			ContainerDefinitions: []*ecs.ContainerDefinition{{
+				Name: aws.String("app"),
+				LogConfiguration: &ecs.LogConfiguration{
+					LogDriver: aws.String("awslogs"),
+					Options: map[string]*string{
+						"awslogs-group":         aws.String("ecs-task-runner"),
+						"awslogs-stream-prefix": aws.String(prefix),
+					},
+				},
+			}},
+		}
+	}
+	logs := map[string]logConfig{"app": {Group: "ecs-task-runner"}}
+
+	r := &Runner{}
+	first, err := r.contentHash(input(defaultStreamPrefix("01J0ZKQ5V2N3B8XG4W6R7T9YAC")), logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered := input(defaultStreamPrefix("01J0ZKQ8H3M4C9YH5X7S8U0ZBD"))
+	second, err := r.contentHash(registered, logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("Expected runs with the default stream prefix to hash the same")
+	}
+	if prefix := aws.StringValue(registered.ContainerDefinitions[0].LogConfiguration.Options["awslogs-stream-prefix"]); prefix != "run_task_01J0ZKQ8H3M4C9YH5X7S8U0ZBD" {
+		t.Errorf("Expected the input to be left as it is, got %s", prefix)
+	}
+
+	r.TaskName = "migrate"
+	first, _ = r.contentHash(input("migrate"), logs)
+	second, _ = r.contentHash(input("backfill"), logs)
+	if first == second {
+		t.Errorf("Expected a different --name to change the hash")
+	}
+
+	// a reused revision's logs are followed under its own prefix
+	td := &preparedTaskDefinition{Logs: map[string]logConfig{"app": {Group: "ecs-task-runner", StreamPrefix: "run_task_new"}}}
+	td.useStreamPrefixes(&ecs.TaskDefinition{ContainerDefinitions: input("run_task_old").ContainerDefinitions})
+	if prefix := td.Logs["app"].StreamPrefix; prefix != "run_task_old" {
+		t.Errorf("Expected the reused revision's stream prefix, got %s", prefix)
+	}
+}
+
+func TestRegisterWithContentHash(t *testing.T) {
+	var registered [][]*ecs.Tag
+	denyTags := true
+	sess := fakeSession(func(req *request.Request) {
+		input := req.Params.(*ecs.RegisterTaskDefinitionInput)
+		registered = append(registered, input.Tags)
+		if denyTags && len(input.Tags) > 1 {
+			req.Error = awserr.New("AccessDeniedException", "not authorized to perform: ecs:TagResource", nil)
+			return
+		}
+		req.Data.(*ecs.RegisterTaskDefinitionOutput).TaskDefinition = &ecs.TaskDefinition{Family: input.Family, Revision: aws.Int64(1)}
+	})
+
+	input := &ecs.RegisterTaskDefinitionInput{
+		Family:               aws.String("app"),
+		ContainerDefinitions: []*ecs.ContainerDefinition{{Name: aws.String("app"), Image: aws.String("app:latest")}},
+		Tags:                 []*ecs.Tag{{Key: aws.String("team"), Value: aws.String("platform")}},
+	}
+	r := &Runner{}
+	if _, err := r.registerWithContentHash(ecs.New(sess), input, "sha256:abc"); err != nil {
+		t.Fatal(err)
+	}
+	if len(registered) != 2 || len(registered[0]) != 2 || aws.StringValue(registered[0][1].Value) != "sha256:abc" || len(registered[1]) != 1 {
+		t.Errorf("Expected a tagged registration, then an untagged one without permission to tag, got %v", registered)
+	}
+	if len(input.Tags) != 1 {
+		t.Errorf("Expected the input's tags to be left alone, got %v", input.Tags)
+	}
+
+	registered, denyTags = nil, false
+	if _, err := r.registerWithContentHash(ecs.New(sess), input, "sha256:abc"); err != nil {
+		t.Fatal(err)
+	}
+	if len(registered) != 1 {
+		t.Errorf("Expected a single registration when tagging is allowed, got %v", registered)
+	}
+}
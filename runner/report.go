@@ -0,0 +1,426 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/buildkite/ecs-run-task/report"
+)
+
+// runReport collects the outcome of a run for the summary file
+type runReport struct {
+	mu       sync.Mutex
+	run      report.Run
+	td       *preparedTaskDefinition
+	tasks    []*ecs.Task
+	failures []*ecs.Failure
+}
+
+func (r *Runner) newRunReport(region string) *runReport {
+	if !r.wantsSummary() {
+		return nil
+	}
+	return &runReport{run: report.Run{
+		RunID:     r.runID(),
+		Cluster:   r.Cluster,
+		Region:    region,
+		Attempt:   r.attempt(),
+		StartedAt: time.Now(),
+	}}
+}
+
+func (rr *runReport) setTaskDefinition(td *preparedTaskDefinition) {
+	if rr == nil {
+		return
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.td = td
+	rr.run.TaskDefinition = td.Name
+}
+
+// setTasks records the latest state of the tasks that were started
+func (rr *runReport) setTasks(tasks []*ecs.Task) {
+	if rr == nil {
+		return
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.tasks = tasks
+}
+
+func (rr *runReport) setFailures(failures []*ecs.Failure) {
+	if rr == nil {
+		return
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.failures = failures
+}
+
+// setCloudTrailEvents records the CloudTrail events of the run's calls
+func (rr *runReport) setCloudTrailEvents(events []report.CloudTrailEvent) {
+	if rr == nil {
+		return
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.run.CloudTrailEvents = events
+}
+
+// finish returns the run as it ended with err
+func (rr *runReport) finish(err error) report.Run {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	run := rr.run
+	run.FinishedAt = time.Now()
+	run.ExitCode = ExitCode(err)
+	if err != nil {
+		run.Error = err.Error()
+	}
+	run.Tasks = reportTasks(rr.td, rr.tasks)
+	for _, f := range rr.failures {
+		run.Failures = append(run.Failures, report.Failure{
+			ARN:    aws.StringValue(f.Arn),
+			Reason: aws.StringValue(f.Reason),
+			Detail: aws.StringValue(f.Detail),
+		})
+	}
+	return run
+}
+
+// reportTasks converts tasks to how they're reported, along with where each
+// container's logs were streamed from
+func reportTasks(td *preparedTaskDefinition, tasks []*ecs.Task) []report.Task {
+	reported := []report.Task{}
+	for _, task := range tasks {
+		t := report.Task{
+			ARN:           aws.StringValue(task.TaskArn),
+			LastStatus:    aws.StringValue(task.LastStatus),
+			StopCode:      aws.StringValue(task.StopCode),
+			StoppedReason: aws.StringValue(task.StoppedReason),
+			CreatedAt:     task.CreatedAt,
+			StartedAt:     task.StartedAt,
+			StoppedAt:     task.StoppedAt,
+
+			LaunchType:       aws.StringValue(task.LaunchType),
+			CapacityProvider: aws.StringValue(task.CapacityProviderName),
+
+			ConsoleURL: taskConsoleURL(task),
+			Containers: []report.Container{},
+		}
+		for _, container := range task.Containers {
+			c := report.Container{
+				Name:       aws.StringValue(container.Name),
+				LastStatus: aws.StringValue(container.LastStatus),
+				ExitCode:   intValue(container.ExitCode),
+				Reason:     aws.StringValue(container.Reason),
+			}
+			if lc, ok := td.streamedLogs(c.Name); ok && container.ContainerArn != nil {
+				c.LogGroup = lc.Group
+				c.LogStream = logStreamName(lc.StreamPrefix, container, task)
+				c.LogConsoleURL = logStreamConsoleURL(lc.Region, c.LogGroup, c.LogStream)
+			}
+			t.Containers = append(t.Containers, c)
+		}
+		reported = append(reported, t)
+	}
+	return reported
+}
+
+// streamedLogs returns where a container's logs are streamed from, if they are
+func (td *preparedTaskDefinition) streamedLogs(container string) (logConfig, bool) {
+	if td == nil {
+		return logConfig{}, false
+	}
+	lc, ok := td.Logs[container]
+	return lc, ok
+}
+
+func intValue(i *int64) *int {
+	if i == nil {
+		return nil
+	}
+	v := int(*i)
+	return &v
+}
+
+// summaryRecorder collects runs from several runners, such as when running
+// against multiple targets
+type summaryRecorder struct {
+	mu   sync.Mutex
+	runs []report.Run
+}
+
+func (sr *summaryRecorder) add(run report.Run) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.runs = append(sr.runs, run)
+}
+
+// recordRun saves the summary of a finished run, or adds it to the
+// shared recorder to be written once every target has finished
+func (r *Runner) recordRun(rr *runReport, err error) {
+	if rr == nil {
+		return
+	}
+	run := rr.finish(err)
+	if r.summary != nil {
+		r.summary.add(run)
+		return
+	}
+	r.saveSummary([]report.Run{run}, err)
+}
+
+// wantsSummary returns whether runs need to be recorded for a summary, for
+// the summary file or the post-hook
+func (r *Runner) wantsSummary() bool {
+	return r.SummaryFile != "" || r.PostHook != ""
+}
+
+// saveSummary writes the summary of runs to the summary file, and passes it
+// to the post-hook
+func (r *Runner) saveSummary(runs []report.Run, err error) {
+	summary := report.Summary{
+		SchemaVersion: report.SchemaVersion,
+		RunID:         r.runID(),
+		ExitCode:      ExitCode(err),
+		Rerun:         r.RerunCommand,
+		Runs:          runs,
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	defer r.runPostHook(summary)
+
+	if r.SummaryFile == "" {
+		return
+	}
+	b, jsonErr := json.MarshalIndent(summary, "", "  ")
+	if jsonErr == nil {
+		jsonErr = ioutil.WriteFile(r.SummaryFile, append(b, '\n'), 0644)
+	}
+	if jsonErr != nil {
+		fmt.Fprintf(r.Stderr, "Failed to write summary to %s: %v\n", r.SummaryFile, jsonErr)
+	}
+}
+
+// EventHandler receives the events of a run as it progresses, the same as
+// those written to the events file, such as to show progress in a UI. Events
+// are handled one at a time, so a handler shouldn't block for long.
+type EventHandler interface {
+	HandleEvent(ev report.Event)
+}
+
+// EventHandlerFunc adapts a function to an EventHandler
+type EventHandlerFunc func(ev report.Event)
+
+func (f EventHandlerFunc) HandleEvent(ev report.Event) {
+	f(ev)
+}
+
+// eventLog writes events as NDJSON as a run progresses and passes them to a
+// handler, tracking the last status of each task and container so that only
+// changes are written
+type eventLog struct {
+	mu       sync.Mutex
+	f        *os.File
+	enc      *json.Encoder
+	handler  EventHandler
+	runID    string
+	stderr   io.Writer
+	statuses map[string]string
+}
+
+// openEventLog creates an event log that writes to file, if it isn't empty,
+// and passes events to handler, if it isn't nil, with events from the run
+func openEventLog(file string, handler EventHandler, runID string, stderr io.Writer) (*eventLog, error) {
+	el := &eventLog{handler: handler, runID: runID, stderr: stderr, statuses: map[string]string{}}
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return nil, err
+		}
+		el.f, el.enc = f, json.NewEncoder(f)
+	}
+	return el, nil
+}
+
+func (el *eventLog) emit(ev report.Event) {
+	if el == nil {
+		return
+	}
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	el.write(ev)
+}
+
+func (el *eventLog) write(ev report.Event) {
+	ev.SchemaVersion = report.SchemaVersion
+	ev.RunID = el.runID
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if el.handler != nil {
+		el.handler.HandleEvent(ev)
+	}
+	if el.enc == nil {
+		return
+	}
+	if err := el.enc.Encode(ev); err != nil {
+		// events are best effort, they shouldn't fail the run
+		fmt.Fprintf(el.stderr, "Failed to write event to %s: %v\n", el.f.Name(), err)
+	}
+}
+
+// started writes an event for each task that was started or failed to start
+func (el *eventLog) started(cluster string, resp *ecs.RunTaskOutput) {
+	if el == nil {
+		return
+	}
+	for _, f := range resp.Failures {
+		el.emit(report.Event{
+			Type:    report.EventTaskFailedToStart,
+			Cluster: cluster,
+			Task:    aws.StringValue(f.Arn),
+			Reason:  aws.StringValue(f.Reason),
+		})
+	}
+	for _, task := range resp.Tasks {
+		el.emit(report.Event{
+			Type:    report.EventTaskStarted,
+			Cluster: cluster,
+			Task:    aws.StringValue(task.TaskArn),
+			Status:  aws.StringValue(task.LastStatus),
+		})
+	}
+
+	// the initial statuses aren't changes
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	for _, task := range resp.Tasks {
+		el.statuses[aws.StringValue(task.TaskArn)] = aws.StringValue(task.LastStatus)
+		for _, container := range task.Containers {
+			el.statuses[aws.StringValue(task.TaskArn)+"/"+aws.StringValue(container.Name)] = aws.StringValue(container.LastStatus)
+		}
+	}
+}
+
+// changed writes an event for each task or container whose status changed
+// since it was last seen
+func (el *eventLog) changed(cluster string, tasks []*ecs.Task) {
+	if el == nil {
+		return
+	}
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	for _, task := range tasks {
+		arn := aws.StringValue(task.TaskArn)
+		for _, container := range task.Containers {
+			key := arn + "/" + aws.StringValue(container.Name)
+			el.pulled(cluster, task, container, key)
+
+			status := aws.StringValue(container.LastStatus)
+			if el.statuses[key] == status {
+				continue
+			}
+			el.statuses[key] = status
+			el.write(report.Event{
+				Type:      report.EventContainerStatus,
+				Cluster:   cluster,
+				Task:      arn,
+				Container: aws.StringValue(container.Name),
+				Status:    status,
+				ExitCode:  intValue(container.ExitCode),
+				Reason:    aws.StringValue(container.Reason),
+			})
+		}
+
+		status := aws.StringValue(task.LastStatus)
+		if el.statuses[arn] == status {
+			continue
+		}
+		el.statuses[arn] = status
+		el.write(report.Event{
+			Type:    report.EventTaskStatus,
+			Cluster: cluster,
+			Task:    arn,
+			Status:  status,
+			Reason:  aws.StringValue(task.StoppedReason),
+		})
+	}
+}
+
+// pulled writes when a container started and finished pulling its image.
+// ECS only reports when a task's pulls started and stopped, so every
+// container of the task gets the same times.
+func (el *eventLog) pulled(cluster string, task *ecs.Task, container *ecs.Container, key string) {
+	milestones := []struct {
+		status string
+		at     *time.Time
+	}{
+		{report.ContainerStatusPulling, task.PullStartedAt},
+		{report.ContainerStatusPulled, task.PullStoppedAt},
+	}
+	for _, m := range milestones {
+		if m.at == nil || el.statuses[key+" "+m.status] != "" {
+			continue
+		}
+		el.statuses[key+" "+m.status] = m.status
+		el.write(report.Event{
+			Time:      *m.at,
+			Type:      report.EventContainerStatus,
+			Cluster:   cluster,
+			Task:      aws.StringValue(task.TaskArn),
+			Container: aws.StringValue(container.Name),
+			Status:    m.status,
+		})
+	}
+}
+
+// finished writes the end of a run that ended with err
+func (el *eventLog) finished(cluster string, err error) {
+	if el == nil {
+		return
+	}
+	code := ExitCode(err)
+	ev := report.Event{
+		Type:     report.EventRunFinished,
+		Cluster:  cluster,
+		ExitCode: &code,
+	}
+	if err != nil {
+		ev.Reason = err.Error()
+	}
+	el.emit(ev)
+}
+
+// cloudTrailEvents writes an event for each call's CloudTrail event
+func (el *eventLog) cloudTrailEvents(cluster string, events []report.CloudTrailEvent) {
+	if el == nil {
+		return
+	}
+	for i := range events {
+		el.emit(report.Event{
+			Type:            report.EventCloudTrailEvent,
+			Cluster:         cluster,
+			CloudTrailEvent: &events[i],
+		})
+	}
+}
+
+func (el *eventLog) Close() error {
+	if el == nil || el.f == nil {
+		return nil
+	}
+	return el.f.Close()
+}
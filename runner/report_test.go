@@ -0,0 +1,173 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/buildkite/ecs-run-task/report"
+)
+
+func TestReportTasksIncludesLogStreams(t *testing.T) {
+	td := &preparedTaskDefinition{
+		Name: "app:3",
+		Logs: map[string]logConfig{"app": {Group: "ecs-task-runner", StreamPrefix: "run_1"}},
+	}
+	tasks := []*ecs.Task{{
+		TaskArn:              aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/abc"),
+		LastStatus:           aws.String("STOPPED"),
+		CapacityProviderName: aws.String("spot"),
+		Containers: []*ecs.Container{
+			{Name: aws.String("app"), ContainerArn: aws.String("arn:aws:ecs:us-east-1:123456789012:container/abc/app"), ExitCode: aws.Int64(3)},
+			{Name: aws.String("sidecar"), ContainerArn: aws.String("arn:aws:ecs:us-east-1:123456789012:container/abc/sidecar")},
+		},
+	}}
+
+	reported := reportTasks(td, tasks)
+	if len(reported) != 1 || len(reported[0].Containers) != 2 {
+		t.Fatalf("Unexpected tasks %+v", reported)
+	}
+	app, sidecar := reported[0].Containers[0], reported[0].Containers[1]
+	if app.LogGroup != "ecs-task-runner" || app.LogStream != "run_1/app/abc" {
+		t.Errorf("Unexpected logs %s %s", app.LogGroup, app.LogStream)
+	}
+	if app.ExitCode == nil || *app.ExitCode != 3 {
+		t.Errorf("Unexpected exit code %v", app.ExitCode)
+	}
+	if app.LogConsoleURL == "" || reported[0].ConsoleURL == "" {
+		t.Errorf("Expected console links, got %q and %q", reported[0].ConsoleURL, app.LogConsoleURL)
+	}
+	if sidecar.LogStream != "" || sidecar.ExitCode != nil {
+		t.Errorf("Unexpected sidecar %+v", sidecar)
+	}
+	if reported[0].CapacityProvider != "spot" {
+		t.Errorf("Expected the capacity provider that placed the task, got %q", reported[0].CapacityProvider)
+	}
+}
+
+func TestEventLogWritesStatusChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "events.ndjson")
+	el, err := openEventLog(file, nil, "run-1", ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := func(status, containerStatus string) *ecs.Task {
+		return &ecs.Task{
+			TaskArn:    aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/abc"),
+			LastStatus: aws.String(status),
+			Containers: []*ecs.Container{{Name: aws.String("app"), LastStatus: aws.String(containerStatus)}},
+		}
+	}
+
+	el.started("default", &ecs.RunTaskOutput{Tasks: []*ecs.Task{task("PROVISIONING", "PENDING")}})
+	el.changed("default", []*ecs.Task{task("PROVISIONING", "PENDING")})
+	el.changed("default", []*ecs.Task{task("RUNNING", "RUNNING")})
+	el.finished("default", nil)
+	el.Close()
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var types []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev report.Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.SchemaVersion != report.SchemaVersion {
+			t.Errorf("Unexpected schema version %d", ev.SchemaVersion)
+		}
+		if ev.RunID != "run-1" {
+			t.Errorf("Unexpected run ID %q", ev.RunID)
+		}
+		types = append(types, ev.Type+":"+ev.Status)
+	}
+
+	expected := []string{
+		"task_started:PROVISIONING",
+		"container_status:RUNNING",
+		"task_status:RUNNING",
+		"run_finished:",
+	}
+	if len(types) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, types)
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Errorf("Expected events %v, got %v", expected, types)
+			break
+		}
+	}
+}
+
+func TestEventHandlerGetsContainerMilestones(t *testing.T) {
+	var events []report.Event
+	el, err := openEventLog("", EventHandlerFunc(func(ev report.Event) {
+		events = append(events, ev)
+	}), "", ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer el.Close()
+
+	pullStarted := time.Date(2024, 5, 1, 10, 0, 5, 0, time.UTC)
+	pullStopped := pullStarted.Add(20 * time.Second)
+	task := func(status, containerStatus string, pulls ...*time.Time) *ecs.Task {
+		t := &ecs.Task{
+			TaskArn:    aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/abc"),
+			LastStatus: aws.String(status),
+			Containers: []*ecs.Container{{Name: aws.String("app"), LastStatus: aws.String(containerStatus)}},
+		}
+		if len(pulls) > 0 {
+			t.PullStartedAt = pulls[0]
+		}
+		if len(pulls) > 1 {
+			t.PullStoppedAt = pulls[1]
+		}
+		return t
+	}
+
+	el.started("default", &ecs.RunTaskOutput{Tasks: []*ecs.Task{task("PROVISIONING", "PENDING")}})
+	el.changed("default", []*ecs.Task{task("PENDING", "PENDING", &pullStarted)})
+	el.changed("default", []*ecs.Task{task("RUNNING", "RUNNING", &pullStarted, &pullStopped)})
+	stopped := task("STOPPED", "STOPPED", &pullStarted, &pullStopped)
+	stopped.Containers[0].ExitCode = aws.Int64(137)
+	stopped.Containers[0].Reason = aws.String("OutOfMemoryError: Container killed due to memory usage")
+	el.changed("default", []*ecs.Task{stopped})
+
+	var containers []string
+	for _, ev := range events {
+		if ev.Type == report.EventContainerStatus {
+			containers = append(containers, ev.Status)
+		}
+	}
+	expected := []string{"PULLING", "PULLED", "RUNNING", "STOPPED"}
+	if strings.Join(containers, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected container statuses %v, got %v", expected, containers)
+	}
+	if !events[1].Time.Equal(pullStarted) {
+		t.Errorf("Expected the pull to start at %v, got %v", pullStarted, events[1].Time)
+	}
+	last := events[len(events)-2]
+	if last.Container != "app" || last.ExitCode == nil || *last.ExitCode != 137 || !strings.HasPrefix(last.Reason, "OutOfMemoryError") {
+		t.Errorf("Unexpected stopped event %+v", last)
+	}
+}
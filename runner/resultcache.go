@@ -0,0 +1,152 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// resultCache stores the output of successful runs in a directory, so that a
+// run with identical inputs can be skipped and its output replayed
+type resultCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// runResult is the output of a successful run, as cached
+type runResult struct {
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+	Output    []string  `json:"output"`
+}
+
+func (r *Runner) resultCache() *resultCache {
+	if r.ResultCacheDir == "" {
+		return nil
+	}
+	return &resultCache{dir: r.ResultCacheDir, ttl: r.ResultCacheTTL}
+}
+
+// key hashes what a run's result depends on: the task definition's contents,
+// where it runs and its overrides, which include the command and environment
+func (c *resultCache) key(region string, td *preparedTaskDefinition, input *ecs.RunTaskInput) (string, error) {
+	if c == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(struct {
+		Region         string
+		Cluster        string
+		TaskDefinition string
+		Count          int64
+		Overrides      *ecs.TaskOverride
+	}{region, aws.StringValue(input.Cluster), td.Digest, aws.Int64Value(input.Count), input.Overrides})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (c *resultCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// load returns the result of a successful run with the same key, if there is
+// one that hasn't expired
+func (c *resultCache) load(key string) (*runResult, bool) {
+	if c == nil {
+		return nil, false
+	}
+	b, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read cached result %s: %v", key, err)
+		}
+		return nil, false
+	}
+
+	var result runResult
+	if err := json.Unmarshal(b, &result); err != nil {
+		log.Printf("Ignoring cached result %s that can't be parsed: %v", key, err)
+		return nil, false
+	}
+	if c.ttl > 0 && time.Since(result.CreatedAt) > c.ttl {
+		log.Printf("Cached result %s from %v has expired", key, result.CreatedAt)
+		return nil, false
+	}
+	return &result, true
+}
+
+// save stores the output of a successful run
+func (c *resultCache) save(key string, output []string) error {
+	if c == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(runResult{Key: key, CreatedAt: time.Now(), Output: output})
+	if err != nil {
+		return err
+	}
+	log.Printf("Caching result %s", key)
+	return ioutil.WriteFile(c.path(key), b, 0644)
+}
+
+// replayCachedResult prints the output of a cached run instead of running it
+func (r *Runner) replayCachedResult(result *runResult) {
+	fmt.Fprintf(r.Stderr, "Replaying the output of an identical run from %s\n", result.CreatedAt.Format(time.RFC3339))
+	for _, line := range result.Output {
+		fmt.Fprintln(r.Stdout, line)
+	}
+}
+
+// capturedOutput collects the lines printed from a run's logs
+type capturedOutput struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// wrap returns a func that captures each line before printing it
+func (co *capturedOutput) wrap(printLine func(string)) func(string) {
+	if co == nil {
+		return printLine
+	}
+	return func(line string) {
+		co.mu.Lock()
+		co.lines = append(co.lines, line)
+		co.mu.Unlock()
+		printLine(line)
+	}
+}
+
+func (co *capturedOutput) output() []string {
+	if co == nil {
+		return nil
+	}
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	return co.lines
+}
+
+// definitionDigest hashes a task definition to be registered. It's taken
+// before logs are configured, so that the stream prefix generated for each
+// run doesn't change it.
+func definitionDigest(input *ecs.RegisterTaskDefinitionInput) (string, error) {
+	b, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func runTaskInputWithCommand(command ...string) *ecs.RunTaskInput {
+	return &ecs.RunTaskInput{
+		Cluster: aws.String("default"),
+		Count:   aws.Int64(1),
+		Overrides: &ecs.TaskOverride{ContainerOverrides: []*ecs.ContainerOverride{{
+			Name:    aws.String("app"),
+			Command: aws.StringSlice(command),
+		}}},
+	}
+}
+
+func TestResultCacheKey(t *testing.T) {
+	c := &resultCache{}
+	td := &preparedTaskDefinition{Digest: "sha256:abc"}
+
+	key, err := c.key("us-east-1", td, runTaskInputWithCommand("./verify.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, _ := c.key("us-east-1", td, runTaskInputWithCommand("./verify.sh"))
+	if key != same {
+		t.Fatalf("Expected the same key for the same inputs, got %s and %s", key, same)
+	}
+
+	for name, other := range map[string]func() (string, error){
+		"command": func() (string, error) { return c.key("us-east-1", td, runTaskInputWithCommand("./other.sh")) },
+		"region":  func() (string, error) { return c.key("eu-west-1", td, runTaskInputWithCommand("./verify.sh")) },
+		"task definition": func() (string, error) {
+			return c.key("us-east-1", &preparedTaskDefinition{Digest: "sha256:def"}, runTaskInputWithCommand("./verify.sh"))
+		},
+	} {
+		if k, _ := other(); k == key {
+			t.Errorf("Expected a different key with a different %s", name)
+		}
+	}
+}
+
+func TestResultCacheSaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "result-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &resultCache{dir: filepath.Join(dir, "cache"), ttl: time.Hour}
+	if _, ok := c.load("abc"); ok {
+		t.Fatal("Expected nothing cached yet")
+	}
+
+	if err := c.save("abc", []string{"Verified 12 checks", "All good"}); err != nil {
+		t.Fatal(err)
+	}
+	result, ok := c.load("abc")
+	if !ok {
+		t.Fatal("Expected a cached result")
+	}
+	if !reflect.DeepEqual(result.Output, []string{"Verified 12 checks", "All good"}) {
+		t.Fatalf("Unexpected output %v", result.Output)
+	}
+
+	c.ttl = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, ok := c.load("abc"); ok {
+		t.Fatal("Expected the cached result to have expired")
+	}
+}
+
+func TestResultCacheDisabled(t *testing.T) {
+	var c *resultCache
+	if key, err := c.key("us-east-1", &preparedTaskDefinition{}, runTaskInputWithCommand("true")); key != "" || err != nil {
+		t.Fatalf("Expected no key, got %q and %v", key, err)
+	}
+	if _, ok := c.load(""); ok {
+		t.Fatal("Expected nothing to be loaded")
+	}
+	if err := c.save("", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCapturedOutput(t *testing.T) {
+	var printed []string
+	printLine := func(line string) { printed = append(printed, line) }
+
+	co := &capturedOutput{}
+	wrapped := co.wrap(printLine)
+	wrapped("first")
+	wrapped("second")
+
+	if expected := []string{"first", "second"}; !reflect.DeepEqual(printed, expected) || !reflect.DeepEqual(co.output(), expected) {
+		t.Fatalf("Expected %v printed and captured, got %v and %v", expected, printed, co.output())
+	}
+
+	var disabled *capturedOutput
+	disabled.wrap(printLine)("third")
+	if disabled.output() != nil || len(printed) != 3 {
+		t.Fatal("Expected lines to be printed but not captured when disabled")
+	}
+}
@@ -0,0 +1,154 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// maxDescribeServices is the most services that a single DescribeServices
+// call accepts
+const maxDescribeServices = 10
+
+// registeredClockSkew is how much earlier than a run's start revisions are
+// still treated as registered by concurrent runs, allowing for the local
+// clock being ahead of ECS's
+const registeredClockSkew = time.Minute
+
+// pruneRevisions deregisters the revisions of the family that a run
+// registered beyond the newest RevisionRetention, once the run has passed.
+// Revisions that a service in any cluster still uses are kept, as are those
+// newer than the one that was run, and those registered since the run started,
+// which a concurrent run may be about to start tasks with. Pruning is best
+// effort, so failures are only warned about.
+func (r *Runner) pruneRevisions(ctx context.Context, svc *ecs.ECS, td *preparedTaskDefinition, started time.Time) {
+	if r.RevisionRetention <= 0 {
+		return
+	}
+	if td.Family == "" {
+		logf(ctx, "Not pruning revisions of %s, which wasn't registered for the run", td.Name)
+		return
+	}
+
+	var revisions []string
+	err := svc.ListTaskDefinitionsPagesWithContext(ctx, &ecs.ListTaskDefinitionsInput{
+		FamilyPrefix: aws.String(td.Family),
+		Status:       aws.String(ecs.TaskDefinitionStatusActive),
+		Sort:         aws.String(ecs.SortOrderDesc),
+	}, func(page *ecs.ListTaskDefinitionsOutput, lastPage bool) bool {
+		for _, arn := range page.TaskDefinitionArns {
+			// the prefix also matches other families, like app-worker for app
+			if family, _ := splitRevision(aws.StringValue(arn)); family == td.Family {
+				revisions = append(revisions, aws.StringValue(arn))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		fmt.Fprintf(r.Stderr, "Failed to prune revisions of %s: %v\n", td.Family, wrapAPIError("ListTaskDefinitions", err))
+		return
+	}
+	if len(revisions) <= r.RevisionRetention {
+		return
+	}
+
+	inUse, err := serviceTaskDefinitions(ctx, svc)
+	if err != nil {
+		fmt.Fprintf(r.Stderr, "Failed to prune revisions of %s: %v\n", td.Family, err)
+		return
+	}
+
+	_, ran := splitRevision(td.Name)
+	var pruned []string
+	for _, arn := range revisions[r.RevisionRetention:] {
+		if _, revision := splitRevision(arn); revision >= ran {
+			continue
+		}
+		if inUse[arn] {
+			logf(ctx, "Keeping %s, which is used by a service", path.Base(arn))
+			continue
+		}
+		resp, err := svc.DescribeTaskDefinitionWithContext(ctx, &ecs.DescribeTaskDefinitionInput{
+			TaskDefinition: aws.String(arn),
+		})
+		if err != nil {
+			fmt.Fprintf(r.Stderr, "Failed to prune %s: %v\n", path.Base(arn), wrapAPIError("DescribeTaskDefinition", err))
+			continue
+		}
+		if registered := resp.TaskDefinition.RegisteredAt; registered != nil && registered.After(started.Add(-registeredClockSkew)) {
+			logf(ctx, "Keeping %s, which was registered since the run started", path.Base(arn))
+			continue
+		}
+		logf(ctx, "Deregistering %s", path.Base(arn))
+		if _, err := svc.DeregisterTaskDefinitionWithContext(ctx, &ecs.DeregisterTaskDefinitionInput{
+			TaskDefinition: aws.String(arn),
+		}); err != nil {
+			fmt.Fprintf(r.Stderr, "Failed to deregister %s: %v\n", path.Base(arn), wrapAPIError("DeregisterTaskDefinition", err))
+			continue
+		}
+		pruned = append(pruned, path.Base(arn))
+	}
+	if len(pruned) > 0 {
+		fmt.Fprintf(r.Stderr, "Deregistered %d old revisions of %s: %s\n", len(pruned), td.Family, strings.Join(pruned, ", "))
+	}
+}
+
+// splitRevision returns the family and revision of a task definition's ARN
+// or family:revision, or a revision of 0 if it doesn't have one
+func splitRevision(name string) (string, int) {
+	name = path.Base(name)
+	i := strings.LastIndex(name, ":")
+	if i == -1 {
+		return name, 0
+	}
+	revision, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return name, 0
+	}
+	return name[:i], revision
+}
+
+// serviceTaskDefinitions returns the ARNs of the task definitions that
+// services in every cluster use, including those still being deployed
+func serviceTaskDefinitions(ctx context.Context, svc *ecs.ECS) (map[string]bool, error) {
+	var clusters []*string
+	err := svc.ListClustersPagesWithContext(ctx, &ecs.ListClustersInput{}, func(page *ecs.ListClustersOutput, lastPage bool) bool {
+		clusters = append(clusters, page.ClusterArns...)
+		return true
+	})
+	if err != nil {
+		return nil, wrapAPIError("ListClusters", err)
+	}
+
+	inUse := map[string]bool{}
+	for _, cluster := range clusters {
+		var services []*string
+		err := svc.ListServicesPagesWithContext(ctx, &ecs.ListServicesInput{Cluster: cluster}, func(page *ecs.ListServicesOutput, lastPage bool) bool {
+			services = append(services, page.ServiceArns...)
+			return true
+		})
+		if err != nil {
+			return nil, wrapAPIError("ListServices", err)
+		}
+
+		for _, chunk := range chunkStrings(services, maxDescribeServices) {
+			resp, err := svc.DescribeServicesWithContext(ctx, &ecs.DescribeServicesInput{Cluster: cluster, Services: chunk})
+			if err != nil {
+				return nil, wrapAPIError("DescribeServices", err)
+			}
+			for _, service := range resp.Services {
+				inUse[aws.StringValue(service.TaskDefinition)] = true
+				for _, deployment := range service.Deployments {
+					inUse[aws.StringValue(deployment.TaskDefinition)] = true
+				}
+			}
+		}
+	}
+	return inUse, nil
+}
@@ -0,0 +1,81 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestPruneRevisions(t *testing.T) {
+	arn := func(name string) string {
+		return "arn:aws:ecs:us-east-1:123456789012:task-definition/" + name
+	}
+	started := time.Now()
+	var deregistered []string
+	sess := fakeSession(func(req *request.Request) {
+		switch in := req.Params.(type) {
+		case *ecs.ListTaskDefinitionsInput:
+			req.Data.(*ecs.ListTaskDefinitionsOutput).TaskDefinitionArns = aws.StringSlice([]string{
+				arn("app-worker:9"), arn("app:6"), arn("app:5"), arn("app:4"), arn("app:3"), arn("app:2"), arn("app:1"),
+			})
+		case *ecs.ListClustersInput:
+			req.Data.(*ecs.ListClustersOutput).ClusterArns = aws.StringSlice([]string{"default"})
+		case *ecs.ListServicesInput:
+			req.Data.(*ecs.ListServicesOutput).ServiceArns = aws.StringSlice([]string{"web"})
+		case *ecs.DescribeServicesInput:
+			req.Data.(*ecs.DescribeServicesOutput).Services = []*ecs.Service{{
+				TaskDefinition: aws.String(arn("app:5")),
+				Deployments:    []*ecs.Deployment{{TaskDefinition: aws.String(arn("app:2"))}},
+			}}
+		case *ecs.DescribeTaskDefinitionInput:
+			// a concurrent run registered app:4 after this one started
+			registered := started.Add(-time.Hour)
+			if aws.StringValue(in.TaskDefinition) == arn("app:4") {
+				registered = started.Add(time.Second)
+			}
+			req.Data.(*ecs.DescribeTaskDefinitionOutput).TaskDefinition = &ecs.TaskDefinition{RegisteredAt: aws.Time(registered)}
+		case *ecs.DeregisterTaskDefinitionInput:
+			deregistered = append(deregistered, aws.StringValue(in.TaskDefinition))
+		}
+	})
+
+	var stderr bytes.Buffer
+	r := &Runner{RevisionRetention: 2, Stderr: &stderr}
+	r.pruneRevisions(context.Background(), ecs.New(sess), &preparedTaskDefinition{Name: "app:6", Family: "app"}, started)
+
+	if expected := []string{arn("app:3"), arn("app:1")}; !reflect.DeepEqual(deregistered, expected) {
+		t.Fatalf("Expected %v to be deregistered, got %v", expected, deregistered)
+	}
+	if stderr.String() != "Deregistered 2 old revisions of app: app:3, app:1\n" {
+		t.Fatalf("Unexpected output %q", stderr.String())
+	}
+
+	// task definitions that are run as-is aren't pruned
+	deregistered = nil
+	r.pruneRevisions(context.Background(), ecs.New(sess), &preparedTaskDefinition{Name: arn("app:6")}, started)
+	if len(deregistered) > 0 {
+		t.Fatalf("Expected nothing to be deregistered, got %v", deregistered)
+	}
+}
+
+func TestSplitRevision(t *testing.T) {
+	for name, expected := range map[string]struct {
+		Family   string
+		Revision int
+	}{
+		"app:3": {"app", 3},
+		"arn:aws:ecs:us-east-1:123456789012:task-definition/app-worker:12": {"app-worker", 12},
+		"app": {"app", 0},
+	} {
+		family, revision := splitRevision(name)
+		if family != expected.Family || revision != expected.Revision {
+			t.Errorf("Expected %s to be %v, got %s %d", name, expected, family, revision)
+		}
+	}
+}
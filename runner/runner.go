@@ -2,22 +2,40 @@ package runner
 
 import (
 	"context"
-	"errors"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"path"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go/service/ecs"
-	"github.com/buildkite/ecs-run-task/parser"
+	"github.com/buildkite/interpolate"
 )
 
+// Policies for containers that stop without an exit code, such as when they
+// never started
+const (
+	MissingExitCodeFail   = "fail"
+	MissingExitCodeIgnore = "ignore"
+)
+
+// Policies for when there are no tasks to run, such as when the count is 0
+const (
+	NoTasksFail    = "fail"
+	NoTasksSucceed = "succeed"
+)
+
+// missingExitCodeStatus is the exit status used when a container has no exit code
+const missingExitCodeStatus = 255
+
 type Override struct {
 	Service string
 	Command []string
@@ -27,207 +45,747 @@ type Runner struct {
 	Service            string
 	TaskName           string
 	TaskDefinitionFile string
+	Family             string
+	FromService        string
+	Images             []string
 	Cluster            string
 	LogGroupName       string
+	LogContainers      []string
 	Region             string
 	Config             *aws.Config
 	Overrides          []Override
+	InitCommands       []string
+	InterpolateCommand bool
 	Fargate            bool
 	SecurityGroups     []string
 	Subnets            []string
+	AssignPublicIP     string
+	NetworkFrom        string
 	Environment        []string
+	SSMEnvironment     []string
+	SecretEnvironment  []string
 	Count              int64
+	Tags               map[string]string
+	ReferenceID        string
+	StartedBy          string
+	ECSManagedTags     bool
+	NoWait             bool
+	Detach             bool
+	LogFetchStrategy   string
+	WaitFor            string
+	FailFast           bool
+	NoLogs             bool
+	AggregateLogs      bool
+	MaxLogLines        int64
+	EphemeralLogs      bool
+	TaskEvents         bool
+	ContainerInstances []string
+	SkipCapacityCheck  bool
+	SkipSecretsCheck   bool
+	WaitForCapacity    time.Duration
+	ScaleInProtection  bool
+	MissingExitCode    string
+	SupportBundle      string
+	SupportBundleWhen  string
+	NoFinishMessage    bool
+	NoWaitLogs         bool
+	StateFile          string
+	StuckLogsTimeout   time.Duration
+	NoTasks            string
+
+	// UseClusterDefaultStrategy runs tasks without a launch type or capacity
+	// provider strategy, even one copied from a service, so that the
+	// cluster's default capacity provider strategy applies
+	UseClusterDefaultStrategy bool
+
+	// ForceRegister registers a new revision of the task definition even when
+	// the family's latest was registered with the same content, which is
+	// otherwise run again
+	ForceRegister bool
+
+	// RevisionRetention is how many of the newest revisions of the family a
+	// run registers in to keep once it passes, deregistering older ones that
+	// no service uses. 0 keeps every revision.
+	RevisionRetention int
+
+	// SaveTaskDefinition is a file to write the task definition that tasks
+	// are run with to, as ECS describes it with its ARN and revision
+	SaveTaskDefinition string
+
+	// TaskDefinition is registered and run instead of TaskDefinitionFile,
+	// such as one made with parser.Builder. Each run registers a copy of it,
+	// so it isn't changed by running it.
+	TaskDefinition *ecs.RegisterTaskDefinitionInput
+
+	// ExistingTaskDefinition is a family, family:revision or ARN of a task
+	// definition that's run as it's registered, such as by Terraform, rather
+	// than registering a new revision. Logs are streamed from containers that
+	// already use awslogs.
+	ExistingTaskDefinition string
+
+	// Revision pins the revision of Family that's run, rather than its latest
+	// active one. A revision that's been deregistered is only run with
+	// AllowInactive.
+	Revision      int64
+	AllowInactive bool
+
+	InferenceAccelerators []string
+	FirelensOptions       []string
+	DisableProxy          bool
+	PidMode               string
+	IpcMode               string
+	InitProcessEnabled    bool
+	SharedMemorySizes     []string
+	Tmpfs                 []string
+	CapAdd                []string
+	CapDrop               []string
+	DescribeGracePeriod   time.Duration
+	HealthCheck           string
+	HealthCheckTimeout    time.Duration
+	WaitForStableService  string
+	StableServiceTimeout  time.Duration
+	PullWarningThreshold  time.Duration
+	SOCICheck             string
+	SOCIMinImageSize      int64
+	TimingsFile           string
+	TimingsFormat         string
+	DumpTaskState         string
+	CloudTrailLookup      time.Duration
+	InsightsQuery         string
+	LogRegion             string
+	LogRoleARN            string
+	ResultCacheDir        string
+	ResultCacheTTL        time.Duration
+	SummaryFile           string
+	EventsFile            string
+	ConsoleLinks          bool
+	ArtifactsPath         string
+	ArtifactsBucket       string
+	ArtifactsDest         string
+
+	// ClientToken makes starting tasks idempotent, so that starting the same
+	// tasks again with the same token returns the tasks that were started.
+	// Without one, the SDK uses a new token for each call, which still stops
+	// its own retries from starting tasks twice.
+	ClientToken string
+
+	// Attempt is which attempt at the run this is, counting from 1, such as
+	// when a caller retries failed runs. Without it, CI's count of retries of
+	// the job is used. LogStreamPerAttempt adds it to log stream names.
+	Attempt             int
+	LogStreamPerAttempt bool
+
+	// PreHook is a shell command run before any tasks are run, which stops
+	// the run if it fails. PostHook is run once every run has finished, with
+	// the summary as JSON on its stdin.
+	PreHook  string
+	PostHook string
+
+	// RerunCommand is a command line that reproduces the run, for the summary
+	RerunCommand string
+
+	// Simulate replaces AWS with a fake backend that fails in the given way
+	Simulate string
+
+	// SecretResolvers adds or replaces resolvers of SecretEnvironment by scheme
+	SecretResolvers map[string]SecretResolver
+
+	// EventHandler receives the same events as EventsFile as the run
+	// progresses, including when each container pulls, runs and stops
+	EventHandler EventHandler
+
+	// Logger receives debug messages with the run, task and container they're
+	// about, identifying the run by RunID. Without one, a ULID is generated,
+	// which is also in the default stream prefix, the tags of tagged tasks,
+	// events and the summary.
+	Logger Logger
+	RunID  string
+
+	// ContainerOutput maps container names to writers that their log lines
+	// are written to rather than Stdout, such as to stream each container to
+	// its own pane, without AggregateLogs. ContainerErrors receives errors
+	// that stop a container's logs from being followed. Sending to it doesn't
+	// block, so errors are dropped if it's full.
+	ContainerOutput map[string]io.Writer
+	ContainerErrors chan<- *ContainerError
+
+	Stdout io.Writer
+	Stderr io.Writer
+
+	cache      *taskDefinitionCache
+	cacheScope string
+	timings    *timingRecorder
+	summary    *summaryRecorder
+	eventLog   *eventLog
+	handle     *Handle
+	logScope   logScope
+	resume     *runState
 }
 
 func New() *Runner {
 	return &Runner{
 		Region: os.Getenv("AWS_REGION"),
 		Config: aws.NewConfig(),
+
+		DescribeGracePeriod: 30 * time.Second,
+		StuckLogsTimeout:    defaultStuckLogsTimeout,
+
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
 	}
 }
 
-func (r *Runner) Run(ctx context.Context) error {
-	taskDefinitionInput, err := parser.Parse(r.TaskDefinitionFile, os.Environ())
-	if err != nil {
-		return err
+// clone returns a copy of the runner for a single run to keep its state in,
+// so that a runner can be reused, or run concurrently, without its runs
+// changing each other or the runner. Slices and maps are shared, as runs only
+// read them, and each run creates its own AWS sessions from Config.
+func (r *Runner) clone() *Runner {
+	c := *r
+	if r.Config != nil {
+		c.Config = r.Config.Copy()
 	}
+	return &c
+}
 
-	streamPrefix := r.TaskName
-	if streamPrefix == "" {
-		streamPrefix = fmt.Sprintf("run_task_%d", time.Now().Nanosecond())
+// newSession creates a session with its own HTTP client. The SDK sets a custom
+// CA bundle, such as from AWS_CA_BUNDLE, on the config's client, which is
+// http.DefaultClient without one, so sessions created at the same time would
+// otherwise race to change the same client. Credentials found from the
+// environment are shared with other sessions if they have to be fetched.
+func newSession(config *aws.Config) (*session.Session, error) {
+	config = config.Copy()
+	client := http.Client{}
+	if config.HTTPClient != nil {
+		client = *config.HTTPClient
 	}
+	if t, ok := client.Transport.(*http.Transport); ok {
+		client.Transport = t.Clone()
+	}
+	config.HTTPClient = &client
 
-	sess := session.Must(session.NewSession(r.Config))
+	fromEnv := config.Credentials == nil
+	if fromEnv {
+		config.Credentials = cachedProfileCredentials()
+	}
+	sess, err := session.NewSession(config)
+	if err == nil && fromEnv {
+		cacheProfileCredentials(sess)
+	}
+	return sess, err
+}
 
-	if err := createLogGroup(sess, r.LogGroupName); err != nil {
-		return err
+// Run runs the task and waits for it to stop, printing its logs. The runner
+// isn't changed, so it can be run again, or concurrently, such as from a pool.
+func (r *Runner) Run(ctx context.Context) (err error) {
+	r = r.clone()
+	runID := r.RunID
+	if runID == "" {
+		runID = newRunID()
+	}
+	bundle := r.newSupportBundle()
+	logger := r.Logger
+	if bundle != nil {
+		logger = bundle.logger(r.Logger)
 	}
+	r.logScope = logScope{logger: logger, fields: LogEntry{RunID: runID, Cluster: r.Cluster}}
+	ctx = withLogScope(ctx, r.logScope)
+	logf(ctx, "Starting run %s", runID)
+	runStarted := time.Now()
 
-	log.Printf("Setting tasks to use log group %s", r.LogGroupName)
-	for _, def := range taskDefinitionInput.ContainerDefinitions {
-		def.LogConfiguration = &ecs.LogConfiguration{
-			LogDriver: aws.String("awslogs"),
-			Options: map[string]*string{
-				"awslogs-group":         aws.String(r.LogGroupName),
-				"awslogs-region":        aws.String(r.Region),
-				"awslogs-stream-prefix": aws.String(streamPrefix),
-			},
-		}
+	if r.Count == 0 {
+		return r.noTasks("the count is 0")
 	}
 
+	sess := session.Must(newSession(r.Config))
+	if r.Simulate != "" {
+		r.simulate(sess)
+	}
+	refreshExpiredCredentials(sess)
 	svc := ecs.New(sess)
 
-	log.Printf("Registering a task for %s", *taskDefinitionInput.Family)
-	resp, err := svc.RegisterTaskDefinition(taskDefinitionInput)
+	// deferred first, so it sees the run's final error
+	defer func() {
+		r.writeSupportBundle(ctx, sess, bundle, err)
+	}()
+
+	if len(r.ClientToken) > maxClientTokenLength {
+		return fmt.Errorf("Client token %q is longer than %d characters", r.ClientToken, maxClientTokenLength)
+	}
+
+	if r.HealthCheck != "" {
+		if _, err := parseHealthCheck(r.HealthCheck); err != nil {
+			return err
+		}
+	}
+
+	el := r.eventLog
+	if el == nil && (r.EventsFile != "" || r.EventHandler != nil) {
+		if el, err = openEventLog(r.EventsFile, r.EventHandler, runID, r.Stderr); err != nil {
+			return err
+		}
+		defer el.Close()
+	}
+	rep := r.newRunReport(aws.StringValue(sess.Config.Region))
+	defer func() {
+		el.finished(r.Cluster, err)
+		r.recordRun(rep, err)
+	}()
+
+	// deferred after the report, so the events are looked up before it's
+	// recorded
+	if r.CloudTrailLookup > 0 {
+		audited := auditCalls(svc)
+		defer r.printCloudTrailEvents(ctx, sess, audited, rep, el)
+	}
+
+	// tasks that have been started, to stop if cancelled through a handle
+	var started []*ecs.Task
+	defer func() {
+		if r.handle != nil && ctx.Err() != nil {
+			err = r.cancelled(svc, started, err)
+		}
+	}()
+	defer func() {
+		if len(started) > 0 && isExpiredCredentials(err) {
+			printCredentialsExpired(r.Stderr, r.Cluster, started, r.StateFile)
+		}
+	}()
+
+	var service *ecs.Service
+	if r.FromService != "" {
+		if service, err = describeService(svc, r.Cluster, r.FromService); err != nil {
+			return err
+		}
+	}
+
+	if r.WaitForStableService != "" {
+		if err := r.waitForStableService(ctx, svc, r.WaitForStableService); err != nil {
+			return err
+		}
+	}
+
+	var td *preparedTaskDefinition
+	if r.resume != nil {
+		td, err = r.resumedTaskDefinition(ctx, sess, svc)
+	} else {
+		td, err = r.prepareTaskDefinition(sess, svc, service)
+	}
 	if err != nil {
 		return err
 	}
-
-	taskDefinition := fmt.Sprintf("%s:%d",
-		*resp.TaskDefinition.Family, *resp.TaskDefinition.Revision)
+	rep.setTaskDefinition(td)
+	bundle.setTaskDefinition(td)
+	if err := r.saveTaskDefinition(svc, td); err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			r.pruneRevisions(ctx, svc, td, runStarted)
+		}
+	}()
+	taskDefinition := td.Name
 
 	runTaskInput := &ecs.RunTaskInput{
 		TaskDefinition: aws.String(taskDefinition),
 		Cluster:        aws.String(r.Cluster),
-		Count:          aws.Int64(r.Count),
 		Overrides: &ecs.TaskOverride{
 			ContainerOverrides: []*ecs.ContainerOverride{},
 		},
 	}
+	if service != nil {
+		applyServiceConfiguration(runTaskInput, service)
+	}
+	if len(r.Tags) > 0 {
+		runTaskInput.Tags = append(runTaskInput.Tags, ecsTags(r.Tags)...)
+	}
+	if r.ReferenceID != "" {
+		runTaskInput.ReferenceId = aws.String(r.ReferenceID)
+	}
+	if r.StartedBy != "" {
+		runTaskInput.StartedBy = aws.String(r.StartedBy)
+	}
+	if r.ECSManagedTags {
+		runTaskInput.EnableECSManagedTags = aws.Bool(true)
+	}
+	if r.ClientToken != "" {
+		runTaskInput.ClientToken = aws.String(r.ClientToken)
+	}
+	if r.NetworkFrom != "" {
+		nc, err := networkConfigurationFrom(sess, svc, r.Cluster, r.NetworkFrom)
+		if err != nil {
+			return err
+		}
+		runTaskInput.NetworkConfiguration = nc
+	}
+	if r.Fargate && len(r.ContainerInstances) > 0 {
+		return fmt.Errorf("Tasks can't be started on a container instance with Fargate")
+	}
 	if r.Fargate {
 		runTaskInput.LaunchType = aws.String("FARGATE")
+		runTaskInput.CapacityProviderStrategy = nil
+	}
+	if r.UseClusterDefaultStrategy {
+		// without either, the cluster's default capacity provider strategy applies
+		logf(ctx, "Using the default capacity provider strategy of cluster %s", r.Cluster)
+		runTaskInput.LaunchType = nil
+		runTaskInput.CapacityProviderStrategy = nil
 	}
 	if len(r.Subnets) > 0 || len(r.SecurityGroups) > 0 {
+		assignPublicIP := ecs.AssignPublicIpEnabled
+		if r.AssignPublicIP != "" {
+			assignPublicIP = r.AssignPublicIP
+		}
 		runTaskInput.NetworkConfiguration = &ecs.NetworkConfiguration{
 			AwsvpcConfiguration: &ecs.AwsVpcConfiguration{
 				Subnets:        awsStrings(r.Subnets),
-				AssignPublicIp: aws.String("ENABLED"),
+				AssignPublicIp: aws.String(assignPublicIP),
 				SecurityGroups: awsStrings(r.SecurityGroups),
 			},
 		}
 	}
 
-	for _, override := range r.Overrides {
-		if len(override.Command) > 0 {
-			cmds := []*string{}
+	environment := r.Environment
+	if len(r.SSMEnvironment) > 0 || len(r.SecretEnvironment) > 0 {
+		secrets, err := r.resolveSecrets(ctx, sess)
+		if err != nil {
+			return err
+		}
+		environment = append(append([]string{}, r.Environment...), secrets...)
+	}
 
-			if override.Service == "" {
-				if len(taskDefinitionInput.ContainerDefinitions) != 1 {
-					return fmt.Errorf("No service provided for override and can't determine default service with %d container definitions", len(taskDefinitionInput.ContainerDefinitions))
-				}
+	overrides, err := r.containerOverrides(ctx, td, environment)
+	if err != nil {
+		return err
+	}
+	runTaskInput.Overrides.ContainerOverrides = append(runTaskInput.Overrides.ContainerOverrides, overrides...)
 
-				override.Service = *taskDefinitionInput.ContainerDefinitions[0].Name
-				log.Printf("Assuming override applies to '%s'", override.Service)
-			}
+	dumper, err := newTaskStateDumper(r.DumpTaskState, r.Stderr)
+	if err != nil {
+		return err
+	}
 
-			for _, command := range override.Command {
-				cmds = append(cmds, aws.String(command))
-			}
+	results := r.resultCache()
+	cacheKey, err := results.key(aws.StringValue(sess.Config.Region), td, runTaskInput)
+	if err != nil {
+		return err
+	}
+	if cached, ok := results.load(cacheKey); ok {
+		r.replayCachedResult(cached)
+		return nil
+	}
 
-			env, err := awsKeyValuePairForEnv(os.LookupEnv, r.Environment)
-			if err != nil {
-				return err
-			}
+	// tasks are only tagged with the run ID if they're already tagged, as
+	// tagging needs ecs:TagResource, and after the cache key, as it's
+	// different every run
+	if len(runTaskInput.Tags) > 0 || aws.BoolValue(runTaskInput.EnableECSManagedTags) {
+		runTaskInput.Tags = append(runTaskInput.Tags, &ecs.Tag{
+			Key:   aws.String(runTagPrefix + "run-id"),
+			Value: aws.String(runID),
+		})
+	}
 
-			runTaskInput.Overrides.ContainerOverrides = append(
-				runTaskInput.Overrides.ContainerOverrides,
-				&ecs.ContainerOverride{
-					Command:     cmds,
-					Name:        aws.String(override.Service),
-					Environment: env,
-				},
-			)
+	// the upload URL is added after the cache key, as it's different every run
+	artifacts, err := r.prepareArtifactsUpload(sess, td, runTaskInput)
+	if err != nil {
+		return err
+	}
+
+	if r.resume == nil {
+		bundle.setRunTaskInput(runTaskInput)
+	}
+
+	if r.resume == nil && r.checksCapacity(runTaskInput) {
+		if err := r.checkCapacity(svc, runTaskInput); err != nil {
+			return err
+		}
+	}
+
+	// subscribe before starting tasks so that no events are missed
+	var events *taskEventSubscription
+	if r.TaskEvents && !r.NoWait && !r.Detach {
+		if events, err = subscribeTaskEvents(sess, r.Cluster, r.Stderr); err != nil {
+			fmt.Fprintf(r.Stderr, "Failed to subscribe to task events, polling instead: %v\n", err)
 		}
+		defer events.Close()
 	}
 
-	log.Printf("Running task %s", taskDefinition)
-	runResp, err := svc.RunTask(runTaskInput)
+	var runResp *ecs.RunTaskOutput
+	expected := r.Count
+	if r.resume != nil {
+		logf(ctx, "Resuming tasks from %s", r.StateFile)
+		runResp, err = r.resumedTasks(ctx, svc)
+	} else if len(r.ContainerInstances) > 0 {
+		expected = r.Count * int64(len(r.ContainerInstances))
+		logf(ctx, "Starting task %s on %s", taskDefinition, strings.Join(r.ContainerInstances, ", "))
+		runResp, err = startTasks(svc, runTaskInput, r.ContainerInstances, r.Count)
+	} else {
+		logf(ctx, "Running task %s", taskDefinition)
+		if r.WaitForCapacity > 0 {
+			runResp, err = r.runTasksWaitingForCapacity(ctx, svc, runTaskInput, r.Count)
+		} else {
+			runResp, err = runTasks(svc, runTaskInput, r.Count)
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("Unable to run task: %s", err.Error())
+		// tasks that started before the error would otherwise be left
+		// running without anything following them
+		if runResp != nil && len(runResp.Tasks) > 0 {
+			r.stopFailedTasks(ctx, svc, runResp.Tasks, "not all tasks could be started")
+		}
+		return err
 	}
 
-	cwl := cloudwatchlogs.New(sess)
-	var wg sync.WaitGroup
+	el.started(r.Cluster, runResp)
+	bundle.started(runResp)
+	rep.setTasks(runResp.Tasks)
+	rep.setFailures(runResp.Failures)
 
-	// spawn a log watcher for each container
-	for _, task := range runResp.Tasks {
-		for _, container := range task.Containers {
-			containerId := path.Base(*container.ContainerArn)
-			watcher := &logWatcher{
-				LogGroupName:   r.LogGroupName,
-				LogStreamName:  logStreamName(streamPrefix, container, task),
-				CloudWatchLogs: cwl,
-
-				// watch for the finish message to terminate the logger
-				Printer: func(ev *cloudwatchlogs.FilteredLogEvent) bool {
-					finishedPrefix := fmt.Sprintf(
-						"Container %s exited with",
-						containerId,
-					)
-					if strings.HasPrefix(*ev.Message, finishedPrefix) {
-						log.Printf("Found container finished message for %s: %s",
-							containerId, *ev.Message)
-						return false
-					}
-					fmt.Println(*ev.Message)
-					return true
-				},
-			}
+	for _, failure := range runResp.Failures {
+		fmt.Fprintf(r.Stderr, "Failed to start task on %s: %s\n",
+			aws.StringValue(failure.Arn), aws.StringValue(failure.Reason))
+	}
+
+	if len(runResp.Tasks) == 0 {
+		return &PlacementError{Failures: runResp.Failures, Started: len(runResp.Tasks), Count: expected}
+	}
+	started = runResp.Tasks
+
+	// detached runs leave the tasks to ECS once they've started, printing
+	// their ARNs for whatever follows them
+	if r.Detach {
+		dumper.dump("started", runResp.Tasks)
+		for _, task := range runResp.Tasks {
+			fmt.Fprintln(r.Stdout, *task.TaskArn)
+		}
+		logf(ctx, "Detaching from tasks")
+		return nil
+	}
+
+	protection := r.protectFromScaleIn(ctx, sess, svc, runResp.Tasks)
+	defer r.releaseScaleInProtection(ctx, protection)
+	dumper.dump("started", runResp.Tasks)
+	if r.ConsoleLinks {
+		r.printConsoleLinks(td, runResp.Tasks)
+	}
+	if r.UseClusterDefaultStrategy {
+		printCapacityProviders(r.Stderr, runResp.Tasks)
+	}
+
+	printLine := func(line string) {
+		fmt.Fprintln(r.Stdout, line)
+	}
+	if r.AggregateLogs {
+		agg := newLogAggregator(r.Stdout, defaultAggregateWindow)
+		defer agg.Close()
+		printLine = agg.Println
+	}
+
+	var captured *capturedOutput
+	if results != nil {
+		captured = &capturedOutput{}
+		printLine = captured.wrap(printLine)
+	}
+	containerPrintLine := r.containerPrinters(printLine, captured)
+
+	cwl := &cloudWatchLogsClients{sess: r.logsSession(sess)}
+	watchers := newLogWatchers(ctx)
+	watcherCancels := map[string]context.CancelFunc{}
 
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				if err := watcher.Watch(ctx); err != nil {
-					log.Printf("Log watcher returned error: %v", err)
+	// saved as the run goes, and once more if it's interrupted
+	state := r.newRunStateFile(aws.StringValue(sess.Config.Region), td, runResp.Tasks, watchers)
+	state.save()
+	defer state.save()
+	defer watchers.stop()
+
+	var streamed *streamedLogs
+	if r.EphemeralLogs {
+		streamed = &streamedLogs{}
+	}
+
+	// spawn a log watcher for each container that has started
+	watchStartedContainers := func(tasks []*ecs.Task, all bool) {
+		for _, task := range tasks {
+			for _, container := range task.Containers {
+				lc, ok := td.Logs[*container.Name]
+				if r.NoLogs || !ok {
+					continue
+				}
+				if _, ok := watcherCancels[*container.ContainerArn]; ok {
+					continue
 				}
-			}()
+				if !all && !containerHasStarted(container) {
+					continue
+				}
+				logf(withContainerLog(ctx, *task.TaskArn, *container.Name), "Watching logs of container %s in task %s", *container.Name, path.Base(*task.TaskArn))
+				watcherCancels[*container.ContainerArn] = r.watchContainerLogs(watchers, cwl, lc, task, container, containerPrintLine(*container.Name), streamed)
+			}
+		}
+	}
+
+	if r.NoWait {
+		watchStartedContainers(runResp.Tasks, true)
+
+		for _, task := range runResp.Tasks {
+			fmt.Fprintf(r.Stderr, "Started task %s\n", *task.TaskArn)
 		}
+
+		// without waiting, logs are followed until cancelled
+		logf(ctx, "Not waiting for tasks to stop")
+		protection.leaveTasksRunning()
+		return watchers.group.Wait()
 	}
 
 	var taskARNs []*string
 	for _, task := range runResp.Tasks {
-		log.Printf("Waiting until task %s has stopped", *task.TaskArn)
+		logf(withTaskLog(ctx, *task.TaskArn), "Waiting until task %s has stopped", *task.TaskArn)
 		taskARNs = append(taskARNs, task.TaskArn)
 	}
 
-	err = svc.WaitUntilTasksStopped(&ecs.DescribeTasksInput{
-		Cluster: aws.String(r.Cluster),
-		Tasks:   taskARNs,
+	// containers are watched as they start, rather than polling for log
+	// streams of containers that are still waiting on their dependencies.
+	// The wait is in the watchers' group, so that if it fails they're
+	// cancelled too.
+	var output *waitResult
+	waited := make(chan error, 1)
+	watchers.group.Go(func() (err error) {
+		defer func() { waited <- err }()
+		output, err = r.waitForTasks(watchers.ctx, svc, td, taskARNs, events, func(tasks []*ecs.Task) {
+			dumper.dumpIfChanged(tasks)
+			el.changed(r.Cluster, tasks)
+			rep.setTasks(tasks)
+			bundle.describe("changed", tasks)
+			watchStartedContainers(tasks, false)
+			state.save()
+		})
+		return err
 	})
-	if err != nil {
+	if err := <-waited; err != nil {
 		return err
 	}
+	dumper.dump("final", output.Tasks)
+	el.changed(r.Cluster, output.Tasks)
+	rep.setTasks(output.Tasks)
+	bundle.describe("final", output.Tasks)
 
-	log.Printf("All tasks have stopped")
-
-	output, err := svc.DescribeTasks(&ecs.DescribeTasksInput{
-		Cluster: aws.String(r.Cluster),
-		Tasks:   taskARNs,
-	})
-	if err != nil {
-		return err
+	// running tasks are left running, with their logs followed until now
+	if output.Running {
+		if err := r.tasksRunning(ctx, output.Tasks); err != nil {
+			r.stopFailedTasks(ctx, svc, output.Tasks, "not all tasks passed the health check")
+			return err
+		}
+		protection.leaveTasksRunning()
+		return nil
 	}
 
-	// Get the final state of each task and container and write to cloudwatch logs
 	for _, task := range output.Tasks {
-		for _, container := range task.Containers {
-			lw := &logWriter{
-				LogGroupName:   r.LogGroupName,
-				LogStreamName:  logStreamName(streamPrefix, container, task),
-				CloudWatchLogs: cwl,
-			}
-			if err := writeContainerFinishedMessage(ctx, lw, task, container); err != nil {
-				return err
+		r.reportImagePull(task)
+	}
+	r.recordTimings(taskTimings(output.Tasks))
+
+	// without waiting for logs, watchers are cancelled and left to finish
+	// in the background, so the exit doesn't wait on a stuck one
+	if r.NoWaitLogs {
+		logf(ctx, "Not waiting for logs to finish")
+		watchers.detach()
+		for _, cancel := range watcherCancels {
+			cancel()
+		}
+	} else {
+		streams := r.probeLogStreams(ctx, cwl, td, output.Tasks)
+
+		// Get the final state of each task and container and write to cloudwatch logs
+		for _, task := range output.Tasks {
+			for _, container := range task.Containers {
+				lc, ok := td.Logs[*container.Name]
+				if r.NoLogs || !ok {
+					continue
+				}
+
+				// containers that never ran won't have a log stream to write to
+				if container.ExitCode == nil {
+					logf(withContainerLog(ctx, *task.TaskArn, *container.Name), "Container %s has no exit code, stopping its log watcher", *container.Name)
+					if cancel, ok := watcherCancels[*container.ContainerArn]; ok {
+						cancel()
+					}
+					continue
+				}
+
+				// a stream that was never created has nothing to wait for
+				if !streams[*container.ContainerArn] {
+					if cancel, ok := watcherCancels[*container.ContainerArn]; ok {
+						cancel()
+					}
+					continue
+				}
+
+				// without a finished message, watchers stop with what's there now
+				if r.NoFinishMessage {
+					logf(withContainerLog(ctx, *task.TaskArn, *container.Name), "Container %s has stopped, stopping its log watcher", *container.Name)
+					if cancel, ok := watcherCancels[*container.ContainerArn]; ok {
+						cancel()
+					}
+					continue
+				}
+
+				lw := &logWriter{
+					LogGroupName:   lc.Group,
+					LogStreamName:  logStreamName(lc.StreamPrefix, container, task),
+					CloudWatchLogs: cwl.forRegion(lc.Region),
+				}
+				if err := writeContainerFinishedMessage(ctx, lw, task, container); err != nil {
+					return err
+				}
 			}
 		}
+
+		logf(ctx, "Waiting for logs to finish")
+		r.waitForLogWatchers(ctx, watchers)
 	}
 
-	log.Printf("Waiting for logs to finish")
-	wg.Wait()
+	// the tasks have stopped and their logs have been followed
+	state.remove()
+
+	r.downloadArtifacts(ctx, sess, artifacts)
+
+	if r.InsightsQuery != "" && !r.NoLogs {
+		r.runInsightsQuery(ctx, cwl, td, output.Tasks)
+	}
+
+	if streamed != nil && !r.NoWaitLogs {
+		r.deleteStreamedLogs(cwl, streamed)
+	}
+
+	r.printExitCodes(r.Stderr, td, output.Tasks)
+	r.printFailureHints(r.Stderr, td, output.Tasks, r.printSecretFailures(r.Stderr, td, output.Tasks))
+
+	if c := output.FailedFast; c != nil {
+		return &exitError{
+			fmt.Errorf("container %s exited with %d", *c.Name, *c.ExitCode),
+			int(*c.ExitCode),
+		}
+	}
 
 	// Determine exit code based on the first non-zero exit code
 	for _, task := range output.Tasks {
 		for _, container := range task.Containers {
+			if r.lingeringContainer(td, container) {
+				continue
+			}
+			if container.ExitCode == nil {
+				reason := containerStopReason(task, container)
+				if r.MissingExitCode == MissingExitCodeIgnore {
+					fmt.Fprintf(r.Stderr, "Container %s stopped without an exit code: %s\n", *container.Name, reason)
+					continue
+				}
+				return &exitError{
+					fmt.Errorf(
+						"container %s stopped without an exit code: %s",
+						*container.Name,
+						reason,
+					),
+					missingExitCodeStatus,
+				}
+			}
 			if *container.ExitCode != 0 {
 				return &exitError{
 					fmt.Errorf(
@@ -241,9 +799,241 @@ func (r *Runner) Run(ctx context.Context) error {
 		}
 	}
 
+	if len(runResp.Failures) > 0 {
+		return &PlacementError{Failures: runResp.Failures, Started: len(runResp.Tasks), Count: expected}
+	}
+
+	if err := results.save(cacheKey, captured.output()); err != nil {
+		fmt.Fprintf(r.Stderr, "Failed to cache the result: %v\n", err)
+	}
+
 	return err
 }
 
+// watchContainerLogs starts a log watcher for a container that prints its
+// logs until the container's finished message, returning a func to stop it.
+// Streams that are printed in full are added to streamed, if it isn't nil.
+func (r *Runner) watchContainerLogs(watchers *logWatchers, cwl *cloudWatchLogsClients, lc logConfig, task *ecs.Task, container *ecs.Container, printLine func(string), streamed *streamedLogs) context.CancelFunc {
+	ctx := withContainerLog(watchers.ctx, *task.TaskArn, *container.Name)
+	containerId := path.Base(*container.ContainerArn)
+	sampler := newLogSampler(r.MaxLogLines, printLine)
+	var finished bool
+	streamName := logStreamName(lc.StreamPrefix, container, task)
+	client := cwl.forRegion(lc.Region)
+	watcher := &logWatcher{
+		LogGroupName:   lc.Group,
+		LogStreamName:  streamName,
+		CloudWatchLogs: client,
+		StartAfter:     r.resume.logPosition(streamName),
+		Strategy:       r.LogFetchStrategy,
+		LiveTail:       startLiveTail(client),
+
+		// watch for the finish message to terminate the logger
+		Printer: func(ev *cloudwatchlogs.FilteredLogEvent) bool {
+			finishedPrefix := fmt.Sprintf(
+				"Container %s exited with",
+				containerId,
+			)
+			if strings.HasPrefix(*ev.Message, finishedPrefix) {
+				logf(ctx, "Found container finished message for %s: %s",
+					containerId, *ev.Message)
+				finished = true
+				return false
+			}
+			sampler.Println(*ev.Message)
+			return true
+		},
+	}
+
+	watcherCtx, cancel := context.WithCancel(ctx)
+	watch := &logWatch{
+		container: *container.Name,
+		task:      *task.TaskArn,
+		watcher:   watcher,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	watchers.add(watch)
+
+	// a watcher failing only means its logs aren't printed, which is warned
+	// about, so it doesn't cancel the rest of the group
+	watchers.group.Go(func() error {
+		defer close(watch.done)
+		// being stopped once the container has, rather than the run being
+		// cancelled, is a normal finish that flushes what's left, unless
+		// the watcher was stuck
+		if err := watcher.Watch(watcherCtx); err != nil && (err != context.Canceled || ctx.Err() != nil) {
+			logf(ctx, "Log watcher returned error: %v", err)
+			accessErr := logAccessError(lc.Group, lc.Region, err)
+			if accessErr != err {
+				fmt.Fprintf(r.Stderr, "Failed to stream logs of container %s: %v\n", *container.Name, accessErr)
+			}
+			r.sendContainerError(*task.TaskArn, *container.Name, accessErr)
+		}
+		sampler.Close()
+		// only streams that were printed up to their finish message, without
+		// skipping any lines, are printed in full
+		if finished && sampler.Skipped() == 0 && !watch.stuck() {
+			streamed.add(lc, watcher.LogStreamName)
+		}
+		r.reportStreamedLogs(*container.Name, task, sampler)
+		return nil
+	})
+
+	return cancel
+}
+
+// reportStreamedLogs prints how much output was streamed from a container
+func (r *Runner) reportStreamedLogs(name string, task *ecs.Task, sampler *logSampler) {
+	msg := fmt.Sprintf("Streamed %d lines (%d bytes) from container %s in task %s",
+		sampler.Lines, sampler.Bytes, name, path.Base(*task.TaskArn))
+	if skipped := sampler.Skipped(); skipped > 0 {
+		msg += fmt.Sprintf(", skipping %d lines over --max-log-lines", skipped)
+	}
+	fmt.Fprintln(r.Stderr, msg)
+}
+
+// containerHasStarted returns whether a container is running or has run, and
+// so will have a log stream
+func containerHasStarted(container *ecs.Container) bool {
+	switch aws.StringValue(container.LastStatus) {
+	case ecs.DesiredStatusRunning:
+		return true
+	case ecs.DesiredStatusStopped:
+		return container.ExitCode != nil
+	}
+	return false
+}
+
+// maxRunTaskCount is the most tasks that a single RunTask call can start
+const maxRunTaskCount = 10
+
+// maxClientTokenLength is the longest client token that RunTask accepts
+const maxClientTokenLength = 64
+
+// suffixClientToken derives the client token of part of a run, such as a
+// batch, retry or target, from the run's. Suffixes can add up past RunTask's
+// limit, so a token that would be too long starts with a hash of the run's
+// token instead, which is still the same when the run is retried.
+func suffixClientToken(token, suffix string) string {
+	if len(token)+len(suffix) <= maxClientTokenLength {
+		return token + suffix
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])[:maxClientTokenLength-len(suffix)] + suffix
+}
+
+// runTasks starts count tasks, splitting them across as many RunTask calls as
+// needed and merging the tasks and failures from each into a single output.
+// Each batch gets its own client token, as they start different tasks. If a
+// batch fails, the output of those before it is returned with the error, as
+// their tasks have started.
+func runTasks(svc *ecs.ECS, input *ecs.RunTaskInput, count int64) (*ecs.RunTaskOutput, error) {
+	output := &ecs.RunTaskOutput{}
+
+	batches := runTaskBatches(count)
+	for i, n := range batches {
+		batch := *input
+		batch.Count = aws.Int64(n)
+		if input.ClientToken != nil && len(batches) > 1 {
+			batch.ClientToken = aws.String(suffixClientToken(*input.ClientToken, fmt.Sprintf("-%d", i)))
+		}
+
+		log.Printf("Starting %d tasks", n)
+		resp, err := svc.RunTask(&batch)
+		if err != nil {
+			return output, wrapAPIError("RunTask", err)
+		}
+
+		output.Tasks = append(output.Tasks, resp.Tasks...)
+		output.Failures = append(output.Failures, resp.Failures...)
+	}
+
+	return output, nil
+}
+
+// stopFailedTasks stops tasks that would otherwise be left running by a run
+// that failed, such as those that started before starting the rest failed. It's
+// done even if the run was cancelled, and failures are only warned about as
+// the run has already failed.
+func (r *Runner) stopFailedTasks(ctx context.Context, svc *ecs.ECS, tasks []*ecs.Task, cause string) {
+	ctx = withLogScope(context.Background(), logScopeFrom(ctx))
+	fmt.Fprintf(r.Stderr, "Stopping %d tasks as %s\n", len(tasks), cause)
+	if err := r.stopTasks(ctx, svc, tasks, cause); err != nil {
+		fmt.Fprintf(r.Stderr, "Failed to stop tasks, stop them by hand: %v\n", err)
+	}
+}
+
+// runTaskBatches splits count into batch sizes that RunTask will accept
+func runTaskBatches(count int64) []int64 {
+	var batches []int64
+	for count > 0 {
+		n := count
+		if n > maxRunTaskCount {
+			n = maxRunTaskCount
+		}
+		batches = append(batches, n)
+		count -= n
+	}
+	return batches
+}
+
+// startTasks starts count tasks on each of the given container instances with
+// StartTask, which unlike RunTask doesn't place tasks itself. Like runTasks, the
+// tasks already started are returned with an error.
+func startTasks(svc *ecs.ECS, input *ecs.RunTaskInput, containerInstances []string, count int64) (*ecs.RunTaskOutput, error) {
+	output := &ecs.RunTaskOutput{}
+
+	startInput := startTaskInput(input, containerInstances)
+	for i := int64(0); i < count; i++ {
+		resp, err := svc.StartTask(startInput)
+		if err != nil {
+			return output, wrapAPIError("StartTask", err)
+		}
+
+		output.Tasks = append(output.Tasks, resp.Tasks...)
+		output.Failures = append(output.Failures, resp.Failures...)
+	}
+
+	return output, nil
+}
+
+// startTaskInput converts the input for RunTask to StartTask, dropping the
+// launch type and placement options that don't apply to a specific instance
+func startTaskInput(input *ecs.RunTaskInput, containerInstances []string) *ecs.StartTaskInput {
+	if input.LaunchType != nil || input.CapacityProviderStrategy != nil || input.PlacementConstraints != nil || input.PlacementStrategy != nil {
+		log.Printf("Ignoring launch type and placement when starting tasks on container instances")
+	}
+	return &ecs.StartTaskInput{
+		Cluster:              input.Cluster,
+		ContainerInstances:   awsStrings(containerInstances),
+		TaskDefinition:       input.TaskDefinition,
+		Overrides:            input.Overrides,
+		NetworkConfiguration: input.NetworkConfiguration,
+		Group:                input.Group,
+		StartedBy:            input.StartedBy,
+		Tags:                 input.Tags,
+		EnableECSManagedTags: input.EnableECSManagedTags,
+		EnableExecuteCommand: input.EnableExecuteCommand,
+		PropagateTags:        input.PropagateTags,
+		ReferenceId:          input.ReferenceId,
+	}
+}
+
+// streamsLogsFor returns whether a container's logs are sent to the log group
+// and streamed, which is every container unless specific ones are selected
+func (r *Runner) streamsLogsFor(container string) bool {
+	if len(r.LogContainers) == 0 {
+		return true
+	}
+	for _, name := range r.LogContainers {
+		if name == container {
+			return true
+		}
+	}
+	return false
+}
+
 func logStreamName(logStreamPrefix string, container *ecs.Container, task *ecs.Task) string {
 	return fmt.Sprintf(
 		"%s/%s/%s",
@@ -253,13 +1043,22 @@ func logStreamName(logStreamPrefix string, container *ecs.Container, task *ecs.T
 	)
 }
 
+// containerStopReason returns why a container stopped, falling back to why
+// its task stopped
+func containerStopReason(task *ecs.Task, container *ecs.Container) string {
+	if container.Reason != nil {
+		return *container.Reason
+	}
+	if task.StoppedReason != nil {
+		return *task.StoppedReason
+	}
+	return "unknown reason"
+}
+
 func writeContainerFinishedMessage(ctx context.Context, w *logWriter, task *ecs.Task, container *ecs.Container) error {
 	if *container.LastStatus != `STOPPED` {
 		return fmt.Errorf("expected container to be STOPPED, got %s", *container.LastStatus)
 	}
-	if container.ExitCode == nil {
-		return errors.New(*container.Reason)
-	}
 	return w.WriteString(ctx, fmt.Sprintf(
 		"Container %s exited with %d",
 		path.Base(*container.ContainerArn),
@@ -284,6 +1083,79 @@ func awsStrings(ss []string) []*string {
 	return out
 }
 
+// interpolateCommand expands $VAR and ${VAR} in a command's arguments from the
+// task's environment, falling back to the calling shell's. $$ or \$ is a literal $
+func interpolateCommand(command []string, env []*ecs.KeyValuePair, environ []string) ([]string, error) {
+	vars := append([]string{}, environ...)
+	for _, kv := range env {
+		vars = append(vars, *kv.Name+"="+*kv.Value)
+	}
+	ienv := interpolate.NewSliceEnv(vars)
+
+	out := make([]string, len(command))
+	for i, arg := range command {
+		s, err := interpolate.Interpolate(ienv, arg)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to interpolate %q: %v", arg, err)
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+// containerOverrides returns the overrides of each container's command, with
+// the environment. Without a command, the environment is set on the main
+// container, which is the given service or the only container.
+func (r *Runner) containerOverrides(ctx context.Context, td *preparedTaskDefinition, environment []string) ([]*ecs.ContainerOverride, error) {
+	env, err := awsKeyValuePairForEnv(os.LookupEnv, environment)
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides []*ecs.ContainerOverride
+	for _, override := range r.Overrides {
+		if len(override.Command) == 0 {
+			continue
+		}
+
+		command := override.Command
+		if r.InterpolateCommand {
+			if command, err = interpolateCommand(command, env, os.Environ()); err != nil {
+				return nil, err
+			}
+		}
+
+		if override.Service == "" {
+			containers := withoutInitContainers(td.Containers, len(r.InitCommands))
+			if len(containers) != 1 {
+				return nil, fmt.Errorf("No service provided for override and can't determine default service with %d container definitions", len(containers))
+			}
+
+			override.Service = *containers[0].Name
+			logf(ctx, "Assuming override applies to '%s'", override.Service)
+		}
+
+		overrides = append(overrides, &ecs.ContainerOverride{
+			Command:     awsStrings(command),
+			Name:        aws.String(override.Service),
+			Environment: env,
+		})
+	}
+
+	if len(overrides) == 0 && len(env) > 0 {
+		def, err := mainContainer(withoutInitContainers(td.Containers, len(r.InitCommands)), r.Service, "the environment")
+		if err != nil {
+			return nil, err
+		}
+		logf(ctx, "Setting the environment of '%s'", aws.StringValue(def.Name))
+		overrides = append(overrides, &ecs.ContainerOverride{
+			Name:        def.Name,
+			Environment: env,
+		})
+	}
+	return overrides, nil
+}
+
 func awsKeyValuePairForEnv(lookupEnv func(key string) (string, bool), wanted []string) ([]*ecs.KeyValuePair, error) {
 	var kvp []*ecs.KeyValuePair
 	for _, s := range wanted {
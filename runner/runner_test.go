@@ -1,6 +1,19 @@
 package runner
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
 
 func TestAWSKeyValuePairForEnvEmpty(t *testing.T) {
 	lookupEnv := func(key string) (string, bool) {
@@ -74,3 +87,290 @@ func TestAWSKeyValuePairForEnvMissing(t *testing.T) {
 		t.Fatalf("bad error message returned: %q", err.Error())
 	}
 }
+
+func TestContainerOverrides(t *testing.T) {
+	td := &preparedTaskDefinition{Containers: []*ecs.ContainerDefinition{{Name: aws.String("app")}}}
+
+	// the environment is set without a command override
+	r := &Runner{}
+	overrides, err := r.containerOverrides(context.Background(), td, []string{"STAGE=staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overrides) != 1 || aws.StringValue(overrides[0].Name) != "app" || overrides[0].Command != nil ||
+		len(overrides[0].Environment) != 1 || aws.StringValue(overrides[0].Environment[0].Value) != "staging" {
+		t.Fatalf("Expected an environment override of app, got %v", overrides)
+	}
+
+	r.Overrides = []Override{{Command: []string{"bin/migrate"}}}
+	overrides, err = r.containerOverrides(context.Background(), td, []string{"STAGE=staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overrides) != 1 || len(overrides[0].Command) != 1 || len(overrides[0].Environment) != 1 {
+		t.Fatalf("Expected the environment with the command, got %v", overrides)
+	}
+
+	// with neither, nothing is overridden
+	if overrides, err := (&Runner{}).containerOverrides(context.Background(), td, nil); err != nil || len(overrides) > 0 {
+		t.Fatalf("Expected no overrides, got %v, %v", overrides, err)
+	}
+
+	td.Containers = append(td.Containers, &ecs.ContainerDefinition{Name: aws.String("sidecar")})
+	if _, err := (&Runner{}).containerOverrides(context.Background(), td, []string{"STAGE=staging"}); err == nil {
+		t.Fatal("Expected an error without a service for the environment")
+	}
+	overrides, err = (&Runner{Service: "sidecar"}).containerOverrides(context.Background(), td, []string{"STAGE=staging"})
+	if err != nil || aws.StringValue(overrides[0].Name) != "sidecar" {
+		t.Fatalf("Expected the environment on the service, got %v, %v", overrides, err)
+	}
+}
+
+func TestRunTaskBatches(t *testing.T) {
+	for _, tc := range []struct {
+		count    int64
+		expected []int64
+	}{
+		{0, nil},
+		{1, []int64{1}},
+		{10, []int64{10}},
+		{11, []int64{10, 1}},
+		{25, []int64{10, 10, 5}},
+	} {
+		batches := runTaskBatches(tc.count)
+		if len(batches) != len(tc.expected) {
+			t.Fatalf("Expected %v batches for %d, got %v", tc.expected, tc.count, batches)
+		}
+		for i := range batches {
+			if batches[i] != tc.expected[i] {
+				t.Fatalf("Expected %v batches for %d, got %v", tc.expected, tc.count, batches)
+			}
+		}
+	}
+}
+
+func TestRunTasksClientTokens(t *testing.T) {
+	var tokens []string
+	sess := fakeSession(func(req *request.Request) {
+		// generated tokens are only added to the body, not the input
+		var body struct{ ClientToken string }
+		if err := json.NewDecoder(req.GetBody()).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, body.ClientToken)
+		req.Data.(*ecs.RunTaskOutput).Tasks = []*ecs.Task{{}}
+	})
+	svc := ecs.New(sess)
+
+	input := &ecs.RunTaskInput{TaskDefinition: aws.String("app:1"), ClientToken: aws.String("build-42")}
+	if _, err := runTasks(svc, input, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runTasks(svc, input, 15); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"build-42", "build-42-0", "build-42-1"}; !reflect.DeepEqual(tokens, expected) {
+		t.Fatalf("Expected tokens %v, got %v", expected, tokens)
+	}
+
+	// without a token, the SDK generates one for each call
+	tokens = nil
+	if _, err := runTasks(svc, &ecs.RunTaskInput{TaskDefinition: aws.String("app:1")}, 15); err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[0] == "" || tokens[0] == tokens[1] {
+		t.Fatalf("Expected a different generated token for each call, got %v", tokens)
+	}
+}
+
+func TestSuffixClientToken(t *testing.T) {
+	if got := suffixClientToken("build-42", "-t1"); got != "build-42-t1" {
+		t.Errorf("Expected a short token to be suffixed, got %q", got)
+	}
+
+	long := strings.Repeat("x", maxClientTokenLength)
+	got := suffixClientToken(long, "-r12")
+	if len(got) != maxClientTokenLength || !strings.HasSuffix(got, "-r12") {
+		t.Errorf("Expected a long token to be hashed to %d characters, got %q", maxClientTokenLength, got)
+	}
+	if again := suffixClientToken(long, "-r12"); again != got {
+		t.Errorf("Expected the same token for a retry, got %q and %q", got, again)
+	}
+	if other := suffixClientToken(long, "-r13"); other[:len(other)-4] != got[:len(got)-4] {
+		t.Errorf("Expected suffixes of the same token to share its hash, got %q and %q", got, other)
+	}
+}
+
+func TestRunTasksPartialFailure(t *testing.T) {
+	var calls int
+	sess := fakeSession(func(req *request.Request) {
+		calls++
+		if calls > 1 {
+			req.Error = awserr.New(ecs.ErrCodeClientException, "Too many tasks", nil)
+			return
+		}
+		req.Data.(*ecs.RunTaskOutput).Tasks = []*ecs.Task{{TaskArn: aws.String("task/1")}}
+	})
+	svc := ecs.New(sess)
+
+	output, err := runTasks(svc, &ecs.RunTaskInput{TaskDefinition: aws.String("app:1")}, 15)
+	if err == nil {
+		t.Fatal("Expected the second batch's error")
+	}
+	if output == nil || len(output.Tasks) != 1 {
+		t.Fatalf("Expected the first batch's tasks with the error, got %v", output)
+	}
+}
+
+func TestStopFailedTasks(t *testing.T) {
+	var stopped []string
+	sess := fakeSession(func(req *request.Request) {
+		in := req.Params.(*ecs.StopTaskInput)
+		if !strings.HasSuffix(aws.StringValue(in.Reason), ": not all tasks passed the health check") {
+			t.Errorf("Expected the cause in the stopped reason, got %q", aws.StringValue(in.Reason))
+		}
+		stopped = append(stopped, aws.StringValue(in.Task))
+	})
+
+	// the tasks are stopped even if the run was cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var stderr strings.Builder
+	r := &Runner{Stderr: &stderr}
+	tasks := []*ecs.Task{{TaskArn: aws.String("task/1")}, {TaskArn: aws.String("task/2")}}
+	r.stopFailedTasks(ctx, ecs.New(sess), tasks, "not all tasks passed the health check")
+	if !reflect.DeepEqual(stopped, []string{"task/1", "task/2"}) {
+		t.Errorf("Expected both tasks to be stopped, got %v", stopped)
+	}
+	if expected := "Stopping 2 tasks as not all tasks passed the health check\n"; stderr.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, stderr.String())
+	}
+}
+
+func TestStreamsLogsFor(t *testing.T) {
+	r := &Runner{}
+	if !r.streamsLogsFor("app") {
+		t.Fatal("Expected logs to be streamed for every container by default")
+	}
+
+	r.LogContainers = []string{"app"}
+	if !r.streamsLogsFor("app") {
+		t.Fatal("Expected logs to be streamed for a selected container")
+	}
+	if r.streamsLogsFor("envoy") {
+		t.Fatal("Expected logs not to be streamed for an unselected container")
+	}
+}
+
+func TestContainerStopReason(t *testing.T) {
+	task := &ecs.Task{StoppedReason: aws.String("Essential container in task exited")}
+
+	if reason := containerStopReason(task, &ecs.Container{Reason: aws.String("CannotPullContainerError")}); reason != "CannotPullContainerError" {
+		t.Fatalf("Expected the container's reason, got %q", reason)
+	}
+	if reason := containerStopReason(task, &ecs.Container{}); reason != "Essential container in task exited" {
+		t.Fatalf("Expected the task's reason, got %q", reason)
+	}
+	if reason := containerStopReason(&ecs.Task{}, &ecs.Container{}); reason != "unknown reason" {
+		t.Fatalf("Expected an unknown reason, got %q", reason)
+	}
+}
+
+func TestInterpolateCommand(t *testing.T) {
+	env := []*ecs.KeyValuePair{
+		{Name: aws.String("BUILD_ID"), Value: aws.String("123")},
+	}
+	environ := []string{"BUILD_ID=host", "USER=llama"}
+
+	command, err := interpolateCommand(
+		[]string{"./run.sh", "${BUILD_ID}", "$USER", "$$HOME", "${MISSING}"},
+		env, environ,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"./run.sh", "123", "llama", "$HOME", ""}
+	if !reflect.DeepEqual(command, expected) {
+		t.Fatalf("Expected %q, got %q", expected, command)
+	}
+}
+
+func TestContainerHasStarted(t *testing.T) {
+	for _, tc := range []struct {
+		container *ecs.Container
+		expected  bool
+	}{
+		{&ecs.Container{LastStatus: aws.String("PENDING")}, false},
+		{&ecs.Container{LastStatus: aws.String("RUNNING")}, true},
+		{&ecs.Container{LastStatus: aws.String("STOPPED"), ExitCode: aws.Int64(1)}, true},
+		{&ecs.Container{LastStatus: aws.String("STOPPED")}, false},
+	} {
+		if actual := containerHasStarted(tc.container); actual != tc.expected {
+			t.Errorf("Expected %v for %s container, got %v", tc.expected, *tc.container.LastStatus, actual)
+		}
+	}
+}
+
+func TestStartTaskInput(t *testing.T) {
+	input := startTaskInput(&ecs.RunTaskInput{
+		Cluster:        aws.String("default"),
+		TaskDefinition: aws.String("myjob:3"),
+		LaunchType:     aws.String("EC2"),
+		Overrides:      &ecs.TaskOverride{},
+		StartedBy:      aws.String("ci"),
+	}, []string{"0123456789abcdef0"})
+
+	if *input.Cluster != "default" || *input.TaskDefinition != "myjob:3" || *input.StartedBy != "ci" || input.Overrides == nil {
+		t.Fatalf("Unexpected input %v", input)
+	}
+	if len(input.ContainerInstances) != 1 || *input.ContainerInstances[0] != "0123456789abcdef0" {
+		t.Fatalf("Unexpected container instances %v", input.ContainerInstances)
+	}
+}
+
+func TestRunnerCanBeRunConcurrently(t *testing.T) {
+	if testing.Short() {
+		t.Skip("simulated runs wait for logs")
+	}
+
+	var mu sync.Mutex
+	runIDs := map[string]bool{}
+	r := New()
+	r.Config = aws.NewConfig().WithRegion("us-east-1")
+	r.TaskDefinitionFile = "../examples/helloworld/taskdefinition.json"
+	r.Cluster = "default"
+	r.LogGroupName = "ecs-task-runner"
+	r.Count = 1
+	r.Simulate = SimulateTimeout
+	r.Logger = LoggerFunc(func(e LogEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		runIDs[e.RunID] = true
+	})
+	r.Stdout, r.Stderr = ioutil.Discard, ioutil.Discard
+
+	errs := make([]error, 3)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = r.Run(context.Background())
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if ExitCode(err) != ExitCodeTimeout {
+			t.Errorf("Expected each run to time out, got %v", err)
+		}
+	}
+	if len(runIDs) != len(errs) {
+		t.Errorf("Expected each run to have its own ID, got %v", runIDs)
+	}
+	if r.WaitForStableService != "" || r.logScope.fields.RunID != "" {
+		t.Errorf("Expected runs to leave the runner unchanged, got %+v", r)
+	}
+}
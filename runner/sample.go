@@ -0,0 +1,70 @@
+package runner
+
+import "fmt"
+
+// logSampler counts the lines and bytes of a log stream, and once a stream has
+// more than a maximum number of lines, prints only the first and last of them
+// so that a runaway task doesn't flood CI logs
+type logSampler struct {
+	max       int64
+	printLine func(string)
+
+	Lines int64
+	Bytes int64
+
+	tail []string
+	next int
+}
+
+func newLogSampler(max int64, printLine func(string)) *logSampler {
+	return &logSampler{max: max, printLine: printLine}
+}
+
+func (s *logSampler) head() int64 {
+	return (s.max + 1) / 2
+}
+
+// Println prints lines up to the head of the stream, keeping the rest in a
+// ring buffer of the most recent lines to print when the stream closes
+func (s *logSampler) Println(line string) {
+	s.Lines++
+	s.Bytes += int64(len(line))
+
+	if s.max <= 0 || s.Lines <= s.head() {
+		s.printLine(line)
+		return
+	}
+
+	size := int(s.max - s.head())
+	if s.Lines == s.head()+1 {
+		s.printLine(fmt.Sprintf("... output after %d lines is held back until the stream finishes, and limited to the last %d lines ...", s.head(), size))
+	}
+	if size == 0 {
+		return
+	}
+	if len(s.tail) < size {
+		s.tail = append(s.tail, line)
+		return
+	}
+	s.tail[s.next] = line
+	s.next = (s.next + 1) % size
+}
+
+// Skipped returns how many lines weren't printed
+func (s *logSampler) Skipped() int64 {
+	if s.max <= 0 || s.Lines <= s.max {
+		return 0
+	}
+	return s.Lines - s.max
+}
+
+// Close prints the most recent lines that were held back
+func (s *logSampler) Close() {
+	if skipped := s.Skipped(); skipped > 0 {
+		s.printLine(fmt.Sprintf("... skipped %d lines ...", skipped))
+	}
+	for i := range s.tail {
+		s.printLine(s.tail[(s.next+i)%len(s.tail)])
+	}
+	s.tail = nil
+}
@@ -0,0 +1,50 @@
+package runner
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestLogSamplerPrintsHeadAndTail(t *testing.T) {
+	var lines []string
+	s := newLogSampler(4, func(line string) {
+		lines = append(lines, line)
+	})
+
+	for i := 1; i <= 10; i++ {
+		s.Println(fmt.Sprintf("line %d", i))
+	}
+	s.Close()
+
+	expected := []string{
+		"line 1",
+		"line 2",
+		"... output after 2 lines is held back until the stream finishes, and limited to the last 2 lines ...",
+		"... skipped 6 lines ...",
+		"line 9",
+		"line 10",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Expected %q, got %q", expected, lines)
+	}
+	if s.Lines != 10 || s.Bytes != 61 || s.Skipped() != 6 {
+		t.Fatalf("Unexpected counts: %d lines, %d bytes, %d skipped", s.Lines, s.Bytes, s.Skipped())
+	}
+}
+
+func TestLogSamplerWithoutLimit(t *testing.T) {
+	var count int
+	s := newLogSampler(0, func(line string) {
+		count++
+	})
+
+	for i := 0; i < 100; i++ {
+		s.Println("llama")
+	}
+	s.Close()
+
+	if count != 100 || s.Skipped() != 0 {
+		t.Fatalf("Expected every line to be printed, got %d", count)
+	}
+}
@@ -0,0 +1,177 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// capacityRetryInterval is how often tasks that couldn't be placed are retried
+// while waiting for a capacity provider to scale out
+const capacityRetryInterval = 15 * time.Second
+
+// isCapacityFailure returns whether a RunTask failure is from a lack of
+// container instances or their resources, which scaling out can fix
+func isCapacityFailure(f *ecs.Failure) bool {
+	reason := aws.StringValue(f.Reason)
+	return strings.HasPrefix(reason, "RESOURCE:") ||
+		strings.HasPrefix(reason, "No Container Instances were found")
+}
+
+// managedScalingProviders returns the capacity providers that tasks will be
+// placed with that scale their auto scaling group to fit tasks. Without a
+// strategy, the cluster's default capacity provider strategy is used.
+func managedScalingProviders(svc *ecs.ECS, cluster string, strategy []*ecs.CapacityProviderStrategyItem) ([]string, error) {
+	if len(strategy) == 0 {
+		var err error
+		if strategy, err = clusterDefaultStrategy(svc, cluster); err != nil {
+			return nil, err
+		}
+	}
+	if len(strategy) == 0 {
+		return nil, nil
+	}
+
+	var names []*string
+	for _, item := range strategy {
+		names = append(names, item.CapacityProvider)
+	}
+	resp, err := svc.DescribeCapacityProviders(&ecs.DescribeCapacityProvidersInput{
+		CapacityProviders: names,
+	})
+	if err != nil {
+		return nil, wrapAPIError("DescribeCapacityProviders", err)
+	}
+	return withManagedScaling(resp.CapacityProviders), nil
+}
+
+// clusterDefaultStrategy returns the cluster's default capacity provider
+// strategy, which places tasks run without a launch type or strategy
+func clusterDefaultStrategy(svc *ecs.ECS, cluster string) ([]*ecs.CapacityProviderStrategyItem, error) {
+	resp, err := svc.DescribeClusters(&ecs.DescribeClustersInput{
+		Clusters: aws.StringSlice([]string{cluster}),
+	})
+	if err != nil {
+		return nil, wrapAPIError("DescribeClusters", err)
+	}
+	for _, c := range resp.Clusters {
+		return c.DefaultCapacityProviderStrategy, nil
+	}
+	return nil, nil
+}
+
+func withManagedScaling(providers []*ecs.CapacityProvider) []string {
+	var names []string
+	for _, cp := range providers {
+		asg := cp.AutoScalingGroupProvider
+		if asg != nil && asg.ManagedScaling != nil &&
+			aws.StringValue(asg.ManagedScaling.Status) == ecs.ManagedScalingStatusEnabled {
+			names = append(names, aws.StringValue(cp.Name))
+		}
+	}
+	return names
+}
+
+// runTasksWaitingForCapacity runs tasks, retrying those that couldn't be placed
+// for lack of capacity while a capacity provider with managed scaling adds
+// container instances, until they've all been placed or the timeout passes.
+// The tasks already started are returned with an error.
+func (r *Runner) runTasksWaitingForCapacity(ctx context.Context, svc *ecs.ECS, input *ecs.RunTaskInput, count int64) (*ecs.RunTaskOutput, error) {
+	output, err := runTasks(svc, input, count)
+	if err != nil || !hasCapacityFailures(output.Failures) {
+		return output, err
+	}
+
+	if aws.StringValue(input.LaunchType) != "" {
+		fmt.Fprintf(r.Stderr, "Not waiting for capacity with the %s launch type\n", aws.StringValue(input.LaunchType))
+		return output, nil
+	}
+	providers, err := managedScalingProviders(svc, r.Cluster, input.CapacityProviderStrategy)
+	if err != nil {
+		return output, err
+	}
+	if len(providers) == 0 {
+		fmt.Fprintf(r.Stderr, "Not waiting for capacity as no capacity providers in cluster %s use managed scaling\n", r.Cluster)
+		return output, nil
+	}
+
+	instances, err := activeContainerInstances(svc, r.Cluster)
+	if err != nil {
+		return output, err
+	}
+	registered := map[string]bool{}
+	for _, ci := range instances {
+		registered[aws.StringValue(ci.ContainerInstanceArn)] = true
+	}
+
+	deadline := time.Now().Add(r.WaitForCapacity)
+	for attempt := 1; hasCapacityFailures(output.Failures); attempt++ {
+		remaining := count - int64(len(output.Tasks))
+		if time.Now().After(deadline) {
+			fmt.Fprintf(r.Stderr, "Gave up waiting for capacity for %d tasks after %v\n", remaining, r.WaitForCapacity)
+			return output, nil
+		}
+
+		fmt.Fprintf(r.Stderr, "Waiting for %s to scale out for %d tasks, %d container instances registered\n",
+			strings.Join(providers, ", "), remaining, len(registered))
+		select {
+		case <-time.After(capacityRetryInterval):
+		case <-ctx.Done():
+			return output, ctx.Err()
+		}
+
+		if instances, err = activeContainerInstances(svc, r.Cluster); err != nil {
+			return output, err
+		}
+		for _, ci := range instances {
+			arn := aws.StringValue(ci.ContainerInstanceArn)
+			if !registered[arn] {
+				fmt.Fprintf(r.Stderr, "Container instance %s registered\n", aws.StringValue(ci.Ec2InstanceId))
+				registered[arn] = true
+			}
+		}
+
+		logf(ctx, "Retrying %d tasks that couldn't be placed", remaining)
+		// a retry starts different tasks, so it can't reuse the client token
+		retry := *input
+		if input.ClientToken != nil {
+			retry.ClientToken = aws.String(suffixClientToken(*input.ClientToken, fmt.Sprintf("-r%d", attempt)))
+		}
+		resp, err := runTasks(svc, &retry, remaining)
+		output.Tasks = append(output.Tasks, resp.Tasks...)
+		if err != nil {
+			return output, err
+		}
+		output.Failures = resp.Failures
+	}
+
+	return output, nil
+}
+
+func hasCapacityFailures(failures []*ecs.Failure) bool {
+	for _, f := range failures {
+		if isCapacityFailure(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// printCapacityProviders prints how each task was placed, for when that was
+// left to the cluster's default capacity provider strategy
+func printCapacityProviders(w io.Writer, tasks []*ecs.Task) {
+	for _, task := range tasks {
+		id := path.Base(aws.StringValue(task.TaskArn))
+		if provider := aws.StringValue(task.CapacityProviderName); provider != "" {
+			fmt.Fprintf(w, "Task %s was placed by capacity provider %s\n", id, provider)
+		} else {
+			fmt.Fprintf(w, "Task %s was placed with the %s launch type\n", id, aws.StringValue(task.LaunchType))
+		}
+	}
+}
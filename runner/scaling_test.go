@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestIsCapacityFailure(t *testing.T) {
+	for reason, expected := range map[string]bool{
+		"RESOURCE:MEMORY": true,
+		"RESOURCE:CPU":    true,
+		"No Container Instances were found in your cluster.":          true,
+		"No Container Instances were found in your capacity provider": true,
+		"ATTRIBUTE": false,
+		"AGENT":     false,
+		"Task definition has an incompatible network mode for Fargate": false,
+	} {
+		if actual := isCapacityFailure(&ecs.Failure{Reason: aws.String(reason)}); actual != expected {
+			t.Errorf("Expected %q to be a capacity failure: %v, got %v", reason, expected, actual)
+		}
+	}
+}
+
+func TestWithManagedScaling(t *testing.T) {
+	providers := []*ecs.CapacityProvider{
+		{Name: aws.String("FARGATE")},
+		{
+			Name: aws.String("scaled"),
+			AutoScalingGroupProvider: &ecs.AutoScalingGroupProvider{
+				ManagedScaling: &ecs.ManagedScaling{Status: aws.String("ENABLED")},
+			},
+		},
+		{
+			Name: aws.String("fixed"),
+			AutoScalingGroupProvider: &ecs.AutoScalingGroupProvider{
+				ManagedScaling: &ecs.ManagedScaling{Status: aws.String("DISABLED")},
+			},
+		},
+	}
+	if names := withManagedScaling(providers); !reflect.DeepEqual(names, []string{"scaled"}) {
+		t.Fatalf("Expected only the scaled provider, got %v", names)
+	}
+}
+
+func TestPrintCapacityProviders(t *testing.T) {
+	var buf bytes.Buffer
+	printCapacityProviders(&buf, []*ecs.Task{
+		{TaskArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/abc"), CapacityProviderName: aws.String("spot"), LaunchType: aws.String("EC2")},
+		{TaskArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/def"), LaunchType: aws.String("FARGATE")},
+	})
+	expected := "Task abc was placed by capacity provider spot\nTask def was placed with the FARGATE launch type\n"
+	if buf.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, buf.String())
+	}
+}
@@ -0,0 +1,302 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// Stores that a task definition's secrets can come from
+const (
+	secretStoreSSM            = "ssm"
+	secretStoreSecretsManager = "secretsmanager"
+)
+
+// getParametersLimit is the most parameters that GetParameters accepts at once
+const getParametersLimit = 10
+
+var (
+	ssmParameterNamePattern = regexp.MustCompile(`^/?[a-zA-Z0-9_.-]+(/[a-zA-Z0-9_.-]+)*(:[a-zA-Z0-9_.-]+)?$`)
+	secretDeniedPattern     = regexp.MustCompile(`not authorized to perform: ([a-zA-Z0-9-]+:[a-zA-Z0-9]+)`)
+	secretMissingPattern    = regexp.MustCompile(`(?i)invalid parameters|ResourceNotFoundException|can't find the specified secret`)
+	secretFailurePattern    = regexp.MustCompile(`ResourceInitializationError`)
+)
+
+// secretRef is a secret that a container reads from Parameter Store or
+// Secrets Manager with valueFrom, in its secrets or log configuration
+type secretRef struct {
+	Container string
+	Name      string
+	ValueFrom string
+
+	Store  string
+	Region string
+
+	// ID is what the store knows the secret as: a parameter's name or ARN,
+	// or a secret's ARN without a JSON key, version stage or version ID
+	ID string
+}
+
+func (s secretRef) String() string {
+	return fmt.Sprintf("secret %s of container %s", s.Name, s.Container)
+}
+
+// containerSecretRefs returns the secrets that containers read, checking that
+// each valueFrom is a parameter name or ARN, or a Secrets Manager ARN
+func containerSecretRefs(defs []*ecs.ContainerDefinition) ([]secretRef, error) {
+	var refs []secretRef
+	add := func(container string, secrets []*ecs.Secret) error {
+		for _, secret := range secrets {
+			ref := secretRef{
+				Container: container,
+				Name:      aws.StringValue(secret.Name),
+				ValueFrom: aws.StringValue(secret.ValueFrom),
+			}
+			if err := ref.parse(); err != nil {
+				return fmt.Errorf("Invalid valueFrom of %s: %v", ref, err)
+			}
+			refs = append(refs, ref)
+		}
+		return nil
+	}
+
+	for _, def := range defs {
+		if err := add(aws.StringValue(def.Name), def.Secrets); err != nil {
+			return nil, err
+		}
+		if def.LogConfiguration != nil {
+			if err := add(aws.StringValue(def.Name), def.LogConfiguration.SecretOptions); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return refs, nil
+}
+
+// parse sets the store, region and ID of a secret from its valueFrom. Names
+// rather than ARNs are parameters in the task's region.
+func (s *secretRef) parse() error {
+	if !strings.HasPrefix(s.ValueFrom, "arn:") {
+		if !ssmParameterNamePattern.MatchString(s.ValueFrom) {
+			return fmt.Errorf("%q isn't a parameter name, or an ARN of a parameter or secret", s.ValueFrom)
+		}
+		s.Store, s.ID = secretStoreSSM, s.ValueFrom
+		return nil
+	}
+
+	a, err := arn.Parse(s.ValueFrom)
+	if err != nil {
+		return fmt.Errorf("%q isn't an ARN: %v", s.ValueFrom, err)
+	}
+	s.Region = a.Region
+
+	switch a.Service {
+	case secretStoreSSM:
+		if !strings.HasPrefix(a.Resource, "parameter/") || len(a.Resource) == len("parameter/") {
+			return fmt.Errorf("%q isn't the ARN of a parameter, which is arn:aws:ssm:REGION:ACCOUNT:parameter/NAME", s.ValueFrom)
+		}
+		s.Store, s.ID = secretStoreSSM, s.ValueFrom
+
+	case secretStoreSecretsManager:
+		// secret:NAME, optionally followed by :JSON-KEY:VERSION-STAGE:VERSION-ID
+		parts := strings.Split(a.Resource, ":")
+		if parts[0] != "secret" || len(parts) < 2 || parts[1] == "" || len(parts) > 5 {
+			return fmt.Errorf("%q isn't the ARN of a secret, which is arn:aws:secretsmanager:REGION:ACCOUNT:secret:NAME, optionally followed by :JSON-KEY:VERSION-STAGE:VERSION-ID", s.ValueFrom)
+		}
+		a.Resource = strings.Join(parts[:2], ":")
+		s.Store, s.ID = secretStoreSecretsManager, a.String()
+
+	default:
+		return fmt.Errorf("%q is an ARN in %s, but secrets can only come from Parameter Store or Secrets Manager", s.ValueFrom, a.Service)
+	}
+	return nil
+}
+
+// mentionedBy returns whether a stopped reason names the secret. Secrets
+// Manager names secrets by their full ARN, which ends in a random suffix that
+// a partial ARN leaves out, and Parameter Store by name or ARN.
+func (s secretRef) mentionedBy(reason string) bool {
+	var pattern string
+	if s.Store == secretStoreSecretsManager {
+		pattern = regexp.QuoteMeta(s.ID) + `(-[a-zA-Z0-9]{6})?([^a-zA-Z0-9_./+=@-]|$)`
+	} else {
+		name := s.ID
+		if a, err := arn.Parse(name); err == nil {
+			name = strings.TrimPrefix(a.Resource, "parameter")
+		}
+		pattern = `(^|[\s,:]|parameter)/?` + regexp.QuoteMeta(strings.TrimPrefix(name, "/")) + `([^a-zA-Z0-9_./-]|$)`
+	}
+	return regexp.MustCompile(pattern).MatchString(reason)
+}
+
+// checkSecrets checks that the secrets containers read are valid references,
+// and unless SkipSecretsCheck is set, that they exist. Secrets are read with
+// the task's execution role rather than the runner's credentials, so secrets
+// that can't be looked up are skipped rather than failing the run.
+func (r *Runner) checkSecrets(sess *session.Session, defs []*ecs.ContainerDefinition) error {
+	refs, err := containerSecretRefs(defs)
+	if err != nil || len(refs) == 0 || r.SkipSecretsCheck {
+		return err
+	}
+
+	var missing []string
+	for _, ref := range r.missingSecrets(sess, refs) {
+		missing = append(missing, fmt.Sprintf("%s reads %s, which doesn't exist", ref, ref.ValueFrom))
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Tasks would fail to start as their secrets don't exist: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// missingSecrets returns the secrets that their stores say don't exist
+func (r *Runner) missingSecrets(sess *session.Session, refs []secretRef) []secretRef {
+	var missing []secretRef
+
+	// parameters are looked up in batches in each region, without their values
+	// being decrypted
+	params := map[string][]secretRef{}
+	var regions []string
+	for _, ref := range refs {
+		if ref.Store != secretStoreSSM {
+			continue
+		}
+		if _, ok := params[ref.Region]; !ok {
+			regions = append(regions, ref.Region)
+		}
+		params[ref.Region] = append(params[ref.Region], ref)
+	}
+	for _, region := range regions {
+		svc := ssm.New(sess, regionConfig(region))
+		batch := params[region]
+		for len(batch) > 0 {
+			n := len(batch)
+			if n > getParametersLimit {
+				n = getParametersLimit
+			}
+			var names []string
+			for _, ref := range batch[:n] {
+				names = append(names, ref.ID)
+			}
+			r.logf("Checking parameters %s exist", strings.Join(names, ", "))
+			resp, err := svc.GetParameters(&ssm.GetParametersInput{
+				Names:          aws.StringSlice(names),
+				WithDecryption: aws.Bool(false),
+			})
+			if err != nil {
+				r.logf("Skipping secrets check of parameters: %v", wrapAPIError("GetParameters", err))
+			} else {
+				invalid := map[string]bool{}
+				for _, name := range resp.InvalidParameters {
+					invalid[aws.StringValue(name)] = true
+				}
+				for _, ref := range batch[:n] {
+					if invalid[ref.ID] {
+						missing = append(missing, ref)
+					}
+				}
+			}
+			batch = batch[n:]
+		}
+	}
+
+	checked := map[string]bool{}
+	for _, ref := range refs {
+		if ref.Store != secretStoreSecretsManager || checked[ref.ID] {
+			continue
+		}
+		checked[ref.ID] = true
+		r.logf("Checking secret %s exists", ref.ID)
+		_, err := secretsmanager.New(sess, regionConfig(ref.Region)).DescribeSecret(&secretsmanager.DescribeSecretInput{
+			SecretId: aws.String(ref.ID),
+		})
+		var aerr awserr.Error
+		switch {
+		case err == nil:
+		case errors.As(err, &aerr) && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException:
+			for _, other := range refs {
+				if other.ID == ref.ID {
+					missing = append(missing, other)
+				}
+			}
+		default:
+			r.logf("Skipping secrets check of %s: %v", ref.ID, wrapAPIError("DescribeSecret", err))
+		}
+	}
+	return missing
+}
+
+// regionConfig returns a config for a client in a region, or the session's
+// region if it's empty
+func regionConfig(region string) *aws.Config {
+	config := aws.NewConfig()
+	if region != "" {
+		config.WithRegion(region)
+	}
+	return config
+}
+
+// printSecretFailures explains tasks that failed to start because one of their
+// secrets couldn't be fetched, naming the secret, which the stopped reason
+// only gives as an ARN or parameter name, if at all. It returns the containers
+// whose secrets were named.
+func (r *Runner) printSecretFailures(w io.Writer, td *preparedTaskDefinition, tasks []*ecs.Task) map[string]bool {
+	explained := map[string]bool{}
+	refs, err := containerSecretRefs(td.Containers)
+	if err != nil || len(refs) == 0 {
+		return explained
+	}
+
+	seen := map[string]bool{}
+	for _, task := range tasks {
+		reasons := []string{aws.StringValue(task.StoppedReason)}
+		for _, container := range task.Containers {
+			reasons = append(reasons, aws.StringValue(container.Reason))
+		}
+		for _, reason := range reasons {
+			if !secretFailurePattern.MatchString(reason) {
+				continue
+			}
+			for _, ref := range refs {
+				key := ref.Container + "/" + ref.Name
+				if seen[key] || !ref.mentionedBy(reason) {
+					continue
+				}
+				seen[key] = true
+				explained[ref.Container] = true
+				why, fix := secretFailureCause(reason, ref)
+				fmt.Fprintf(w, "Task failed to start because %s, from %s, %s. To fix it, %s.\n", ref, ref.ValueFrom, why, fix)
+			}
+		}
+	}
+	return explained
+}
+
+// secretFailureCause explains why a secret couldn't be fetched from the
+// stopped reason that names it, and how to fix it
+func secretFailureCause(reason string, ref secretRef) (string, string) {
+	if m := secretDeniedPattern.FindStringSubmatch(reason); m != nil {
+		if strings.HasPrefix(m[1], "kms:") {
+			return fmt.Sprintf("couldn't be decrypted as the execution role isn't allowed %s on its KMS key", m[1]),
+				fmt.Sprintf("allow %s on the key in the execution role's policy, and the role in the key's policy", m[1])
+		}
+		return fmt.Sprintf("couldn't be fetched as the execution role isn't allowed %s on it", m[1]),
+			fmt.Sprintf("allow %s on %s in the execution role's policy", m[1], ref.ID)
+	}
+	if secretMissingPattern.MatchString(reason) {
+		return "doesn't exist",
+			"create it, or fix its valueFrom, which must be a full ARN for a secret or a parameter in another region"
+	}
+	return "couldn't be fetched",
+		"check it exists and that the execution role can read it"
+}
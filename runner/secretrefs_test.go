@@ -0,0 +1,228 @@
+package runner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestSecretRefParse(t *testing.T) {
+	for _, tc := range []struct {
+		ValueFrom string
+		Store     string
+		Region    string
+		ID        string
+		Err       string
+	}{
+		{ValueFrom: "/prod/db/password", Store: "ssm", ID: "/prod/db/password"},
+		{ValueFrom: "db-password:3", Store: "ssm", ID: "db-password:3"},
+		{
+			ValueFrom: "arn:aws:ssm:eu-west-1:123456789012:parameter/prod/db",
+			Store:     "ssm",
+			Region:    "eu-west-1",
+			ID:        "arn:aws:ssm:eu-west-1:123456789012:parameter/prod/db",
+		},
+		{
+			ValueFrom: "arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db-AbCdEf:password::",
+			Store:     "secretsmanager",
+			Region:    "us-east-1",
+			ID:        "arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db-AbCdEf",
+		},
+		{ValueFrom: "prod db password", Err: "isn't a parameter name"},
+		{ValueFrom: "arn:aws:ssm:us-east-1:123456789012:document/prod", Err: "isn't the ARN of a parameter"},
+		{ValueFrom: "arn:aws:secretsmanager:us-east-1:123456789012:prod/db", Err: "isn't the ARN of a secret"},
+		{ValueFrom: "arn:aws:s3:::my-bucket/secret", Err: "only come from Parameter Store or Secrets Manager"},
+		{ValueFrom: "arn:aws:ssm", Err: "isn't an ARN"},
+	} {
+		t.Run(tc.ValueFrom, func(t *testing.T) {
+			ref := secretRef{ValueFrom: tc.ValueFrom}
+			err := ref.parse()
+			if tc.Err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.Err) {
+					t.Fatalf("Expected an error containing %q, got %v", tc.Err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ref.Store != tc.Store || ref.Region != tc.Region || ref.ID != tc.ID {
+				t.Errorf("Expected %s %q %q, got %s %q %q", tc.Store, tc.Region, tc.ID, ref.Store, ref.Region, ref.ID)
+			}
+		})
+	}
+}
+
+func TestContainerSecretRefs(t *testing.T) {
+	refs, err := containerSecretRefs([]*ecs.ContainerDefinition{{
+		Name:    aws.String("app"),
+		Secrets: []*ecs.Secret{{Name: aws.String("DB_PASSWORD"), ValueFrom: aws.String("/prod/db")}},
+		LogConfiguration: &ecs.LogConfiguration{
+			LogDriver:     aws.String("splunk"),
+			SecretOptions: []*ecs.Secret{{Name: aws.String("splunk-token"), ValueFrom: aws.String("/prod/splunk")}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[1].Name != "splunk-token" {
+		t.Errorf("Expected the log configuration's secret options to be included, got %+v", refs)
+	}
+
+	_, err = containerSecretRefs([]*ecs.ContainerDefinition{{
+		Name:    aws.String("app"),
+		Secrets: []*ecs.Secret{{Name: aws.String("API_KEY"), ValueFrom: aws.String("arn:aws:s3:::keys/api")}},
+	}})
+	if err == nil || !strings.Contains(err.Error(), "secret API_KEY of container app") {
+		t.Errorf("Expected the invalid secret to be named, got %v", err)
+	}
+}
+
+func TestCheckSecrets(t *testing.T) {
+	const (
+		found   = "arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/found-AbCdEf"
+		missing = "arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/missing"
+	)
+	var calls []string
+	sess := fakeSession(func(req *request.Request) {
+		calls = append(calls, req.Operation.Name)
+		switch params := req.Params.(type) {
+		case *ssm.GetParametersInput:
+			if aws.BoolValue(params.WithDecryption) {
+				t.Errorf("Expected parameters not to be decrypted")
+			}
+			req.Data.(*ssm.GetParametersOutput).InvalidParameters = aws.StringSlice([]string{"/prod/missing"})
+		case *secretsmanager.DescribeSecretInput:
+			if aws.StringValue(params.SecretId) == missing {
+				req.Error = awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "Secrets Manager can't find the specified secret.", nil)
+			}
+		}
+	})
+
+	defs := []*ecs.ContainerDefinition{{
+		Name: aws.String("app"),
+		Secrets: []*ecs.Secret{
+			{Name: aws.String("FOUND_PARAM"), ValueFrom: aws.String("/prod/found")},
+			{Name: aws.String("MISSING_PARAM"), ValueFrom: aws.String("/prod/missing")},
+			{Name: aws.String("FOUND_SECRET"), ValueFrom: aws.String(found + ":password::")},
+			{Name: aws.String("MISSING_SECRET"), ValueFrom: aws.String(missing)},
+		},
+	}}
+
+	var stderr bytes.Buffer
+	r := &Runner{Stderr: &stderr}
+	err := r.checkSecrets(sess, defs)
+	if err == nil {
+		t.Fatal("Expected the missing secrets to fail the check")
+	}
+	for _, want := range []string{"secret MISSING_PARAM of container app reads /prod/missing", "secret MISSING_SECRET of container app reads " + missing} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "FOUND") {
+		t.Errorf("Expected secrets that exist not to be reported, got %v", err)
+	}
+
+	calls = nil
+	r.SkipSecretsCheck = true
+	if err := r.checkSecrets(sess, defs); err != nil || len(calls) > 0 {
+		t.Errorf("Expected no checks with SkipSecretsCheck, got %v after %v", err, calls)
+	}
+}
+
+func TestCheckSecretsSkipsDenied(t *testing.T) {
+	sess := fakeSession(func(req *request.Request) {
+		req.Error = awserr.New("AccessDeniedException", "not authorized", nil)
+	})
+
+	r := &Runner{}
+	err := r.checkSecrets(sess, []*ecs.ContainerDefinition{{
+		Name: aws.String("app"),
+		Secrets: []*ecs.Secret{
+			{Name: aws.String("PARAM"), ValueFrom: aws.String("/prod/param")},
+			{Name: aws.String("SECRET"), ValueFrom: aws.String("arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db")},
+		},
+	}})
+	if err != nil {
+		t.Errorf("Expected secrets that can't be looked up to be skipped, got %v", err)
+	}
+}
+
+func TestPrintSecretFailures(t *testing.T) {
+	td := &preparedTaskDefinition{Containers: []*ecs.ContainerDefinition{{
+		Name: aws.String("app"),
+		Secrets: []*ecs.Secret{
+			{Name: aws.String("DB_PASSWORD"), ValueFrom: aws.String("arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db:password::")},
+			{Name: aws.String("DB_PASSWORD_OLD"), ValueFrom: aws.String("arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db-old")},
+			{Name: aws.String("API_KEY"), ValueFrom: aws.String("/prod/api-key")},
+			{Name: aws.String("API_KEY_V2"), ValueFrom: aws.String("/prod/api-key-v2")},
+		},
+	}}}
+
+	for _, tc := range []struct {
+		Name   string
+		Reason string
+		Want   string
+		Not    string
+	}{
+		{
+			Name: "secrets manager access denied",
+			Reason: "ResourceInitializationError: unable to pull secrets or registry auth: execution resource retrieval failed: " +
+				"unable to retrieve secret from asm: service call has been retried 1 time(s): failed to fetch secret " +
+				"arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db-AbCdEf from secrets manager: AccessDeniedException: " +
+				"User: arn:aws:sts::123456789012:assumed-role/ecsTaskExecutionRole/abc is not authorized to perform: " +
+				"secretsmanager:GetSecretValue on resource: arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db-AbCdEf",
+			Want: "secret DB_PASSWORD of container app, from arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db:password::, " +
+				"couldn't be fetched as the execution role isn't allowed secretsmanager:GetSecretValue on it",
+			Not: "DB_PASSWORD_OLD",
+		},
+		{
+			Name: "parameter missing",
+			Reason: "ResourceInitializationError: unable to pull secrets or registry auth: execution resource retrieval failed: " +
+				"unable to retrieve secrets from ssm: invalid parameters: /prod/api-key",
+			Want: "secret API_KEY of container app, from /prod/api-key, doesn't exist",
+			Not:  "API_KEY_V2",
+		},
+		{
+			Name: "parameter key denied",
+			Reason: "ResourceInitializationError: unable to pull secrets or registry auth: execution resource retrieval failed: " +
+				"unable to retrieve secrets from ssm: AccessDeniedException: User: arn:aws:sts::123456789012:assumed-role/ecsTaskExecutionRole/abc " +
+				"is not authorized to perform: kms:Decrypt on resource: arn:aws:kms:us-east-1:123456789012:key/abc for " +
+				"arn:aws:ssm:us-east-1:123456789012:parameter/prod/api-key-v2",
+			Want: "secret API_KEY_V2 of container app, from /prod/api-key-v2, couldn't be decrypted as the execution role isn't allowed kms:Decrypt on its KMS key",
+			Not:  "API_KEY,",
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			r := &Runner{}
+			r.printSecretFailures(&buf, td, []*ecs.Task{
+				{TaskArn: aws.String("task/1"), StoppedReason: aws.String(tc.Reason)},
+				{TaskArn: aws.String("task/2"), StoppedReason: aws.String(tc.Reason)},
+			})
+			if !strings.Contains(buf.String(), tc.Want) {
+				t.Errorf("Expected %q, got %q", tc.Want, buf.String())
+			}
+			if strings.Contains(buf.String(), tc.Not) {
+				t.Errorf("Expected %s not to be blamed, got %q", tc.Not, buf.String())
+			}
+			if n := strings.Count(buf.String(), "\n"); n != 1 {
+				t.Errorf("Expected the secret to be reported once, got %q", buf.String())
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	(&Runner{}).printSecretFailures(&buf, td, []*ecs.Task{{StoppedReason: aws.String("Essential container in task exited")}})
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing for other failures, got %q", buf.String())
+	}
+}
@@ -0,0 +1,222 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// SecretResolver fetches secrets from a secret store as KEY=value environment
+// variables for the task's command override. The reference is whatever
+// follows the resolver's scheme in `SCHEME:REF`, such as `/myapp/*` for
+// `ssm:/myapp/*`.
+type SecretResolver interface {
+	Resolve(ctx context.Context, ref string) ([]string, error)
+}
+
+// SecretResolverFunc adapts a function to a SecretResolver
+type SecretResolverFunc func(ctx context.Context, ref string) ([]string, error)
+
+func (f SecretResolverFunc) Resolve(ctx context.Context, ref string) ([]string, error) {
+	return f(ctx, ref)
+}
+
+// secretResolvers returns the built-in resolvers by scheme, replaced or added
+// to by the runner's own
+func (r *Runner) secretResolvers(sess *session.Session) map[string]SecretResolver {
+	resolvers := map[string]SecretResolver{
+		"ssm":            &ssmResolver{svc: ssm.New(sess)},
+		"secretsmanager": &secretsManagerResolver{svc: secretsmanager.New(sess)},
+		"vault":          &vaultResolver{addr: os.Getenv("VAULT_ADDR"), token: os.Getenv("VAULT_TOKEN"), client: http.DefaultClient},
+		"sops":           &sopsResolver{command: "sops"},
+	}
+	for scheme, resolver := range r.SecretResolvers {
+		resolvers[scheme] = resolver
+	}
+	return resolvers
+}
+
+// resolveSecrets fetches SSMEnvironment and SecretEnvironment as environment
+// variables, in that order
+func (r *Runner) resolveSecrets(ctx context.Context, sess *session.Session) ([]string, error) {
+	if len(r.SSMEnvironment) == 0 && len(r.SecretEnvironment) == 0 {
+		return nil, nil
+	}
+
+	resolvers := r.secretResolvers(sess)
+	var refs []string
+	for _, param := range r.SSMEnvironment {
+		refs = append(refs, "ssm:"+param)
+	}
+	refs = append(refs, r.SecretEnvironment...)
+
+	var env []string
+	for _, ref := range refs {
+		parts := strings.SplitN(ref, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Secret %q should be in the form SCHEME:REF", ref)
+		}
+		resolver, ok := resolvers[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("No secret resolver for %q in %q", parts[0], ref)
+		}
+		secrets, err := resolver.Resolve(ctx, parts[1])
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, secrets...)
+	}
+	return env, nil
+}
+
+// splitSecretRef splits an optional explicit variable name from a reference
+// in the form `KEY=REF`
+func splitSecretRef(ref string) (name, id string) {
+	if parts := strings.SplitN(ref, "=", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "", ref
+}
+
+// secretFields returns each top-level field of a JSON object as a KEY=value
+// variable, sorted by key. Values that aren't strings are used as JSON.
+func secretFields(data []byte) ([]string, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	var env []string
+	for key, value := range fields {
+		if s, ok := value.(string); ok {
+			env = append(env, key+"="+s)
+			continue
+		}
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, key+"="+string(b))
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// ssmResolver resolves SSM parameters, as with --ssm-env
+type ssmResolver struct {
+	svc ssmInterface
+}
+
+func (s *ssmResolver) Resolve(ctx context.Context, ref string) ([]string, error) {
+	return ssmEnv(s.svc, []string{ref})
+}
+
+type secretsManagerInterface interface {
+	GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// secretsManagerResolver resolves Secrets Manager secrets. With `KEY=SECRET`
+// the secret is one variable, otherwise a secret that's a JSON object is a
+// variable for each field, and any other secret is named after the last part
+// of its name.
+type secretsManagerResolver struct {
+	svc secretsManagerInterface
+}
+
+func (s *secretsManagerResolver) Resolve(ctx context.Context, ref string) ([]string, error) {
+	name, id := splitSecretRef(ref)
+
+	logf(ctx, "Fetching secret %s", id)
+	resp, err := s.svc.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return nil, wrapAPIError("GetSecretValue", err)
+	}
+	value := aws.StringValue(resp.SecretString)
+
+	if name == "" {
+		if env, err := secretFields([]byte(value)); err == nil {
+			return env, nil
+		}
+		name = path.Base(id)
+	}
+	return []string{name + "=" + value}, nil
+}
+
+// vaultResolver resolves every field of a Vault secret by its API path, such
+// as `secret/data/myapp` for a KV version 2 secret
+type vaultResolver struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+func (v *vaultResolver) Resolve(ctx context.Context, ref string) ([]string, error) {
+	if v.addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR must be set to fetch Vault secret %s", ref)
+	}
+
+	logf(ctx, "Fetching Vault secret %s", ref)
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(v.addr, "/")+"/v1/"+strings.TrimPrefix(ref, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+
+	resp, err := v.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch Vault secret %s: %v", ref, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to fetch Vault secret %s: %s", ref, resp.Status)
+	}
+
+	var secret struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("Failed to parse Vault secret %s: %v", ref, err)
+	}
+
+	// KV version 2 nests the secret's fields alongside its metadata
+	var kv2 struct {
+		Data     json.RawMessage `json:"data"`
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	if err := json.Unmarshal(secret.Data, &kv2); err == nil && kv2.Data != nil && kv2.Metadata != nil {
+		secret.Data = kv2.Data
+	}
+	return secretFields(secret.Data)
+}
+
+// sopsResolver resolves every top-level field of a file encrypted with SOPS,
+// decrypting it with the sops command
+type sopsResolver struct {
+	command string
+}
+
+func (s *sopsResolver) Resolve(ctx context.Context, ref string) ([]string, error) {
+	logf(ctx, "Decrypting %s with %s", ref, s.command)
+	out, err := exec.CommandContext(ctx, s.command, "--decrypt", "--output-type", "json", ref).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("Failed to decrypt %s: %s", ref, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("Failed to decrypt %s: %v", ref, err)
+	}
+	return secretFields(out)
+}
@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	r := New()
+	r.SSMEnvironment = []string{"/myapp/DB_URL"}
+	r.SecretEnvironment = []string{"custom:token"}
+	r.SecretResolvers = map[string]SecretResolver{
+		"ssm": SecretResolverFunc(func(ctx context.Context, ref string) ([]string, error) {
+			return []string{"DB_URL=" + ref}, nil
+		}),
+		"custom": SecretResolverFunc(func(ctx context.Context, ref string) ([]string, error) {
+			return []string{"TOKEN=" + strings.ToUpper(ref)}, nil
+		}),
+	}
+
+	env, err := r.resolveSecrets(context.Background(), session.Must(session.NewSession()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"DB_URL=/myapp/DB_URL", "TOKEN=TOKEN"}; !reflect.DeepEqual(env, expected) {
+		t.Fatalf("Expected %v, got %v", expected, env)
+	}
+
+	r.SecretEnvironment = []string{"keychain:token"}
+	if _, err := r.resolveSecrets(context.Background(), session.Must(session.NewSession())); err == nil {
+		t.Fatal("Expected an error for an unknown scheme")
+	}
+}
+
+func TestSecretsManagerResolver(t *testing.T) {
+	s := &secretsManagerResolver{svc: &mockSecretsManager{secrets: map[string]string{
+		"myapp/db":    `{"DB_USER":"app","DB_PORT":5432}`,
+		"myapp/token": "abc123",
+	}}}
+
+	for ref, expected := range map[string][]string{
+		"myapp/db":          {"DB_PORT=5432", "DB_USER=app"},
+		"myapp/token":       {"token=abc123"},
+		"DB_JSON=myapp/db":  {`DB_JSON={"DB_USER":"app","DB_PORT":5432}`},
+		"TOKEN=myapp/token": {"TOKEN=abc123"},
+	} {
+		env, err := s.Resolve(context.Background(), ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(env, expected) {
+			t.Errorf("Expected %v for %s, got %v", expected, ref, env)
+		}
+	}
+
+	if _, err := s.Resolve(context.Background(), "myapp/missing"); err == nil {
+		t.Fatal("Expected an error for a missing secret")
+	}
+}
+
+func TestVaultResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch req.URL.Path {
+		case "/v1/secret/data/myapp":
+			w.Write([]byte(`{"data":{"data":{"API_KEY":"secret"},"metadata":{"version":3}}}`))
+		case "/v1/kv/myapp":
+			w.Write([]byte(`{"data":{"API_KEY":"v1secret"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	v := &vaultResolver{addr: server.URL, token: "s.token", client: server.Client()}
+	for ref, expected := range map[string]string{
+		"secret/data/myapp": "API_KEY=secret",
+		"kv/myapp":          "API_KEY=v1secret",
+	} {
+		env, err := v.Resolve(context.Background(), ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(env, []string{expected}) {
+			t.Errorf("Expected %s for %s, got %v", expected, ref, env)
+		}
+	}
+
+	if _, err := v.Resolve(context.Background(), "secret/data/missing"); err == nil {
+		t.Fatal("Expected an error for a missing secret")
+	}
+}
+
+type mockSecretsManager struct {
+	secrets map[string]string
+}
+
+func (m *mockSecretsManager) GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	value, ok := m.secrets[*input.SecretId]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "not found", nil)
+	}
+	return &secretsmanager.GetSecretValueOutput{Name: input.SecretId, SecretString: aws.String(value)}, nil
+}
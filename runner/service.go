@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// ParseServiceRef splits a service reference in the form `cluster/service`
+// into its cluster and service. The cluster is empty if the reference is just
+// a service name, or a service ARN that doesn't include the cluster.
+func ParseServiceRef(ref string) (cluster, service string) {
+	if a, err := arn.Parse(ref); err == nil {
+		parts := strings.Split(a.Resource, "/")
+		if len(parts) == 3 {
+			return parts[1], ref
+		}
+		return "", ref
+	}
+	if parts := strings.SplitN(ref, "/", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "", ref
+}
+
+// describeService finds a service in a cluster
+func describeService(svc *ecs.ECS, cluster, service string) (*ecs.Service, error) {
+	log.Printf("Describing service %s in %s", service, cluster)
+	resp, err := svc.DescribeServices(&ecs.DescribeServicesInput{
+		Cluster:  aws.String(cluster),
+		Services: aws.StringSlice([]string{service}),
+	})
+	if err != nil {
+		return nil, wrapAPIError("DescribeServices", err)
+	}
+
+	for _, failure := range resp.Failures {
+		return nil, fmt.Errorf("Failed to describe service %s: %s",
+			service, aws.StringValue(failure.Reason))
+	}
+	if len(resp.Services) == 0 {
+		return nil, fmt.Errorf("No service %s in cluster %s", service, cluster)
+	}
+
+	s := resp.Services[0]
+	if aws.StringValue(s.TaskDefinition) == "" {
+		return nil, fmt.Errorf("Service %s has no task definition, services using task sets aren't supported", service)
+	}
+
+	return s, nil
+}
+
+// applyServiceConfiguration runs tasks the same way a service does
+func applyServiceConfiguration(input *ecs.RunTaskInput, service *ecs.Service) {
+	input.NetworkConfiguration = service.NetworkConfiguration
+	input.LaunchType = service.LaunchType
+	input.CapacityProviderStrategy = service.CapacityProviderStrategy
+	input.PlatformVersion = service.PlatformVersion
+	input.PlacementConstraints = service.PlacementConstraints
+	input.PlacementStrategy = service.PlacementStrategy
+}
+
+const defaultStableServicePollInterval = 10 * time.Second
+
+// serviceIsStable returns whether a service has finished deploying, along with
+// a description of its progress if not
+func serviceIsStable(s *ecs.Service) (bool, string) {
+	if len(s.Deployments) > 1 {
+		return false, fmt.Sprintf("%d deployments in progress", len(s.Deployments))
+	}
+	for _, d := range s.Deployments {
+		if aws.StringValue(d.RolloutState) == ecs.DeploymentRolloutStateInProgress {
+			return false, fmt.Sprintf("deployment %s is in progress", aws.StringValue(d.Id))
+		}
+	}
+	if aws.Int64Value(s.RunningCount) != aws.Int64Value(s.DesiredCount) {
+		return false, fmt.Sprintf("%d of %d tasks running",
+			aws.Int64Value(s.RunningCount), aws.Int64Value(s.DesiredCount))
+	}
+	return true, ""
+}
+
+// waitForStableService blocks until a service has no deployments in progress
+func (r *Runner) waitForStableService(ctx context.Context, svc *ecs.ECS, ref string) error {
+	cluster, service := ParseServiceRef(ref)
+	if cluster == "" {
+		cluster = r.Cluster
+	}
+
+	if r.StableServiceTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.StableServiceTimeout)
+		defer cancel()
+	}
+
+	var lastProgress string
+	for {
+		s, err := describeService(svc, cluster, service)
+		if err != nil {
+			return err
+		}
+
+		stable, progress := serviceIsStable(s)
+		if stable {
+			logf(ctx, "Service %s is stable", service)
+			return nil
+		}
+		if progress != lastProgress {
+			fmt.Fprintf(r.Stderr, "Waiting for service %s to be stable: %s\n", service, progress)
+			lastProgress = progress
+		}
+
+		select {
+		case <-time.After(defaultStableServicePollInterval):
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return &TimeoutError{fmt.Errorf("Timed out waiting for service %s to be stable: %s", service, progress)}
+			}
+			return ctx.Err()
+		}
+	}
+}
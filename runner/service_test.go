@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestParseServiceRef(t *testing.T) {
+	for _, tc := range []struct {
+		ref, cluster, service string
+	}{
+		{"web", "", "web"},
+		{"production/web", "production", "web"},
+		{"arn:aws:ecs:us-east-1:123456789012:service/production/web", "production", "arn:aws:ecs:us-east-1:123456789012:service/production/web"},
+		{"arn:aws:ecs:us-east-1:123456789012:service/web", "", "arn:aws:ecs:us-east-1:123456789012:service/web"},
+	} {
+		cluster, service := ParseServiceRef(tc.ref)
+		if cluster != tc.cluster || service != tc.service {
+			t.Fatalf("Expected %q to be cluster %q and service %q, got %q and %q",
+				tc.ref, tc.cluster, tc.service, cluster, service)
+		}
+	}
+}
+
+func TestServiceIsStable(t *testing.T) {
+	for _, tc := range []struct {
+		service  *ecs.Service
+		stable   bool
+		progress string
+	}{
+		{
+			&ecs.Service{
+				Deployments:  []*ecs.Deployment{{Id: aws.String("ecs-svc/1"), RolloutState: aws.String("COMPLETED")}},
+				RunningCount: aws.Int64(2),
+				DesiredCount: aws.Int64(2),
+			},
+			true, "",
+		},
+		{
+			&ecs.Service{
+				Deployments:  []*ecs.Deployment{{Id: aws.String("ecs-svc/2")}, {Id: aws.String("ecs-svc/1")}},
+				RunningCount: aws.Int64(2),
+				DesiredCount: aws.Int64(2),
+			},
+			false, "2 deployments in progress",
+		},
+		{
+			&ecs.Service{
+				Deployments:  []*ecs.Deployment{{Id: aws.String("ecs-svc/1"), RolloutState: aws.String("IN_PROGRESS")}},
+				RunningCount: aws.Int64(2),
+				DesiredCount: aws.Int64(2),
+			},
+			false, "deployment ecs-svc/1 is in progress",
+		},
+		{
+			&ecs.Service{
+				Deployments:  []*ecs.Deployment{{Id: aws.String("ecs-svc/1")}},
+				RunningCount: aws.Int64(1),
+				DesiredCount: aws.Int64(2),
+			},
+			false, "1 of 2 tasks running",
+		},
+	} {
+		stable, progress := serviceIsStable(tc.service)
+		if stable != tc.stable || progress != tc.progress {
+			t.Fatalf("Expected %v %q, got %v %q", tc.stable, tc.progress, stable, progress)
+		}
+	}
+}
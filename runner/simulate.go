@@ -0,0 +1,279 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Failures that can be simulated, to test how callers handle them
+const (
+	SimulatePlacementFailure = "placement-failure"
+	SimulatePullError        = "pull-error"
+	SimulateOOM              = "oom"
+	SimulateTimeout          = "timeout"
+)
+
+const (
+	simulatedRegion         = "us-east-1"
+	simulatedAccount        = "000000000000"
+	simulatedServiceTimeout = 2 * time.Second
+)
+
+// simulate replaces AWS with an in-memory backend for ECS and CloudWatch Logs
+// that fails in the given way, so that each failure can be exercised end to
+// end without real infrastructure. It must be called before any clients are
+// created from the session.
+func (r *Runner) simulate(sess *session.Session) {
+	fmt.Fprintf(r.Stderr, "Simulating %s, no AWS calls will be made\n", r.Simulate)
+
+	if aws.StringValue(sess.Config.Region) == "" {
+		sess.Config.WithRegion(simulatedRegion)
+	}
+	sess.Config.WithCredentials(credentials.NewStaticCredentials("SIMULATED", "SIMULATED", ""))
+
+	b := &simulatedBackend{
+		mode:            r.Simulate,
+		region:          aws.StringValue(sess.Config.Region),
+		taskDefinitions: map[string]*ecs.TaskDefinition{},
+		streams:         map[string][]*cloudwatchlogs.OutputLogEvent{},
+	}
+	sess.Handlers.Send.Clear()
+	sess.Handlers.Send.PushBack(b.send)
+
+	if r.Simulate == SimulateTimeout {
+		if r.WaitForStableService == "" {
+			r.WaitForStableService = "simulated"
+		}
+		r.StableServiceTimeout = simulatedServiceTimeout
+	}
+}
+
+// simulatedBackend answers AWS requests from memory
+type simulatedBackend struct {
+	mode   string
+	region string
+
+	mu              sync.Mutex
+	taskDefinitions map[string]*ecs.TaskDefinition
+	tasks           []*ecs.Task
+	streams         map[string][]*cloudwatchlogs.OutputLogEvent
+}
+
+// send fills in a request's output, or fails it, instead of sending it
+func (b *simulatedBackend) send(req *request.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	log.Printf("Simulating %s", req.Operation.Name)
+	req.HTTPResponse = &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(&bytes.Buffer{}),
+	}
+	req.RequestID = "simulated-" + randomHex(8)
+
+	if err := b.handle(req.Params, req.Data); err != nil {
+		req.Error = awserr.NewRequestFailure(err, http.StatusBadRequest, req.RequestID)
+		req.Retryable = aws.Bool(false)
+	}
+}
+
+func (b *simulatedBackend) handle(params, data interface{}) awserr.Error {
+	switch in := params.(type) {
+	case *ecs.RegisterTaskDefinitionInput:
+		// ECS returns what was registered, with the fields it sets itself
+		td := &ecs.TaskDefinition{}
+		if body, err := json.Marshal(in); err == nil {
+			json.Unmarshal(body, td)
+		}
+		td.TaskDefinitionArn = aws.String(b.arn("task-definition/" + *in.Family + ":1"))
+		td.Revision = aws.Int64(1)
+		td.Status = aws.String(ecs.TaskDefinitionStatusActive)
+		b.taskDefinitions[*td.TaskDefinitionArn] = td
+		b.taskDefinitions[*in.Family+":1"] = td
+		*data.(*ecs.RegisterTaskDefinitionOutput) = ecs.RegisterTaskDefinitionOutput{TaskDefinition: td}
+
+	case *ecs.DescribeTaskDefinitionInput:
+		td, ok := b.taskDefinitions[*in.TaskDefinition]
+		if !ok {
+			return awserr.New(ecs.ErrCodeClientException, "Only registered task definitions can be simulated", nil)
+		}
+		*data.(*ecs.DescribeTaskDefinitionOutput) = ecs.DescribeTaskDefinitionOutput{TaskDefinition: td}
+
+	case *ecs.DescribeServicesInput:
+		out := data.(*ecs.DescribeServicesOutput)
+		for _, name := range in.Services {
+			out.Services = append(out.Services, &ecs.Service{
+				ServiceName:    name,
+				TaskDefinition: aws.String(b.arn("task-definition/simulated:1")),
+				Deployments:    []*ecs.Deployment{{Id: aws.String("ecs-svc/1")}, {Id: aws.String("ecs-svc/2")}},
+			})
+		}
+
+	case *ecs.RunTaskInput:
+		out := data.(*ecs.RunTaskOutput)
+		if b.mode == SimulatePlacementFailure {
+			out.Failures = []*ecs.Failure{{
+				Arn:    aws.String(b.arn("container-instance/" + aws.StringValue(in.Cluster) + "/simulated")),
+				Reason: aws.String("RESOURCE:MEMORY"),
+			}}
+			return nil
+		}
+		for i := int64(0); i < aws.Int64Value(in.Count); i++ {
+			out.Tasks = append(out.Tasks, b.startTask(in))
+		}
+
+	case *ecs.DescribeTasksInput:
+		out := data.(*ecs.DescribeTasksOutput)
+		for _, task := range b.tasks {
+			for _, arn := range in.Tasks {
+				if *task.TaskArn == *arn {
+					out.Tasks = append(out.Tasks, task)
+				}
+			}
+		}
+
+	case *ecs.StopTaskInput:
+		*data.(*ecs.StopTaskOutput) = ecs.StopTaskOutput{}
+
+	case *cloudwatchlogs.DescribeLogGroupsInput:
+		*data.(*cloudwatchlogs.DescribeLogGroupsOutput) = cloudwatchlogs.DescribeLogGroupsOutput{
+			LogGroups: []*cloudwatchlogs.LogGroup{{
+				LogGroupName: in.LogGroupNamePrefix,
+				Arn:          aws.String(fmt.Sprintf("arn:aws:logs:%s:%s:log-group:%s:*", b.region, simulatedAccount, *in.LogGroupNamePrefix)),
+			}},
+		}
+
+	case *cloudwatchlogs.TagResourceInput:
+		*data.(*cloudwatchlogs.TagResourceOutput) = cloudwatchlogs.TagResourceOutput{}
+
+	case *cloudwatchlogs.DescribeLogStreamsInput:
+		out := data.(*cloudwatchlogs.DescribeLogStreamsOutput)
+		for key := range b.streams {
+			if strings.HasPrefix(key, *in.LogGroupName+":"+aws.StringValue(in.LogStreamNamePrefix)) {
+				out.LogStreams = append(out.LogStreams, &cloudwatchlogs.LogStream{
+					LogStreamName: aws.String(strings.SplitN(key, ":", 2)[1]),
+				})
+			}
+		}
+
+	case *cloudwatchlogs.FilterLogEventsInput:
+		out := data.(*cloudwatchlogs.FilterLogEventsOutput)
+		for _, stream := range in.LogStreamNames {
+			for _, e := range b.streams[*in.LogGroupName+":"+*stream] {
+				if *e.Timestamp >= aws.Int64Value(in.StartTime) {
+					out.Events = append(out.Events, &cloudwatchlogs.FilteredLogEvent{
+						LogStreamName: stream,
+						Timestamp:     e.Timestamp,
+						Message:       e.Message,
+					})
+				}
+			}
+		}
+
+	case *cloudwatchlogs.GetLogEventsInput:
+		// tokens are the index of the next event, which is returned as-is at
+		// the end of the stream
+		events := b.streams[*in.LogGroupName+":"+*in.LogStreamName]
+		var next int
+		if in.NextToken != nil {
+			fmt.Sscanf(*in.NextToken, "f/%d", &next)
+		}
+		out := data.(*cloudwatchlogs.GetLogEventsOutput)
+		for ; next < len(events); next++ {
+			if in.NextToken != nil || *events[next].Timestamp >= aws.Int64Value(in.StartTime) {
+				out.Events = append(out.Events, events[next])
+			}
+		}
+		out.NextForwardToken = aws.String(fmt.Sprintf("f/%d", next))
+
+	case *cloudwatchlogs.PutLogEventsInput:
+		key := *in.LogGroupName + ":" + *in.LogStreamName
+		for _, e := range in.LogEvents {
+			b.streams[key] = append(b.streams[key], &cloudwatchlogs.OutputLogEvent{Timestamp: e.Timestamp, Message: e.Message})
+		}
+		*data.(*cloudwatchlogs.PutLogEventsOutput) = cloudwatchlogs.PutLogEventsOutput{}
+
+	case *cloudwatchlogs.DeleteLogStreamInput:
+		delete(b.streams, *in.LogGroupName+":"+*in.LogStreamName)
+		*data.(*cloudwatchlogs.DeleteLogStreamOutput) = cloudwatchlogs.DeleteLogStreamOutput{}
+
+	default:
+		return awserr.New("UnsupportedOperation", fmt.Sprintf("%T can't be simulated", params), nil)
+	}
+	return nil
+}
+
+func (b *simulatedBackend) arn(resource string) string {
+	return fmt.Sprintf("arn:aws:ecs:%s:%s:%s", b.region, simulatedAccount, resource)
+}
+
+// startTask creates a task that has already stopped in the simulated way, with
+// some output from each container that logs to CloudWatch Logs
+func (b *simulatedBackend) startTask(in *ecs.RunTaskInput) *ecs.Task {
+	id := randomHex(16)
+	now := time.Now()
+	task := &ecs.Task{
+		TaskArn:           aws.String(b.arn("task/" + aws.StringValue(in.Cluster) + "/" + id)),
+		TaskDefinitionArn: in.TaskDefinition,
+		LastStatus:        aws.String(ecs.DesiredStatusStopped),
+		CreatedAt:         aws.Time(now),
+		StartedAt:         aws.Time(now),
+		StoppedAt:         aws.Time(now),
+		StoppedReason:     aws.String("Essential container in task exited"),
+	}
+
+	var defs []*ecs.ContainerDefinition
+	if td, ok := b.taskDefinitions[aws.StringValue(in.TaskDefinition)]; ok {
+		defs = td.ContainerDefinitions
+	}
+	for i, def := range defs {
+		container := &ecs.Container{
+			Name:         def.Name,
+			ContainerArn: aws.String(b.arn("container/" + id + "/" + *def.Name)),
+			TaskArn:      task.TaskArn,
+			LastStatus:   aws.String(ecs.DesiredStatusStopped),
+			ExitCode:     aws.Int64(0),
+		}
+		switch {
+		case i == 0 && b.mode == SimulatePullError:
+			container.ExitCode = nil
+			container.Reason = aws.String("CannotPullContainerError: pull image manifest has been retried 5 time(s): failed to resolve ref " +
+				aws.StringValue(def.Image) + ": not found")
+			task.StoppedReason = aws.String("Task failed to start")
+			task.StopCode = aws.String(ecs.TaskStopCodeTaskFailedToStart)
+			task.StartedAt = nil
+		case i == 0 && b.mode == SimulateOOM:
+			container.ExitCode = aws.Int64(137)
+			container.Reason = aws.String("OutOfMemoryError: Container killed due to memory usage")
+		}
+		task.Containers = append(task.Containers, container)
+
+		if lc, ok := awslogsConfig(def.LogConfiguration, b.region); ok && container.ExitCode != nil {
+			key := lc.Group + ":" + path.Join(lc.StreamPrefix, *def.Name, id)
+			b.streams[key] = append(b.streams[key], &cloudwatchlogs.OutputLogEvent{
+				Timestamp: aws.Int64(now.UnixNano() / int64(time.Millisecond)),
+				Message:   aws.String(fmt.Sprintf("Simulated output from %s", *def.Name)),
+			})
+		}
+	}
+
+	b.tasks = append(b.tasks, task)
+	return task
+}
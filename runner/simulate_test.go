@@ -0,0 +1,101 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestSimulatedFailureExitCodes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("simulated runs wait for logs and services")
+	}
+
+	for _, tc := range []struct {
+		Mode     string
+		ExitCode int
+	}{
+		{SimulatePlacementFailure, ExitCodePlacement},
+		{SimulatePullError, 255},
+		{SimulateOOM, 137},
+		{SimulateTimeout, ExitCodeTimeout},
+	} {
+		t.Run(tc.Mode, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			r := New()
+			r.Config = aws.NewConfig().WithRegion("us-east-1")
+			r.TaskDefinitionFile = "../examples/helloworld/taskdefinition.json"
+			r.Cluster = "default"
+			r.LogGroupName = "ecs-task-runner"
+			r.Count = 1
+			r.Simulate = tc.Mode
+			r.Stdout, r.Stderr = &stdout, &stderr
+
+			err := r.Run(context.Background())
+			if got := ExitCode(err); got != tc.ExitCode {
+				t.Fatalf("expected exit code %d, got %d (%v)\n%s", tc.ExitCode, got, err, stderr.String())
+			}
+		})
+	}
+}
+
+func TestSimulatedDetach(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	r := New()
+	r.Config = aws.NewConfig().WithRegion("us-east-1")
+	r.TaskDefinitionFile = "../examples/helloworld/taskdefinition.json"
+	r.Cluster = "default"
+	r.LogGroupName = "ecs-task-runner"
+	r.Count = 2
+	r.Simulate = SimulateOOM
+	r.Detach = true
+	r.Stdout, r.Stderr = &stdout, &stderr
+
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatalf("Expected a detached run to succeed once tasks start, got %v\n%s", err, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "arn:aws:ecs:us-east-1:000000000000:task/default/") {
+		t.Errorf("Expected the ARNs of the 2 tasks on stdout, got %q", stdout.String())
+	}
+	if strings.Contains(stdout.String(), "Simulated output") {
+		t.Errorf("Expected logs not to be followed, got %q", stdout.String())
+	}
+}
+
+func TestSimulatedEphemeralLogs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("simulated runs wait for logs and services")
+	}
+
+	for _, tc := range []struct {
+		Name            string
+		NoFinishMessage bool
+		Deleted         bool
+	}{
+		{Name: "printed to the finish message", Deleted: true},
+		{Name: "no finish message", NoFinishMessage: true},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			r := New()
+			r.Config = aws.NewConfig().WithRegion("us-east-1")
+			r.TaskDefinitionFile = "../examples/helloworld/taskdefinition.json"
+			r.Cluster = "default"
+			r.LogGroupName = "ecs-task-runner"
+			r.Count = 1
+			r.Simulate = SimulateOOM
+			r.EphemeralLogs = true
+			r.NoFinishMessage = tc.NoFinishMessage
+			r.Stdout, r.Stderr = &stdout, &stderr
+
+			r.Run(context.Background())
+			if deleted := strings.Contains(stderr.String(), "Deleted 1 log streams"); deleted != tc.Deleted {
+				t.Errorf("Expected the stream to be deleted: %v, got %s", tc.Deleted, stderr.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Modes for checking images for SOCI indexes before running
+const (
+	SOCICheckReport = "report"
+	SOCICheckWarn   = "warn"
+	SOCICheckFail   = "fail"
+)
+
+const (
+	sociIndexV1MediaType   = "application/vnd.amazon.soci.index.v1+json"
+	sociIndexV2MediaType   = "application/vnd.amazon.soci.index.v2+json"
+	ociManifestMediaType   = "application/vnd.oci.image.manifest.v1+json"
+	ociImageIndexMediaType = "application/vnd.oci.image.index.v1+json"
+
+	// batchGetImageLimit is the most image ids BatchGetImage accepts at once
+	batchGetImageLimit = 100
+)
+
+type ecrInterface interface {
+	DescribeImages(input *ecr.DescribeImagesInput) (*ecr.DescribeImagesOutput, error)
+	DescribeImagesPages(input *ecr.DescribeImagesInput,
+		fn func(*ecr.DescribeImagesOutput, bool) bool) error
+	BatchGetImage(input *ecr.BatchGetImageInput) (*ecr.BatchGetImageOutput, error)
+}
+
+var ecrImagePattern = regexp.MustCompile(
+	`^(\d{12})\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?/([^:@]+)(?::([^@]+))?(?:@(sha256:[a-f0-9]+))?$`,
+)
+
+// ecrImage is a reference to an image in an ECR repository
+type ecrImage struct {
+	RegistryID string
+	Region     string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseECRImage parses an image reference, returning false if it isn't in ECR
+func parseECRImage(image string) (*ecrImage, bool) {
+	m := ecrImagePattern.FindStringSubmatch(image)
+	if m == nil {
+		return nil, false
+	}
+	ref := &ecrImage{
+		RegistryID: m[1],
+		Region:     m[2],
+		Repository: m[3],
+		Tag:        m[4],
+		Digest:     m[5],
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref, true
+}
+
+func (i *ecrImage) imageID() *ecr.ImageIdentifier {
+	if i.Digest != "" {
+		return &ecr.ImageIdentifier{ImageDigest: aws.String(i.Digest)}
+	}
+	return &ecr.ImageIdentifier{ImageTag: aws.String(i.Tag)}
+}
+
+// sociStatus is whether an image has a SOCI index, along with its size
+type sociStatus struct {
+	Size    int64
+	Indexed bool
+}
+
+// ociManifest is the subset of an OCI manifest or image index needed to find SOCI indexes
+type ociManifest struct {
+	MediaType    string          `json:"mediaType"`
+	ArtifactType string          `json:"artifactType"`
+	Subject      *ociDescriptor  `json:"subject"`
+	Manifests    []ociDescriptor `json:"manifests"`
+}
+
+type ociDescriptor struct {
+	MediaType    string `json:"mediaType"`
+	ArtifactType string `json:"artifactType"`
+	Digest       string `json:"digest"`
+}
+
+// sociIndexStatus looks up an image in ECR and determines whether it has a SOCI index
+func sociIndexStatus(svc ecrInterface, ref *ecrImage) (*sociStatus, error) {
+	resp, err := svc.DescribeImages(&ecr.DescribeImagesInput{
+		RegistryId:     aws.String(ref.RegistryID),
+		RepositoryName: aws.String(ref.Repository),
+		ImageIds:       []*ecr.ImageIdentifier{ref.imageID()},
+	})
+	if err != nil {
+		return nil, wrapAPIError("DescribeImages", err)
+	} else if len(resp.ImageDetails) == 0 {
+		return nil, fmt.Errorf("failed to find image %s in repository %s", ref.imageID(), ref.Repository)
+	}
+
+	detail := resp.ImageDetails[0]
+	digest := aws.StringValue(detail.ImageDigest)
+	status := &sociStatus{Size: aws.Int64Value(detail.ImageSizeInBytes)}
+
+	// SOCI v2 indexes are bundled into an image index alongside the image itself
+	if aws.StringValue(detail.ImageManifestMediaType) == ociImageIndexMediaType {
+		manifests, err := batchGetManifests(svc, ref, []*ecr.ImageIdentifier{{ImageDigest: aws.String(digest)}})
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range manifests {
+			for _, d := range m.Manifests {
+				if d.ArtifactType == sociIndexV2MediaType {
+					status.Indexed = true
+				}
+			}
+		}
+		return status, nil
+	}
+
+	// SOCI v1 indexes are separate artifacts in the repository that refer back to the image
+	var indexes []*ecr.ImageIdentifier
+	err = svc.DescribeImagesPages(&ecr.DescribeImagesInput{
+		RegistryId:     aws.String(ref.RegistryID),
+		RepositoryName: aws.String(ref.Repository),
+	}, func(page *ecr.DescribeImagesOutput, lastPage bool) bool {
+		for _, d := range page.ImageDetails {
+			if aws.StringValue(d.ArtifactMediaType) == sociIndexV1MediaType {
+				indexes = append(indexes, &ecr.ImageIdentifier{ImageDigest: d.ImageDigest})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, wrapAPIError("DescribeImages", err)
+	}
+
+	log.Printf("Found %d SOCI indexes in repository %s", len(indexes), ref.Repository)
+	for len(indexes) > 0 {
+		n := len(indexes)
+		if n > batchGetImageLimit {
+			n = batchGetImageLimit
+		}
+
+		manifests, err := batchGetManifests(svc, ref, indexes[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range manifests {
+			if m.Subject != nil && m.Subject.Digest == digest {
+				status.Indexed = true
+				return status, nil
+			}
+		}
+		indexes = indexes[n:]
+	}
+
+	return status, nil
+}
+
+func batchGetManifests(svc ecrInterface, ref *ecrImage, ids []*ecr.ImageIdentifier) ([]ociManifest, error) {
+	resp, err := svc.BatchGetImage(&ecr.BatchGetImageInput{
+		RegistryId:         aws.String(ref.RegistryID),
+		RepositoryName:     aws.String(ref.Repository),
+		ImageIds:           ids,
+		AcceptedMediaTypes: aws.StringSlice([]string{ociManifestMediaType, ociImageIndexMediaType}),
+	})
+	if err != nil {
+		return nil, wrapAPIError("BatchGetImage", err)
+	}
+
+	var manifests []ociManifest
+	for _, image := range resp.Images {
+		var m ociManifest
+		if err := json.Unmarshal([]byte(aws.StringValue(image.ImageManifest)), &m); err != nil {
+			return nil, fmt.Errorf("Failed to parse manifest %s: %v",
+				aws.StringValue(image.ImageId.ImageDigest), err)
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, nil
+}
+
+// checkSOCIIndexes reports which ECR images in a task definition have SOCI
+// indexes, warning or failing for large images that lack them
+func (r *Runner) checkSOCIIndexes(sess *session.Session, defs []*ecs.ContainerDefinition) error {
+	var missing []string
+
+	for _, container := range defs {
+		image := aws.StringValue(container.Image)
+		ref, ok := parseECRImage(image)
+		if !ok {
+			r.logf("Skipping SOCI index check for non-ECR image %s", image)
+			continue
+		}
+
+		svc := ecr.New(sess, aws.NewConfig().WithRegion(ref.Region))
+		status, err := sociIndexStatus(svc, ref)
+		if err != nil {
+			return err
+		}
+
+		sizeMiB := status.Size / 1024 / 1024
+		if status.Indexed {
+			fmt.Fprintf(r.Stderr, "Image %s (%d MiB) has a SOCI index\n", image, sizeMiB)
+			continue
+		}
+
+		fmt.Fprintf(r.Stderr, "Image %s (%d MiB) has no SOCI index\n", image, sizeMiB)
+		if status.Size >= r.SOCIMinImageSize {
+			missing = append(missing, image)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	switch r.SOCICheck {
+	case SOCICheckWarn:
+		for _, image := range missing {
+			fmt.Fprintf(r.Stderr, "WARNING: Image %s is large and has no SOCI index, it will be fully pulled before starting\n", image)
+		}
+	case SOCICheckFail:
+		return fmt.Errorf("Large images without a SOCI index: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+func TestParseECRImage(t *testing.T) {
+	for _, tc := range []struct {
+		image    string
+		expected ecrImage
+	}{
+		{
+			"123456789012.dkr.ecr.us-east-1.amazonaws.com/my-app",
+			ecrImage{RegistryID: "123456789012", Region: "us-east-1", Repository: "my-app", Tag: "latest"},
+		},
+		{
+			"123456789012.dkr.ecr.ap-southeast-2.amazonaws.com/team/my-app:v1.2",
+			ecrImage{RegistryID: "123456789012", Region: "ap-southeast-2", Repository: "team/my-app", Tag: "v1.2"},
+		},
+		{
+			"123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn/my-app@sha256:abc123",
+			ecrImage{RegistryID: "123456789012", Region: "cn-north-1", Repository: "my-app", Digest: "sha256:abc123"},
+		},
+	} {
+		ref, ok := parseECRImage(tc.image)
+		if !ok {
+			t.Fatalf("Expected %s to be an ECR image", tc.image)
+		}
+		if *ref != tc.expected {
+			t.Fatalf("Bad image reference for %s, got %+v", tc.image, *ref)
+		}
+	}
+
+	if _, ok := parseECRImage("hello-world:latest"); ok {
+		t.Fatal("Expected a Docker Hub image not to be an ECR image")
+	}
+}
+
+func TestSOCIIndexStatusFindsV1Index(t *testing.T) {
+	svc := &mockECR{
+		images: []*ecr.ImageDetail{
+			{ImageDigest: aws.String("sha256:app"), ImageSizeInBytes: aws.Int64(500), ImageTags: aws.StringSlice([]string{"latest"})},
+			{ImageDigest: aws.String("sha256:other-index"), ArtifactMediaType: aws.String(sociIndexV1MediaType)},
+			{ImageDigest: aws.String("sha256:app-index"), ArtifactMediaType: aws.String(sociIndexV1MediaType)},
+		},
+		manifests: map[string]string{
+			"sha256:other-index": `{"subject":{"digest":"sha256:other"}}`,
+			"sha256:app-index":   `{"subject":{"digest":"sha256:app"}}`,
+		},
+	}
+
+	status, err := sociIndexStatus(svc, &ecrImage{RegistryID: "123456789012", Repository: "my-app", Tag: "latest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Indexed {
+		t.Fatal("Expected image to have a SOCI index")
+	}
+	if status.Size != 500 {
+		t.Fatalf("Expected size of 500, got %d", status.Size)
+	}
+}
+
+func TestSOCIIndexStatusFindsV2Index(t *testing.T) {
+	svc := &mockECR{
+		images: []*ecr.ImageDetail{
+			{ImageDigest: aws.String("sha256:index"), ImageManifestMediaType: aws.String(ociImageIndexMediaType), ImageTags: aws.StringSlice([]string{"latest"})},
+		},
+		manifests: map[string]string{
+			"sha256:index": `{"manifests":[{"digest":"sha256:app"},{"digest":"sha256:soci","artifactType":"` + sociIndexV2MediaType + `"}]}`,
+		},
+	}
+
+	status, err := sociIndexStatus(svc, &ecrImage{RegistryID: "123456789012", Repository: "my-app", Tag: "latest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Indexed {
+		t.Fatal("Expected image to have a SOCI index")
+	}
+}
+
+func TestSOCIIndexStatusWithoutIndex(t *testing.T) {
+	svc := &mockECR{
+		images: []*ecr.ImageDetail{
+			{ImageDigest: aws.String("sha256:app"), ImageTags: aws.StringSlice([]string{"v1"})},
+		},
+	}
+
+	status, err := sociIndexStatus(svc, &ecrImage{RegistryID: "123456789012", Repository: "my-app", Tag: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Indexed {
+		t.Fatal("Expected image not to have a SOCI index")
+	}
+}
+
+type mockECR struct {
+	images    []*ecr.ImageDetail
+	manifests map[string]string
+}
+
+func (m *mockECR) DescribeImages(input *ecr.DescribeImagesInput) (*ecr.DescribeImagesOutput, error) {
+	output := &ecr.DescribeImagesOutput{}
+	for _, image := range m.images {
+		if len(input.ImageIds) == 0 {
+			output.ImageDetails = append(output.ImageDetails, image)
+			continue
+		}
+		for _, id := range input.ImageIds {
+			if aws.StringValue(id.ImageDigest) == aws.StringValue(image.ImageDigest) {
+				output.ImageDetails = append(output.ImageDetails, image)
+			}
+			for _, tag := range image.ImageTags {
+				if aws.StringValue(id.ImageTag) == *tag {
+					output.ImageDetails = append(output.ImageDetails, image)
+				}
+			}
+		}
+	}
+	return output, nil
+}
+
+func (m *mockECR) DescribeImagesPages(input *ecr.DescribeImagesInput,
+	fn func(*ecr.DescribeImagesOutput, bool) bool) error {
+
+	output, err := m.DescribeImages(input)
+	if err != nil {
+		return err
+	}
+
+	fn(output, true)
+	return nil
+}
+
+func (m *mockECR) BatchGetImage(input *ecr.BatchGetImageInput) (*ecr.BatchGetImageOutput, error) {
+	output := &ecr.BatchGetImageOutput{}
+	for _, id := range input.ImageIds {
+		if manifest, ok := m.manifests[*id.ImageDigest]; ok {
+			output.Images = append(output.Images, &ecr.Image{
+				ImageId:       id,
+				ImageManifest: aws.String(manifest),
+			})
+		}
+	}
+	return output, nil
+}
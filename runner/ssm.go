@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+type ssmInterface interface {
+	GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error)
+	GetParametersByPathPages(input *ssm.GetParametersByPathInput,
+		fn func(*ssm.GetParametersByPathOutput, bool) bool) error
+}
+
+// ssmEnv fetches SSM parameters and returns them as KEY=value environment
+// variables, decrypting SecureString parameters. Each parameter is one of:
+//
+//	/myapp/staging/DB_URL       a single parameter, named DB_URL
+//	DATABASE_URL=/myapp/DB_URL  a single parameter with an explicit name
+//	/myapp/staging/*            every parameter directly under the path
+func ssmEnv(svc ssmInterface, params []string) ([]string, error) {
+	var env []string
+
+	for _, param := range params {
+		if strings.HasSuffix(param, "/*") {
+			byPath, err := ssmEnvByPath(svc, strings.TrimSuffix(param, "*"))
+			if err != nil {
+				return nil, err
+			}
+			env = append(env, byPath...)
+			continue
+		}
+
+		name, paramName := path.Base(param), param
+		if parts := strings.SplitN(param, "=", 2); len(parts) == 2 {
+			name, paramName = parts[0], parts[1]
+		}
+
+		log.Printf("Fetching SSM parameter %s", paramName)
+		resp, err := svc.GetParameter(&ssm.GetParameterInput{
+			Name:           aws.String(paramName),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, wrapAPIError("GetParameter", err)
+		}
+		env = append(env, name+"="+aws.StringValue(resp.Parameter.Value))
+	}
+
+	return env, nil
+}
+
+func ssmEnvByPath(svc ssmInterface, paramPath string) ([]string, error) {
+	var env []string
+
+	log.Printf("Fetching SSM parameters under %s", paramPath)
+	err := svc.GetParametersByPathPages(&ssm.GetParametersByPathInput{
+		Path:           aws.String(paramPath),
+		WithDecryption: aws.Bool(true),
+	}, func(page *ssm.GetParametersByPathOutput, lastPage bool) bool {
+		for _, p := range page.Parameters {
+			env = append(env, path.Base(*p.Name)+"="+aws.StringValue(p.Value))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, wrapAPIError("GetParametersByPath", err)
+	}
+	if len(env) == 0 {
+		return nil, fmt.Errorf("No SSM parameters found under %s", paramPath)
+	}
+
+	return env, nil
+}
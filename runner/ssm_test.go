@@ -0,0 +1,67 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestSSMEnv(t *testing.T) {
+	svc := &mockSSM{parameters: map[string]string{
+		"/myapp/staging/DB_URL":  "postgres://db",
+		"/myapp/staging/API_KEY": "secret",
+		"/myapp/shared/REGION":   "us-east-1",
+	}}
+
+	env, err := ssmEnv(svc, []string{"/myapp/staging/*", "AWS_REGION=/myapp/shared/REGION", "/myapp/shared/REGION"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"API_KEY=secret", "DB_URL=postgres://db", "AWS_REGION=us-east-1", "REGION=us-east-1"}
+	if strings.Join(env, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected %v, got %v", expected, env)
+	}
+}
+
+func TestSSMEnvEmptyPath(t *testing.T) {
+	_, err := ssmEnv(&mockSSM{}, []string{"/myapp/missing/*"})
+	if err == nil || err.Error() != "No SSM parameters found under /myapp/missing/" {
+		t.Fatalf("Bad error %v", err)
+	}
+}
+
+type mockSSM struct {
+	parameters map[string]string
+}
+
+func (m *mockSSM) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	value, ok := m.parameters[*input.Name]
+	if !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil)
+	}
+	return &ssm.GetParameterOutput{
+		Parameter: &ssm.Parameter{Name: input.Name, Value: aws.String(value)},
+	}, nil
+}
+
+func (m *mockSSM) GetParametersByPathPages(input *ssm.GetParametersByPathInput,
+	fn func(*ssm.GetParametersByPathOutput, bool) bool) error {
+
+	output := &ssm.GetParametersByPathOutput{}
+	for _, name := range []string{"/myapp/staging/API_KEY", "/myapp/staging/DB_URL", "/myapp/shared/REGION"} {
+		value, ok := m.parameters[name]
+		if ok && strings.HasPrefix(name, *input.Path) && !strings.Contains(name[len(*input.Path):], "/") {
+			output.Parameters = append(output.Parameters, &ssm.Parameter{
+				Name:  aws.String(name),
+				Value: aws.String(value),
+			})
+		}
+	}
+
+	fn(output, true)
+	return nil
+}
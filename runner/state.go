@@ -0,0 +1,212 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// runStateVersion changes if the state file changes in a way that an older
+// state file can't be resumed from
+const runStateVersion = 1
+
+// runState is what's needed to pick up following a run where it was left,
+// such as after ecs-run-task was killed or the CI agent running it restarted
+type runState struct {
+	Version        int                  `json:"version"`
+	RunID          string               `json:"run_id"`
+	Cluster        string               `json:"cluster"`
+	Region         string               `json:"region"`
+	TaskDefinition string               `json:"task_definition"`
+	Logs           map[string]logConfig `json:"logs,omitempty"`
+	Tasks          []string             `json:"tasks"`
+
+	// LogPositions is the timestamp in milliseconds of the last event that
+	// was printed from each log stream
+	LogPositions map[string]int64 `json:"log_positions,omitempty"`
+}
+
+// runStateFile keeps the state file of a run up to date as it progresses,
+// and removes it once the run has finished
+type runStateFile struct {
+	file     string
+	stderr   io.Writer
+	watchers *logWatchers
+
+	mu      sync.Mutex
+	state   runState
+	removed bool
+	warned  bool
+}
+
+func (r *Runner) newRunStateFile(region string, td *preparedTaskDefinition, tasks []*ecs.Task, watchers *logWatchers) *runStateFile {
+	if r.StateFile == "" {
+		return nil
+	}
+	s := &runStateFile{
+		file:     r.StateFile,
+		stderr:   r.Stderr,
+		watchers: watchers,
+		state: runState{
+			Version:        runStateVersion,
+			RunID:          r.logScope.fields.RunID,
+			Cluster:        r.Cluster,
+			Region:         region,
+			TaskDefinition: td.Name,
+			Logs:           td.Logs,
+			LogPositions:   map[string]int64{},
+		},
+	}
+	for _, task := range tasks {
+		s.state.Tasks = append(s.state.Tasks, aws.StringValue(task.TaskArn))
+	}
+	if r.resume != nil {
+		for stream, ts := range r.resume.LogPositions {
+			s.state.LogPositions[stream] = ts
+		}
+	}
+	return s
+}
+
+// save writes the state with how far each log stream has been printed. It's
+// written to a temporary file first, so a crash can't leave it half written.
+// State files are best effort, so failures are only warned about once.
+func (s *runStateFile) save() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.removed {
+		return
+	}
+
+	s.watchers.mu.Lock()
+	for _, w := range s.watchers.watches {
+		if ts := w.watcher.position(); ts > 0 {
+			s.state.LogPositions[w.watcher.LogStreamName] = ts
+		}
+	}
+	s.watchers.mu.Unlock()
+
+	body, err := json.MarshalIndent(s.state, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(s.file+".tmp", append(body, '\n'), 0644)
+	}
+	if err == nil {
+		err = os.Rename(s.file+".tmp", s.file)
+	}
+	if err != nil && !s.warned {
+		s.warned = true
+		fmt.Fprintf(s.stderr, "Failed to write run state to %s: %v\n", s.file, err)
+	}
+}
+
+// remove removes the state file once the tasks have stopped and their logs
+// have been printed, as there's nothing left to resume
+func (s *runStateFile) remove() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removed = true
+	if err := os.Remove(s.file); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(s.stderr, "Failed to remove run state %s: %v\n", s.file, err)
+	}
+}
+
+func loadRunState(file string) (*runState, error) {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var state runState
+	if err := json.Unmarshal(body, &state); err != nil {
+		return nil, fmt.Errorf("Failed to read run state from %s: %v", file, err)
+	}
+	if state.Version != runStateVersion {
+		return nil, fmt.Errorf("Run state in %s is version %d, but this version of ecs-run-task resumes version %d", file, state.Version, runStateVersion)
+	}
+	if len(state.Tasks) == 0 {
+		return nil, fmt.Errorf("Run state in %s has no tasks to resume", file)
+	}
+	return &state, nil
+}
+
+// logPosition returns the timestamp of the last event that was printed from
+// a log stream before the run was resumed, or 0 if the run isn't resumed
+func (s *runState) logPosition(stream string) int64 {
+	if s == nil {
+		return 0
+	}
+	return s.LogPositions[stream]
+}
+
+// Resume picks up a run from its state file, waiting for its tasks to stop
+// and printing their logs from where they were left. The state file is kept
+// up to date as it goes, so the resumed run can be resumed too.
+func (r *Runner) Resume(ctx context.Context, file string) error {
+	state, err := loadRunState(file)
+	if err != nil {
+		return err
+	}
+	r = r.clone()
+	r.resume = state
+	r.StateFile = file
+	r.RunID = state.RunID
+	r.Cluster = state.Cluster
+	r.Count = int64(len(state.Tasks))
+	if state.Region != "" {
+		r.Region = state.Region
+		r.Config = r.Config.Copy().WithRegion(state.Region)
+	}
+	fmt.Fprintf(r.Stderr, "Resuming %d tasks in cluster %s\n", len(state.Tasks), state.Cluster)
+	return r.Run(ctx)
+}
+
+// resumedTaskDefinition returns the task definition that a resumed run's
+// tasks were started with, with logs streamed from where they were before
+func (r *Runner) resumedTaskDefinition(ctx context.Context, sess *session.Session, svc *ecs.ECS) (*preparedTaskDefinition, error) {
+	if r.resume.TaskDefinition == "" {
+		return r.attachedTaskDefinition(ctx, sess, svc)
+	}
+	resp, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(r.resume.TaskDefinition),
+	})
+	if err != nil {
+		return nil, wrapAPIError("DescribeTaskDefinition", err)
+	}
+	logs := r.resume.Logs
+	if logs == nil {
+		logs = map[string]logConfig{}
+	}
+	return &preparedTaskDefinition{
+		Name:       r.resume.TaskDefinition,
+		Containers: resp.TaskDefinition.ContainerDefinitions,
+		Logs:       logs,
+		Digest:     aws.StringValue(resp.TaskDefinition.TaskDefinitionArn),
+	}, nil
+}
+
+// resumedTasks describes the tasks of a resumed run, as if they had just
+// been started. Tasks that stopped too long ago are forgotten by ECS.
+func (r *Runner) resumedTasks(ctx context.Context, svc *ecs.ECS) (*ecs.RunTaskOutput, error) {
+	resp, err := describeTasks(ctx, svc, r.Cluster, aws.StringSlice(r.resume.Tasks))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Failures) > 0 {
+		return nil, fmt.Errorf("Failed to find task %s: %s, tasks are only kept for about an hour after they stop",
+			aws.StringValue(resp.Failures[0].Arn), aws.StringValue(resp.Failures[0].Reason))
+	}
+	return &ecs.RunTaskOutput{Tasks: resp.Tasks}, nil
+}
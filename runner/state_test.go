@@ -0,0 +1,99 @@
+package runner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestRunStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "run.json")
+	var stderr bytes.Buffer
+	r := &Runner{Cluster: "default", StateFile: file, Stderr: &stderr}
+	r.logScope.fields.RunID = "run-1"
+	td := &preparedTaskDefinition{
+		Name: "app:3",
+		Logs: map[string]logConfig{"app": {Group: "ecs-run-task", StreamPrefix: "run"}},
+	}
+	watchers := &logWatchers{}
+	watcher := &logWatcher{LogStreamName: "run/app/abc"}
+	watchers.add(&logWatch{watcher: watcher})
+
+	s := r.newRunStateFile("us-east-1", td, []*ecs.Task{{TaskArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/abc")}}, watchers)
+	s.save()
+	watcher.progressed(1700000000123)
+	s.save()
+
+	state, err := loadRunState(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &runState{
+		Version:        runStateVersion,
+		RunID:          "run-1",
+		Cluster:        "default",
+		Region:         "us-east-1",
+		TaskDefinition: "app:3",
+		Logs:           td.Logs,
+		Tasks:          []string{"arn:aws:ecs:us-east-1:123456789012:task/default/abc"},
+		LogPositions:   map[string]int64{"run/app/abc": 1700000000123},
+	}
+	if !reflect.DeepEqual(state, expected) {
+		t.Fatalf("Expected state %+v, got %+v", expected, state)
+	}
+	if state.logPosition("run/app/abc") != 1700000000123 || (*runState)(nil).logPosition("run/app/abc") != 0 {
+		t.Fatal("Unexpected log positions")
+	}
+
+	// a resumed run keeps the positions of streams it hasn't watched yet
+	resumed := &Runner{Cluster: "default", StateFile: file, Stderr: &stderr, resume: state}
+	s = resumed.newRunStateFile("us-east-1", td, nil, &logWatchers{})
+	if s.state.LogPositions["run/app/abc"] != 1700000000123 {
+		t.Fatalf("Expected log positions to be carried over, got %v", s.state.LogPositions)
+	}
+
+	s.remove()
+	s.save()
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("Expected the state file to be removed, got %v", err)
+	}
+	if stderr.Len() > 0 {
+		t.Fatalf("Unexpected output %q", stderr.String())
+	}
+}
+
+func TestLoadRunState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "run.json")
+	if err := ioutil.WriteFile(file, []byte(`{"version": 99, "tasks": ["arn"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadRunState(file); err == nil || !strings.Contains(err.Error(), "version 99") {
+		t.Fatalf("Expected an unknown version to fail, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(file, []byte(`{"version": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadRunState(file); err == nil || !strings.Contains(err.Error(), "no tasks") {
+		t.Fatalf("Expected a state without tasks to fail, got %v", err)
+	}
+}
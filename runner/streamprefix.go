@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// streamPrefixData is what a task name can refer to as a template, such as
+// `{{.Family}}-{{.GitSHA}}`
+type streamPrefixData struct {
+	Family  string
+	Cluster string
+	Date    string
+	GitSHA  string
+	BuildID string
+	Attempt int
+	RunID   string
+}
+
+// streamPrefix returns the log stream prefix for a task definition family,
+// which is the task name with any template variables expanded, and the
+// attempt if each attempt gets its own streams
+func (r *Runner) streamPrefix(family string) (string, error) {
+	prefix, err := r.expandTaskName(family)
+	if err != nil {
+		return "", err
+	}
+	if r.LogStreamPerAttempt {
+		prefix += fmt.Sprintf("-attempt-%d", r.attempt())
+	}
+	return prefix, nil
+}
+
+func (r *Runner) expandTaskName(family string) (string, error) {
+	if r.TaskName == "" {
+		return defaultStreamPrefix(r.runID()), nil
+	}
+	if !strings.Contains(r.TaskName, "{{") {
+		return r.TaskName, nil
+	}
+
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(r.TaskName)
+	if err != nil {
+		return "", fmt.Errorf("Invalid task name template %q: %v", r.TaskName, err)
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, streamPrefixData{
+		Family:  family,
+		Cluster: r.Cluster,
+		Date:    time.Now().UTC().Format("2006-01-02"),
+		GitSHA:  gitSHA(),
+		BuildID: ciBuildID(),
+		Attempt: r.attempt(),
+		RunID:   r.runID(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Invalid task name template %q: %v", r.TaskName, err)
+	}
+
+	// stream prefixes can't contain colons or asterisks
+	prefix := strings.NewReplacer(":", "-", "*", "-").Replace(buf.String())
+	if prefix == "" {
+		return "", fmt.Errorf("Task name template %q is empty once expanded", r.TaskName)
+	}
+	return prefix, nil
+}
+
+// gitSHA returns the commit being built, from CI's environment or the
+// working directory's git repository
+func gitSHA() string {
+	for _, env := range []string{"BUILDKITE_COMMIT", "GITHUB_SHA", "CI_COMMIT_SHA", "GIT_COMMIT"} {
+		if sha := os.Getenv(env); sha != "" {
+			return sha
+		}
+	}
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// ciBuildID returns the ID of the CI build that's running, if there is one
+func ciBuildID() string {
+	for _, env := range []string{"BUILDKITE_BUILD_ID", "GITHUB_RUN_ID", "CI_PIPELINE_ID", "BUILD_ID"} {
+		if id := os.Getenv(env); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// attempt returns which attempt at the run this is, counting from 1, either as
+// set or from CI's count of retries of the job
+func (r *Runner) attempt() int {
+	if r.Attempt > 0 {
+		return r.Attempt
+	}
+	if n, err := strconv.Atoi(os.Getenv("BUILDKITE_RETRY_COUNT")); err == nil && n >= 0 {
+		return n + 1
+	}
+	if n, err := strconv.Atoi(os.Getenv("GITHUB_RUN_ATTEMPT")); err == nil && n > 0 {
+		return n
+	}
+	return 1
+}
@@ -0,0 +1,63 @@
+package runner
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStreamPrefix(t *testing.T) {
+	os.Setenv("BUILDKITE_COMMIT", "abc123")
+	os.Setenv("BUILDKITE_BUILD_ID", "build-1")
+	defer os.Unsetenv("BUILDKITE_COMMIT")
+	defer os.Unsetenv("BUILDKITE_BUILD_ID")
+
+	date := time.Now().UTC().Format("2006-01-02")
+	for name, expected := range map[string]string{
+		"migrations":                               "migrations",
+		"{{.Family}}/{{.GitSHA}}":                  "app/abc123",
+		"{{.Cluster}}-{{.Date}}":                   "ci-" + date,
+		"{{.BuildID}}:{{printf \"%.3s\" .GitSHA}}": "build-1-abc",
+		"{{.Family}}-{{.RunID}}":                   "app-01J0ZKQ5V2N3B8XG4W6R7T9YAC",
+	} {
+		r := &Runner{TaskName: name, Cluster: "ci", RunID: "01J0ZKQ5V2N3B8XG4W6R7T9YAC"}
+		actual, err := r.streamPrefix("app")
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", name, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, name, actual)
+		}
+	}
+
+	if prefix, _ := (&Runner{RunID: "01J0ZKQ5V2N3B8XG4W6R7T9YAC"}).streamPrefix("app"); prefix != "run_task_01J0ZKQ5V2N3B8XG4W6R7T9YAC" {
+		t.Errorf("Unexpected default prefix %q", prefix)
+	}
+
+	for _, name := range []string{"{{.Nope}}", "{{.Family", "{{if false}}x{{end}}"} {
+		if _, err := (&Runner{TaskName: name}).streamPrefix("app"); err == nil {
+			t.Errorf("Expected an error for %q", name)
+		}
+	}
+}
+
+func TestStreamPrefixPerAttempt(t *testing.T) {
+	os.Setenv("BUILDKITE_RETRY_COUNT", "2")
+	defer os.Unsetenv("BUILDKITE_RETRY_COUNT")
+
+	r := &Runner{TaskName: "migrations", LogStreamPerAttempt: true}
+	if prefix, _ := r.streamPrefix("app"); prefix != "migrations-attempt-3" {
+		t.Errorf("Unexpected prefix %q", prefix)
+	}
+
+	r = &Runner{TaskName: "{{.Family}}/{{.Attempt}}", Attempt: 5}
+	if prefix, _ := r.streamPrefix("app"); prefix != "app/5" {
+		t.Errorf("Unexpected prefix %q", prefix)
+	}
+
+	os.Unsetenv("BUILDKITE_RETRY_COUNT")
+	if attempt := (&Runner{}).attempt(); attempt != 1 {
+		t.Errorf("Expected the first attempt, got %d", attempt)
+	}
+}
@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// runTagPrefix namespaces the tags that describe a run
+const runTagPrefix = "ecs-run-task:"
+
+type logGroupTaggerInterface interface {
+	DescribeLogGroups(input *cloudwatchlogs.DescribeLogGroupsInput) (*cloudwatchlogs.DescribeLogGroupsOutput, error)
+	TagResource(input *cloudwatchlogs.TagResourceInput) (*cloudwatchlogs.TagResourceOutput, error)
+}
+
+// runTags describe a run of a task definition family: where it ran, who
+// started it and from which CI build, so that its logs can be attributed
+func (r *Runner) runTags(family string) map[string]string {
+	tags := map[string]string{
+		runTagPrefix + "family":    family,
+		runTagPrefix + "cluster":   r.Cluster,
+		runTagPrefix + "initiator": initiator(),
+		runTagPrefix + "build-id":  ciBuildID(),
+		runTagPrefix + "run-id":    r.runID(),
+	}
+	for k, v := range tags {
+		if v == "" {
+			delete(tags, k)
+		}
+	}
+	return tags
+}
+
+// initiator returns who started the run, from CI's environment or the
+// current user
+func initiator() string {
+	for _, env := range []string{"BUILDKITE_BUILD_CREATOR_EMAIL", "GITHUB_ACTOR", "GITLAB_USER_LOGIN"} {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// tagLogGroup tags a log group that the run created with the run's tags.
+// Tagging is best effort, so a failure is only warned about, and not at all
+// without permission to tag.
+func (r *Runner) tagLogGroup(cwl logGroupTaggerInterface, group, family string) {
+	tags := r.runTags(family)
+	err := tagLogGroup(cwl, group, tags)
+	if err == nil {
+		return
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Code() == "AccessDeniedException" {
+		r.logf("Not allowed to tag log group %s: %v", group, err)
+		return
+	}
+	fmt.Fprintf(r.Stderr, "Failed to tag log group %s: %v\n", group, err)
+}
+
+func tagLogGroup(cwl logGroupTaggerInterface, group string, tags map[string]string) error {
+	resp, err := cwl.DescribeLogGroups(&cloudwatchlogs.DescribeLogGroupsInput{
+		Limit:              aws.Int64(1),
+		LogGroupNamePrefix: aws.String(group),
+	})
+	if err != nil {
+		return wrapAPIError("DescribeLogGroups", err)
+	}
+	if len(resp.LogGroups) == 0 || aws.StringValue(resp.LogGroups[0].LogGroupName) != group {
+		return fmt.Errorf("No log group named %s", group)
+	}
+
+	// the ARN that DescribeLogGroups returns ends in :* for the group's streams
+	groupARN := strings.TrimSuffix(aws.StringValue(resp.LogGroups[0].Arn), ":*")
+	log.Printf("Tagging log group %s with %v", groupARN, tags)
+	_, err = cwl.TagResource(&cloudwatchlogs.TagResourceInput{
+		ResourceArn: aws.String(groupARN),
+		Tags:        aws.StringMap(tags),
+	})
+	return wrapAPIError("TagResource", err)
+}
+
+// ecsTags converts tags to ECS tags, sorted by key
+func ecsTags(tags map[string]string) []*ecs.Tag {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var ecsTags []*ecs.Tag
+	for _, k := range keys {
+		ecsTags = append(ecsTags, &ecs.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return ecsTags
+}
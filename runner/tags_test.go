@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+type mockLogGroupTagger struct {
+	input *cloudwatchlogs.TagResourceInput
+	err   error
+}
+
+func (m *mockLogGroupTagger) DescribeLogGroups(input *cloudwatchlogs.DescribeLogGroupsInput) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	return &cloudwatchlogs.DescribeLogGroupsOutput{LogGroups: []*cloudwatchlogs.LogGroup{{
+		LogGroupName: input.LogGroupNamePrefix,
+		Arn:          aws.String("arn:aws:logs:us-east-1:123456789012:log-group:" + *input.LogGroupNamePrefix + ":*"),
+	}}}, nil
+}
+
+func (m *mockLogGroupTagger) TagResource(input *cloudwatchlogs.TagResourceInput) (*cloudwatchlogs.TagResourceOutput, error) {
+	m.input = input
+	return &cloudwatchlogs.TagResourceOutput{}, m.err
+}
+
+func TestTagLogGroup(t *testing.T) {
+	os.Setenv("BUILDKITE_BUILD_CREATOR_EMAIL", "dev@example.com")
+	os.Setenv("BUILDKITE_BUILD_ID", "build-1")
+	defer os.Unsetenv("BUILDKITE_BUILD_CREATOR_EMAIL")
+	defer os.Unsetenv("BUILDKITE_BUILD_ID")
+
+	var stderr bytes.Buffer
+	r := &Runner{Cluster: "ci", RunID: "01J0ZKQ5V2N3B8XG4W6R7T9YAC", Stderr: &stderr}
+	m := &mockLogGroupTagger{}
+	r.tagLogGroup(m, "ecs-task-runner", "migrations")
+
+	expected := map[string]string{
+		"ecs-run-task:family":    "migrations",
+		"ecs-run-task:cluster":   "ci",
+		"ecs-run-task:initiator": "dev@example.com",
+		"ecs-run-task:build-id":  "build-1",
+		"ecs-run-task:run-id":    "01J0ZKQ5V2N3B8XG4W6R7T9YAC",
+	}
+	if arn := aws.StringValue(m.input.ResourceArn); arn != "arn:aws:logs:us-east-1:123456789012:log-group:ecs-task-runner" {
+		t.Errorf("Unexpected ARN %s", arn)
+	}
+	tags := aws.StringValueMap(m.input.Tags)
+	if len(tags) != len(expected) {
+		t.Fatalf("Expected tags %v, got %v", expected, tags)
+	}
+	for k, v := range expected {
+		if tags[k] != v {
+			t.Errorf("Expected %s=%s, got %q", k, v, tags[k])
+		}
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("Unexpected output %q", stderr.String())
+	}
+}
+
+func TestTagLogGroupFailures(t *testing.T) {
+	var stderr bytes.Buffer
+	r := &Runner{Cluster: "ci", Stderr: &stderr}
+
+	r.tagLogGroup(&mockLogGroupTagger{err: awserr.New("AccessDeniedException", "denied", nil)}, "ecs-task-runner", "app")
+	if stderr.Len() > 0 {
+		t.Errorf("Expected no warning without permission, got %q", stderr.String())
+	}
+
+	r.tagLogGroup(&mockLogGroupTagger{err: errors.New("throttled")}, "ecs-task-runner", "app")
+	if stderr.Len() == 0 {
+		t.Error("Expected a warning")
+	}
+}
+
+func TestECSTags(t *testing.T) {
+	tags := ecsTags(map[string]string{"team": "data", "cost-centre": "42"})
+	if len(tags) != 2 || *tags[0].Key != "cost-centre" || *tags[1].Value != "data" {
+		t.Fatalf("Unexpected tags %v", tags)
+	}
+}
@@ -0,0 +1,312 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/ghodss/yaml"
+)
+
+// Target is a cluster to run a task on, optionally in a different region or
+// in another account via an assumed role
+type Target struct {
+	Cluster    string `json:"cluster"`
+	Region     string `json:"region,omitempty"`
+	Account    string `json:"account,omitempty"`
+	Role       string `json:"role,omitempty"`
+	ExternalID string `json:"external_id,omitempty"`
+}
+
+// TargetForCluster returns a target for a cluster name or ARN, taking the
+// region from the ARN if there is one
+func TargetForCluster(cluster string) Target {
+	if a, err := arn.Parse(cluster); err == nil {
+		return Target{Cluster: cluster, Region: a.Region}
+	}
+	return Target{Cluster: cluster}
+}
+
+// Name identifies the target in output
+func (t Target) Name() string {
+	var parts []string
+	if t.Account != "" {
+		parts = append(parts, t.Account)
+	}
+	if t.Region != "" {
+		parts = append(parts, t.Region)
+	}
+	if a, err := arn.Parse(t.Cluster); err == nil && len(parts) > 0 {
+		parts = append(parts, strings.TrimPrefix(a.Resource, "cluster/"))
+	} else {
+		parts = append(parts, t.Cluster)
+	}
+	return strings.Join(parts, "/")
+}
+
+// RoleARN returns the ARN of the role to assume for the target. The role can
+// be given as a full ARN, or as a role name along with the account.
+func (t Target) RoleARN() (string, error) {
+	if t.Role == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(t.Role, "arn:") {
+		return t.Role, nil
+	}
+	if t.Account == "" {
+		return "", fmt.Errorf("Target %s needs an account to assume role %q", t.Name(), t.Role)
+	}
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", partitionForRegion(t.Region), t.Account, t.Role), nil
+}
+
+func partitionForRegion(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	default:
+		return "aws"
+	}
+}
+
+// LoadTargets reads a list of targets from a YAML or JSON file in the form:
+//
+//	targets:
+//	  - cluster: staging
+//	    region: us-east-1
+//	  - cluster: production
+//	    region: eu-west-1
+//	    account: "123456789012"
+//	    role: ecs-run-task
+func LoadTargets(file string) ([]Target, error) {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Targets []Target `json:"targets"`
+	}
+	if err := yaml.Unmarshal(body, &config); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", file, err)
+	}
+
+	for i, t := range config.Targets {
+		if t.Cluster == "" {
+			return nil, fmt.Errorf("Target %d in %s has no cluster", i+1, file)
+		}
+		if _, err := t.RoleARN(); err != nil {
+			return nil, err
+		}
+	}
+
+	return config.Targets, nil
+}
+
+// forTarget returns a copy of the runner that runs against a target
+func (r *Runner) forTarget(t Target) (*Runner, error) {
+	tr := *r
+	tr.Cluster = t.Cluster
+	tr.Config = r.Config.Copy()
+
+	if t.Region != "" {
+		tr.Region = t.Region
+		tr.Config.WithRegion(t.Region)
+	}
+
+	roleARN, err := t.RoleARN()
+	if err != nil {
+		return nil, err
+	}
+	tr.cacheScope = tr.Region + "/" + roleARN
+	if roleARN != "" {
+		sess, err := newSession(r.Config)
+		if err != nil {
+			return nil, err
+		}
+		tr.Config.WithCredentials(assumeRoleCredentials(sess, roleARN, t.ExternalID))
+	}
+
+	return &tr, nil
+}
+
+// TargetResult is the outcome of running a task against a target
+type TargetResult struct {
+	Target Target
+	Err    error
+}
+
+// RunTargets runs the task against each target in parallel, prefixing output
+// with the target name. It prints a summary of each target once they have all
+// finished, and returns an error if any of them failed. A single target is
+// run as-is without prefixes or a summary.
+func (r *Runner) RunTargets(ctx context.Context, targets []Target) error {
+	if len(targets) == 0 {
+		return r.noTasks("there are no targets")
+	}
+	if err := r.runPreHook(ctx, targets); err != nil {
+		return err
+	}
+
+	// share a run ID, stream prefix and task definitions between targets, so
+	// that identical definitions are only registered once per account and
+	// region, and everything from the invocation can be found by its run ID
+	shared := *r
+	if shared.RunID == "" {
+		shared.RunID = newRunID()
+	}
+	if len(targets) > 1 {
+		shared.cache = newTaskDefinitionCache()
+		shared.timings = &timingRecorder{}
+		if shared.wantsSummary() {
+			shared.summary = &summaryRecorder{}
+		}
+		if shared.EventsFile != "" || shared.EventHandler != nil {
+			el, err := openEventLog(shared.EventsFile, shared.EventHandler, shared.RunID, r.Stderr)
+			if err != nil {
+				return err
+			}
+			defer el.Close()
+			shared.eventLog = el
+		}
+		if shared.TaskName == "" {
+			shared.TaskName = defaultStreamPrefix(shared.RunID)
+		}
+	}
+
+	runners := make([]*Runner, len(targets))
+	for i, t := range targets {
+		tr, err := shared.forTarget(t)
+		if err != nil {
+			return err
+		}
+		// each target writes its own support bundle, state file and task
+		// definition
+		if tr.SupportBundle != "" && len(targets) > 1 {
+			tr.SupportBundle = targetFileName(tr.SupportBundle, t)
+		}
+		if tr.StateFile != "" && len(targets) > 1 {
+			tr.StateFile = targetFileName(tr.StateFile, t)
+		}
+		if tr.SaveTaskDefinition != "" && len(targets) > 1 {
+			tr.SaveTaskDefinition = targetFileName(tr.SaveTaskDefinition, t)
+		}
+
+		// each target starts different tasks, so needs its own client token
+		if tr.ClientToken != "" && len(targets) > 1 {
+			tr.ClientToken = suffixClientToken(tr.ClientToken, fmt.Sprintf("-t%d", i))
+		}
+		runners[i] = tr
+	}
+
+	if len(targets) == 1 {
+		return runners[0].Run(ctx)
+	}
+
+	results := make([]TargetResult, len(targets))
+	var wg sync.WaitGroup
+
+	for i, t := range targets {
+		tr := runners[i]
+		if tr.DumpTaskState != "" {
+			tr.DumpTaskState = filepath.Join(tr.DumpTaskState, t.Name())
+		}
+		prefix := fmt.Sprintf("[%s] ", t.Name())
+		tr.Stdout = &prefixWriter{w: r.Stdout, prefix: prefix}
+		tr.Stderr = &prefixWriter{w: r.Stderr, prefix: prefix}
+
+		results[i].Target = t
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i].Err = tr.Run(ctx)
+		}(i)
+	}
+
+	wg.Wait()
+	r.recordTimings(shared.timings.timings)
+	err := r.summarizeTargets(results)
+	if shared.summary != nil {
+		shared.saveSummary(shared.summary.runs, err)
+	}
+	return err
+}
+
+func (r *Runner) summarizeTargets(results []TargetResult) error {
+	var failed int
+	var exitCode int
+
+	fmt.Fprintln(r.Stderr, "Summary:")
+	for _, result := range results {
+		if result.Err == nil {
+			fmt.Fprintf(r.Stderr, "  %s: succeeded\n", result.Target.Name())
+			continue
+		}
+
+		fmt.Fprintf(r.Stderr, "  %s: failed: %v\n", result.Target.Name(), result.Err)
+		failed++
+		if exitCode == 0 || exitCode == ExitCodeError {
+			exitCode = ExitCode(result.Err)
+		}
+	}
+
+	if failed == 0 {
+		return nil
+	}
+
+	return &exitError{
+		fmt.Errorf("%d of %d targets failed", failed, len(results)),
+		exitCode,
+	}
+}
+
+// prefixWriter writes a prefix at the start of every line written to it
+type prefixWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	prefix  string
+	midLine bool
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !pw.midLine {
+			buf.WriteString(pw.prefix)
+		}
+		buf.Write(line)
+		pw.midLine = line[len(line)-1] != '\n'
+	}
+
+	if _, err := pw.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// targetFileName inserts a target's name into a file name before its
+// extension, so that each target can write its own file
+func targetFileName(file string, t Target) string {
+	ext := filepath.Ext(file)
+	name := strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '-'
+	}, t.Name())
+	return strings.TrimSuffix(file, ext) + "-" + name + ext
+}
@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTargetForCluster(t *testing.T) {
+	target := TargetForCluster("arn:aws:ecs:eu-west-1:123456789012:cluster/production")
+	if target.Region != "eu-west-1" {
+		t.Fatalf("Expected region from ARN, got %q", target.Region)
+	}
+	if target.Name() != "eu-west-1/production" {
+		t.Fatalf("Bad target name %q", target.Name())
+	}
+
+	target = TargetForCluster("staging")
+	if target.Region != "" || target.Name() != "staging" {
+		t.Fatalf("Bad target %+v", target)
+	}
+}
+
+func TestLoadTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "targets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "targets.yml")
+	err = ioutil.WriteFile(file, []byte("targets:\n  - cluster: staging\n    region: us-east-1\n  - cluster: production\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := LoadTargets(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(targets))
+	}
+	if targets[0] != (Target{Cluster: "staging", Region: "us-east-1"}) {
+		t.Fatalf("Bad first target %+v", targets[0])
+	}
+	if targets[1] != (Target{Cluster: "production"}) {
+		t.Fatalf("Bad second target %+v", targets[1])
+	}
+}
+
+func TestSummarizeTargetsUsesFirstExitCode(t *testing.T) {
+	r := &Runner{Stderr: ioutil.Discard}
+
+	err := r.summarizeTargets([]TargetResult{
+		{Target: Target{Cluster: "a"}},
+		{Target: Target{Cluster: "b"}, Err: errors.New("boom")},
+		{Target: Target{Cluster: "c"}, Err: &exitError{errors.New("exited"), 3}},
+	})
+
+	ee, ok := err.(*exitError)
+	if !ok {
+		t.Fatalf("Expected an exit error, got %v", err)
+	}
+	if ee.ExitCode() != 3 {
+		t.Fatalf("Expected exit code 3, got %d", ee.ExitCode())
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &prefixWriter{w: &buf, prefix: "[a] "}
+
+	w.Write([]byte("first line\nsecond "))
+	w.Write([]byte("line\n"))
+
+	if expected := "[a] first line\n[a] second line\n"; buf.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestTargetRoleARN(t *testing.T) {
+	for _, tc := range []struct {
+		target   Target
+		expected string
+	}{
+		{Target{Cluster: "a"}, ""},
+		{Target{Cluster: "a", Account: "123456789012", Role: "runner"}, "arn:aws:iam::123456789012:role/runner"},
+		{Target{Cluster: "a", Region: "cn-north-1", Account: "123456789012", Role: "runner"}, "arn:aws-cn:iam::123456789012:role/runner"},
+		{Target{Cluster: "a", Role: "arn:aws:iam::123456789012:role/other"}, "arn:aws:iam::123456789012:role/other"},
+	} {
+		roleARN, err := tc.target.RoleARN()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if roleARN != tc.expected {
+			t.Fatalf("Expected role %q for %+v, got %q", tc.expected, tc.target, roleARN)
+		}
+	}
+
+	if _, err := (Target{Cluster: "a", Role: "runner"}).RoleARN(); err == nil {
+		t.Fatal("Expected an error for a role name without an account")
+	}
+}
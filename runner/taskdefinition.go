@@ -0,0 +1,517 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// describeTaskDefinitionForRegister fetches an existing task definition and
+// returns the input needed to register a new revision identical to it
+func (r *Runner) describeTaskDefinitionForRegister(svc *ecs.ECS, taskDefinition string) (*ecs.RegisterTaskDefinitionInput, error) {
+	r.logf("Describing task definition %s", taskDefinition)
+	resp, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(taskDefinition),
+		Include:        aws.StringSlice([]string{ecs.TaskDefinitionFieldTags}),
+	})
+	if err != nil {
+		return nil, wrapAPIError("DescribeTaskDefinition", err)
+	}
+
+	r.logf("Using task definition %s", *resp.TaskDefinition.TaskDefinitionArn)
+	return registerInputFromTaskDefinition(resp.TaskDefinition, resp.Tags), nil
+}
+
+// familyTaskDefinition returns the input needed to register a copy of the
+// latest active revision of Family, or of Revision if it's pinned. A pinned
+// revision that's been deregistered is only used with AllowInactive, such as
+// to roll back to it.
+func (r *Runner) familyTaskDefinition(svc *ecs.ECS) (*ecs.RegisterTaskDefinitionInput, error) {
+	if r.Revision == 0 {
+		return r.describeTaskDefinitionForRegister(svc, r.Family)
+	}
+
+	taskDefinition := fmt.Sprintf("%s:%d", r.Family, r.Revision)
+	r.logf("Describing task definition %s", taskDefinition)
+	resp, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(taskDefinition),
+		Include:        aws.StringSlice([]string{ecs.TaskDefinitionFieldTags}),
+	})
+	if err != nil {
+		return nil, wrapAPIError("DescribeTaskDefinition", err)
+	}
+	if aws.StringValue(resp.TaskDefinition.Status) == ecs.TaskDefinitionStatusInactive {
+		if !r.AllowInactive {
+			return nil, fmt.Errorf("Task definition %s has been deregistered, use --allow-inactive to run it anyway", taskDefinition)
+		}
+		fmt.Fprintf(r.Stderr, "Running task definition %s, which has been deregistered\n", taskDefinition)
+	}
+
+	r.logf("Using task definition %s", *resp.TaskDefinition.TaskDefinitionArn)
+	return registerInputFromTaskDefinition(resp.TaskDefinition, resp.Tags), nil
+}
+
+func registerInputFromTaskDefinition(td *ecs.TaskDefinition, tags []*ecs.Tag) *ecs.RegisterTaskDefinitionInput {
+	input := &ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions:    td.ContainerDefinitions,
+		Cpu:                     td.Cpu,
+		EphemeralStorage:        td.EphemeralStorage,
+		ExecutionRoleArn:        td.ExecutionRoleArn,
+		Family:                  td.Family,
+		InferenceAccelerators:   td.InferenceAccelerators,
+		IpcMode:                 td.IpcMode,
+		Memory:                  td.Memory,
+		NetworkMode:             td.NetworkMode,
+		PidMode:                 td.PidMode,
+		PlacementConstraints:    td.PlacementConstraints,
+		ProxyConfiguration:      td.ProxyConfiguration,
+		RequiresCompatibilities: td.RequiresCompatibilities,
+		RuntimePlatform:         td.RuntimePlatform,
+		TaskRoleArn:             td.TaskRoleArn,
+		Volumes:                 td.Volumes,
+	}
+	if len(tags) > 0 {
+		input.Tags = tags
+	}
+	return input
+}
+
+// copyRegisterInput returns a deep copy of a task definition, as registering
+// one changes it
+func copyRegisterInput(input *ecs.RegisterTaskDefinitionInput) (*ecs.RegisterTaskDefinitionInput, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	var copied ecs.RegisterTaskDefinitionInput
+	if err := json.Unmarshal(body, &copied); err != nil {
+		return nil, err
+	}
+	return &copied, nil
+}
+
+// overrideImages replaces the images of container definitions. Each image is
+// either `container=image` to target a named container, or just an image which
+// replaces the image of any container from the same repository, or the image of
+// the only container if there is just one
+func overrideImages(defs []*ecs.ContainerDefinition, images []string) error {
+	for _, image := range images {
+		if parts := strings.SplitN(image, "=", 2); len(parts) == 2 {
+			def := findContainerDefinition(defs, parts[0])
+			if def == nil {
+				return fmt.Errorf("No container named %q to set the image of", parts[0])
+			}
+			log.Printf("Replacing image for %s with %s", parts[0], parts[1])
+			def.Image = aws.String(parts[1])
+			continue
+		}
+
+		var matched bool
+		for _, def := range defs {
+			if imageRepository(aws.StringValue(def.Image)) == imageRepository(image) {
+				log.Printf("Replacing image for %s with %s", aws.StringValue(def.Name), image)
+				def.Image = aws.String(image)
+				matched = true
+			}
+		}
+
+		if !matched {
+			if len(defs) != 1 {
+				return fmt.Errorf("No container uses the repository of %s and can't determine a default with %d container definitions", image, len(defs))
+			}
+			log.Printf("Replacing image for %s with %s", aws.StringValue(defs[0].Name), image)
+			defs[0].Image = aws.String(image)
+		}
+	}
+	return nil
+}
+
+func findContainerDefinition(defs []*ecs.ContainerDefinition, name string) *ecs.ContainerDefinition {
+	for _, def := range defs {
+		if aws.StringValue(def.Name) == name {
+			return def
+		}
+	}
+	return nil
+}
+
+// imageRepository returns an image reference without its tag or digest
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i != -1 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// preparedTaskDefinition is a registered task definition that is ready to run
+type preparedTaskDefinition struct {
+	// Name is the family and revision or ARN of the task definition
+	Name       string
+	Containers []*ecs.ContainerDefinition
+
+	// Logs is where each container that has its logs streamed sends them
+	Logs map[string]logConfig
+
+	// Digest identifies the task definition's contents, for caching results
+	Digest string
+
+	// Family is the family that the task definition was registered in, if it
+	// was registered for the run rather than run as-is
+	Family string
+}
+
+// prepareTaskDefinition finds or registers the task definition to run. Task
+// definitions are registered from a file or an existing family with their logs
+// sent to the runner's log group. A service's task definition is run as-is
+// with logs streamed from wherever it already sends them, unless it's being
+// changed, such as by replacing images, as is ExistingTaskDefinition.
+func (r *Runner) prepareTaskDefinition(sess *session.Session, svc *ecs.ECS, service *ecs.Service) (*preparedTaskDefinition, error) {
+	if r.ExistingTaskDefinition != "" {
+		if r.changesTaskDefinition() {
+			return nil, fmt.Errorf("Task definition %s is run as it's registered, so it can't be changed, such as by replacing images or adding artifacts, which needs a new revision from --from-family instead",
+				r.ExistingTaskDefinition)
+		}
+		return r.existingTaskDefinition(sess, svc, r.ExistingTaskDefinition)
+	}
+	if service != nil && !r.changesTaskDefinition() {
+		return r.existingTaskDefinition(sess, svc, *service.TaskDefinition)
+	}
+
+	var input *ecs.RegisterTaskDefinitionInput
+	var err error
+
+	switch {
+	case service != nil:
+		input, err = r.describeTaskDefinitionForRegister(svc, *service.TaskDefinition)
+	case r.Family != "":
+		input, err = r.familyTaskDefinition(svc)
+	case r.TaskDefinition != nil:
+		input, err = copyRegisterInput(r.TaskDefinition)
+	default:
+		input, err = r.cache.parse(r.TaskDefinitionFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return r.registerTaskDefinition(sess, svc, input)
+}
+
+func (r *Runner) registerTaskDefinition(sess *session.Session, svc *ecs.ECS, input *ecs.RegisterTaskDefinitionInput) (*preparedTaskDefinition, error) {
+	if r.DisableProxy {
+		disableProxy(input)
+	}
+
+	if err := validateProxyConfiguration(input); err != nil {
+		return nil, err
+	}
+
+	if err := overrideImages(input.ContainerDefinitions, r.Images); err != nil {
+		return nil, err
+	}
+
+	if err := applyInferenceAccelerators(input, r.InferenceAccelerators); err != nil {
+		return nil, err
+	}
+
+	if err := applyFirelensOptions(input.ContainerDefinitions, r.FirelensOptions); err != nil {
+		return nil, err
+	}
+
+	if err := r.applyLinuxOptions(input); err != nil {
+		return nil, err
+	}
+
+	if err := applyInitCommands(input, r.Service, r.InitCommands); err != nil {
+		return nil, err
+	}
+
+	r.stripFargateUnsupported(input)
+
+	if err := r.applyArtifactsWrapper(input); err != nil {
+		return nil, err
+	}
+
+	if err := r.checkLogContainers(input.ContainerDefinitions); err != nil {
+		return nil, err
+	}
+
+	if err := r.checkSecrets(sess, input.ContainerDefinitions); err != nil {
+		return nil, err
+	}
+
+	if r.SOCICheck != "" {
+		if err := r.checkSOCIIndexes(sess, input.ContainerDefinitions); err != nil {
+			return nil, err
+		}
+	}
+
+	streamPrefix, err := r.streamPrefix(aws.StringValue(input.Family))
+	if err != nil {
+		return nil, err
+	}
+
+	logsSess := r.logsSession(sess)
+	created, err := createLogGroup(logsSess, r.LogGroupName)
+	if err != nil {
+		return nil, logAccessError(r.LogGroupName, r.logRegion(), err)
+	}
+	// a log group that's shared with other runs isn't tagged with this one
+	if created {
+		r.tagLogGroup(cloudwatchlogs.New(logsSess), r.LogGroupName, aws.StringValue(input.Family))
+	}
+
+	digest, err := definitionDigest(input)
+	if err != nil {
+		return nil, err
+	}
+
+	td := &preparedTaskDefinition{
+		Containers: input.ContainerDefinitions,
+		Logs:       map[string]logConfig{},
+		Digest:     digest,
+		Family:     aws.StringValue(input.Family),
+	}
+
+	r.logf("Setting tasks to use log group %s", r.LogGroupName)
+	proxyContainer := proxyContainerName(input)
+	for _, def := range input.ContainerDefinitions {
+		if !r.streamsLogsFor(*def.Name) {
+			r.logf("Leaving log configuration of %s unchanged", *def.Name)
+			continue
+		}
+
+		// proxies like Envoy are configured to log to wherever the mesh
+		// expects, so only change them when asked to
+		if *def.Name == proxyContainer && len(r.LogContainers) == 0 {
+			r.logf("Leaving log configuration of proxy container %s unchanged", *def.Name)
+			continue
+		}
+
+		def.LogConfiguration = &ecs.LogConfiguration{
+			LogDriver: aws.String("awslogs"),
+			Options: map[string]*string{
+				"awslogs-group":         aws.String(r.LogGroupName),
+				"awslogs-region":        aws.String(r.logRegion()),
+				"awslogs-stream-prefix": aws.String(streamPrefix),
+			},
+		}
+		td.Logs[*def.Name] = logConfig{
+			Group:        r.LogGroupName,
+			Region:       r.logRegion(),
+			StreamPrefix: streamPrefix,
+		}
+	}
+
+	// whether the revision was registered by this run, or reused along with
+	// the stream prefix it was registered with
+	var registered bool
+	var reused *ecs.TaskDefinition
+	name, err := r.cache.register(r.cacheScope, input, func() (string, error) {
+		hash, err := r.contentHash(input, td.Logs)
+		if err != nil {
+			return "", err
+		}
+		if !r.ForceRegister {
+			if def, ok := r.unchangedRevision(svc, *input.Family, hash); ok {
+				reused = def
+				name := fmt.Sprintf("%s:%d", aws.StringValue(def.Family), aws.Int64Value(def.Revision))
+				fmt.Fprintf(r.Stderr, "Reusing task definition %s, which is unchanged\n", name)
+				return name, nil
+			}
+		}
+		registered = true
+
+		r.logf("Registering a task for %s", *input.Family)
+		resp, err := r.registerWithContentHash(svc, input, hash)
+		if err != nil {
+			return "", revisionLimitError(*input.Family, wrapAPIError("RegisterTaskDefinition", err))
+		}
+		printRegistrationWarnings(r.Stderr, input, resp.TaskDefinition)
+		return fmt.Sprintf("%s:%d", *resp.TaskDefinition.Family, *resp.TaskDefinition.Revision), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// another target may have reused a revision registered with another
+	// run's stream prefix
+	if reused == nil && !registered && r.TaskName == "" {
+		r.logf("Describing task definition %s for its stream prefixes", name)
+		resp, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: aws.String(name)})
+		if err != nil {
+			return nil, wrapAPIError("DescribeTaskDefinition", err)
+		}
+		reused = resp.TaskDefinition
+	}
+	if reused != nil {
+		td.useStreamPrefixes(reused)
+	}
+
+	td.Name = name
+	return td, nil
+}
+
+// existingTaskDefinition prepares an already registered task definition to be
+// run without changes, streaming logs from containers that use awslogs
+func (r *Runner) existingTaskDefinition(sess *session.Session, svc *ecs.ECS, taskDefinition string) (*preparedTaskDefinition, error) {
+	r.logf("Describing task definition %s", taskDefinition)
+	resp, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(taskDefinition),
+	})
+	if err != nil {
+		return nil, wrapAPIError("DescribeTaskDefinition", err)
+	}
+	// tasks that were already started are followed whatever the status of
+	// their task definition
+	if r.resume == nil {
+		if err := checkTaskDefinitionStatus(resp.TaskDefinition); err != nil {
+			return nil, err
+		}
+	}
+
+	td := &preparedTaskDefinition{
+		Name:       *resp.TaskDefinition.TaskDefinitionArn,
+		Containers: resp.TaskDefinition.ContainerDefinitions,
+		Logs:       map[string]logConfig{},
+		Digest:     *resp.TaskDefinition.TaskDefinitionArn,
+	}
+
+	if err := r.checkLogContainers(td.Containers); err != nil {
+		return nil, err
+	}
+
+	// tasks that were already started have fetched their secrets
+	if r.resume == nil {
+		if err := r.checkSecrets(sess, td.Containers); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.SOCICheck != "" {
+		if err := r.checkSOCIIndexes(sess, td.Containers); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, def := range td.Containers {
+		if !r.streamsLogsFor(*def.Name) {
+			continue
+		}
+		lc, ok := awslogsConfig(def.LogConfiguration, aws.StringValue(sess.Config.Region))
+		if !ok {
+			fmt.Fprintf(r.Stderr, "Not streaming logs for %s, it doesn't use the awslogs driver with a stream prefix\n", *def.Name)
+			continue
+		}
+		td.Logs[*def.Name] = lc
+	}
+
+	return td, nil
+}
+
+// saveTaskDefinition writes the task definition that tasks are run with to
+// SaveTaskDefinition, in the JSON that ECS describes it with, including its
+// ARN and revision, so that later steps such as a service deploy can use the
+// same revision
+func (r *Runner) saveTaskDefinition(svc *ecs.ECS, td *preparedTaskDefinition) error {
+	if r.SaveTaskDefinition == "" {
+		return nil
+	}
+	resp, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(td.Name),
+	})
+	if err != nil {
+		return wrapAPIError("DescribeTaskDefinition", err)
+	}
+
+	body, err := json.MarshalIndent(apiJSON(reflect.ValueOf(resp.TaskDefinition)), "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(r.SaveTaskDefinition, append(body, '\n'), 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to save task definition to %s: %v", r.SaveTaskDefinition, err)
+	}
+	r.logf("Saved task definition %s to %s", aws.StringValue(resp.TaskDefinition.TaskDefinitionArn), r.SaveTaskDefinition)
+	return nil
+}
+
+// apiJSON converts an API type to a value that encodes to JSON with the keys
+// that ECS uses, which are the fields' location names rather than their Go
+// names. Keys of maps such as docker labels are left as they are.
+func apiJSON(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return apiJSON(v.Elem())
+
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return t
+		}
+		m := map[string]interface{}{}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := field.Tag.Get("locationName")
+			if name == "" {
+				name = strings.ToLower(field.Name[:1]) + field.Name[1:]
+			}
+			if value := apiJSON(v.Field(i)); value != nil {
+				m[name] = value
+			}
+		}
+		return m
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		s := make([]interface{}, v.Len())
+		for i := range s {
+			s[i] = apiJSON(v.Index(i))
+		}
+		return s
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := map[string]interface{}{}
+		for _, key := range v.MapKeys() {
+			m[key.String()] = apiJSON(v.MapIndex(key))
+		}
+		return m
+	}
+	return v.Interface()
+}
+
+// defaultStreamPrefix generates a log stream prefix for when there's no task
+// name, from the run's ID
+func defaultStreamPrefix(runID string) string {
+	return "run_task_" + runID
+}
+
+func (r *Runner) checkLogContainers(defs []*ecs.ContainerDefinition) error {
+	for _, name := range r.LogContainers {
+		if findContainerDefinition(defs, name) == nil {
+			return fmt.Errorf("No container named %q to stream logs from", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestImageRepository(t *testing.T) {
+	for image, expected := range map[string]string{
+		"hello-world":              "hello-world",
+		"hello-world:latest":       "hello-world",
+		"localhost:5000/my-app":    "localhost:5000/my-app",
+		"localhost:5000/my-app:v1": "localhost:5000/my-app",
+		"my-app@sha256:abc123":     "my-app",
+		"123456789012.dkr.ecr.us-east-1.amazonaws.com/team/app:v2": "123456789012.dkr.ecr.us-east-1.amazonaws.com/team/app",
+	} {
+		if actual := imageRepository(image); actual != expected {
+			t.Fatalf("Expected repository of %s to be %s, got %s", image, expected, actual)
+		}
+	}
+}
+
+func TestOverrideImages(t *testing.T) {
+	defs := []*ecs.ContainerDefinition{
+		{Name: aws.String("app"), Image: aws.String("my-org/app:v1")},
+		{Name: aws.String("worker"), Image: aws.String("my-org/app:v1")},
+		{Name: aws.String("envoy"), Image: aws.String("envoyproxy/envoy:v1.28")},
+	}
+
+	err := overrideImages(defs, []string{"my-org/app:v2", "envoy=envoyproxy/envoy:v1.29"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{
+		"app":    "my-org/app:v2",
+		"worker": "my-org/app:v2",
+		"envoy":  "envoyproxy/envoy:v1.29",
+	} {
+		if image := *findContainerDefinition(defs, name).Image; image != expected {
+			t.Fatalf("Expected image of %s to be %s, got %s", name, expected, image)
+		}
+	}
+}
+
+func TestOverrideImagesDefaultsToOnlyContainer(t *testing.T) {
+	defs := []*ecs.ContainerDefinition{
+		{Name: aws.String("app"), Image: aws.String("my-org/app:v1")},
+	}
+
+	if err := overrideImages(defs, []string{"my-org/other:v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if *defs[0].Image != "my-org/other:v2" {
+		t.Fatalf("Bad image %s", *defs[0].Image)
+	}
+}
+
+func TestCopyRegisterInput(t *testing.T) {
+	input := &ecs.RegisterTaskDefinitionInput{
+		Family: aws.String("app"),
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{Name: aws.String("app"), Image: aws.String("my-org/app:v1")},
+		},
+	}
+
+	copied, err := copyRegisterInput(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := overrideImages(copied.ContainerDefinitions, []string{"my-org/app:v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if *input.ContainerDefinitions[0].Image != "my-org/app:v1" {
+		t.Fatalf("Expected the original to be unchanged, got %s", *input.ContainerDefinitions[0].Image)
+	}
+}
+
+func TestOverrideImagesErrors(t *testing.T) {
+	defs := []*ecs.ContainerDefinition{
+		{Name: aws.String("app"), Image: aws.String("my-org/app:v1")},
+		{Name: aws.String("envoy"), Image: aws.String("envoyproxy/envoy:v1.28")},
+	}
+
+	if err := overrideImages(defs, []string{"my-org/other:v2"}); err == nil {
+		t.Fatal("Expected an error for an image with no matching repository")
+	}
+	if err := overrideImages(defs, []string{"missing=my-org/app:v2"}); err == nil {
+		t.Fatal("Expected an error for a missing container")
+	}
+}
+
+func TestApplyInferenceAccelerators(t *testing.T) {
+	input := &ecs.RegisterTaskDefinitionInput{
+		InferenceAccelerators: []*ecs.InferenceAccelerator{
+			{DeviceName: aws.String("device_1"), DeviceType: aws.String("eia2.medium")},
+		},
+	}
+
+	if err := applyInferenceAccelerators(input, []string{"device_1=eia2.large", "device_2=eia2.xlarge"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(input.InferenceAccelerators) != 2 {
+		t.Fatalf("Expected 2 accelerators, got %d", len(input.InferenceAccelerators))
+	}
+	if *input.InferenceAccelerators[0].DeviceType != "eia2.large" || *input.InferenceAccelerators[1].DeviceName != "device_2" {
+		t.Fatalf("Unexpected accelerators %v", input.InferenceAccelerators)
+	}
+
+	if err := applyInferenceAccelerators(input, []string{"device_3"}); err == nil {
+		t.Fatal("Expected an error without a device type")
+	}
+}
+
+func TestApplyFirelensOptions(t *testing.T) {
+	defs := []*ecs.ContainerDefinition{
+		{Name: aws.String("app")},
+		{Name: aws.String("log_router"), FirelensConfiguration: &ecs.FirelensConfiguration{Type: aws.String("fluentbit")}},
+	}
+
+	if err := applyFirelensOptions(defs, []string{"enable-ecs-log-metadata=true", "log_router:config-file-type=s3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	options := defs[1].FirelensConfiguration.Options
+	if *options["enable-ecs-log-metadata"] != "true" || *options["config-file-type"] != "s3" {
+		t.Fatalf("Unexpected options %v", options)
+	}
+
+	if err := applyFirelensOptions(defs, []string{"app:config-file-type=s3"}); err == nil {
+		t.Fatal("Expected an error for a container without a FireLens configuration")
+	}
+}
+
+func TestApplyLinuxOptions(t *testing.T) {
+	input := &ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{Name: aws.String("app")},
+			{Name: aws.String("sidecar")},
+		},
+	}
+	r := &Runner{
+		PidMode:            NamespaceModeTask,
+		InitProcessEnabled: true,
+		SharedMemorySizes:  []string{"app=512"},
+		Tmpfs:              []string{"app=/scratch:64:rw,noexec"},
+		CapAdd:             []string{"app=sys_ptrace"},
+		CapDrop:            []string{"sidecar=NET_RAW"},
+	}
+	if err := r.applyLinuxOptions(input); err != nil {
+		t.Fatal(err)
+	}
+
+	if aws.StringValue(input.PidMode) != "task" || input.IpcMode != nil {
+		t.Errorf("Unexpected PID mode %v and IPC mode %v", input.PidMode, input.IpcMode)
+	}
+	app, sidecar := input.ContainerDefinitions[0].LinuxParameters, input.ContainerDefinitions[1].LinuxParameters
+	if !aws.BoolValue(app.InitProcessEnabled) || !aws.BoolValue(sidecar.InitProcessEnabled) {
+		t.Errorf("Expected an init process in every container")
+	}
+	if aws.Int64Value(app.SharedMemorySize) != 512 {
+		t.Errorf("Unexpected shared memory size %v", app.SharedMemorySize)
+	}
+	if len(app.Tmpfs) != 1 || *app.Tmpfs[0].ContainerPath != "/scratch" || *app.Tmpfs[0].Size != 64 || len(app.Tmpfs[0].MountOptions) != 2 {
+		t.Errorf("Unexpected tmpfs %v", app.Tmpfs)
+	}
+	if caps := app.Capabilities; len(caps.Add) != 1 || *caps.Add[0] != "SYS_PTRACE" {
+		t.Errorf("Unexpected capabilities %v", caps)
+	}
+	if caps := sidecar.Capabilities; len(caps.Drop) != 1 || *caps.Drop[0] != "NET_RAW" {
+		t.Errorf("Unexpected capabilities %v", caps)
+	}
+}
+
+func TestApplyLinuxOptionsErrors(t *testing.T) {
+	for _, r := range []*Runner{
+		{CapAdd: []string{"SYS_PTRACE"}},
+		{CapAdd: []string{"missing=SYS_PTRACE"}},
+		{SharedMemorySizes: []string{"app=lots"}},
+		{Tmpfs: []string{"app=/scratch"}},
+	} {
+		input := &ecs.RegisterTaskDefinitionInput{
+			ContainerDefinitions: []*ecs.ContainerDefinition{{Name: aws.String("app")}, {Name: aws.String("sidecar")}},
+		}
+		if err := r.applyLinuxOptions(input); err == nil {
+			t.Errorf("Expected an error for %+v", r)
+		}
+	}
+}
+
+func TestApplyInitCommands(t *testing.T) {
+	input := &ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{Name: aws.String("app"), Image: aws.String("app:1"), Memory: aws.Int64(512)},
+		},
+	}
+	if err := applyInitCommands(input, "", []string{"./fetch-fixtures.sh", "./migrate.sh"}); err != nil {
+		t.Fatal(err)
+	}
+
+	defs := input.ContainerDefinitions
+	if len(defs) != 3 || *defs[1].Name != "init-1" || *defs[2].Name != "init-2" {
+		t.Fatalf("Unexpected containers %v", defs)
+	}
+	if *defs[1].Image != "app:1" || aws.BoolValue(defs[1].Essential) || aws.Int64Value(defs[1].MemoryReservation) != 512 {
+		t.Errorf("Unexpected init container %v", defs[1])
+	}
+	if cmd := aws.StringValueSlice(append(defs[2].EntryPoint, defs[2].Command...)); len(cmd) != 3 || cmd[2] != "./migrate.sh" {
+		t.Errorf("Unexpected command %v", cmd)
+	}
+	if len(defs[1].DependsOn) != 0 || *defs[2].DependsOn[0].ContainerName != "init-1" {
+		t.Errorf("Expected init containers to run in order")
+	}
+	if deps := defs[0].DependsOn; len(deps) != 1 || *deps[0].ContainerName != "init-2" || *deps[0].Condition != "SUCCESS" {
+		t.Errorf("Expected the main container to wait for init-2, got %v", deps)
+	}
+
+	if main := withoutInitContainers(defs, 2); len(main) != 1 || *main[0].Name != "app" {
+		t.Errorf("Unexpected containers without init containers %v", main)
+	}
+}
+
+func TestApplyInitCommandsNeedsAService(t *testing.T) {
+	input := &ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions: []*ecs.ContainerDefinition{{Name: aws.String("app")}, {Name: aws.String("worker")}},
+	}
+	if err := applyInitCommands(input, "", []string{"true"}); err == nil {
+		t.Fatal("Expected an error without a service")
+	}
+	if err := applyInitCommands(input, "worker", []string{"true"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(input.ContainerDefinitions[1].DependsOn) != 1 {
+		t.Error("Expected worker to wait for the init container")
+	}
+}
+
+func TestSaveTaskDefinition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taskdefinition")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var described string
+	sess := fakeSession(func(req *request.Request) {
+		described = aws.StringValue(req.Params.(*ecs.DescribeTaskDefinitionInput).TaskDefinition)
+		req.Data.(*ecs.DescribeTaskDefinitionOutput).TaskDefinition = &ecs.TaskDefinition{
+			TaskDefinitionArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/app:3"),
+			Family:            aws.String("app"),
+			Revision:          aws.Int64(3),
+			ContainerDefinitions: []*ecs.ContainerDefinition{{
+				Name:         aws.String("app"),
+				Image:        aws.String("app:latest"),
+				DockerLabels: map[string]*string{"Team": aws.String("platform")},
+			}},
+		}
+	})
+
+	file := filepath.Join(dir, "taskdefinition.json")
+	r := &Runner{SaveTaskDefinition: file}
+	if err := r.saveTaskDefinition(ecs.New(sess), &preparedTaskDefinition{Name: "app:3"}); err != nil {
+		t.Fatal(err)
+	}
+	if described != "app:3" {
+		t.Errorf("Expected app:3 to be described, got %q", described)
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved map[string]interface{}
+	if err := json.Unmarshal(b, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved["taskDefinitionArn"] != "arn:aws:ecs:us-east-1:123456789012:task-definition/app:3" || saved["revision"] != float64(3) {
+		t.Fatalf("Expected the task definition as ECS describes it, got %s", b)
+	}
+	container := saved["containerDefinitions"].([]interface{})[0].(map[string]interface{})
+	if labels, ok := container["dockerLabels"].(map[string]interface{}); !ok || labels["Team"] != "platform" {
+		t.Fatalf("Expected docker labels to keep their keys, got %s", b)
+	}
+}
+
+func TestFamilyTaskDefinitionRevision(t *testing.T) {
+	var described string
+	sess := fakeSession(func(req *request.Request) {
+		described = aws.StringValue(req.Params.(*ecs.DescribeTaskDefinitionInput).TaskDefinition)
+		req.Data.(*ecs.DescribeTaskDefinitionOutput).TaskDefinition = &ecs.TaskDefinition{
+			TaskDefinitionArn:    aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/app:3"),
+			Family:               aws.String("app"),
+			Revision:             aws.Int64(3),
+			Status:               aws.String(ecs.TaskDefinitionStatusInactive),
+			ContainerDefinitions: []*ecs.ContainerDefinition{{Name: aws.String("app"), Image: aws.String("app:v1")}},
+		}
+	})
+
+	var stderr bytes.Buffer
+	r := &Runner{Family: "app", Revision: 3, Stderr: &stderr}
+	if _, err := r.familyTaskDefinition(ecs.New(sess)); err == nil || !strings.Contains(err.Error(), "--allow-inactive") {
+		t.Fatalf("Expected an inactive revision to fail without --allow-inactive, got %v", err)
+	}
+	if described != "app:3" {
+		t.Errorf("Expected app:3 to be described, got %q", described)
+	}
+
+	r.AllowInactive = true
+	input, err := r.familyTaskDefinition(ecs.New(sess))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image := aws.StringValue(input.ContainerDefinitions[0].Image); image != "app:v1" {
+		t.Errorf("Expected the pinned revision's image, got %q", image)
+	}
+	if !strings.Contains(stderr.String(), "deregistered") {
+		t.Errorf("Expected a warning about running a deregistered revision, got %q", stderr.String())
+	}
+}
+
+func TestPrepareExistingTaskDefinition(t *testing.T) {
+	var described string
+	sess := fakeSession(func(req *request.Request) {
+		params, ok := req.Params.(*ecs.DescribeTaskDefinitionInput)
+		if !ok {
+			t.Fatalf("Expected only the task definition to be described, got %s", req.Operation.Name)
+		}
+		described = aws.StringValue(params.TaskDefinition)
+		req.Data.(*ecs.DescribeTaskDefinitionOutput).TaskDefinition = &ecs.TaskDefinition{
+			TaskDefinitionArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/app:3"),
+			Family:            aws.String("app"),
+			Revision:          aws.Int64(3),
+			Status:            aws.String(ecs.TaskDefinitionStatusActive),
+			ContainerDefinitions: []*ecs.ContainerDefinition{{
+				Name:  aws.String("app"),
+				Image: aws.String("app:v1"),
+				LogConfiguration: &ecs.LogConfiguration{
+					LogDriver: aws.String("awslogs"),
+					Options: aws.StringMap(map[string]string{
+						"awslogs-group":         "terraform-managed",
+						"awslogs-stream-prefix": "app",
+					}),
+				},
+			}},
+		}
+	})
+
+	var stderr bytes.Buffer
+	r := &Runner{ExistingTaskDefinition: "app:3", LogGroupName: "ecs-task-runner", Stderr: &stderr}
+	td, err := r.prepareTaskDefinition(sess, ecs.New(sess), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if described != "app:3" {
+		t.Errorf("Expected app:3 to be described, got %q", described)
+	}
+	if td.Name != "arn:aws:ecs:us-east-1:123456789012:task-definition/app:3" || td.Family != "" {
+		t.Errorf("Expected the registered revision to be run as-is, got %+v", td)
+	}
+	if lc := td.Logs["app"]; lc.Group != "terraform-managed" {
+		t.Errorf("Expected logs to be streamed from the task definition's log group, got %+v", td.Logs)
+	}
+
+	r.Images = []string{"app=app:v2"}
+	if _, err := r.prepareTaskDefinition(sess, ecs.New(sess), nil); err == nil || !strings.Contains(err.Error(), "--from-family") {
+		t.Errorf("Expected changing an existing task definition to fail, got %v", err)
+	}
+}
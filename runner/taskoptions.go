@@ -0,0 +1,313 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// changesTaskDefinition returns whether options need a new revision of a
+// task definition to be registered, rather than running it as-is
+func (r *Runner) changesTaskDefinition() bool {
+	return len(r.Images) > 0 || len(r.InferenceAccelerators) > 0 || len(r.FirelensOptions) > 0 || r.DisableProxy ||
+		r.PidMode != "" || r.IpcMode != "" || r.InitProcessEnabled || len(r.SharedMemorySizes) > 0 ||
+		len(r.Tmpfs) > 0 || len(r.CapAdd) > 0 || len(r.CapDrop) > 0 || len(r.InitCommands) > 0 ||
+		r.ArtifactsPath != ""
+}
+
+// applyInferenceAccelerators adds inference accelerators to a task definition
+// from `DEVICE=TYPE` values, replacing any existing ones with the same name
+func applyInferenceAccelerators(input *ecs.RegisterTaskDefinitionInput, accelerators []string) error {
+	for _, accelerator := range accelerators {
+		parts := strings.SplitN(accelerator, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("Inference accelerator %q should be in the form DEVICE=TYPE", accelerator)
+		}
+
+		var replaced bool
+		for _, ia := range input.InferenceAccelerators {
+			if aws.StringValue(ia.DeviceName) == parts[0] {
+				ia.DeviceType = aws.String(parts[1])
+				replaced = true
+			}
+		}
+		if !replaced {
+			input.InferenceAccelerators = append(input.InferenceAccelerators, &ecs.InferenceAccelerator{
+				DeviceName: aws.String(parts[0]),
+				DeviceType: aws.String(parts[1]),
+			})
+		}
+		log.Printf("Using inference accelerator %s of type %s", parts[0], parts[1])
+	}
+	return nil
+}
+
+// applyFirelensOptions sets FireLens options from `[CONTAINER:]KEY=VALUE`
+// values. Without a container name, options are set on the only container
+// with a FireLens configuration, which is the log router.
+func applyFirelensOptions(defs []*ecs.ContainerDefinition, options []string) error {
+	for _, option := range options {
+		parts := strings.SplitN(option, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("FireLens option %q should be in the form [CONTAINER:]KEY=VALUE", option)
+		}
+
+		key := parts[0]
+		var def *ecs.ContainerDefinition
+		if i := strings.Index(key, ":"); i != -1 {
+			def = findContainerDefinition(defs, key[:i])
+			if def == nil || def.FirelensConfiguration == nil {
+				return fmt.Errorf("No container named %q with a FireLens configuration", key[:i])
+			}
+			key = key[i+1:]
+		} else {
+			var routers []*ecs.ContainerDefinition
+			for _, d := range defs {
+				if d.FirelensConfiguration != nil {
+					routers = append(routers, d)
+				}
+			}
+			if len(routers) != 1 {
+				return fmt.Errorf("Can't determine which container to set FireLens option %q on with %d log routers", key, len(routers))
+			}
+			def = routers[0]
+		}
+
+		if def.FirelensConfiguration.Options == nil {
+			def.FirelensConfiguration.Options = map[string]*string{}
+		}
+		log.Printf("Setting FireLens option %s for %s", key, aws.StringValue(def.Name))
+		def.FirelensConfiguration.Options[key] = aws.String(parts[1])
+	}
+	return nil
+}
+
+// Task-level PID and IPC namespace modes
+const (
+	NamespaceModeHost = "host"
+	NamespaceModeTask = "task"
+	NamespaceModeNone = "none"
+)
+
+// applyLinuxOptions sets the task's PID and IPC modes and container Linux
+// parameters, such as adding SYS_PTRACE for a profiler
+func (r *Runner) applyLinuxOptions(input *ecs.RegisterTaskDefinitionInput) error {
+	if r.PidMode != "" {
+		r.logf("Setting PID mode to %s", r.PidMode)
+		input.PidMode = aws.String(r.PidMode)
+	}
+	if r.IpcMode != "" {
+		r.logf("Setting IPC mode to %s", r.IpcMode)
+		input.IpcMode = aws.String(r.IpcMode)
+	}
+
+	defs := input.ContainerDefinitions
+	if r.InitProcessEnabled {
+		for _, def := range defs {
+			linuxParameters(def).InitProcessEnabled = aws.Bool(true)
+		}
+	}
+
+	for _, value := range r.SharedMemorySizes {
+		def, size, err := containerOption(defs, value, "shared memory size")
+		if err != nil {
+			return err
+		}
+		mib, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || mib <= 0 {
+			return fmt.Errorf("Shared memory size %q should be in the form [CONTAINER=]MIB", value)
+		}
+		r.logf("Setting shared memory size of %s to %d MiB", aws.StringValue(def.Name), mib)
+		linuxParameters(def).SharedMemorySize = aws.Int64(mib)
+	}
+
+	for _, value := range r.Tmpfs {
+		def, mount, err := containerOption(defs, value, "tmpfs mount")
+		if err != nil {
+			return err
+		}
+		tmpfs, err := parseTmpfs(mount)
+		if err != nil {
+			return fmt.Errorf("Tmpfs mount %q should be in the form [CONTAINER=]PATH:MIB[:OPTION,...]", value)
+		}
+		r.logf("Adding a tmpfs mount at %s to %s", aws.StringValue(tmpfs.ContainerPath), aws.StringValue(def.Name))
+		lp := linuxParameters(def)
+		lp.Tmpfs = append(lp.Tmpfs, tmpfs)
+	}
+
+	for _, value := range r.CapAdd {
+		def, capability, err := containerOption(defs, value, "capability")
+		if err != nil {
+			return err
+		}
+		r.logf("Adding capability %s to %s", capability, aws.StringValue(def.Name))
+		caps := kernelCapabilities(def)
+		caps.Add = append(caps.Add, aws.String(strings.ToUpper(capability)))
+	}
+
+	for _, value := range r.CapDrop {
+		def, capability, err := containerOption(defs, value, "capability")
+		if err != nil {
+			return err
+		}
+		r.logf("Dropping capability %s from %s", capability, aws.StringValue(def.Name))
+		caps := kernelCapabilities(def)
+		caps.Drop = append(caps.Drop, aws.String(strings.ToUpper(capability)))
+	}
+
+	return nil
+}
+
+// containerOption splits a `[CONTAINER=]VALUE` option into the container it
+// applies to and its value. Without a container name it applies to the only
+// container.
+func containerOption(defs []*ecs.ContainerDefinition, option, what string) (*ecs.ContainerDefinition, string, error) {
+	if parts := strings.SplitN(option, "=", 2); len(parts) == 2 {
+		def := findContainerDefinition(defs, parts[0])
+		if def == nil {
+			return nil, "", fmt.Errorf("No container named %q to set the %s of", parts[0], what)
+		}
+		return def, parts[1], nil
+	}
+	if len(defs) != 1 {
+		return nil, "", fmt.Errorf("Can't determine which container to set %s %q on with %d container definitions", what, option, len(defs))
+	}
+	return defs[0], option, nil
+}
+
+// parseTmpfs parses a `PATH:MIB[:OPTION,...]` tmpfs mount
+func parseTmpfs(mount string) (*ecs.Tmpfs, error) {
+	parts := strings.SplitN(mount, ":", 3)
+	if len(parts) < 2 || parts[0] == "" {
+		return nil, fmt.Errorf("Invalid tmpfs mount %q", mount)
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("Invalid tmpfs size %q", parts[1])
+	}
+	tmpfs := &ecs.Tmpfs{ContainerPath: aws.String(parts[0]), Size: aws.Int64(size)}
+	if len(parts) == 3 && parts[2] != "" {
+		tmpfs.MountOptions = aws.StringSlice(strings.Split(parts[2], ","))
+	}
+	return tmpfs, nil
+}
+
+func linuxParameters(def *ecs.ContainerDefinition) *ecs.LinuxParameters {
+	if def.LinuxParameters == nil {
+		def.LinuxParameters = &ecs.LinuxParameters{}
+	}
+	return def.LinuxParameters
+}
+
+func kernelCapabilities(def *ecs.ContainerDefinition) *ecs.KernelCapabilities {
+	lp := linuxParameters(def)
+	if lp.Capabilities == nil {
+		lp.Capabilities = &ecs.KernelCapabilities{}
+	}
+	return lp.Capabilities
+}
+
+// initContainerName is the name of the init container that runs the nth
+// init command, counting from 1
+func initContainerName(n int) string {
+	return fmt.Sprintf("init-%d", n)
+}
+
+// withoutInitContainers returns container definitions other than those added
+// for n init commands
+func withoutInitContainers(defs []*ecs.ContainerDefinition, n int) []*ecs.ContainerDefinition {
+	if n == 0 {
+		return defs
+	}
+	init := map[string]bool{}
+	for i := 1; i <= n; i++ {
+		init[initContainerName(i)] = true
+	}
+	var without []*ecs.ContainerDefinition
+	for _, def := range defs {
+		if !init[aws.StringValue(def.Name)] {
+			without = append(without, def)
+		}
+	}
+	return without
+}
+
+// mainContainer returns the container that options for what apply to, which
+// is the given service or the only container
+func mainContainer(defs []*ecs.ContainerDefinition, service, what string) (*ecs.ContainerDefinition, error) {
+	if service != "" {
+		def := findContainerDefinition(defs, service)
+		if def == nil {
+			return nil, fmt.Errorf("No container named %q for %s", service, what)
+		}
+		return def, nil
+	}
+	if len(defs) != 1 {
+		return nil, fmt.Errorf("No service provided for %s and can't determine default service with %d container definitions", what, len(defs))
+	}
+	return defs[0], nil
+}
+
+// applyInitCommands adds a container for each init command that runs it with
+// `sh -c` in the image of the main container, which is the given service or
+// the only container. Each waits for the one before it to succeed, and the
+// main container waits for the last.
+func applyInitCommands(input *ecs.RegisterTaskDefinitionInput, service string, commands []string) error {
+	if len(commands) == 0 {
+		return nil
+	}
+
+	main, err := mainContainer(input.ContainerDefinitions, service, "init commands")
+	if err != nil {
+		return err
+	}
+
+	var previous string
+	for i, command := range commands {
+		name := initContainerName(i + 1)
+		if findContainerDefinition(input.ContainerDefinitions, name) != nil {
+			return fmt.Errorf("Can't add init container %s, there's already a container with that name", name)
+		}
+
+		def := &ecs.ContainerDefinition{
+			Name:             aws.String(name),
+			Image:            main.Image,
+			Essential:        aws.Bool(false),
+			EntryPoint:       aws.StringSlice([]string{"sh", "-c"}),
+			Command:          aws.StringSlice([]string{command}),
+			Environment:      main.Environment,
+			Secrets:          main.Secrets,
+			MountPoints:      main.MountPoints,
+			VolumesFrom:      main.VolumesFrom,
+			User:             main.User,
+			WorkingDirectory: main.WorkingDirectory,
+		}
+		// without task-level memory, each container needs its own
+		if input.Memory == nil {
+			def.MemoryReservation = main.MemoryReservation
+			if def.MemoryReservation == nil {
+				def.MemoryReservation = main.Memory
+			}
+		}
+		if previous != "" {
+			def.DependsOn = []*ecs.ContainerDependency{{
+				ContainerName: aws.String(previous),
+				Condition:     aws.String(ecs.ContainerConditionSuccess),
+			}}
+		}
+
+		log.Printf("Adding init container %s to run %q before %s", name, command, aws.StringValue(main.Name))
+		input.ContainerDefinitions = append(input.ContainerDefinitions, def)
+		previous = name
+	}
+
+	main.DependsOn = append(main.DependsOn, &ecs.ContainerDependency{
+		ContainerName: aws.String(previous),
+		Condition:     aws.String(ecs.ContainerConditionSuccess),
+	})
+	return nil
+}
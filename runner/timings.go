@@ -0,0 +1,240 @@
+package runner
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Formats for --timings-file
+const (
+	TimingsFormatBuildkite = "buildkite"
+	TimingsFormatCSV       = "csv"
+)
+
+// containerTiming is how long a container's task ran, keyed by the task
+// definition family and the container's command
+type containerTiming struct {
+	Cluster   string
+	Family    string
+	Container string
+	Command   string
+	StartedAt time.Time
+	StoppedAt time.Time
+	ExitCode  *int64
+	Reason    string
+}
+
+func (ct containerTiming) Duration() time.Duration {
+	if ct.StartedAt.IsZero() || ct.StoppedAt.IsZero() {
+		return 0
+	}
+	return ct.StoppedAt.Sub(ct.StartedAt)
+}
+
+func (ct containerTiming) Passed() bool {
+	return ct.ExitCode != nil && *ct.ExitCode == 0
+}
+
+// taskTimings returns the timing of each container in stopped tasks
+func taskTimings(tasks []*ecs.Task) []containerTiming {
+	var timings []containerTiming
+	for _, task := range tasks {
+		family := path.Base(aws.StringValue(task.TaskDefinitionArn))
+		if i := strings.LastIndex(family, ":"); i >= 0 {
+			family = family[:i]
+		}
+
+		startedAt := aws.TimeValue(task.StartedAt)
+		if startedAt.IsZero() {
+			startedAt = aws.TimeValue(task.CreatedAt)
+		}
+
+		for _, container := range task.Containers {
+			timings = append(timings, containerTiming{
+				Cluster:   path.Base(aws.StringValue(task.ClusterArn)),
+				Family:    family,
+				Container: aws.StringValue(container.Name),
+				Command:   strings.Join(aws.StringValueSlice(overrideCommand(task, container)), " "),
+				StartedAt: startedAt,
+				StoppedAt: aws.TimeValue(task.StoppedAt),
+				ExitCode:  container.ExitCode,
+				Reason:    containerStopReason(task, container),
+			})
+		}
+	}
+	return timings
+}
+
+// overrideCommand returns the command a container was run with, if it was overridden
+func overrideCommand(task *ecs.Task, container *ecs.Container) []*string {
+	if task.Overrides == nil {
+		return nil
+	}
+	for _, o := range task.Overrides.ContainerOverrides {
+		if aws.StringValue(o.Name) == aws.StringValue(container.Name) {
+			return o.Command
+		}
+	}
+	return nil
+}
+
+// timingRecorder collects timings from several runners, such as when running
+// against multiple targets
+type timingRecorder struct {
+	mu      sync.Mutex
+	timings []containerTiming
+}
+
+func (tr *timingRecorder) add(timings []containerTiming) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.timings = append(tr.timings, timings...)
+}
+
+// recordTimings writes timings to the timings file, or adds them to the
+// shared recorder to be written once every target has finished
+func (r *Runner) recordTimings(timings []containerTiming) {
+	if r.TimingsFile == "" {
+		return
+	}
+	if r.timings != nil {
+		r.timings.add(timings)
+		return
+	}
+	r.saveTimings(timings)
+}
+
+func (r *Runner) saveTimings(timings []containerTiming) {
+	if err := writeTimingsFile(r.TimingsFile, r.TimingsFormat, timings); err != nil {
+		fmt.Fprintf(r.Stderr, "Failed to write timings to %s: %v\n", r.TimingsFile, err)
+	}
+}
+
+func writeTimingsFile(file, format string, timings []containerTiming) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	if format == TimingsFormatCSV {
+		err = writeTimingsCSV(f, timings)
+	} else {
+		err = writeTimingsBuildkite(f, timings)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeTimingsCSV writes a row per container, for loading into a spreadsheet
+func writeTimingsCSV(w io.Writer, timings []containerTiming) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"cluster", "family", "container", "command", "started_at", "stopped_at", "duration_seconds", "exit_code"})
+
+	for _, t := range timings {
+		var exitCode string
+		if t.ExitCode != nil {
+			exitCode = strconv.FormatInt(*t.ExitCode, 10)
+		}
+		cw.Write([]string{
+			t.Cluster,
+			t.Family,
+			t.Container,
+			t.Command,
+			formatTime(t.StartedAt),
+			formatTime(t.StoppedAt),
+			strconv.FormatFloat(t.Duration().Seconds(), 'f', 3, 64),
+			exitCode,
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// buildkiteTest is a test in the Buildkite Test Analytics JSON format, see
+// https://buildkite.com/docs/test-analytics/importing-json
+type buildkiteTest struct {
+	ID            string           `json:"id"`
+	Scope         string           `json:"scope"`
+	Name          string           `json:"name"`
+	Identifier    string           `json:"identifier"`
+	Result        string           `json:"result"`
+	FailureReason string           `json:"failure_reason,omitempty"`
+	History       buildkiteHistory `json:"history"`
+}
+
+type buildkiteHistory struct {
+	Section  string  `json:"section"`
+	StartAt  float64 `json:"start_at"`
+	EndAt    float64 `json:"end_at"`
+	Duration float64 `json:"duration"`
+}
+
+// writeTimingsBuildkite writes each container as a test for Buildkite Test
+// Analytics, scoped by family and named after the container and command
+func writeTimingsBuildkite(w io.Writer, timings []containerTiming) error {
+	tests := []buildkiteTest{}
+	for _, t := range timings {
+		name := t.Container
+		if t.Command != "" {
+			name += " " + t.Command
+		}
+
+		test := buildkiteTest{
+			ID:         newUUID(),
+			Scope:      t.Family,
+			Name:       name,
+			Identifier: t.Family + " " + name,
+			Result:     "passed",
+			History: buildkiteHistory{
+				Section:  "top",
+				EndAt:    t.Duration().Seconds(),
+				Duration: t.Duration().Seconds(),
+			},
+		}
+		if !t.Passed() {
+			test.Result = "failed"
+			if t.ExitCode != nil {
+				test.FailureReason = fmt.Sprintf("Exited with %d", *t.ExitCode)
+			} else {
+				test.FailureReason = t.Reason
+			}
+		}
+		tests = append(tests, test)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(tests)
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func timingsTestTask() *ecs.Task {
+	started := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	return &ecs.Task{
+		TaskArn:           aws.String("arn:aws:ecs:us-east-1:123456789012:task/default/abc"),
+		ClusterArn:        aws.String("arn:aws:ecs:us-east-1:123456789012:cluster/default"),
+		TaskDefinitionArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/nightly:12"),
+		StartedAt:         aws.Time(started),
+		StoppedAt:         aws.Time(started.Add(90 * time.Second)),
+		Overrides: &ecs.TaskOverride{
+			ContainerOverrides: []*ecs.ContainerOverride{
+				{Name: aws.String("app"), Command: aws.StringSlice([]string{"./report.sh", "--all"})},
+			},
+		},
+		Containers: []*ecs.Container{
+			{Name: aws.String("app"), ExitCode: aws.Int64(0)},
+			{Name: aws.String("sidecar"), ExitCode: aws.Int64(137)},
+		},
+	}
+}
+
+func TestTaskTimings(t *testing.T) {
+	timings := taskTimings([]*ecs.Task{timingsTestTask()})
+	if len(timings) != 2 {
+		t.Fatalf("Expected 2 timings, got %d", len(timings))
+	}
+
+	app := timings[0]
+	if app.Family != "nightly" || app.Cluster != "default" || app.Command != "./report.sh --all" {
+		t.Fatalf("Unexpected timing: %+v", app)
+	}
+	if app.Duration() != 90*time.Second {
+		t.Fatalf("Expected 1m30s, got %v", app.Duration())
+	}
+	if timings[1].Command != "" || timings[1].Passed() {
+		t.Fatalf("Unexpected timing: %+v", timings[1])
+	}
+}
+
+func TestWriteTimingsBuildkite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeTimingsBuildkite(&buf, taskTimings([]*ecs.Task{timingsTestTask()})); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests []buildkiteTest
+	if err := json.Unmarshal(buf.Bytes(), &tests); err != nil {
+		t.Fatal(err)
+	}
+
+	if tests[0].Scope != "nightly" || tests[0].Name != "app ./report.sh --all" || tests[0].Result != "passed" {
+		t.Fatalf("Unexpected test: %+v", tests[0])
+	}
+	if tests[0].History.Duration != 90 {
+		t.Fatalf("Expected a duration of 90, got %v", tests[0].History.Duration)
+	}
+	if tests[1].Result != "failed" || tests[1].FailureReason != "Exited with 137" {
+		t.Fatalf("Unexpected test: %+v", tests[1])
+	}
+	if len(tests[0].ID) != 36 || tests[0].ID == tests[1].ID {
+		t.Fatalf("Expected unique UUIDs, got %q and %q", tests[0].ID, tests[1].ID)
+	}
+}
+
+func TestWriteTimingsCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeTimingsCSV(&buf, taskTimings([]*ecs.Task{timingsTestTask()})); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected a header and 2 rows, got %q", lines)
+	}
+	if expected := "default,nightly,app,./report.sh --all,2009-11-10T23:00:00Z,2009-11-10T23:01:30Z,90.000,0"; lines[1] != expected {
+		t.Fatalf("Expected %q, got %q", expected, lines[1])
+	}
+}
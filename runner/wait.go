@@ -0,0 +1,259 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const (
+	defaultTaskPollInterval = 6 * time.Second
+
+	// missingTaskRetryInterval is how often tasks are described again when
+	// they haven't shown up yet after being started
+	missingTaskRetryInterval = time.Second
+)
+
+// waitResult is the final state of tasks once they've all stopped, or once
+// they're all running when waiting for that
+type waitResult struct {
+	Tasks []*ecs.Task
+
+	// Running is set when every task is running, rather than stopped
+	Running bool
+
+	// FailedFast is the container that caused other tasks to be stopped early
+	FailedFast *ecs.Container
+}
+
+// waitForTasks polls tasks until they have all stopped, or are all running
+// when WaitFor is WaitForRunning, calling onDescribe with
+// their state each time. With WaitForEssential, a task counts as stopped once
+// its essential containers have. With a subscription to task events, tasks are
+// described as soon as an event arrives rather than on an interval. When
+// failing fast, the first watched container to exit non-zero causes every
+// other task to be stopped.
+func (r *Runner) waitForTasks(ctx context.Context, svc *ecs.ECS, td *preparedTaskDefinition, taskARNs []*string, events *taskEventSubscription, onDescribe func([]*ecs.Task)) (*waitResult, error) {
+	result := &waitResult{}
+	stopped := map[string]bool{}
+	started := time.Now()
+
+	for {
+		resp, err := describeTasks(ctx, svc, r.Cluster, taskARNs)
+		if err != nil {
+			return nil, err
+		}
+		result.Tasks = resp.Tasks
+
+		// tasks can briefly be missing right after they are started
+		if missing := missingTasks(resp.Failures); len(missing) > 0 {
+			if time.Since(started) > r.DescribeGracePeriod {
+				return nil, fmt.Errorf("Tasks are missing after %v: %s",
+					r.DescribeGracePeriod, strings.Join(missing, ", "))
+			}
+			logf(ctx, "%d tasks are missing, describing them again", len(missing))
+			select {
+			case <-time.After(missingTaskRetryInterval):
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		for _, failure := range resp.Failures {
+			return nil, fmt.Errorf("Failed to describe task %s: %s",
+				aws.StringValue(failure.Arn), aws.StringValue(failure.Reason))
+		}
+		if onDescribe != nil {
+			onDescribe(resp.Tasks)
+		}
+
+		var running []*ecs.Task
+		for _, task := range resp.Tasks {
+			if aws.StringValue(task.LastStatus) == ecs.DesiredStatusStopped {
+				if !stopped[*task.TaskArn] {
+					logf(withTaskLog(ctx, *task.TaskArn), "Task %s has stopped", *task.TaskArn)
+					stopped[*task.TaskArn] = true
+				}
+				continue
+			}
+			if r.WaitFor == WaitForEssential && essentialContainersStopped(task, td) {
+				if !stopped[*task.TaskArn] {
+					logf(withTaskLog(ctx, *task.TaskArn), "Essential containers of task %s have stopped, not waiting for the rest", *task.TaskArn)
+					stopped[*task.TaskArn] = true
+				}
+				continue
+			}
+			running = append(running, task)
+		}
+
+		if len(running) == 0 {
+			logf(ctx, "All tasks have stopped")
+			return result, nil
+		}
+
+		if r.WaitFor == WaitForRunning && allTasksRunning(resp.Tasks) {
+			logf(ctx, "All tasks are running")
+			result.Running = true
+			return result, nil
+		}
+
+		if r.FailFast && result.FailedFast == nil {
+			if container := failedContainer(resp.Tasks, td); container != nil {
+				result.FailedFast = container
+				fmt.Fprintf(r.Stderr, "Container %s exited with %d, stopping %d other tasks\n",
+					*container.Name, *container.ExitCode, len(running))
+
+				reason := fmt.Sprintf("fail-fast, container %s in task %s exited with %d",
+					*container.Name, path.Base(*container.TaskArn), *container.ExitCode)
+				if err := r.stopTasks(ctx, svc, running, reason); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if err := events.wait(ctx, taskARNs, defaultTaskPollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// maxDescribeTasks is the most tasks that a single DescribeTasks call accepts
+const maxDescribeTasks = 100
+
+// describeTasks describes any number of tasks, splitting them across as many
+// DescribeTasks calls as needed and merging the results
+func describeTasks(ctx context.Context, svc *ecs.ECS, cluster string, taskARNs []*string) (*ecs.DescribeTasksOutput, error) {
+	output := &ecs.DescribeTasksOutput{}
+
+	for _, chunk := range chunkStrings(taskARNs, maxDescribeTasks) {
+		resp, err := svc.DescribeTasksWithContext(ctx, &ecs.DescribeTasksInput{
+			Cluster: aws.String(cluster),
+			Tasks:   chunk,
+		})
+		if err != nil {
+			return nil, wrapAPIError("DescribeTasks", err)
+		}
+		output.Tasks = append(output.Tasks, resp.Tasks...)
+		output.Failures = append(output.Failures, resp.Failures...)
+	}
+
+	return output, nil
+}
+
+// chunkStrings splits a slice into chunks of at most size n
+func chunkStrings(ss []*string, n int) [][]*string {
+	var chunks [][]*string
+	for len(ss) > n {
+		chunks = append(chunks, ss[:n])
+		ss = ss[n:]
+	}
+	if len(ss) > 0 {
+		chunks = append(chunks, ss)
+	}
+	return chunks
+}
+
+// missingTasks returns the ARNs of tasks that DescribeTasks couldn't find
+func missingTasks(failures []*ecs.Failure) []string {
+	var missing []string
+	for _, failure := range failures {
+		if aws.StringValue(failure.Reason) == "MISSING" {
+			missing = append(missing, aws.StringValue(failure.Arn))
+		}
+	}
+	return missing
+}
+
+// failedContainer returns the first essential or log-streamed container that
+// has stopped with a non-zero exit code
+func failedContainer(tasks []*ecs.Task, td *preparedTaskDefinition) *ecs.Container {
+	for _, task := range tasks {
+		for _, container := range task.Containers {
+			if container.ExitCode == nil || *container.ExitCode == 0 {
+				continue
+			}
+			if _, streamed := td.Logs[*container.Name]; streamed {
+				return container
+			}
+			if td.isEssential(*container.Name) {
+				return container
+			}
+		}
+	}
+	return nil
+}
+
+// isEssential returns whether a container is essential, which containers are
+// unless their definition says otherwise
+func (td *preparedTaskDefinition) isEssential(name string) bool {
+	def := findContainerDefinition(td.Containers, name)
+	return def == nil || def.Essential == nil || *def.Essential
+}
+
+// essentialContainersStopped returns whether every essential container of a
+// task has stopped, after which ECS stops the rest
+func essentialContainersStopped(task *ecs.Task, td *preparedTaskDefinition) bool {
+	for _, container := range task.Containers {
+		if td.isEssential(*container.Name) && aws.StringValue(container.LastStatus) != ecs.DesiredStatusStopped {
+			return false
+		}
+	}
+	return len(task.Containers) > 0
+}
+
+// lingeringContainer returns whether a container is non-essential and still
+// running after the essential ones stopped, which isn't waited for with
+// WaitForEssential
+func (r *Runner) lingeringContainer(td *preparedTaskDefinition, container *ecs.Container) bool {
+	return r.WaitFor == WaitForEssential && !td.isEssential(*container.Name) &&
+		aws.StringValue(container.LastStatus) != ecs.DesiredStatusStopped
+}
+
+// maxStopReason is the longest reason that StopTask accepts
+const maxStopReason = 255
+
+// stopReason describes why the runner stopped a task, for the task's stopped
+// reason in ECS, such as "Stopped by ecs-run-task for jane@example.com
+// (build 0185f1c3): fail-fast, container app in task abc exited with 1"
+func stopReason(cause string) string {
+	reason := "Stopped by ecs-run-task"
+	if who := initiator(); who != "" {
+		reason += " for " + who
+	}
+	if build := ciBuildID(); build != "" {
+		reason += " (build " + build + ")"
+	}
+	reason += ": " + cause
+	if len(reason) > maxStopReason {
+		// cut at the start of a character, so one isn't split
+		cut := maxStopReason - 3
+		for cut > 0 && !utf8.RuneStart(reason[cut]) {
+			cut--
+		}
+		reason = reason[:cut] + "..."
+	}
+	return reason
+}
+
+// stopTasks stops tasks, with a stopped reason that includes cause
+func (r *Runner) stopTasks(ctx context.Context, svc *ecs.ECS, tasks []*ecs.Task, cause string) error {
+	reason := stopReason(cause)
+	for _, task := range tasks {
+		logf(withTaskLog(ctx, *task.TaskArn), "Stopping task %s: %s", *task.TaskArn, reason)
+		_, err := svc.StopTaskWithContext(ctx, &ecs.StopTaskInput{
+			Cluster: aws.String(r.Cluster),
+			Task:    task.TaskArn,
+			Reason:  aws.String(reason),
+		})
+		if err != nil {
+			return wrapAPIError("StopTask", err)
+		}
+	}
+	return nil
+}
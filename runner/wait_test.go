@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestFailedContainer(t *testing.T) {
+	td := &preparedTaskDefinition{
+		Containers: []*ecs.ContainerDefinition{
+			{Name: aws.String("app")},
+			{Name: aws.String("sidecar"), Essential: aws.Bool(false)},
+		},
+		Logs: map[string]logConfig{},
+	}
+
+	tasks := []*ecs.Task{{
+		Containers: []*ecs.Container{
+			{Name: aws.String("sidecar"), ExitCode: aws.Int64(1)},
+			{Name: aws.String("app")},
+		},
+	}}
+	if c := failedContainer(tasks, td); c != nil {
+		t.Fatalf("Expected a non-essential container failing to be ignored, got %s", *c.Name)
+	}
+
+	td.Logs["sidecar"] = logConfig{}
+	if c := failedContainer(tasks, td); c == nil || *c.Name != "sidecar" {
+		t.Fatal("Expected a log-streamed container failing to count")
+	}
+
+	delete(td.Logs, "sidecar")
+	tasks[0].Containers[1].ExitCode = aws.Int64(2)
+	if c := failedContainer(tasks, td); c == nil || *c.Name != "app" {
+		t.Fatal("Expected an essential container failing to count")
+	}
+}
+
+func TestMissingTasks(t *testing.T) {
+	missing := missingTasks([]*ecs.Failure{
+		{Arn: aws.String("task-1"), Reason: aws.String("MISSING")},
+		{Arn: aws.String("task-2"), Reason: aws.String("SOMETHING_ELSE")},
+	})
+	if len(missing) != 1 || missing[0] != "task-1" {
+		t.Fatalf("Expected only task-1 to be missing, got %v", missing)
+	}
+}
+
+func TestChunkStrings(t *testing.T) {
+	ss := make([]*string, 250)
+	for i := range ss {
+		ss[i] = aws.String("task")
+	}
+
+	chunks := chunkStrings(ss, maxDescribeTasks)
+	if len(chunks) != 3 {
+		t.Fatalf("Expected 3 chunks, got %d", len(chunks))
+	}
+	for i, expected := range []int{100, 100, 50} {
+		if len(chunks[i]) != expected {
+			t.Fatalf("Expected chunk %d to have %d items, got %d", i, expected, len(chunks[i]))
+		}
+	}
+
+	if chunks := chunkStrings(nil, maxDescribeTasks); len(chunks) != 0 {
+		t.Fatalf("Expected no chunks, got %d", len(chunks))
+	}
+}
+
+func TestStopReason(t *testing.T) {
+	os.Setenv("BUILDKITE_BUILD_CREATOR_EMAIL", "dev@example.com")
+	os.Setenv("BUILDKITE_BUILD_ID", "build-1")
+	defer os.Unsetenv("BUILDKITE_BUILD_CREATOR_EMAIL")
+	defer os.Unsetenv("BUILDKITE_BUILD_ID")
+
+	reason := stopReason("fail-fast, container app in task abc exited with 1")
+	expected := "Stopped by ecs-run-task for dev@example.com (build build-1): fail-fast, container app in task abc exited with 1"
+	if reason != expected {
+		t.Errorf("Expected %q, got %q", expected, reason)
+	}
+
+	long := stopReason("cancelled, " + strings.Repeat("x", 300))
+	if len(long) != maxStopReason || !strings.HasSuffix(long, "...") {
+		t.Errorf("Expected a reason truncated to %d characters, got %d", maxStopReason, len(long))
+	}
+
+	multibyte := stopReason("cancelled, a" + strings.Repeat("é", 300))
+	if len(multibyte) > maxStopReason || !utf8.ValidString(multibyte) || !strings.HasSuffix(multibyte, "é...") {
+		t.Errorf("Expected a reason truncated to whole characters, got %q", multibyte)
+	}
+}
+
+func TestEssentialContainersStopped(t *testing.T) {
+	td := &preparedTaskDefinition{
+		Containers: []*ecs.ContainerDefinition{
+			{Name: aws.String("app")},
+			{Name: aws.String("envoy"), Essential: aws.Bool(false)},
+		},
+	}
+	task := &ecs.Task{
+		Containers: []*ecs.Container{
+			{Name: aws.String("app"), LastStatus: aws.String("RUNNING")},
+			{Name: aws.String("envoy"), LastStatus: aws.String("RUNNING")},
+		},
+	}
+	if essentialContainersStopped(task, td) {
+		t.Fatal("Expected a running essential container to be waited for")
+	}
+
+	task.Containers[0].LastStatus = aws.String("STOPPED")
+	task.Containers[0].ExitCode = aws.Int64(0)
+	if !essentialContainersStopped(task, td) {
+		t.Fatal("Expected a task whose essential containers stopped to count as stopped")
+	}
+
+	r := &Runner{WaitFor: WaitForEssential}
+	if r.lingeringContainer(td, task.Containers[0]) || !r.lingeringContainer(td, task.Containers[1]) {
+		t.Error("Expected only the running sidecar to be lingering")
+	}
+	r.WaitFor = WaitForStopped
+	if r.lingeringContainer(td, task.Containers[1]) {
+		t.Error("Expected containers to only linger with --wait-for essential")
+	}
+}
@@ -0,0 +1,198 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// defaultStuckLogsTimeout is how long a watcher can go without printing
+	// anything once its container has stopped
+	defaultStuckLogsTimeout = 5 * time.Minute
+
+	// logWatchdogInterval is the most time between checking watchers for
+	// progress
+	logWatchdogInterval = 5 * time.Second
+
+	// stuckWatcherGrace is how long a stopped watcher has to flush and
+	// finish before it's abandoned
+	stuckWatcherGrace = finalLogFlushTimeout + 5*time.Second
+)
+
+// logWatchers keeps track of the log watchers of a run, so that once their
+// containers have stopped, watchers that make no progress can be stopped
+// rather than waited for forever. Watchers run in a group with the wait for
+// tasks to stop, so that if the wait fails, or the run returns early, they're
+// cancelled and have returned before the run does.
+type logWatchers struct {
+	group  *errgroup.Group
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	watches  []*logWatch
+	detached bool
+}
+
+func newLogWatchers(ctx context.Context) *logWatchers {
+	ctx, cancel := context.WithCancel(ctx)
+	group, ctx := errgroup.WithContext(ctx)
+	return &logWatchers{group: group, ctx: ctx, cancel: cancel}
+}
+
+// logWatch is a log watcher following a container's logs
+type logWatch struct {
+	container string
+	task      string
+	watcher   *logWatcher
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	mu        sync.Mutex
+	stoppedAt time.Time
+}
+
+func (lws *logWatchers) add(w *logWatch) {
+	lws.mu.Lock()
+	defer lws.mu.Unlock()
+	lws.watches = append(lws.watches, w)
+}
+
+// detach leaves the watchers to finish in the background, so that stop
+// doesn't wait for them
+func (lws *logWatchers) detach() {
+	lws.mu.Lock()
+	defer lws.mu.Unlock()
+	lws.detached = true
+}
+
+// stop cancels every watcher and waits for them to return, so that nothing is
+// printed once the run has returned. Watchers that don't return when asked,
+// such as when blocked on a call that can't be cancelled, are left behind.
+func (lws *logWatchers) stop() {
+	lws.cancel()
+	lws.mu.Lock()
+	detached := lws.detached
+	lws.mu.Unlock()
+	if detached {
+		return
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		lws.group.Wait()
+		close(finished)
+	}()
+	timer := time.NewTimer(stuckWatcherGrace)
+	defer timer.Stop()
+	select {
+	case <-finished:
+	case <-timer.C:
+		logf(lws.ctx, "Gave up waiting for log watchers to stop")
+	}
+}
+
+// finished returns whether a watcher has returned
+func (w *logWatch) finished() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopStuck stops a watcher that has made no progress, returning false if
+// it was already stopped
+func (w *logWatch) stopStuck() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stoppedAt.IsZero() {
+		return false
+	}
+	w.stoppedAt = time.Now()
+	w.cancel()
+	return true
+}
+
+// stuck returns whether a watcher was stopped for making no progress, and
+// so didn't print its stream in full
+func (w *logWatch) stuck() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !w.stoppedAt.IsZero()
+}
+
+// abandoned returns whether a watcher hasn't finished long after it was
+// stopped, such as when it's blocked on a call that can't be cancelled
+func (w *logWatch) abandoned() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !w.stoppedAt.IsZero() && time.Since(w.stoppedAt) > stuckWatcherGrace && !w.finished()
+}
+
+// waitForLogWatchers waits for every watcher to finish once their containers
+// have stopped. Watchers that print nothing for StuckLogsTimeout are stopped,
+// and those that still don't finish are abandoned, so that a stuck watcher
+// can't keep the run from finishing.
+func (r *Runner) waitForLogWatchers(ctx context.Context, lws *logWatchers) {
+	finished := make(chan struct{})
+	go func() {
+		lws.group.Wait()
+		close(finished)
+	}()
+	if r.StuckLogsTimeout <= 0 {
+		<-finished
+		return
+	}
+
+	interval := r.StuckLogsTimeout / 4
+	if interval > logWatchdogInterval {
+		interval = logWatchdogInterval
+	}
+	since := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-finished:
+			return
+		case <-ticker.C:
+		}
+
+		lws.mu.Lock()
+		watches := append([]*logWatch{}, lws.watches...)
+		lws.mu.Unlock()
+
+		var pending, abandoned int
+		for _, w := range watches {
+			if w.finished() {
+				continue
+			}
+			if w.abandoned() {
+				abandoned++
+				continue
+			}
+			pending++
+
+			idle := w.watcher.idleSince(since)
+			if idle > r.StuckLogsTimeout && w.stopStuck() {
+				logf(withContainerLog(ctx, w.task, w.container), "Log watcher made no progress for %v, stopping it", idle)
+				fmt.Fprintf(r.Stderr, "Stopped following logs of container %s in task %s, which had no new events for %v after it stopped. Its logs are still in CloudWatch.\n",
+					w.container, path.Base(w.task), idle.Round(time.Second))
+			}
+		}
+
+		if pending == 0 && abandoned > 0 {
+			fmt.Fprintf(r.Stderr, "Gave up waiting for %d log watchers that didn't stop when asked\n", abandoned)
+			lws.detach()
+			return
+		}
+	}
+}
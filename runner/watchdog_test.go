@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+func TestWaitForLogWatchersStopsStuckWatchers(t *testing.T) {
+	var stderr bytes.Buffer
+	r := &Runner{Stderr: &stderr, StuckLogsTimeout: 50 * time.Millisecond}
+
+	// the stream never appears, so the watcher would wait for an hour
+	watcher := &logWatcher{
+		LogGroupName:   "my-group",
+		LogStreamName:  "my-stream",
+		Interval:       5 * time.Millisecond,
+		CloudWatchLogs: &mockCloudWatchLogs{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watch := &logWatch{
+		container: "app",
+		task:      "arn:aws:ecs:us-east-1:123456789012:task/default/abc",
+		watcher:   watcher,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	lws := newLogWatchers(context.Background())
+	lws.add(watch)
+	lws.group.Go(func() error {
+		defer close(watch.done)
+		watcher.Watch(ctx)
+		return nil
+	})
+
+	finished := make(chan struct{})
+	go func() {
+		r.waitForLogWatchers(context.Background(), lws)
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the stuck watcher to be stopped")
+	}
+
+	if !watch.stuck() {
+		t.Error("Expected the watcher to be marked as stuck")
+	}
+	if !strings.Contains(stderr.String(), "Stopped following logs of container app in task abc") {
+		t.Errorf("Unexpected output %q", stderr.String())
+	}
+}
+
+func TestStopLogWatchersWaitsForThem(t *testing.T) {
+	lws := newLogWatchers(context.Background())
+	var stopped bool
+	lws.group.Go(func() error {
+		<-lws.ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		stopped = true
+		return nil
+	})
+
+	lws.stop()
+	if !stopped {
+		t.Fatal("Expected stop to wait for the watcher to return")
+	}
+}
+
+func TestFailedWaitCancelsLogWatchers(t *testing.T) {
+	lws := newLogWatchers(context.Background())
+	watcher := &logWatcher{
+		LogGroupName:   "my-group",
+		LogStreamName:  "my-stream",
+		Interval:       5 * time.Millisecond,
+		CloudWatchLogs: &mockCloudWatchLogs{},
+	}
+	lws.group.Go(func() error {
+		watcher.Watch(lws.ctx)
+		return nil
+	})
+	lws.group.Go(func() error {
+		return errors.New("Failed to describe tasks")
+	})
+
+	finished := make(chan error)
+	go func() {
+		finished <- lws.group.Wait()
+	}()
+	select {
+	case err := <-finished:
+		if err == nil || err.Error() != "Failed to describe tasks" {
+			t.Fatalf("Expected the wait's error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the watcher to be cancelled")
+	}
+}
+
+func TestLogWatcherIdleSince(t *testing.T) {
+	since := time.Now().Add(-time.Minute)
+	lw := &logWatcher{Printer: func(*cloudwatchlogs.FilteredLogEvent) bool { return true }}
+	if idle := lw.idleSince(since); idle < time.Minute {
+		t.Errorf("Expected to be idle for a minute, got %v", idle)
+	}
+	lw.progressed(1)
+	if idle := lw.idleSince(since); idle > time.Second {
+		t.Errorf("Expected progress to reset the idle time, got %v", idle)
+	}
+}
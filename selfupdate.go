@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+const (
+	latestReleaseURL = "https://api.github.com/repos/buildkite/ecs-run-task/releases/latest"
+
+	// checksumsAsset lists the SHA-256 of each binary in a release, as
+	// written by make release
+	checksumsAsset = "sha256sums.txt"
+
+	// signatureAsset is the minisign signature of checksumsAsset, made with
+	// the release signing key by make release
+	signatureAsset = checksumsAsset + ".minisig"
+)
+
+// ReleasePublicKey is the minisign public key that releases are signed with,
+// set at build time by make release. Builds without it can't self-update, as
+// there's nothing to check a release's signature with.
+var ReleasePublicKey string
+
+// release is the subset of a GitHub release that we use
+type release struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (rel *release) assetURL(name string) (string, bool) {
+	for _, a := range rel.Assets {
+		if a.Name == name {
+			return a.URL, true
+		}
+	}
+	return "", false
+}
+
+// updater replaces a binary with the latest release
+type updater struct {
+	Client     *http.Client
+	ReleaseURL string
+	PublicKey  string
+	Stdout     io.Writer
+}
+
+func selfUpdateAction(ctx *cli.Context) error {
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Failed to find the running binary: %v", err), 1)
+	}
+
+	u := &updater{
+		Client:     &http.Client{Timeout: 5 * time.Minute},
+		ReleaseURL: latestReleaseURL,
+		PublicKey:  ReleasePublicKey,
+		Stdout:     os.Stdout,
+	}
+	if err := u.update(exe, Version, ctx.Bool("force")); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	return nil
+}
+
+// update replaces exe with the latest release if it's newer than version. The
+// release's checksums are checked against their signature, and the download
+// against the checksums, before anything is replaced.
+func (u *updater) update(exe, version string, force bool) error {
+	key, err := parseMinisignPublicKey(u.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	rel, err := u.latestRelease()
+	if err != nil {
+		return err
+	}
+
+	latest, ok := parseVersion(rel.TagName)
+	if !ok {
+		return fmt.Errorf("Latest release has an unexpected tag %q", rel.TagName)
+	}
+	if current, ok := parseVersion(version); !ok && !force {
+		return fmt.Errorf("This is a development build without a version, use --force to replace it with %s", rel.TagName)
+	} else if ok && compareVersions(current, latest) >= 0 && !force {
+		fmt.Fprintf(u.Stdout, "Already up to date with %s\n", rel.TagName)
+		return nil
+	}
+
+	name := releaseAssetName(runtime.GOOS, runtime.GOARCH)
+	binURL, ok := rel.assetURL(name)
+	if !ok {
+		return fmt.Errorf("Release %s has no binary for %s/%s", rel.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	sumsURL, ok := rel.assetURL(checksumsAsset)
+	if !ok {
+		return fmt.Errorf("Release %s has no %s to verify the download with", rel.TagName, checksumsAsset)
+	}
+	sigURL, ok := rel.assetURL(signatureAsset)
+	if !ok {
+		return fmt.Errorf("Release %s has no %s to verify its checksums with", rel.TagName, signatureAsset)
+	}
+
+	sums, err := u.checksums(sumsURL, sigURL, key)
+	if err != nil {
+		return err
+	}
+	want, ok := sums[name]
+	if !ok {
+		return fmt.Errorf("%s in release %s has no checksum for %s", checksumsAsset, rel.TagName, name)
+	}
+
+	fmt.Fprintf(u.Stdout, "Downloading %s %s\n", name, rel.TagName)
+	tmp, err := u.download(binURL, filepath.Dir(exe), want)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := replaceBinary(exe, tmp); err != nil {
+		return err
+	}
+	fmt.Fprintf(u.Stdout, "Updated %s to %s\n", exe, rel.TagName)
+	return nil
+}
+
+func (u *updater) get(url string) (*http.Response, error) {
+	resp, err := u.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Failed to get %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+func (u *updater) latestRelease() (*release, error) {
+	resp, err := u.get(u.ReleaseURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var rel release
+	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
+		return nil, fmt.Errorf("Failed to parse the latest release: %v", err)
+	}
+	return &rel, nil
+}
+
+// checksums reads a sha256sum file into a map of file names to hashes, once
+// its signature has been verified with key
+func (u *updater) checksums(url, sigURL string, key *minisignPublicKey) (map[string]string, error) {
+	body, err := u.read(url)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := u.read(sigURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := key.verify(body, sig); err != nil {
+		return nil, fmt.Errorf("Failed to verify %s: %v", checksumsAsset, err)
+	}
+
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return sums, scanner.Err()
+}
+
+func (u *updater) read(url string) ([]byte, error) {
+	resp, err := u.get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// minisignPublicKey is an Ed25519 key in minisign's format
+type minisignPublicKey struct {
+	ID  []byte
+	Key ed25519.PublicKey
+}
+
+// parseMinisignPublicKey parses a minisign public key, either the base64 line
+// or the whole file that minisign -G writes
+func parseMinisignPublicKey(s string) (*minisignPublicKey, error) {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+	if s == "" || err != nil || len(b) != 2+8+ed25519.PublicKeySize || string(b[:2]) != "Ed" {
+		return nil, fmt.Errorf("This build has no valid release signing key to verify updates with, install the latest release by hand instead")
+	}
+	return &minisignPublicKey{ID: b[2:10], Key: b[10:]}, nil
+}
+
+// verify checks a minisign signature of message, made with minisign -S -l.
+// Both the signature of the message and the one of its trusted comment have
+// to be valid.
+func (k *minisignPublicKey) verify(message, sig []byte) error {
+	lines := strings.Split(strings.TrimSpace(string(sig)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("the signature isn't in minisign's format")
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(b) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("the signature isn't in minisign's format")
+	}
+	if string(b[:2]) != "Ed" {
+		return fmt.Errorf("the signature uses algorithm %q, rather than Ed from minisign -l", b[:2])
+	}
+	if !bytes.Equal(b[2:10], k.ID) {
+		return fmt.Errorf("the signature was made with key %X, not the release signing key %X", b[2:10], k.ID)
+	}
+	if !ed25519.Verify(k.Key, message, b[10:]) {
+		return fmt.Errorf("the signature doesn't match")
+	}
+
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	comment := strings.TrimSuffix(strings.TrimPrefix(lines[2], "trusted comment: "), "\r")
+	if err != nil || !ed25519.Verify(k.Key, append(append([]byte{}, b[10:]...), comment...), global) {
+		return fmt.Errorf("the signature's trusted comment doesn't match")
+	}
+	return nil
+}
+
+// download writes url to a temporary file in dir, so that it can be renamed
+// into place, returning an error if its SHA-256 isn't sum
+func (u *updater) download(url, dir, sum string) (string, error) {
+	resp, err := u.get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	f, err := ioutil.TempFile(dir, ".ecs-run-task-update")
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+			err = fmt.Errorf("Checksum of the download is %s, expected %s", got, sum)
+		}
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0755)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// replaceBinary moves a new binary over exe. Windows doesn't allow a running
+// binary to be replaced, but does allow it to be renamed out of the way.
+func replaceBinary(exe, binary string) error {
+	if runtime.GOOS != "windows" {
+		return os.Rename(binary, exe)
+	}
+	return replaceBinaryAside(exe, binary)
+}
+
+// replaceBinaryAside renames exe out of the way to exe.old before moving the
+// new binary into its place, renaming it back if that fails
+func replaceBinaryAside(exe, binary string) error {
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(binary, exe); err != nil {
+		if restoreErr := os.Rename(old, exe); restoreErr != nil {
+			return fmt.Errorf("%v, and failed to restore %s from %s: %v", err, exe, old, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// releaseAssetName is the name of the binary for a platform in a release
+func releaseAssetName(goos, goarch string) string {
+	name := fmt.Sprintf("ecs-run-task-%s-%s", goos, goarch)
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
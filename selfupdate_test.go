@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// testSigner signs like minisign -S -l with a key made for the test
+type testSigner struct {
+	id   []byte
+	priv ed25519.PrivateKey
+	pub  ed25519.PublicKey
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSigner{id: []byte("testkey1"), priv: priv, pub: pub}
+}
+
+func (s *testSigner) publicKey() string {
+	key := append(append([]byte("Ed"), s.id...), s.pub...)
+	return "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(key) + "\n"
+}
+
+func (s *testSigner) sign(message []byte) string {
+	sig := ed25519.Sign(s.priv, message)
+	comment := "timestamp:1700000000\tfile:sha256sums.txt"
+	global := ed25519.Sign(s.priv, append(append([]byte{}, sig...), comment...))
+	return fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), s.id...), sig...)), comment,
+		base64.StdEncoding.EncodeToString(global))
+}
+
+func testReleaseServer(t *testing.T, signer *testSigner, tag string, binary []byte, sum string) *httptest.Server {
+	name := releaseAssetName(runtime.GOOS, runtime.GOARCH)
+	sums := fmt.Sprintf("%s  ecs-run-task-other-arch\n%s  %s\n", strings.Repeat("0", 64), sum, name)
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/latest":
+			fmt.Fprintf(w, `{"tag_name": %q, "assets": [
+				{"name": %q, "browser_download_url": "%s/binary"},
+				{"name": "sha256sums.txt", "browser_download_url": "%s/sums"},
+				{"name": "sha256sums.txt.minisig", "browser_download_url": "%s/sums.minisig"}
+			]}`, tag, name, srv.URL, srv.URL, srv.URL)
+		case "/binary":
+			w.Write(binary)
+		case "/sums":
+			fmt.Fprint(w, sums)
+		case "/sums.minisig":
+			fmt.Fprint(w, signer.sign([]byte(sums)))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	return srv
+}
+
+func testExecutable(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "selfupdate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "ecs-run-task")
+	if err := ioutil.WriteFile(exe, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return exe, func() { os.RemoveAll(dir) }
+}
+
+func TestSelfUpdate(t *testing.T) {
+	binary := []byte("new binary")
+	h := sha256.Sum256(binary)
+	signer := newTestSigner(t)
+	srv := testReleaseServer(t, signer, "v1.9.0", binary, hex.EncodeToString(h[:]))
+	defer srv.Close()
+
+	exe, cleanup := testExecutable(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+	u := &updater{Client: srv.Client(), ReleaseURL: srv.URL + "/latest", PublicKey: signer.publicKey(), Stdout: &out}
+	if err := u.update(exe, "v1.8.0", false); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(exe); string(b) != "new binary" {
+		t.Fatalf("Expected the binary to be replaced, got %q", b)
+	}
+	if !strings.Contains(out.String(), "Updated") {
+		t.Fatalf("Unexpected output %q", out.String())
+	}
+}
+
+func TestSelfUpdateUpToDate(t *testing.T) {
+	signer := newTestSigner(t)
+	srv := testReleaseServer(t, signer, "v1.9.0", []byte("new binary"), "unused")
+	defer srv.Close()
+
+	exe, cleanup := testExecutable(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+	u := &updater{Client: srv.Client(), ReleaseURL: srv.URL + "/latest", PublicKey: signer.publicKey(), Stdout: &out}
+	if err := u.update(exe, "v1.9.0-2-gabc1234", false); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(exe); string(b) != "old" {
+		t.Fatalf("Expected the binary to be unchanged, got %q", b)
+	}
+	if err := u.update(exe, "", false); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("Expected a development build to need --force, got %v", err)
+	}
+}
+
+func TestSelfUpdateChecksumMismatch(t *testing.T) {
+	signer := newTestSigner(t)
+	srv := testReleaseServer(t, signer, "v1.9.0", []byte("tampered binary"), strings.Repeat("a", 64))
+	defer srv.Close()
+
+	exe, cleanup := testExecutable(t)
+	defer cleanup()
+
+	u := &updater{Client: srv.Client(), ReleaseURL: srv.URL + "/latest", PublicKey: signer.publicKey(), Stdout: ioutil.Discard}
+	err := u.update(exe, "v1.8.0", false)
+	if err == nil || !strings.Contains(err.Error(), "Checksum") {
+		t.Fatalf("Expected a checksum error, got %v", err)
+	}
+	if b, _ := ioutil.ReadFile(exe); string(b) != "old" {
+		t.Fatalf("Expected the binary to be unchanged, got %q", b)
+	}
+	files, _ := ioutil.ReadDir(filepath.Dir(exe))
+	if len(files) != 1 {
+		t.Fatalf("Expected the download to be removed, found %d files", len(files))
+	}
+}
+
+func TestSelfUpdateSignature(t *testing.T) {
+	binary := []byte("new binary")
+	h := sha256.Sum256(binary)
+
+	// checksums signed with another key, as anyone who can publish a release
+	// could make, aren't trusted
+	srv := testReleaseServer(t, newTestSigner(t), "v1.9.0", binary, hex.EncodeToString(h[:]))
+	defer srv.Close()
+
+	exe, cleanup := testExecutable(t)
+	defer cleanup()
+
+	u := &updater{Client: srv.Client(), ReleaseURL: srv.URL + "/latest", PublicKey: newTestSigner(t).publicKey(), Stdout: ioutil.Discard}
+	if err := u.update(exe, "v1.8.0", false); err == nil || !strings.Contains(err.Error(), "signature doesn't match") {
+		t.Fatalf("Expected a signature error, got %v", err)
+	}
+	if b, _ := ioutil.ReadFile(exe); string(b) != "old" {
+		t.Fatalf("Expected the binary to be unchanged, got %q", b)
+	}
+
+	u.PublicKey = ""
+	if err := u.update(exe, "v1.8.0", false); err == nil || !strings.Contains(err.Error(), "no valid release signing key") {
+		t.Fatalf("Expected builds without a key not to update, got %v", err)
+	}
+}
+
+func TestReplaceBinaryAsideRestores(t *testing.T) {
+	exe, cleanup := testExecutable(t)
+	defer cleanup()
+
+	if err := replaceBinaryAside(exe, filepath.Join(filepath.Dir(exe), "missing")); err == nil {
+		t.Fatal("Expected an error moving a missing binary into place")
+	}
+	if b, _ := ioutil.ReadFile(exe); string(b) != "old" {
+		t.Fatalf("Expected the old binary to be restored, got %q", b)
+	}
+}
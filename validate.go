@@ -0,0 +1,170 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/buildkite/ecs-run-task/runner"
+	"github.com/urfave/cli"
+)
+
+// flagRule rejects a combination of options that can't work, so that it fails
+// before anything is registered or run rather than with an AWS error later
+type flagRule struct {
+	Invalid func(ctx *cli.Context, r *runner.Runner) bool
+	Message string
+}
+
+var flagRules = []flagRule{
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool { return r.Count < 0 },
+		Message: "--count can't be negative",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return ctx.IsSet("revision-retention") && r.RevisionRetention < 1
+		},
+		Message: "--revision-retention must keep at least 1 revision, the one that was run",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return (r.RevisionRetention > 0 || r.ForceRegister) && r.ExistingTaskDefinition != ""
+		},
+		Message: "--revision-retention and --force-register are for the revisions that runs register, and --task-definition doesn't register one",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return (r.Revision != 0 || r.AllowInactive) && r.Family == ""
+		},
+		Message: "--revision and --allow-inactive pick a revision of --from-family, so they need it",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool { return r.Revision < 0 },
+		Message: "--revision must be a revision number, 1 or more",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.Revision != 0 && strings.Contains(r.Family, ":")
+		},
+		Message: "--from-family already has a revision, so it can't be used with --revision",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.Fargate && len(r.ContainerInstances) > 0
+		},
+		Message: "--container-instance starts tasks on EC2 instances, so it can't be used with --fargate",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.Fargate && len(r.Subnets) == 0 && r.NetworkFrom == "" && r.FromService == ""
+		},
+		Message: "--fargate needs --subnet, or --network-from or --from-service to copy a service's network configuration",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.Fargate && r.WaitForCapacity > 0
+		},
+		Message: "--wait-for-capacity only applies to EC2 clusters, so it can't be used with --fargate",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.UseClusterDefaultStrategy && (r.Fargate || len(r.ContainerInstances) > 0)
+		},
+		Message: "--use-cluster-default-strategy lets the cluster decide where tasks run, so it can't be used with --fargate or --container-instance",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.ScaleInProtection && (r.Fargate || r.NoWait)
+		},
+		Message: "--scale-in-protection protects EC2 instances until tasks stop, so it can't be used with --fargate or --no-wait",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.Fargate && (r.PidMode == runner.NamespaceModeHost || r.IpcMode != "" ||
+				len(r.SharedMemorySizes) > 0 || len(r.Tmpfs) > 0)
+		},
+		Message: "Fargate doesn't support --pid-mode host, --ipc-mode, --shared-memory-size or --tmpfs",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.NetworkFrom != "" && (ctx.IsSet("subnet") || ctx.IsSet("security-group"))
+		},
+		Message: "--network-from copies subnets and security groups, so it can't be used with --subnet or --security-group",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return len(r.SecurityGroups) > 0 && len(r.Subnets) == 0 && r.NetworkFrom == ""
+		},
+		Message: "--security-group needs --subnet, as security groups only apply to tasks with their own network interface",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.NoLogs && (r.EphemeralLogs || r.AggregateLogs || r.MaxLogLines > 0 ||
+				len(r.LogContainers) > 0 || r.InsightsQuery != "")
+		},
+		Message: "--no-logs can't be used with --ephemeral-logs, --aggregate-logs, --max-log-lines, --log-container or --insights-query, which need logs to be streamed",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.NoWaitLogs && (r.EphemeralLogs || r.ResultCacheDir != "")
+		},
+		Message: "--no-wait-logs can't be used with --ephemeral-logs or --cache, which need logs to be printed in full",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.NoFinishMessage && r.EphemeralLogs
+		},
+		Message: "--no-finish-message can't be used with --ephemeral-logs, which only deletes streams that were printed up to their finish message",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.NoWait && (r.FailFast || r.ResultCacheDir != "" || r.InsightsQuery != "")
+		},
+		Message: "--wait=false can't be used with --fail-fast, --cache or --insights-query, which need tasks to stop",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.Detach && (r.NoWait || r.WaitFor == runner.WaitForRunning || r.WaitFor == runner.WaitForEssential || r.FailFast || r.ResultCacheDir != "" ||
+				r.InsightsQuery != "" || r.ArtifactsPath != "" || r.ScaleInProtection || r.TaskEvents || r.RevisionRetention > 0)
+		},
+		Message: "--detach exits once tasks start, so it can't be used with --wait=false, --wait-for, --fail-fast, --cache, --insights-query, --artifacts, --scale-in-protection, --task-events or --revision-retention",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.WaitFor == runner.WaitForRunning && (r.NoWait || r.ResultCacheDir != "" || r.InsightsQuery != "")
+		},
+		Message: "--wait-for running can't be used with --wait=false, --cache or --insights-query, which need tasks to stop",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.HealthCheck != "" && r.WaitFor != runner.WaitForRunning
+		},
+		Message: "--health-check is only used with --wait-for running",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.ArtifactsPath != "" && r.ArtifactsBucket == ""
+		},
+		Message: "--artifacts needs --artifacts-bucket to upload them through",
+	},
+	{
+		Invalid: func(ctx *cli.Context, r *runner.Runner) bool {
+			return r.ArtifactsPath != "" && (r.Count > 1 || r.NoWait || r.WaitFor == runner.WaitForRunning || r.ResultCacheDir != "")
+		},
+		Message: "--artifacts are uploaded by a single task once it stops, so it can't be used with --count, --wait=false, --wait-for running or --cache",
+	},
+}
+
+// validateFlags checks the options once defaults have been applied, returning
+// every problem at once
+func validateFlags(ctx *cli.Context, r *runner.Runner) error {
+	var problems []string
+	for _, rule := range flagRules {
+		if rule.Invalid(ctx, r) {
+			problems = append(problems, rule.Message)
+		}
+	}
+	if len(problems) > 0 {
+		return cli.NewExitError(strings.Join(problems, "\n"), 1)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/ecs-run-task/runner"
+	"github.com/urfave/cli"
+)
+
+func TestValidateFlags(t *testing.T) {
+	for _, tc := range []struct {
+		Name     string
+		Args     []string
+		Runner   func(r *runner.Runner)
+		Expected string
+	}{
+		{
+			Name:   "defaults",
+			Runner: func(r *runner.Runner) {},
+		},
+		{
+			Name:     "count",
+			Runner:   func(r *runner.Runner) { r.Count = -1 },
+			Expected: "--count can't be negative",
+		},
+		{
+			Name:     "revision retention",
+			Args:     []string{"--revision-retention", "0"},
+			Runner:   func(r *runner.Runner) { r.RevisionRetention = 0 },
+			Expected: "--revision-retention must keep at least 1 revision",
+		},
+		{
+			Name: "revision retention of an existing task definition",
+			Runner: func(r *runner.Runner) {
+				r.ExistingTaskDefinition = "app:3"
+				r.RevisionRetention = 5
+			},
+			Expected: "--task-definition doesn't register one",
+		},
+		{
+			Name:     "revision without a family",
+			Runner:   func(r *runner.Runner) { r.Revision = 3 },
+			Expected: "--revision and --allow-inactive pick a revision of --from-family, so they need it",
+		},
+		{
+			Name: "revision of a family",
+			Runner: func(r *runner.Runner) {
+				r.Family = "app"
+				r.Revision = 3
+				r.AllowInactive = true
+			},
+		},
+		{
+			Name: "revision of a family with a revision",
+			Runner: func(r *runner.Runner) {
+				r.Family = "app:2"
+				r.Revision = 3
+			},
+			Expected: "--from-family already has a revision, so it can't be used with --revision",
+		},
+		{
+			Name:   "detach",
+			Runner: func(r *runner.Runner) { r.Detach = true },
+		},
+		{
+			Name: "detach waiting for running",
+			Runner: func(r *runner.Runner) {
+				r.Detach = true
+				r.WaitFor = runner.WaitForRunning
+			},
+			Expected: "--detach exits once tasks start, so it can't be used with --wait=false, --wait-for, --fail-fast, --cache, --insights-query, --artifacts, --scale-in-protection, --task-events or --revision-retention",
+		},
+		{
+			Name: "detach with revision retention",
+			Runner: func(r *runner.Runner) {
+				r.Detach = true
+				r.RevisionRetention = 5
+			},
+			Expected: "--revision-retention",
+		},
+		{
+			Name:     "fargate without subnets",
+			Runner:   func(r *runner.Runner) { r.Fargate = true },
+			Expected: "--fargate needs --subnet",
+		},
+		{
+			Name: "fargate with a service's network",
+			Runner: func(r *runner.Runner) {
+				r.Fargate = true
+				r.FromService = "web"
+			},
+		},
+		{
+			Name: "fargate with wait for capacity",
+			Runner: func(r *runner.Runner) {
+				r.Fargate = true
+				r.Subnets = []string{"subnet-1"}
+				r.WaitForCapacity = time.Minute
+			},
+			Expected: "--wait-for-capacity only applies to EC2 clusters",
+		},
+		{
+			Name: "cluster default strategy with fargate",
+			Runner: func(r *runner.Runner) {
+				r.UseClusterDefaultStrategy = true
+				r.Fargate = true
+				r.Subnets = []string{"subnet-1"}
+			},
+			Expected: "--use-cluster-default-strategy lets the cluster decide where tasks run",
+		},
+		{
+			Name: "scale-in protection without waiting",
+			Runner: func(r *runner.Runner) {
+				r.ScaleInProtection = true
+				r.NoWait = true
+			},
+			Expected: "--scale-in-protection protects EC2 instances until tasks stop",
+		},
+		{
+			Name: "network from with subnet",
+			Args: []string{"--subnet", "subnet-1"},
+			Runner: func(r *runner.Runner) {
+				r.NetworkFrom = "service:web"
+				r.Subnets = []string{"subnet-1"}
+			},
+			Expected: "--network-from copies subnets",
+		},
+		{
+			Name:     "security group without subnet",
+			Runner:   func(r *runner.Runner) { r.SecurityGroups = []string{"sg-1"} },
+			Expected: "--security-group needs --subnet",
+		},
+		{
+			Name: "no logs with insights",
+			Runner: func(r *runner.Runner) {
+				r.NoLogs = true
+				r.InsightsQuery = "stats count(*)"
+			},
+			Expected: "--no-logs can't be used",
+		},
+		{
+			Name: "no wait logs with ephemeral logs",
+			Runner: func(r *runner.Runner) {
+				r.NoWaitLogs = true
+				r.EphemeralLogs = true
+			},
+			Expected: "--no-wait-logs can't be used",
+		},
+		{
+			Name: "no finish message with ephemeral logs",
+			Runner: func(r *runner.Runner) {
+				r.NoFinishMessage = true
+				r.EphemeralLogs = true
+			},
+			Expected: "--no-finish-message can't be used with --ephemeral-logs",
+		},
+		{
+			Name: "no wait with fail fast",
+			Runner: func(r *runner.Runner) {
+				r.NoWait = true
+				r.FailFast = true
+			},
+			Expected: "--wait=false can't be used",
+		},
+		{
+			Name: "artifacts with count",
+			Runner: func(r *runner.Runner) {
+				r.ArtifactsPath = "/app/out"
+				r.ArtifactsBucket = "ci-artifacts"
+				r.Count = 2
+			},
+			Expected: "--artifacts are uploaded by a single task",
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			set := flag.NewFlagSet("test", flag.ContinueOnError)
+			set.String("subnet", "", "")
+			set.String("security-group", "", "")
+			set.Int("revision-retention", 0, "")
+			if err := set.Parse(tc.Args); err != nil {
+				t.Fatal(err)
+			}
+
+			r := runner.New()
+			r.Count = 1
+			tc.Runner(r)
+
+			err := validateFlags(cli.NewContext(nil, set, nil), r)
+			if tc.Expected == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.Expected) {
+				t.Fatalf("Expected an error containing %q, got %v", tc.Expected, err)
+			}
+		})
+	}
+}